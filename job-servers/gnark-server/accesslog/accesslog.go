@@ -0,0 +1,187 @@
+// Package accesslog logs one JSON line per HTTP request, prefixed with
+// ACCESS, with the caller and job it concerned as annotated by the
+// handlers. Ordinary requests are sampled; failed and slow ones are always
+// logged.
+package accesslog
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"gnark-server/apierror"
+)
+
+type Entry struct {
+	Method        string  `json:"method"`
+	Path          string  `json:"path"`
+	Status        int     `json:"status"`
+	DurationMs    float64 `json:"durationMs"`
+	RequestBytes  int64   `json:"requestBytes"`
+	ResponseBytes int64   `json:"responseBytes"`
+	RequestId     string  `json:"requestId,omitempty"`
+	Remote        string  `json:"remote,omitempty"`
+	UserAgent     string  `json:"userAgent,omitempty"`
+	// Actor is the name of the caller's identity and Key the owner
+	// fingerprint of its API key or token subject; keys are never logged.
+	Actor string `json:"actor,omitempty"`
+	Key   string `json:"key,omitempty"`
+	JobId string `json:"jobId,omitempty"`
+	DagId string `json:"dagId,omitempty"`
+	// SampleRate is the probability the entry had to be logged, so that
+	// sampled counts can be scaled back up; 1 for failed and slow requests.
+	SampleRate float64 `json:"sampleRate"`
+}
+
+type Logger struct {
+	// SampleRate is the fraction of ordinary requests logged.
+	SampleRate float64
+	// SlowThreshold is the duration above which requests are always logged.
+	SlowThreshold time.Duration
+	// SkipPaths are only logged when they fail or are slow, such as probes.
+	SkipPaths []string
+	// ClientIP resolves the address of the caller.
+	ClientIP func(r *http.Request) net.IP
+}
+
+type contextKey struct{}
+
+type annotations struct {
+	mu    sync.Mutex
+	entry Entry
+}
+
+func annotate(ctx context.Context, set func(entry *Entry)) {
+	if a, ok := ctx.Value(contextKey{}).(*annotations); ok {
+		a.mu.Lock()
+		set(&a.entry)
+		a.mu.Unlock()
+	}
+}
+
+// SetCaller records the identity the request was authenticated as.
+func SetCaller(ctx context.Context, actor string, key string) {
+	annotate(ctx, func(entry *Entry) { entry.Actor, entry.Key = actor, key })
+}
+
+// SetJob records the job the request submitted.
+func SetJob(ctx context.Context, jobId string) {
+	annotate(ctx, func(entry *Entry) { entry.JobId = jobId })
+}
+
+// SetDag records the DAG the request submitted.
+func SetDag(ctx context.Context, dagId string) {
+	annotate(ctx, func(entry *Entry) { entry.DagId = dagId })
+}
+
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	n      int64
+}
+
+func (w *recordingWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+func (w *recordingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (l *Logger) skipped(path string) bool {
+	for _, prefix := range l.SkipPaths {
+		if path == prefix || strings.HasPrefix(path, strings.TrimSuffix(prefix, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Middleware logs the requests of next. It must run inside
+// apierror.Middleware, to see the request ID.
+func (l *Logger) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		a := &annotations{}
+		body := &countingBody{ReadCloser: r.Body}
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = body
+		}
+		recorder := &recordingWriter{ResponseWriter: w}
+		next.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), contextKey{}, a)))
+
+		elapsed := time.Since(started)
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		rate := l.SampleRate
+		if l.skipped(r.URL.Path) {
+			rate = 0
+		}
+		if recorder.status >= 400 || (l.SlowThreshold > 0 && elapsed >= l.SlowThreshold) {
+			rate = 1
+		}
+		if rate <= 0 || (rate < 1 && rand.Float64() >= rate) {
+			return
+		}
+
+		a.mu.Lock()
+		entry := a.entry
+		a.mu.Unlock()
+		entry.Method = r.Method
+		entry.Path = r.URL.Path
+		entry.Status = recorder.status
+		entry.DurationMs = float64(elapsed.Microseconds()) / 1000
+		entry.RequestBytes = body.n
+		entry.ResponseBytes = recorder.n
+		entry.RequestId = apierror.RequestID(r.Context())
+		entry.UserAgent = r.UserAgent()
+		entry.SampleRate = rate
+		if entry.JobId == "" {
+			entry.JobId = r.URL.Query().Get("jobId")
+		}
+		if entry.DagId == "" {
+			entry.DagId = r.URL.Query().Get("dagId")
+		}
+		if l.ClientIP != nil {
+			if ip := l.ClientIP(r); ip != nil {
+				entry.Remote = ip.String()
+			}
+		}
+		entryJSON, err := json.Marshal(entry)
+		if err != nil {
+			return
+		}
+		log.Printf("ACCESS %s\n", entryJSON)
+	})
+}
@@ -0,0 +1,110 @@
+// Package apierror writes the JSON error envelope returned by every endpoint
+// and assigns the request IDs it carries.
+package apierror
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+const RequestIDHeader = "X-Request-Id"
+
+// maxRequestIDLength bounds caller-supplied request IDs, which are logged
+// and forwarded to peers.
+const maxRequestIDLength = 128
+
+type Envelope struct {
+	Code      string      `json:"code" openapi:"required"`
+	Message   string      `json:"message" openapi:"required"`
+	RequestId string      `json:"requestId" openapi:"required"`
+	Details   interface{} `json:"details,omitempty"`
+}
+
+var codes = map[int]string{
+	http.StatusBadRequest:            "bad_request",
+	http.StatusUnauthorized:          "unauthorized",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not_found",
+	http.StatusMethodNotAllowed:      "method_not_allowed",
+	http.StatusConflict:              "conflict",
+	http.StatusRequestEntityTooLarge: "payload_too_large",
+	http.StatusTooManyRequests:       "too_many_requests",
+	http.StatusInternalServerError:   "internal",
+	http.StatusServiceUnavailable:    "unavailable",
+}
+
+// Code is the envelope code for an HTTP status.
+func Code(status int) string {
+	if code, ok := codes[status]; ok {
+		return code
+	}
+	if status >= 500 {
+		return "internal"
+	}
+	return "error"
+}
+
+// Error replies with an error envelope, like http.Error. The request ID is
+// taken from the response header set by Middleware.
+func Error(w http.ResponseWriter, message string, status int) {
+	WithDetails(w, message, status, nil)
+}
+
+// WithDetails replies with an error envelope carrying machine-readable
+// details, such as the field that failed validation.
+func WithDetails(w http.ResponseWriter, message string, status int, details interface{}) {
+	envelope := Envelope{
+		Code:      Code(status),
+		Message:   message,
+		RequestId: w.Header().Get(RequestIDHeader),
+		Details:   details,
+	}
+	if status >= 500 {
+		log.Printf("Request %s failed with %d: %s\n", envelope.RequestId, status, message)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(envelope)
+}
+
+type contextKey struct{}
+
+// Middleware assigns every request an ID, reusing a well-formed X-Request-Id
+// sent by the caller, and echoes it in the response header.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = uuid.New().String()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(WithRequestID(r.Context(), id)))
+	})
+}
+
+// WithRequestID returns a copy of ctx carrying id, for work that outlives the
+// request, such as the prove of a job.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// RequestID returns the ID Middleware assigned to the request ctx belongs to.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	return strings.IndexFunc(id, func(r rune) bool {
+		return r <= ' ' || r > '~'
+	}) < 0
+}
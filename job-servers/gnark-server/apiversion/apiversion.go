@@ -0,0 +1,57 @@
+// Package apiversion serves the client APIs under a /v<N> prefix per
+// version, side by side. Handlers are shared between versions and read the
+// version of the request from its context where their responses differ.
+package apiversion
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+const (
+	V1 = 1
+	// V2 differs from V1 in breaking ways: get-proof reports the job's
+	// state instead of success.
+	V2 = 2
+)
+
+// Supported are the versions served.
+var Supported = []int{V1, V2}
+
+type contextKey struct{}
+
+// Prefix is the path prefix of version, e.g. /v1.
+func Prefix(version int) string {
+	return fmt.Sprintf("/v%d", version)
+}
+
+// With serves next with version stored in the request context.
+func With(version int, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		next(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, version)))
+	}
+}
+
+// FromContext returns the version stored by With, V1 if none was.
+func FromContext(ctx context.Context) int {
+	if version, ok := ctx.Value(contextKey{}).(int); ok {
+		return version
+	}
+	return V1
+}
+
+// HandleFunc registers handler for path under the prefix of every supported
+// version, and for path itself as V1, which it was before versions were
+// introduced.
+func HandleFunc(mux *http.ServeMux, path string, handler http.HandlerFunc) {
+	mux.HandleFunc(path, With(V1, handler))
+	for _, version := range Supported {
+		mux.HandleFunc(Prefix(version)+path, With(version, handler))
+	}
+}
+
+// Handle is HandleFunc for an http.Handler.
+func Handle(mux *http.ServeMux, path string, handler http.Handler) {
+	HandleFunc(mux, path, handler.ServeHTTP)
+}
@@ -0,0 +1,164 @@
+package artifacts
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"gnark-server/apierror"
+)
+
+// Files are the artifacts the server loads for a circuit.
+var Files = []string{
+	"circuit.r1cs",
+	"proving.key",
+	"verifying.key",
+	"verifier_only_circuit_data.json",
+}
+
+type FileInfo struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+type Manifest struct {
+	Circuit string `json:"circuit"`
+	// Version identifies the setup the artifacts come from.
+	Version string     `json:"version,omitempty"`
+	Files   []FileInfo `json:"files"`
+}
+
+// ManifestFile, written next to the artifacts by the setup, is the manifest
+// they are verified against at startup.
+const ManifestFile = "manifest.json"
+
+func isArtifact(name string) bool {
+	for _, file := range Files {
+		if file == name {
+			return true
+		}
+	}
+	return false
+}
+
+func validCircuitName(circuit string) bool {
+	return circuit != "" && !strings.ContainsAny(circuit, "/\\") && !strings.HasPrefix(circuit, ".")
+}
+
+func hashFile(path string) (FileInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	return FileInfo{Name: filepath.Base(path), Size: size, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// BuildManifest hashes every artifact of circuit found in dataDir. The
+// version is taken from the local manifest file, if any.
+func BuildManifest(dataDir string, circuit string) (Manifest, error) {
+	manifest := Manifest{Circuit: circuit}
+	if local, err := ReadManifest(dataDir, circuit); err == nil {
+		manifest.Version = local.Version
+	}
+	for _, name := range Files {
+		info, err := hashFile(filepath.Join(dataDir, circuit, name))
+		if err != nil {
+			return manifest, err
+		}
+		manifest.Files = append(manifest.Files, info)
+	}
+	return manifest, nil
+}
+
+// Server shares local circuit artifacts with peers:
+//
+//	GET /artifacts/{circuit}         manifest with sizes and SHA-256 digests
+//	GET /artifacts/{circuit}/{file}  file contents, with Range support
+type Server struct {
+	DataDir string
+	// Key is required from peers in the X-Artifact-Key header. An empty key
+	// disables sharing.
+	Key string
+
+	mu        sync.Mutex
+	manifests map[string]Manifest
+}
+
+func (s *Server) manifest(circuit string) (Manifest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if manifest, ok := s.manifests[circuit]; ok {
+		return manifest, nil
+	}
+	manifest, err := BuildManifest(s.DataDir, circuit)
+	if err != nil {
+		return manifest, err
+	}
+	if s.manifests == nil {
+		s.manifests = make(map[string]Manifest)
+	}
+	s.manifests[circuit] = manifest
+	return manifest, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.Key == "" {
+		apierror.Error(w, "Artifact sharing is disabled", http.StatusForbidden)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Artifact-Key")), []byte(s.Key)) != 1 {
+		apierror.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/artifacts/"), "/")
+	if !validCircuitName(parts[0]) || len(parts) > 2 {
+		apierror.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	circuit := parts[0]
+	if len(parts) == 1 {
+		manifest, err := s.manifest(circuit)
+		if os.IsNotExist(err) {
+			apierror.Error(w, "Not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Printf("Failed to build artifact manifest: %v\n", err)
+			apierror.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(manifest)
+		return
+	}
+	if !isArtifact(parts[1]) {
+		apierror.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	f, err := os.Open(filepath.Join(s.DataDir, circuit, parts[1]))
+	if err != nil {
+		apierror.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		apierror.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, parts[1], stat.ModTime(), f)
+}
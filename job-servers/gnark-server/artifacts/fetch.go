@@ -0,0 +1,246 @@
+package artifacts
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const chunkSize = 64 << 20
+
+type Fetcher struct {
+	Peers   []string
+	Key     string
+	DataDir string
+	// Parallelism is the number of chunks downloaded concurrently.
+	Parallelism int
+	HTTPClient  *http.Client
+}
+
+// FetchMissing downloads the artifacts of circuit that are missing locally.
+// Each file is split into chunks fetched concurrently from all peers that
+// have an identical copy, and is checked against the peers' SHA-256 digest
+// before being moved into place.
+func (f *Fetcher) FetchMissing(ctx context.Context, circuit string) error {
+	var missing []string
+	for _, name := range Files {
+		if _, err := os.Stat(filepath.Join(f.DataDir, circuit, name)); os.IsNotExist(err) {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	if len(f.Peers) == 0 {
+		return fmt.Errorf("artifacts %v of %s are missing and no peers are configured", missing, circuit)
+	}
+
+	manifests := make(map[string]Manifest)
+	for _, peer := range f.Peers {
+		var manifest Manifest
+		if err := f.getJSON(ctx, peer+"/artifacts/"+circuit, &manifest); err != nil {
+			log.Printf("Peer %s has no artifacts for %s: %v\n", peer, circuit, err)
+			continue
+		}
+		manifests[peer] = manifest
+	}
+
+	if err := os.MkdirAll(filepath.Join(f.DataDir, circuit), os.ModePerm); err != nil {
+		return err
+	}
+	fetched := Manifest{Circuit: circuit}
+	for _, name := range missing {
+		info, sources := agreeingSources(manifests, name)
+		if len(sources) == 0 {
+			return fmt.Errorf("no peer has %s of %s", name, circuit)
+		}
+		start := time.Now()
+		if err := f.fetchFile(ctx, circuit, info, sources); err != nil {
+			return fmt.Errorf("failed to fetch %s of %s: %w", name, circuit, err)
+		}
+		log.Printf("Fetched %s of %s (%d bytes) from %d peers in %s\n", name, circuit, info.Size, len(sources), time.Since(start))
+		fetched.Files = append(fetched.Files, info)
+		fetched.Version = manifests[sources[0]].Version
+	}
+
+	// A node that fetched all its artifacts records the peers' digests as
+	// its manifest file.
+	if _, err := ReadManifest(f.DataDir, circuit); os.IsNotExist(err) && len(missing) == len(Files) {
+		if err := writeManifest(f.DataDir, fetched); err != nil {
+			log.Printf("Failed to write the artifact manifest of %s: %v\n", circuit, err)
+		}
+	}
+	return nil
+}
+
+// agreeingSources picks the most common version of a file among peers and
+// returns the peers serving it.
+func agreeingSources(manifests map[string]Manifest, name string) (FileInfo, []string) {
+	byDigest := make(map[string][]string)
+	infos := make(map[string]FileInfo)
+	for peer, manifest := range manifests {
+		for _, info := range manifest.Files {
+			if info.Name == name {
+				byDigest[info.SHA256] = append(byDigest[info.SHA256], peer)
+				infos[info.SHA256] = info
+			}
+		}
+	}
+	var best string
+	for digest, peers := range byDigest {
+		if len(peers) > len(byDigest[best]) {
+			best = digest
+		}
+	}
+	return infos[best], byDigest[best]
+}
+
+func (f *Fetcher) fetchFile(ctx context.Context, circuit string, info FileInfo, sources []string) error {
+	return download(ctx, filepath.Join(f.DataDir, circuit, info.Name), info, f.Parallelism,
+		func(ctx context.Context, dst io.WriterAt, offset, end int64, chunk, worker int) error {
+			return f.fetchChunk(ctx, dst, circuit, info, offset, end, chunk, sources, worker)
+		})
+}
+
+// download writes a file of info.Size bytes to dst from chunks fetched
+// concurrently by fetch, and moves it into place once its SHA-256 digest
+// matches info.
+func download(ctx context.Context, dst string, info FileInfo, parallelism int,
+	fetch func(ctx context.Context, dst io.WriterAt, offset, end int64, chunk, worker int) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(dst), info.Name+".partial-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if err := tmp.Truncate(info.Size); err != nil {
+		return err
+	}
+
+	numChunks := int((info.Size + chunkSize - 1) / chunkSize)
+	chunks := make(chan int, numChunks)
+	for i := 0; i < numChunks; i++ {
+		chunks <- i
+	}
+	close(chunks)
+
+	if parallelism <= 0 {
+		parallelism = 4
+	}
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	for worker := 0; worker < parallelism; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for chunk := range chunks {
+				offset := int64(chunk) * chunkSize
+				end := offset + chunkSize
+				if end > info.Size {
+					end = info.Size
+				}
+				if err := fetch(ctx, tmp, offset, end, chunk, worker); err != nil {
+					once.Do(func() { firstErr = err })
+					return
+				}
+			}
+		}(worker)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, tmp); err != nil {
+		return err
+	}
+	if digest := hex.EncodeToString(h.Sum(nil)); digest != info.SHA256 {
+		return fmt.Errorf("checksum mismatch: expected %s, got %s", info.SHA256, digest)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dst)
+}
+
+// fetchChunk downloads one chunk, trying each source in turn starting from a
+// different one per worker to spread the load.
+func (f *Fetcher) fetchChunk(ctx context.Context, dst io.WriterAt, circuit string, info FileInfo, offset, end int64, chunk int, sources []string, worker int) error {
+	var lastErr error
+	for attempt := 0; attempt < len(sources); attempt++ {
+		peer := sources[(chunk+worker+attempt)%len(sources)]
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, peer+"/artifacts/"+circuit+"/"+info.Name, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("X-Artifact-Key", f.Key)
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, end-1))
+		resp, err := f.client().Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if resp.StatusCode != http.StatusPartialContent {
+			resp.Body.Close()
+			lastErr = fmt.Errorf("peer %s returned status %d", peer, resp.StatusCode)
+			continue
+		}
+		_, err = io.Copy(io.NewOffsetWriter(dst, offset), io.LimitReader(resp.Body, end-offset))
+		resp.Body.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return nil
+	}
+	return lastErr
+}
+
+func (f *Fetcher) getJSON(ctx context.Context, url string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Artifact-Key", f.Key)
+	resp, err := f.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (f *Fetcher) client() *http.Client {
+	if f.HTTPClient != nil {
+		return f.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+func NormalizePeers(peers []string) []string {
+	normalized := make([]string, 0, len(peers))
+	for _, peer := range peers {
+		if peer = strings.TrimSuffix(strings.TrimSpace(peer), "/"); peer != "" {
+			normalized = append(normalized, peer)
+		}
+	}
+	return normalized
+}
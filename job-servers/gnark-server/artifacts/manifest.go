@@ -0,0 +1,108 @@
+package artifacts
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+func ReadManifest(dataDir string, circuit string) (Manifest, error) {
+	var manifest Manifest
+	data, err := os.ReadFile(filepath.Join(dataDir, circuit, ManifestFile))
+	if err != nil {
+		return manifest, err
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return manifest, fmt.Errorf("invalid %s of %s: %w", ManifestFile, circuit, err)
+	}
+	return manifest, nil
+}
+
+func (m Manifest) file(name string) (FileInfo, bool) {
+	for _, info := range m.Files {
+		if info.Name == name {
+			return info, true
+		}
+	}
+	return FileInfo{}, false
+}
+
+// WriteManifest hashes the artifacts of circuit and records them with
+// version in its manifest file.
+func WriteManifest(dataDir string, circuit string, version string) (Manifest, error) {
+	manifest, err := BuildManifest(dataDir, circuit)
+	if err != nil {
+		return manifest, err
+	}
+	manifest.Version = version
+	return manifest, writeManifest(dataDir, manifest)
+}
+
+func writeManifest(dataDir string, manifest Manifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dataDir, manifest.Circuit, ManifestFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Verify checks every artifact of circuit against its manifest file and
+// returns the manifest, or an error naming each missing, truncated or
+// mismatched file.
+func Verify(dataDir string, circuit string) (Manifest, error) {
+	manifest, err := ReadManifest(dataDir, circuit)
+	if err != nil {
+		return manifest, err
+	}
+	if manifest.Circuit != circuit {
+		return manifest, fmt.Errorf("%s of %s is for circuit %q", ManifestFile, circuit, manifest.Circuit)
+	}
+	var problems []string
+	for _, name := range Files {
+		expected, ok := manifest.file(name)
+		if !ok {
+			problems = append(problems, name+" is not listed")
+			continue
+		}
+		local, err := hashFile(filepath.Join(dataDir, circuit, name))
+		switch {
+		case os.IsNotExist(err):
+			problems = append(problems, name+" is missing")
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("%s: %v", name, err))
+		case local.Size != expected.Size:
+			problems = append(problems, fmt.Sprintf("%s has %d bytes, expected %d", name, local.Size, expected.Size))
+		case local.SHA256 != expected.SHA256:
+			problems = append(problems, fmt.Sprintf("%s has SHA-256 %s, expected %s", name, local.SHA256, expected.SHA256))
+		}
+	}
+	if len(problems) > 0 {
+		return manifest, fmt.Errorf("artifacts of %s do not match %s: %s", circuit, ManifestFile, strings.Join(problems, "; "))
+	}
+	return manifest, nil
+}
+
+// CheckLocal verifies the artifacts of circuit before they are loaded and
+// returns their manifest. A missing manifest file is only an error when
+// required; the manifest returned then has no files.
+func CheckLocal(dataDir string, circuit string, required bool) (Manifest, error) {
+	start := time.Now()
+	manifest, err := Verify(dataDir, circuit)
+	if os.IsNotExist(err) && !required {
+		log.Printf("No %s for %s, loading its artifacts unverified\n", ManifestFile, circuit)
+		return Manifest{Circuit: circuit}, nil
+	} else if err != nil {
+		return manifest, err
+	}
+	log.Printf("Verified artifacts of %s (version %q) in %s\n", circuit, manifest.Version, time.Since(start))
+	return manifest, nil
+}
@@ -0,0 +1,166 @@
+package artifacts
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gnark-server/objectstore"
+)
+
+// StoreSource downloads circuit artifacts from an object store, where the
+// files of a circuit and their manifest file, as written by the setup, are
+// stored under Prefix+circuit+"/".
+type StoreSource struct {
+	Store   *objectstore.Client
+	Prefix  string
+	DataDir string
+	// Parallelism is the number of chunks downloaded concurrently.
+	Parallelism int
+}
+
+// NewStoreSource reaches the bucket and prefix of an s3://bucket/prefix or
+// gs://bucket/prefix URL. The endpoint defaults to AWS S3 in region, or to
+// Google Cloud Storage's XML API for gs:// URLs.
+func NewStoreSource(rawURL, endpoint, region, accessKey, secretKey string) (*StoreSource, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if region == "" {
+		region = "us-east-1"
+		if u.Scheme == "gs" {
+			region = "auto"
+		}
+	}
+	if endpoint == "" {
+		switch u.Scheme {
+		case "s3":
+			endpoint = "https://s3." + region + ".amazonaws.com"
+		case "gs":
+			endpoint = "https://storage.googleapis.com"
+		}
+	}
+	if (u.Scheme != "s3" && u.Scheme != "gs") || u.Host == "" {
+		return nil, fmt.Errorf("artifact store URL must look like s3://bucket/prefix or gs://bucket/prefix")
+	}
+	store, err := objectstore.New(endpoint, u.Host, region, accessKey, secretKey)
+	if err != nil {
+		return nil, err
+	}
+	// Chunks of multi-GB keys can take longer than the default timeout.
+	store.HTTPClient = &http.Client{}
+	prefix := strings.Trim(u.Path, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	return &StoreSource{Store: store, Prefix: prefix}, nil
+}
+
+// Sync makes the local artifacts of circuit match the store's manifest,
+// which becomes the local manifest file. Files already downloaded and
+// unchanged since are kept; others are fetched in chunks and checked against
+// the manifest's SHA-256 digest before being moved into place.
+func (s *StoreSource) Sync(ctx context.Context, circuit string) error {
+	data, err := s.Store.Get(ctx, s.Prefix+circuit+"/"+ManifestFile)
+	if err != nil {
+		return fmt.Errorf("failed to get the manifest of %s: %w", circuit, err)
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return fmt.Errorf("invalid manifest of %s: %w", circuit, err)
+	}
+	if manifest.Circuit != circuit {
+		return fmt.Errorf("manifest of %s is for circuit %q", circuit, manifest.Circuit)
+	}
+
+	if err := os.MkdirAll(filepath.Join(s.DataDir, circuit), os.ModePerm); err != nil {
+		return err
+	}
+	for _, name := range Files {
+		info, ok := manifest.file(name)
+		if !ok {
+			return fmt.Errorf("manifest of %s does not list %s", circuit, name)
+		}
+		dst := filepath.Join(s.DataDir, circuit, name)
+		if cached(dst, info) {
+			continue
+		}
+		key := s.Prefix + circuit + "/" + name
+		start := time.Now()
+		err := download(ctx, dst, info, s.Parallelism,
+			func(ctx context.Context, dst io.WriterAt, offset, end int64, chunk, worker int) error {
+				body, err := s.Store.OpenRange(ctx, key, offset, end-offset)
+				if err != nil {
+					return err
+				}
+				defer body.Close()
+				_, err = io.Copy(io.NewOffsetWriter(dst, offset), io.LimitReader(body, end-offset))
+				return err
+			})
+		if err != nil {
+			return fmt.Errorf("failed to fetch %s of %s: %w", name, circuit, err)
+		}
+		if err := writeStamp(dst, info); err != nil {
+			log.Printf("Failed to record the digest of %s: %v\n", dst, err)
+		}
+		log.Printf("Fetched %s of %s (%d bytes) from the artifact store in %s\n", name, circuit, info.Size, time.Since(start))
+	}
+	return writeManifest(s.DataDir, manifest)
+}
+
+// stamp records the digest of a verified local file next to it, so that it
+// is not hashed again on every start while its size and mtime are unchanged.
+type stamp struct {
+	SHA256  string `json:"sha256"`
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"modTime"`
+}
+
+func stampPath(path string) string {
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".sha256")
+}
+
+func writeStamp(path string, info FileInfo) error {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(stamp{SHA256: info.SHA256, Size: stat.Size(), ModTime: stat.ModTime().UnixNano()})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(stampPath(path), data, 0o644)
+}
+
+// cached reports whether the local file at path matches info, hashing it
+// when its stamp is missing or stale.
+func cached(path string, info FileInfo) bool {
+	stat, err := os.Stat(path)
+	if err != nil || stat.Size() != info.Size {
+		return false
+	}
+	if data, err := os.ReadFile(stampPath(path)); err == nil {
+		var st stamp
+		if json.Unmarshal(data, &st) == nil && st.Size == stat.Size() && st.ModTime == stat.ModTime().UnixNano() {
+			return st.SHA256 == info.SHA256
+		}
+	}
+	local, err := hashFile(path)
+	if err != nil || local.SHA256 != info.SHA256 {
+		log.Printf("Local %s does not match the artifact store and will be replaced\n", path)
+		return false
+	}
+	if err := writeStamp(path, info); err != nil {
+		log.Printf("Failed to record the digest of %s: %v\n", path, err)
+	}
+	return true
+}
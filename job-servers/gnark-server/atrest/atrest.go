@@ -0,0 +1,109 @@
+// Package atrest encrypts records with AES-256-GCM before they are stored,
+// under a current key and, for records written before a rotation, previous
+// keys that are only used to decrypt.
+package atrest
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	KeySize = 32
+	// keyIdSize is the size of the key fingerprint prefixed to sealed
+	// records, which picks the key to open them with.
+	keyIdSize = 4
+)
+
+var ErrUnknownKey = errors.New("record encrypted with an unknown key")
+
+type Keyring struct {
+	current [keyIdSize]byte
+	aeads   map[[keyIdSize]byte]cipher.AEAD
+}
+
+// ParseKey decodes a 32-byte key, in hex or base64.
+func ParseKey(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if key, err := hex.DecodeString(s); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(s); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+	return nil, fmt.Errorf("encryption key must be %d bytes in hex or base64", KeySize)
+}
+
+// NewKeyring seals with current and opens with current or previous.
+func NewKeyring(current []byte, previous ...[]byte) (*Keyring, error) {
+	k := &Keyring{aeads: make(map[[keyIdSize]byte]cipher.AEAD)}
+	for i, key := range append([][]byte{current}, previous...) {
+		if len(key) != KeySize {
+			return nil, fmt.Errorf("encryption key must be %d bytes, not %d", KeySize, len(key))
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		id := keyId(key)
+		if i == 0 {
+			k.current = id
+		}
+		k.aeads[id] = aead
+	}
+	return k, nil
+}
+
+func keyId(key []byte) [keyIdSize]byte {
+	var id [keyIdSize]byte
+	sum := sha256.Sum256(key)
+	copy(id[:], sum[:])
+	return id
+}
+
+// Seal appends to dst the key id, a random nonce and the ciphertext of
+// plaintext.
+func (k *Keyring) Seal(dst []byte, plaintext []byte) []byte {
+	aead := k.aeads[k.current]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		panic(fmt.Sprintf("atrest: no randomness for a nonce: %v", err))
+	}
+	dst = append(dst, k.current[:]...)
+	dst = append(dst, nonce...)
+	return aead.Seal(dst, nonce, plaintext, nil)
+}
+
+// Open decrypts a record sealed by Seal, without the dst prefix.
+func (k *Keyring) Open(sealed []byte) ([]byte, error) {
+	if len(sealed) < keyIdSize {
+		return nil, fmt.Errorf("truncated encrypted record")
+	}
+	var id [keyIdSize]byte
+	copy(id[:], sealed)
+	aead, ok := k.aeads[id]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	sealed = sealed[keyIdSize:]
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("truncated encrypted record")
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt record: %w", err)
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,55 @@
+// Package attestation produces hardware attestation reports of the trusted
+// execution environment the server runs in, with report data chosen by the
+// server, so that clients can check the measured build they are talking to
+// and the keys and circuit it vouches for.
+package attestation
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ReportDataSize is the size of the report data bound into every report: all
+// of it for SGX, SEV-SNP and TDX, the user data for Nitro.
+const ReportDataSize = 64
+
+const (
+	PlatformSGX    = "sgx"
+	PlatformSEVSNP = "sev-snp"
+	PlatformTDX    = "tdx"
+	PlatformNitro  = "nitro"
+)
+
+// ErrUnavailable is returned by New when the requested environment, or with
+// "auto" any environment, is not available.
+var ErrUnavailable = errors.New("no trusted execution environment available")
+
+// Provider produces the attestation evidence of one environment: an SGX
+// quote, an SEV-SNP or TDX report, or a Nitro attestation document.
+type Provider interface {
+	Platform() string
+	Attest(reportData [ReportDataSize]byte) ([]byte, error)
+}
+
+// New returns the provider of platform, one of PlatformSGX, PlatformSEVSNP,
+// PlatformTDX and PlatformNitro, or the first available with "auto".
+func New(platform string) (Provider, error) {
+	switch platform {
+	case "auto", PlatformSGX, PlatformSEVSNP, PlatformTDX, PlatformNitro:
+	default:
+		return nil, fmt.Errorf("unknown attestation platform %q", platform)
+	}
+	// Each detection returns nil if its device is missing.
+	for _, provider := range []Provider{detectGramineSGX(), detectConfigfsTSM(), detectNitro()} {
+		if provider == nil {
+			continue
+		}
+		if platform == "auto" || platform == provider.Platform() {
+			return provider, nil
+		}
+	}
+	if platform == "auto" {
+		return nil, ErrUnavailable
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnavailable, platform)
+}
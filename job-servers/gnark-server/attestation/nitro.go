@@ -0,0 +1,62 @@
+package attestation
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// nitro attests through the Nitro Secure Module of an AWS Nitro Enclave: the
+// report data is the user data of the COSE-signed attestation document.
+type nitro struct {
+	mu sync.Mutex
+}
+
+const nitroDevicePath = "/dev/nsm"
+
+// nsmRequest and nsmResponse are the CBOR messages of the NSM API.
+type nsmRequest struct {
+	Attestation nsmAttestationRequest `cbor:"Attestation"`
+}
+
+type nsmAttestationRequest struct {
+	UserData  []byte `cbor:"user_data"`
+	Nonce     []byte `cbor:"nonce"`
+	PublicKey []byte `cbor:"public_key"`
+}
+
+type nsmResponse struct {
+	Attestation *struct {
+		Document []byte `cbor:"document"`
+	} `cbor:"Attestation"`
+	Error string `cbor:"Error"`
+}
+
+// nsmMaxResponseSize bounds the attestation document with its certificates.
+const nsmMaxResponseSize = 0x3000
+
+func (p *nitro) Platform() string {
+	return PlatformNitro
+}
+
+func (p *nitro) Attest(reportData [ReportDataSize]byte) ([]byte, error) {
+	request, err := cbor.Marshal(nsmRequest{Attestation: nsmAttestationRequest{UserData: reportData[:]}})
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	responseBytes, err := nsmCall(request, nsmMaxResponseSize)
+	p.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	var response nsmResponse
+	if err := cbor.Unmarshal(responseBytes, &response); err != nil {
+		return nil, fmt.Errorf("decoding NSM response: %w", err)
+	}
+	if response.Attestation == nil {
+		return nil, fmt.Errorf("NSM returned an error: %s", response.Error)
+	}
+	return response.Attestation.Document, nil
+}
@@ -0,0 +1,47 @@
+//go:build linux
+
+package attestation
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// nsmIoctl is _IOWR(0x0A, 0, struct nsm_message), the message being the
+// request and response iovecs.
+const nsmIoctl = 0xC0200A00
+
+type nsmMessage struct {
+	request  syscall.Iovec
+	response syscall.Iovec
+}
+
+func detectNitro() Provider {
+	if _, err := os.Stat(nitroDevicePath); err != nil {
+		return nil
+	}
+	return &nitro{}
+}
+
+// nsmCall sends a CBOR request to the NSM and returns its CBOR response.
+func nsmCall(request []byte, maxResponseSize int) ([]byte, error) {
+	device, err := os.Open(nitroDevicePath)
+	if err != nil {
+		return nil, err
+	}
+	defer device.Close()
+	response := make([]byte, maxResponseSize)
+	message := nsmMessage{
+		request:  syscall.Iovec{Base: &request[0]},
+		response: syscall.Iovec{Base: &response[0]},
+	}
+	message.request.SetLen(len(request))
+	message.response.SetLen(len(response))
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, device.Fd(), nsmIoctl, uintptr(unsafe.Pointer(&message)))
+	if errno != 0 {
+		return nil, fmt.Errorf("NSM ioctl: %w", errno)
+	}
+	return response[:message.response.Len], nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package attestation
+
+import "errors"
+
+// Nitro Enclaves run Linux only.
+func detectNitro() Provider {
+	return nil
+}
+
+func nsmCall(request []byte, maxResponseSize int) ([]byte, error) {
+	return nil, errors.New("the Nitro Secure Module is only available on Linux")
+}
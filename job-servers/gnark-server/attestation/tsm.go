@@ -0,0 +1,85 @@
+package attestation
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// configfsTSM attests through the kernel's configfs-tsm report interface,
+// which SEV-SNP and TDX guests share: the report data written to inblob of a
+// report entry is embedded in the report read from outblob.
+type configfsTSM struct {
+	platform string
+	mu       sync.Mutex
+}
+
+const configfsTSMPath = "/sys/kernel/config/tsm/report"
+
+// tsmProviders maps the provider names of configfs-tsm to platforms.
+var tsmProviders = map[string]string{
+	"sev_guest": PlatformSEVSNP,
+	"tdx_guest": PlatformTDX,
+}
+
+func detectConfigfsTSM() Provider {
+	if _, err := os.Stat(configfsTSMPath); err != nil {
+		return nil
+	}
+	p := &configfsTSM{}
+	dir, err := p.entry()
+	if err != nil {
+		return nil
+	}
+	defer os.Remove(dir)
+	provider, err := os.ReadFile(filepath.Join(dir, "provider"))
+	if err != nil {
+		return nil
+	}
+	p.platform = tsmProviders[strings.TrimSpace(string(provider))]
+	if p.platform == "" {
+		return nil
+	}
+	return p
+}
+
+func (p *configfsTSM) entry() (string, error) {
+	return os.MkdirTemp(configfsTSMPath, "gnark-server-")
+}
+
+func (p *configfsTSM) Platform() string {
+	return p.platform
+}
+
+func (p *configfsTSM) Attest(reportData [ReportDataSize]byte) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	dir, err := p.entry()
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(dir)
+	if err := os.WriteFile(filepath.Join(dir, "inblob"), reportData[:], 0); err != nil {
+		return nil, err
+	}
+	generation, err := os.ReadFile(filepath.Join(dir, "generation"))
+	if err != nil {
+		return nil, err
+	}
+	report, err := os.ReadFile(filepath.Join(dir, "outblob"))
+	if err != nil {
+		return nil, err
+	}
+	// Another writer to the entry between the two reads would have changed
+	// the report data the report was made with.
+	after, err := os.ReadFile(filepath.Join(dir, "generation"))
+	if err != nil {
+		return nil, err
+	}
+	if string(after) != string(generation) {
+		return nil, fmt.Errorf("report entry %s was modified concurrently", dir)
+	}
+	return report, nil
+}
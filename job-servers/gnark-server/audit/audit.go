@@ -0,0 +1,161 @@
+// Package audit keeps an append-only trail of who submitted which job and
+// which admin actions were taken, in Redis or Postgres. Events are numbered
+// in the order they were appended; they are never changed, and only removed
+// once older than the retention, if one is set.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"gnark-server/rediskey"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	redisLogKey      = "audit_log"
+	redisSequenceKey = "audit_sequence"
+
+	// maxScan bounds the events one filtered listing reads.
+	maxScan = 10000
+)
+
+type Event struct {
+	Id   int64     `json:"id"`
+	Time time.Time `json:"time"`
+	// Action is what was done, e.g. "start-proof" or "job-purge".
+	Action string `json:"action"`
+	// Actor is the name of the caller's identity and Key the owner
+	// fingerprint of its API key or token subject, if any.
+	Actor  string `json:"actor"`
+	Key    string `json:"key,omitempty"`
+	Remote string `json:"remote,omitempty"`
+	JobId  string `json:"jobId,omitempty"`
+	// Details are the parameters and outcome of the action.
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// Query selects events, newest first: those before the event Before (all if
+// 0) matching Action and Actor when set, up to Limit.
+type Query struct {
+	Before int64
+	Action string
+	Actor  string
+	Limit  int
+}
+
+func (q Query) matches(event Event) bool {
+	return (q.Action == "" || event.Action == q.Action) && (q.Actor == "" || event.Actor == q.Actor)
+}
+
+type Store interface {
+	// Append records event, assigning its Id.
+	Append(ctx context.Context, event Event) error
+	List(ctx context.Context, query Query) ([]Event, error)
+	// Prune removes the events older than retention.
+	Prune(ctx context.Context, retention time.Duration) error
+}
+
+// RedisStore keeps events in a sorted set scored by their id, drawn from a
+// counter.
+type RedisStore struct {
+	client redis.UniversalClient
+}
+
+func NewRedisStore(client redis.UniversalClient) *RedisStore {
+	return &RedisStore{client: client}
+}
+
+func (s *RedisStore) Append(ctx context.Context, event Event) error {
+	id, err := s.client.Incr(ctx, rediskey.Key(redisSequenceKey)).Result()
+	if err != nil {
+		return err
+	}
+	event.Id = id
+	eventJSON, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return s.client.ZAdd(ctx, rediskey.Key(redisLogKey), &redis.Z{Score: float64(id), Member: eventJSON}).Err()
+}
+
+func (s *RedisStore) List(ctx context.Context, query Query) ([]Event, error) {
+	max := "+inf"
+	if query.Before > 0 {
+		max = "(" + strconv.FormatInt(query.Before, 10)
+	}
+	events := make([]Event, 0, query.Limit)
+	for scanned := 0; len(events) < query.Limit && scanned < maxScan; {
+		members, err := s.client.ZRevRangeByScore(ctx, rediskey.Key(redisLogKey), &redis.ZRangeBy{Max: max, Min: "-inf", Count: int64(query.Limit)}).Result()
+		if err != nil {
+			return nil, err
+		}
+		if len(members) == 0 {
+			break
+		}
+		scanned += len(members)
+		for _, member := range members {
+			var event Event
+			if err := json.Unmarshal([]byte(member), &event); err != nil {
+				return nil, fmt.Errorf("corrupt audit event: %w", err)
+			}
+			max = "(" + strconv.FormatInt(event.Id, 10)
+			if query.matches(event) && len(events) < query.Limit {
+				events = append(events, event)
+			}
+		}
+	}
+	return events, nil
+}
+
+func (s *RedisStore) Prune(ctx context.Context, retention time.Duration) error {
+	cutoff := time.Now().Add(-retention)
+	for {
+		members, err := s.client.ZRange(ctx, rediskey.Key(redisLogKey), 0, 99).Result()
+		if err != nil || len(members) == 0 {
+			return err
+		}
+		var last int64
+		for _, member := range members {
+			var event Event
+			if err := json.Unmarshal([]byte(member), &event); err != nil {
+				return fmt.Errorf("corrupt audit event: %w", err)
+			}
+			if !event.Time.Before(cutoff) {
+				break
+			}
+			last = event.Id
+		}
+		if last == 0 {
+			return nil
+		}
+		if err := s.client.ZRemRangeByScore(ctx, rediskey.Key(redisLogKey), "-inf", strconv.FormatInt(last, 10)).Err(); err != nil {
+			return err
+		}
+	}
+}
+
+// Run prunes store every interval until ctx is done. A zero retention keeps
+// events forever.
+func Run(ctx context.Context, store Store, retention time.Duration, interval time.Duration) {
+	if retention == 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := store.Prune(ctx, retention); err != nil && ctx.Err() == nil {
+			log.Printf("Failed to prune audit log: %v\n", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,300 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"gnark-server/accesslog"
+	"gnark-server/apierror"
+)
+
+const DefaultProfile = "relayer"
+
+// Roles of API keys: client keys use the proof APIs, admin keys also the
+// admin API.
+const (
+	RoleClient = "client"
+	RoleAdmin  = "admin"
+)
+
+type Identity struct {
+	Name    string `json:"name"`
+	Key     string `json:"key"`
+	Profile string `json:"profile"`
+
+	// Circuits and Operations restrict what the identity may do; empty means
+	// unrestricted.
+	Circuits   []string `json:"circuits,omitempty"`
+	Operations []string `json:"operations,omitempty"`
+
+	// MaxPendingJobs caps the identity's queued and running jobs; 0 uses the
+	// server default and a negative value removes the cap.
+	MaxPendingJobs int `json:"maxPendingJobs,omitempty"`
+
+	// Role is RoleClient (the default) or RoleAdmin.
+	Role string `json:"role,omitempty"`
+
+	// DailyQuota rejects the identity's submissions beyond that many jobs a
+	// UTC day, and DailySoftQuota only warns about them; 0 means none.
+	DailyQuota     int `json:"dailyQuota,omitempty"`
+	DailySoftQuota int `json:"dailySoftQuota,omitempty"`
+
+	// PaymentExempt submits jobs without a payment when payments are
+	// required.
+	PaymentExempt bool `json:"paymentExempt,omitempty"`
+	// PaymentAddresses are the addresses the identity pays from, which the
+	// onchain payment verifier requires transactions to be sent from.
+	PaymentAddresses []string `json:"paymentAddresses,omitempty"`
+
+	// Tenant groups the keys of one team: they share its jobs, idempotency
+	// keys, pending-job cap and stats, which no other tenant sees. Each key
+	// is its own tenant when empty.
+	Tenant string `json:"tenant,omitempty"`
+
+	owner string
+	keyId string
+}
+
+func (i Identity) IsAdmin() bool {
+	return i.Role == RoleAdmin
+}
+
+// TenantName is the namespace of the caller's jobs: its Tenant or, when it
+// has none, its Owner, "key:" or "token:" prefixed, which no tenant name
+// can take (see ValidateTenant), and its name for Anonymous.
+func (i Identity) TenantName() string {
+	if i.Tenant != "" {
+		return i.Tenant
+	}
+	if i.owner != "" {
+		return i.owner
+	}
+	return i.Name
+}
+
+// ValidateTenant refuses tenant names with a colon, which would collide
+// with the TenantName of the keys and tokens without a tenant.
+func ValidateTenant(tenant string) error {
+	if strings.Contains(tenant, ":") {
+		return fmt.Errorf("tenant %q must not contain ':'", tenant)
+	}
+	return nil
+}
+
+// KeyId identifies the credential of the caller, unlike its Name, which
+// keys may share: "key:" and a fingerprint of its API key, or "token:" and
+// the subject of its service token. It is the Name of Anonymous.
+func (i Identity) KeyId() string {
+	if i.keyId != "" {
+		return i.keyId
+	}
+	return i.Name
+}
+
+// Owner identifies the caller as the owner of the jobs it submits: its
+// tenant, a fingerprint of its API key, or the subject of its service token.
+// It is empty for Anonymous, whose jobs anyone may read.
+func (i Identity) Owner() string {
+	return i.owner
+}
+
+func tenantOwner(tenant string) string {
+	return "tenant:" + tenant
+}
+
+func keyFingerprint(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Allows reports whether the identity may perform operation on circuit.
+func (i Identity) Allows(circuit string, operation string) bool {
+	return allowed(i.Circuits, circuit) && allowed(i.Operations, operation)
+}
+
+func allowed(scope []string, value string) bool {
+	if len(scope) == 0 {
+		return true
+	}
+	for _, v := range scope {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Anonymous is the identity of every caller when no API keys are configured.
+var Anonymous = Identity{Name: "anonymous", Profile: DefaultProfile}
+
+type KeyStore struct {
+	keys map[string]Identity
+
+	// Tokens, when set, additionally accepts service tokens it signed.
+	Tokens *TokenIssuer
+}
+
+type contextKey struct{}
+
+// LoadKeyStore reads a JSON array of identities from path. An empty path
+// disables authentication.
+func LoadKeyStore(path string) (*KeyStore, error) {
+	store := &KeyStore{}
+	if path == "" {
+		return store, nil
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var identities []Identity
+	if err := json.Unmarshal(raw, &identities); err != nil {
+		return nil, fmt.Errorf("failed to parse API keys file: %w", err)
+	}
+	store.keys = make(map[string]Identity, len(identities))
+	for _, identity := range identities {
+		if identity.Key == "" {
+			return nil, fmt.Errorf("API key for %q is empty", identity.Name)
+		}
+		if _, ok := store.keys[identity.Key]; ok {
+			return nil, fmt.Errorf("duplicate API key for %q", identity.Name)
+		}
+		if identity.Profile == "" {
+			identity.Profile = DefaultProfile
+		}
+		if err := ValidateTenant(identity.Tenant); err != nil {
+			return nil, fmt.Errorf("API key for %q: %w", identity.Name, err)
+		}
+		identity.keyId = "key:" + keyFingerprint(identity.Key)
+		identity.owner = identity.keyId
+		if identity.Tenant != "" {
+			identity.owner = tenantOwner(identity.Tenant)
+		}
+		switch identity.Role {
+		case "":
+			identity.Role = RoleClient
+		case RoleClient, RoleAdmin:
+		default:
+			return nil, fmt.Errorf("API key for %q: unknown role %q", identity.Name, identity.Role)
+		}
+		if err := ValidateOperations(identity.Operations); err != nil {
+			return nil, fmt.Errorf("API key for %q: %w", identity.Name, err)
+		}
+		store.keys[identity.Key] = identity
+	}
+	return store, nil
+}
+
+func (k *KeyStore) Enabled() bool {
+	return k.keys != nil || k.Tokens != nil
+}
+
+func (k *KeyStore) Identities() []Identity {
+	identities := make([]Identity, 0, len(k.keys))
+	for _, identity := range k.keys {
+		identities = append(identities, identity)
+	}
+	return identities
+}
+
+func (k *KeyStore) Lookup(key string) (Identity, bool) {
+	if identity, ok := k.keys[key]; ok {
+		return identity, true
+	}
+	if k.Tokens != nil && strings.HasPrefix(key, tokenPrefix) {
+		claims, err := k.Tokens.Verify(key, time.Now())
+		if err != nil {
+			return Identity{}, false
+		}
+		return identityFromClaims(claims), true
+	}
+	return Identity{}, false
+}
+
+func apiKeyFromRequest(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+// Middleware resolves the caller's identity and stores it in the request
+// context, rejecting requests with a missing or unknown API key or service
+// token. Scopes are checked by the handlers with Identity.Allows.
+func (k *KeyStore) Middleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		identity := Anonymous
+		if k.Enabled() {
+			var ok bool
+			identity, ok = k.Lookup(apiKeyFromRequest(r))
+			if !ok {
+				apierror.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		next(w, withIdentity(r, identity))
+	}
+}
+
+// withIdentity stores identity in the context of r and annotates its access
+// log entry with it.
+func withIdentity(r *http.Request, identity Identity) *http.Request {
+	accesslog.SetCaller(r.Context(), identity.Name, identity.Owner())
+	return r.WithContext(context.WithValue(r.Context(), contextKey{}, identity))
+}
+
+func FromContext(ctx context.Context) Identity {
+	if identity, ok := ctx.Value(contextKey{}).(Identity); ok {
+		return identity
+	}
+	return Anonymous
+}
+
+func (k *KeyStore) hasAdmins() bool {
+	for _, identity := range k.keys {
+		if identity.IsAdmin() {
+			return true
+		}
+	}
+	return false
+}
+
+// AdminMiddleware guards operator endpoints: callers authenticate with the
+// shared admin key in the X-Admin-Key header or with the API key of an
+// admin-role identity. Other API keys and service tokens get 403. The
+// endpoints are disabled when there is neither an adminKey nor an admin key.
+func (k *KeyStore) AdminMiddleware(adminKey string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if adminKey == "" && !k.hasAdmins() {
+			apierror.Error(w, "Admin API is disabled", http.StatusForbidden)
+			return
+		}
+		if sharedKey := r.Header.Get("X-Admin-Key"); sharedKey != "" || apiKeyFromRequest(r) == "" {
+			if adminKey == "" || subtle.ConstantTimeCompare([]byte(sharedKey), []byte(adminKey)) != 1 {
+				apierror.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			identity := Identity{Name: "admin", Profile: DefaultProfile, Role: RoleAdmin}
+			next(w, withIdentity(r, identity))
+			return
+		}
+		identity, ok := k.Lookup(apiKeyFromRequest(r))
+		if !ok {
+			apierror.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !identity.IsAdmin() {
+			apierror.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, withIdentity(r, identity))
+	}
+}
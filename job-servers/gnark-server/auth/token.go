@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// tokenPrefix distinguishes service tokens from static API keys.
+const tokenPrefix = "gst."
+
+// Operations a scoped identity can be granted.
+const (
+	OperationStartProof = "start-proof"
+	OperationGetProof   = "get-proof"
+)
+
+var errInvalidToken = errors.New("invalid service token")
+
+// TokenClaims is the signed content of a service token.
+type TokenClaims struct {
+	Subject    string   `json:"sub"`
+	Profile    string   `json:"profile,omitempty"`
+	Circuits   []string `json:"circuits,omitempty"`
+	Operations []string `json:"operations,omitempty"`
+	// Tenant, when set, makes the token act for that tenant's keys.
+	Tenant    string `json:"tenant,omitempty"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// TokenIssuer mints and verifies HMAC-SHA256 signed service tokens. Tokens
+// are signed with the first secret and accepted if signed with any of them,
+// so secrets can be rotated without invalidating live tokens.
+type TokenIssuer struct {
+	secrets [][]byte
+}
+
+func NewTokenIssuer(secret string, previousSecrets []string) *TokenIssuer {
+	issuer := &TokenIssuer{secrets: [][]byte{[]byte(secret)}}
+	for _, previous := range previousSecrets {
+		issuer.secrets = append(issuer.secrets, []byte(previous))
+	}
+	return issuer
+}
+
+func ValidateOperations(operations []string) error {
+	for _, operation := range operations {
+		switch operation {
+		case OperationStartProof, OperationGetProof:
+		default:
+			return fmt.Errorf("unknown operation %q", operation)
+		}
+	}
+	return nil
+}
+
+func (t *TokenIssuer) Mint(claims TokenClaims) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	signature := base64.RawURLEncoding.EncodeToString(t.sign(t.secrets[0], encoded))
+	return tokenPrefix + encoded + "." + signature, nil
+}
+
+// Verify checks the signature and expiry of token and returns its claims.
+func (t *TokenIssuer) Verify(token string, now time.Time) (TokenClaims, error) {
+	var claims TokenClaims
+	encoded, signature, ok := strings.Cut(strings.TrimPrefix(token, tokenPrefix), ".")
+	if !ok || !strings.HasPrefix(token, tokenPrefix) {
+		return claims, errInvalidToken
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return claims, errInvalidToken
+	}
+	valid := false
+	for _, secret := range t.secrets {
+		if hmac.Equal(mac, t.sign(secret, encoded)) {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return claims, errInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return claims, errInvalidToken
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return claims, errInvalidToken
+	}
+	if now.Unix() >= claims.ExpiresAt {
+		return claims, errors.New("service token expired")
+	}
+	return claims, nil
+}
+
+func (t *TokenIssuer) sign(secret []byte, encoded string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(encoded))
+	return mac.Sum(nil)
+}
+
+func identityFromClaims(claims TokenClaims) Identity {
+	profile := claims.Profile
+	if profile == "" {
+		profile = DefaultProfile
+	}
+	identity := Identity{
+		Name:       claims.Subject,
+		Profile:    profile,
+		Circuits:   claims.Circuits,
+		Operations: claims.Operations,
+		Tenant:     claims.Tenant,
+		owner:      "token:" + claims.Subject,
+		keyId:      "token:" + claims.Subject,
+	}
+	if claims.Tenant != "" {
+		identity.owner = tenantOwner(claims.Tenant)
+	}
+	return identity
+}
@@ -0,0 +1,60 @@
+// Package buildinfo reports what the running binary was built from.
+package buildinfo
+
+import "runtime/debug"
+
+// Commit and Time are set at build time with
+//
+//	go build -ldflags "-X gnark-server/buildinfo.Commit=$(git rev-parse HEAD) -X gnark-server/buildinfo.Time=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// and otherwise taken from the version control information Go stamps into
+// binaries built from a checkout.
+var (
+	Commit string
+	Time   string
+)
+
+type Info struct {
+	Commit      string `json:"commit"`
+	BuildTime   string `json:"buildTime"`
+	Modified    bool   `json:"modified,omitempty"`
+	GoVersion   string `json:"goVersion"`
+	Gnark       string `json:"gnark"`
+	GnarkCrypto string `json:"gnarkCrypto"`
+}
+
+func Read() Info {
+	info := Info{Commit: Commit, BuildTime: Time}
+	build, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	info.GoVersion = build.GoVersion
+	for _, setting := range build.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			if info.Commit == "" {
+				info.Commit = setting.Value
+			}
+		case "vcs.time":
+			if info.BuildTime == "" {
+				info.BuildTime = setting.Value
+			}
+		case "vcs.modified":
+			info.Modified = Commit == "" && setting.Value == "true"
+		}
+	}
+	for _, dep := range build.Deps {
+		version := dep.Version
+		if dep.Replace != nil {
+			version = dep.Replace.Path + "@" + dep.Replace.Version
+		}
+		switch dep.Path {
+		case "github.com/consensys/gnark":
+			info.Gnark = version
+		case "github.com/consensys/gnark-crypto":
+			info.GnarkCrypto = version
+		}
+	}
+	return info
+}
@@ -1,49 +1,123 @@
 package circuitData
 
 import (
+	"encoding/json"
+	"fmt"
+	"log"
 	"os"
 
+	"gnark-server/pubinputs"
+
 	plonk_bn254 "github.com/consensys/gnark/backend/plonk/bn254"
 	cs "github.com/consensys/gnark/constraint/bn254"
 	"github.com/qope/gnark-plonky2-verifier/types"
 	"github.com/qope/gnark-plonky2-verifier/variables"
 )
 
+type LoadOptions struct {
+	// DataDir is the directory of the circuits' directories, "data" if
+	// empty.
+	DataDir string
+	// LazyProvingKey defers reading the proving key until the first prove.
+	LazyProvingKey bool
+	// MmapProvingKey reads the proving key through a memory mapping of the
+	// file instead of read calls.
+	MmapProvingKey bool
+}
+
 type CircuitData struct {
-   Pk plonk_bn254.ProvingKey
-   Vk plonk_bn254.VerifyingKey
-   Ccs cs.SparseR1CS
-   VerifierOnlyCircuitData variables.VerifierOnlyCircuitData
+	Vk                      plonk_bn254.VerifyingKey
+	Ccs                     cs.SparseR1CS
+	VerifierOnlyCircuitData variables.VerifierOnlyCircuitData
+	// CommonCircuitData describes the shape of the circuit's plonky2
+	// proofs; it is nil when common_circuit_data.json is not available.
+	CommonCircuitData *types.CommonCircuitDataRaw
+	// PublicInputRules are the rules of public_inputs.json, if any, that
+	// submitted proofs must satisfy.
+	PublicInputRules *pubinputs.Rules
+
+	pk *provingKey
+}
+
+func InitCircuitData(circuitName string, opts LoadOptions) CircuitData {
+	data, err := LoadCircuitData(circuitName, opts)
+	if err != nil {
+		panic(err)
+	}
+	return data
 }
 
-func InitCircuitData(circuitName string) CircuitData{
+func LoadCircuitData(circuitName string, opts LoadOptions) (CircuitData, error) {
 	var data CircuitData
+	dir := opts.DataDir
+	if dir == "" {
+		dir = "data"
+	}
+	dir += "/" + circuitName
 	{
-		fVk, err := os.Open("data/"+circuitName+"/verifying.key")
+		fVk, err := os.Open(dir + "/verifying.key")
 		if err != nil {
-			panic(err)
+			return data, err
 		}
-		_, _ = data.Vk.ReadFrom(fVk)
 		defer fVk.Close()
+		if _, err := data.Vk.ReadFrom(fVk); err != nil {
+			return data, fmt.Errorf("failed to read verifying key: %w", err)
+		}
 	}
 	{
-		fPk, err := os.Open("data/"+circuitName+"/proving.key")
-		if err != nil {
-			panic(err)
+		data.pk = &provingKey{path: dir + "/proving.key", mmap: opts.MmapProvingKey}
+		if !opts.LazyProvingKey {
+			if _, err := data.pk.get(); err != nil {
+				return data, err
+			}
+		} else if _, err := os.Stat(data.pk.path); err != nil {
+			return data, err
 		}
-		_, _ = data.Pk.ReadFrom(fPk)
-		defer fPk.Close()
 	}
 	{
-		fCs, err := os.Open("data/"+circuitName+"/circuit.r1cs")
+		fCs, err := os.Open(dir + "/circuit.r1cs")
 		if err != nil {
-			panic(err)
+			return data, err
 		}
-		_, _ = data.Ccs.ReadFrom(fCs)
 		defer fCs.Close()
+		if _, err := data.Ccs.ReadFrom(fCs); err != nil {
+			return data, fmt.Errorf("failed to read constraint system: %w", err)
+		}
 	}
 	{
-		data.VerifierOnlyCircuitData = variables.DeserializeVerifierOnlyCircuitData(types.ReadVerifierOnlyCircuitData("data/"+circuitName+"/verifier_only_circuit_data.json"))
+		data.VerifierOnlyCircuitData = variables.DeserializeVerifierOnlyCircuitData(types.ReadVerifierOnlyCircuitData(dir + "/verifier_only_circuit_data.json"))
 	}
-	return data
+	{
+		commonJSON, err := os.ReadFile(dir + "/common_circuit_data.json")
+		if os.IsNotExist(err) {
+			log.Printf("No common_circuit_data.json for %s, proof inputs are validated without their expected lengths\n", circuitName)
+		} else if err != nil {
+			return data, err
+		} else {
+			data.CommonCircuitData = new(types.CommonCircuitDataRaw)
+			if err := json.Unmarshal(commonJSON, data.CommonCircuitData); err != nil {
+				return data, fmt.Errorf("failed to read common circuit data: %w", err)
+			}
+		}
+	}
+	{
+		rules, err := pubinputs.Load(dir + "/public_inputs.json")
+		if err != nil {
+			return data, err
+		}
+		data.PublicInputRules = rules
+	}
+	return data, nil
+}
+
+// ProvingKey returns the proving key, reading it first if it was loaded
+// lazily.
+func (d *CircuitData) ProvingKey() (*plonk_bn254.ProvingKey, error) {
+	return d.pk.get()
+}
+
+// ProvingKeyStatus is one of ProvingKeyNotLoaded, ProvingKeyLoading,
+// ProvingKeyLoaded and ProvingKeyFailed.
+func (d *CircuitData) ProvingKeyStatus() string {
+	return d.pk.status()
 }
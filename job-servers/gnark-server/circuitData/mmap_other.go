@@ -0,0 +1,14 @@
+//go:build !unix
+
+package circuitData
+
+import (
+	"io"
+	"os"
+)
+
+// mmapFile falls back to reading the whole file where mmap is unavailable.
+func mmapFile(f *os.File) ([]byte, func(), error) {
+	data, err := io.ReadAll(f)
+	return data, func() {}, err
+}
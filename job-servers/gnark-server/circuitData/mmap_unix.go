@@ -0,0 +1,26 @@
+//go:build unix
+
+package circuitData
+
+import (
+	"os"
+	"syscall"
+)
+
+// mmapFile maps f read-only. The pages are backed by the file, so the kernel
+// can drop them under memory pressure instead of the process holding a
+// second copy of the key while it is deserialized.
+func mmapFile(f *os.File) ([]byte, func(), error) {
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	if stat.Size() == 0 {
+		return nil, func() {}, nil
+	}
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(stat.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() { syscall.Munmap(data) }, nil
+}
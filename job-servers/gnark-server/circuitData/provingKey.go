@@ -0,0 +1,88 @@
+package circuitData
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	plonk_bn254 "github.com/consensys/gnark/backend/plonk/bn254"
+)
+
+const (
+	ProvingKeyNotLoaded = "not_loaded"
+	ProvingKeyLoading   = "loading"
+	ProvingKeyLoaded    = "loaded"
+	ProvingKeyFailed    = "failed"
+)
+
+type provingKey struct {
+	path string
+	mmap bool
+
+	once  sync.Once
+	mu    sync.Mutex
+	state string
+	key   plonk_bn254.ProvingKey
+	err   error
+}
+
+func (p *provingKey) status() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.state == "" {
+		return ProvingKeyNotLoaded
+	}
+	return p.state
+}
+
+func (p *provingKey) setState(state string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.state = state
+}
+
+// get reads the key on first use; concurrent callers wait for the same read.
+func (p *provingKey) get() (*plonk_bn254.ProvingKey, error) {
+	p.once.Do(func() {
+		p.setState(ProvingKeyLoading)
+		start := time.Now()
+		p.err = p.load()
+		if p.err != nil {
+			p.setState(ProvingKeyFailed)
+			return
+		}
+		p.setState(ProvingKeyLoaded)
+		log.Println("Loaded proving key", p.path, "in", time.Since(start))
+	})
+	if p.err != nil {
+		return nil, p.err
+	}
+	return &p.key, nil
+}
+
+func (p *provingKey) load() error {
+	f, err := os.Open(p.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if p.mmap {
+		data, unmap, err := mmapFile(f)
+		if err != nil {
+			return fmt.Errorf("failed to map proving key: %w", err)
+		}
+		defer unmap()
+		if _, err := p.key.ReadFrom(bytes.NewReader(data)); err != nil {
+			return fmt.Errorf("failed to read proving key: %w", err)
+		}
+		return nil
+	}
+	if _, err := p.key.ReadFrom(bufio.NewReaderSize(f, 1<<20)); err != nil {
+		return fmt.Errorf("failed to read proving key: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,394 @@
+//! Typed client for the gnark-server proof API, mirroring the Go `client`
+//! package.
+
+use std::{collections::HashMap, time::Duration};
+
+use reqwest::{header, StatusCode};
+use serde::{de::DeserializeOwned, Deserialize, Serialize};
+
+const DEFAULT_POLL_INTERVAL: Duration = Duration::from_secs(2);
+const DEFAULT_MAX_POLL_INTERVAL: Duration = Duration::from_secs(30);
+
+#[derive(Debug, thiserror::Error)]
+pub enum GnarkClientError {
+    #[error("network error: {0}")]
+    Network(#[from] reqwest::Error),
+
+    #[error("gnark-server returned status {status}: {message} (requestId {request_id})")]
+    Http {
+        status: StatusCode,
+        code: String,
+        message: String,
+        request_id: String,
+        details: Option<serde_json::Value>,
+        /// The delay asked for with `Retry-After`, e.g. when the queue is full (429).
+        retry_after: Option<Duration>,
+    },
+
+    #[error("proof generation failed: {code}: {message}")]
+    ProofFailed { code: String, message: String },
+
+    #[error("failed to decode response: {0}")]
+    Decode(#[from] serde_json::Error),
+}
+
+/// The error envelope of non-200 responses.
+#[derive(Debug, Clone, Default, Serialize, Deserialize)]
+pub struct ErrorEnvelope {
+    #[serde(default)]
+    pub code: String,
+    #[serde(default)]
+    pub message: String,
+    #[serde(rename = "requestId", default)]
+    pub request_id: String,
+    #[serde(default, skip_serializing_if = "Option::is_none")]
+    pub details: Option<serde_json::Value>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct RaceReport {
+    pub winner: String,
+    pub contenders: u32,
+    #[serde(rename = "winnerMs")]
+    pub winner_ms: i64,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct RelayReport {
+    pub from: String,
+    #[serde(rename = "txHash", default)]
+    pub tx_hash: Option<String>,
+    #[serde(default)]
+    pub error: Option<String>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct SimulationReport {
+    pub contract: String,
+    pub verified: bool,
+    #[serde(rename = "gasEstimate", default)]
+    pub gas_estimate: Option<u64>,
+    #[serde(default)]
+    pub error: Option<String>,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct ProveResult {
+    #[serde(rename = "publicInputs")]
+    pub public_inputs: Vec<String>,
+    pub proof: String,
+    /// keccak256 of the Solidity-encoded proof bytes, 0x-prefixed hex.
+    #[serde(rename = "proofKeccak256", default)]
+    pub proof_keccak256: Option<String>,
+    /// SHA-256 of the Solidity-encoded proof bytes, 0x-prefixed hex.
+    #[serde(rename = "proofSha256", default)]
+    pub proof_sha256: Option<String>,
+    #[serde(default)]
+    pub race: Option<RaceReport>,
+    #[serde(default)]
+    pub calldata: Option<String>,
+    #[serde(default)]
+    pub relay: Option<RelayReport>,
+    #[serde(default)]
+    pub simulation: Option<SimulationReport>,
+    #[serde(default)]
+    pub blobs: Option<Vec<String>>,
+    #[serde(rename = "blobVersionedHashes", default)]
+    pub blob_versioned_hashes: Option<Vec<String>>,
+    /// The circuit the proof was made with, the loaded one or a canary.
+    #[serde(default)]
+    pub circuit: Option<String>,
+    /// The artifact manifest version of `circuit`.
+    #[serde(rename = "circuitVersion", default)]
+    pub circuit_version: Option<String>,
+    /// Set when the server signs results.
+    #[serde(default)]
+    pub signature: Option<ResultSignature>,
+}
+
+/// Signature of a proof result by the key served at `/result/signer`: the
+/// 0x-prefixed `[R || S || V]` secp256k1 signature of
+/// keccak256("gnark-server result v1\n" || jobId || proofHash || publicInputsHash).
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct ResultSignature {
+    /// Ethereum address of the signing key.
+    pub signer: String,
+    /// keccak256 of the Solidity-encoded proof bytes.
+    #[serde(rename = "proofHash")]
+    pub proof_hash: String,
+    /// keccak256 of the public inputs as 32-byte words.
+    #[serde(rename = "publicInputsHash")]
+    pub public_inputs_hash: String,
+    pub signature: String,
+}
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct ProofResponse {
+    pub success: bool,
+    pub proof: Option<ProveResult>,
+    #[serde(rename = "errorMessage")]
+    pub error_message: Option<String>,
+    /// One of `INVALID_INPUT`, `WITNESS_FAILED`, `PROVE_FAILED`, `TIMEOUT`,
+    /// `CANCELLED` or `INTERNAL` when the job failed.
+    #[serde(rename = "errorCode", default)]
+    pub error_code: Option<String>,
+    #[serde(default)]
+    pub attempts: u32,
+    /// Set when the job failed with a panic on the server.
+    #[serde(rename = "errorStack", default)]
+    pub error_stack: Option<String>,
+    /// Position in the queue (1 for the next job) while the job is waiting.
+    #[serde(rename = "queuePosition", default)]
+    pub queue_position: Option<u32>,
+    /// Expected completion time (RFC 3339) while the job is pending.
+    #[serde(rename = "estimatedCompletionAt", default)]
+    pub estimated_completion_at: Option<String>,
+}
+
+impl ProofResponse {
+    /// Whether the job has finished, successfully or not.
+    pub fn is_done(&self) -> bool {
+        !self.success || self.proof.is_some()
+    }
+}
+
+#[derive(Debug, Clone, Default, Serialize)]
+pub struct StartProofRequest {
+    /// The plonky2 proof with public inputs, as JSON.
+    pub proof: String,
+    #[serde(rename = "jobId", skip_serializing_if = "Option::is_none")]
+    pub job_id: Option<String>,
+    #[serde(rename = "webhookUrl", skip_serializing_if = "Option::is_none")]
+    pub webhook_url: Option<String>,
+    #[serde(
+        rename = "expectedPublicInputs",
+        skip_serializing_if = "HashMap::is_empty"
+    )]
+    pub expected_public_inputs: HashMap<usize, String>,
+    #[serde(skip_serializing_if = "std::ops::Not::not")]
+    pub race: bool,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub format: Option<String>,
+    /// `"high"` (default) or `"low"` for batch jobs.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub priority: Option<String>,
+    /// Payment reference of the job, when the server charges for proofs.
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub payment: Option<String>,
+
+    /// Sent as the Idempotency-Key header.
+    #[serde(skip)]
+    pub idempotency_key: Option<String>,
+}
+
+/// Prefix of the bytes a receipt signature covers.
+const RECEIPT_SIGNING_DOMAIN: &str = "gnark-server receipt v1\n";
+
+/// Signed submission receipt. Fields are declared in the order the server
+/// signs them.
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct Receipt {
+    #[serde(rename = "jobId")]
+    pub job_id: String,
+    pub circuit: String,
+    #[serde(rename = "payloadHash")]
+    pub payload_hash: String,
+    pub sequence: i64,
+    #[serde(rename = "acceptedAt")]
+    pub accepted_at: String,
+    #[serde(rename = "nodeId")]
+    pub node_id: String,
+    #[serde(rename = "keyId")]
+    pub key_id: String,
+    pub signature: String,
+}
+
+impl Receipt {
+    /// The bytes the base64 Ed25519 `signature` covers, to be checked with
+    /// the key served at `/receipt/public-key`.
+    pub fn signed_bytes(&self) -> Vec<u8> {
+        let unsigned = Receipt {
+            signature: String::new(),
+            ..self.clone()
+        };
+        let mut bytes = RECEIPT_SIGNING_DOMAIN.as_bytes().to_vec();
+        bytes.extend(serde_json::to_vec(&unsigned).expect("receipt serializes"));
+        bytes
+    }
+}
+
+#[derive(Debug, Clone, Deserialize)]
+pub struct StartProofResponse {
+    #[serde(rename = "jobId")]
+    pub job_id: String,
+    /// Set when the server signs submission receipts.
+    #[serde(default)]
+    pub receipt: Option<Receipt>,
+}
+
+#[derive(Debug, Clone)]
+pub struct GnarkClient {
+    base_url: String,
+    api_key: Option<String>,
+    http: reqwest::Client,
+    /// Initial delay between get-proof polls in `wait_for_proof`; it doubles
+    /// up to `max_poll_interval`.
+    pub poll_interval: Duration,
+    pub max_poll_interval: Duration,
+}
+
+impl GnarkClient {
+    pub fn new(base_url: &str, api_key: Option<String>) -> Self {
+        Self {
+            base_url: base_url.trim_end_matches('/').to_string(),
+            api_key,
+            http: reqwest::Client::new(),
+            poll_interval: DEFAULT_POLL_INTERVAL,
+            max_poll_interval: DEFAULT_MAX_POLL_INTERVAL,
+        }
+    }
+
+    /// Submits a proof job and returns its job ID.
+    pub async fn start_proof(
+        &self,
+        request: &StartProofRequest,
+    ) -> Result<String, GnarkClientError> {
+        Ok(self.submit(request).await?.job_id)
+    }
+
+    /// Starts a proof job like `start_proof`, also returning its receipt.
+    pub async fn submit(
+        &self,
+        request: &StartProofRequest,
+    ) -> Result<StartProofResponse, GnarkClientError> {
+        let mut builder = self
+            .http
+            .post(format!("{}/v1/start-proof", self.base_url))
+            .json(request);
+        if let Some(key) = &request.idempotency_key {
+            builder = builder.header("Idempotency-Key", key);
+        }
+        self.send(builder).await
+    }
+
+    /// Fetches the current state of a job.
+    pub async fn get_proof(&self, job_id: &str) -> Result<ProofResponse, GnarkClientError> {
+        let builder = self
+            .http
+            .get(format!("{}/v1/get-proof", self.base_url))
+            .query(&[("jobId", job_id)]);
+        self.send(builder).await
+    }
+
+    /// Polls get-proof with exponential backoff until the job finishes. Wrap
+    /// the future in `tokio::time::timeout` to bound the wait.
+    pub async fn wait_for_proof(&self, job_id: &str) -> Result<ProveResult, GnarkClientError> {
+        let mut interval = self.poll_interval;
+        let max_interval = self.max_poll_interval.max(interval);
+        loop {
+            let response = self.get_proof(job_id).await?;
+            if !response.success {
+                return Err(GnarkClientError::ProofFailed {
+                    code: response.error_code.unwrap_or_default(),
+                    message: response.error_message.unwrap_or_default(),
+                });
+            }
+            if let Some(result) = response.proof {
+                return Ok(result);
+            }
+            tokio::time::sleep(interval).await;
+            interval = (interval * 2).min(max_interval);
+        }
+    }
+
+    async fn send<R: DeserializeOwned>(
+        &self,
+        mut builder: reqwest::RequestBuilder,
+    ) -> Result<R, GnarkClientError> {
+        if let Some(key) = &self.api_key {
+            builder = builder.header("X-API-Key", key);
+        }
+        let response = builder
+            .header(header::ACCEPT, "application/json")
+            .send()
+            .await?;
+        let status = response.status();
+        let retry_after = response
+            .headers()
+            .get(header::RETRY_AFTER)
+            .and_then(|value| value.to_str().ok())
+            .and_then(|value| value.parse().ok())
+            .map(Duration::from_secs);
+        let body = response.text().await?;
+        if status != StatusCode::OK {
+            let envelope =
+                serde_json::from_str::<ErrorEnvelope>(&body).unwrap_or_else(|_| ErrorEnvelope {
+                    message: body.trim().to_string(),
+                    ..Default::default()
+                });
+            return Err(GnarkClientError::Http {
+                status,
+                code: envelope.code,
+                message: envelope.message,
+                request_id: envelope.request_id,
+                details: envelope.details,
+                retry_after,
+            });
+        }
+        Ok(serde_json::from_str(&body)?)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn receipt_signed_bytes_match_server() {
+        let receipt = Receipt {
+            job_id: "j1".to_string(),
+            circuit: "withdrawal_circuit_data".to_string(),
+            payload_hash: "ab".to_string(),
+            sequence: 7,
+            accepted_at: "2025-01-02T03:04:05.0000006Z".to_string(),
+            node_id: "n1".to_string(),
+            key_id: "k1".to_string(),
+            signature: "sig".to_string(),
+        };
+        assert_eq!(
+            String::from_utf8(receipt.signed_bytes()).unwrap(),
+            "gnark-server receipt v1\n{\"jobId\":\"j1\",\"circuit\":\"withdrawal_circuit_data\",\"payloadHash\":\"ab\",\"sequence\":7,\"acceptedAt\":\"2025-01-02T03:04:05.0000006Z\",\"nodeId\":\"n1\",\"keyId\":\"k1\",\"signature\":\"\"}"
+        );
+    }
+
+    #[test]
+    fn error_envelope_decodes() {
+        let envelope: ErrorEnvelope = serde_json::from_str(
+            r#"{"code":"bad_request","message":"job \"a\": bad","requestId":"r1","details":{"job":"a"}}"#,
+        )
+        .unwrap();
+        assert_eq!(envelope.code, "bad_request");
+        assert_eq!(envelope.request_id, "r1");
+        assert_eq!(envelope.details.unwrap()["job"], "a");
+    }
+
+    #[test]
+    fn start_proof_request_omits_unset_fields() {
+        let request = StartProofRequest {
+            proof: "{}".to_string(),
+            idempotency_key: Some("key".to_string()),
+            ..Default::default()
+        };
+        assert_eq!(
+            serde_json::to_string(&request).unwrap(),
+            r#"{"proof":"{}"}"#
+        );
+    }
+
+    #[test]
+    fn pending_response_is_not_done() {
+        let response: ProofResponse =
+            serde_json::from_str(r#"{"success":true,"proof":null,"errorMessage":null}"#).unwrap();
+        assert!(!response.is_done());
+    }
+}
@@ -0,0 +1,442 @@
+// Package client is a Go client for the gnark-server proof API.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdh"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"gnark-server/receipt"
+	"gnark-server/resultbox"
+	"gnark-server/resultsig"
+
+	"golang.org/x/crypto/sha3"
+)
+
+const (
+	defaultPollInterval    = 2 * time.Second
+	defaultMaxPollInterval = 30 * time.Second
+)
+
+// ErrProofFailed is wrapped by the error WaitForProof returns when the server
+// reports that the job failed.
+var ErrProofFailed = errors.New("proof generation failed")
+
+// Error codes of failed jobs.
+const (
+	ErrorCodeInvalidInput  = "INVALID_INPUT"
+	ErrorCodeWitnessFailed = "WITNESS_FAILED"
+	ErrorCodeProveFailed   = "PROVE_FAILED"
+	ErrorCodeTimeout       = "TIMEOUT"
+	ErrorCodeCancelled     = "CANCELLED"
+	ErrorCodeInternal      = "INTERNAL"
+)
+
+// JobError is the error WaitForProof returns for a failed job.
+type JobError struct {
+	Code    string
+	Message string
+}
+
+func (e *JobError) Error() string {
+	return fmt.Sprintf("%v: %s: %s", ErrProofFailed, e.Code, e.Message)
+}
+
+func (e *JobError) Unwrap() error {
+	return ErrProofFailed
+}
+
+type RaceReport struct {
+	Winner     string `json:"winner"`
+	Contenders int    `json:"contenders"`
+	WinnerMs   int64  `json:"winnerMs"`
+}
+
+type RelayReport struct {
+	From   string `json:"from"`
+	TxHash string `json:"txHash,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type SimulationReport struct {
+	Contract    string `json:"contract"`
+	Verified    bool   `json:"verified"`
+	GasEstimate uint64 `json:"gasEstimate,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+type ProveResult struct {
+	PublicInputs []string          `json:"publicInputs"`
+	Proof        string            `json:"proof"`
+	Race         *RaceReport       `json:"race,omitempty"`
+	Calldata     string            `json:"calldata,omitempty"`
+	Relay        *RelayReport      `json:"relay,omitempty"`
+	Simulation   *SimulationReport `json:"simulation,omitempty"`
+
+	// ProofKeccak256 and ProofSHA256 are the hashes of the proof bytes;
+	// CheckProofHashes compares them with the proof received.
+	ProofKeccak256 string `json:"proofKeccak256,omitempty"`
+	ProofSHA256    string `json:"proofSha256,omitempty"`
+
+	// Signature is set when the server signs results; check it with
+	// resultsig.Verify against the address served at /result/signer.
+	Signature *resultsig.Signature `json:"signature,omitempty"`
+
+	// Circuit and CircuitVersion are those the proof was made with: the
+	// loaded circuit or a canary.
+	Circuit        string `json:"circuit,omitempty"`
+	CircuitVersion string `json:"circuitVersion,omitempty"`
+
+	// Encrypted is set instead of the other fields for jobs submitted with
+	// a ResultPublicKey; DecryptResult opens it.
+	Encrypted string `json:"encrypted,omitempty"`
+}
+
+// CheckProofHashes reports an error if the hex proof of result does not match
+// the hashes the server computed, such as when it was truncated on the way.
+// Results without hashes pass.
+func CheckProofHashes(result *ProveResult) error {
+	proof, err := hex.DecodeString(strings.TrimPrefix(result.Proof, "0x"))
+	if err != nil {
+		return fmt.Errorf("decoding proof: %w", err)
+	}
+	if result.ProofKeccak256 != "" {
+		keccak := sha3.NewLegacyKeccak256()
+		keccak.Write(proof)
+		if got := "0x" + hex.EncodeToString(keccak.Sum(nil)); !strings.EqualFold(got, result.ProofKeccak256) {
+			return fmt.Errorf("proof keccak256 mismatch: expected %s, got %s", result.ProofKeccak256, got)
+		}
+	}
+	if result.ProofSHA256 != "" {
+		sha := sha256.Sum256(proof)
+		if got := "0x" + hex.EncodeToString(sha[:]); !strings.EqualFold(got, result.ProofSHA256) {
+			return fmt.Errorf("proof sha256 mismatch: expected %s, got %s", result.ProofSHA256, got)
+		}
+	}
+	return nil
+}
+
+// DecryptResult opens the result of job jobId, encrypted to the public key of
+// key. Results that are not encrypted are returned as they are.
+func DecryptResult(key *ecdh.PrivateKey, jobId string, result *ProveResult) (*ProveResult, error) {
+	if result.Encrypted == "" {
+		return result, nil
+	}
+	sealed, err := base64.StdEncoding.DecodeString(result.Encrypted)
+	if err != nil {
+		return nil, resultbox.ErrMalformed
+	}
+	resultJSON, err := resultbox.Open(key, jobId, sealed)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting result: %w", err)
+	}
+	var decrypted ProveResult
+	if err := json.Unmarshal(resultJSON, &decrypted); err != nil {
+		return nil, err
+	}
+	return &decrypted, nil
+}
+
+type ProofResponse struct {
+	Success      bool         `json:"success"`
+	Proof        *ProveResult `json:"proof"`
+	ErrorMessage *string      `json:"errorMessage"`
+	ErrorCode    string       `json:"errorCode,omitempty"`
+	Attempts     int          `json:"attempts,omitempty"`
+	// ErrorStack is set when the job failed with a panic on the server.
+	ErrorStack *string `json:"errorStack,omitempty"`
+
+	// QueuePosition (1 for the next job) and EstimatedCompletionAt may be
+	// set while the job is pending.
+	QueuePosition         int        `json:"queuePosition,omitempty"`
+	EstimatedCompletionAt *time.Time `json:"estimatedCompletionAt,omitempty"`
+	// NotBefore is set while a scheduled job waits for it.
+	NotBefore *time.Time `json:"notBefore,omitempty"`
+}
+
+// Done reports whether the job has finished, successfully or not.
+func (r *ProofResponse) Done() bool {
+	return !r.Success || r.Proof != nil
+}
+
+type StartProofRequest struct {
+	// Proof is the plonky2 proof with public inputs, the JSON object sent
+	// as is.
+	Proof                json.RawMessage `json:"proof"`
+	JobId                string          `json:"jobId,omitempty"`
+	WebhookURL           string          `json:"webhookUrl,omitempty"`
+	ExpectedPublicInputs map[int]string  `json:"expectedPublicInputs,omitempty"`
+	Race                 bool            `json:"race,omitempty"`
+	Format               string          `json:"format,omitempty"`
+	// Priority is "high" (default) or "low" for batch jobs.
+	Priority string `json:"priority,omitempty"`
+	// ResultPublicKey is an X25519 public key, hex or base64, the server
+	// encrypts the result to.
+	ResultPublicKey string `json:"resultPublicKey,omitempty"`
+	// NotBefore, when set, schedules the job to run no earlier.
+	NotBefore *time.Time `json:"notBefore,omitempty"`
+	// Payment is the payment reference of the job, when the server
+	// charges for proofs.
+	Payment string `json:"payment,omitempty"`
+
+	// IdempotencyKey is sent as the Idempotency-Key header.
+	IdempotencyKey string `json:"-"`
+}
+
+// HTTPError is returned when the server answers with a non-200 status. Code,
+// Message and RequestId come from the server's error envelope.
+type HTTPError struct {
+	StatusCode int
+	Code       string          `json:"code"`
+	Message    string          `json:"message"`
+	RequestId  string          `json:"requestId"`
+	Details    json.RawMessage `json:"details,omitempty"`
+	// RetryAfter is the delay the server asked for with Retry-After, for
+	// example when its queue is full (429).
+	RetryAfter time.Duration `json:"-"`
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("gnark-server returned status %d: %s (requestId %s)", e.StatusCode, e.Message, e.RequestId)
+}
+
+type Client struct {
+	BaseURL    string
+	APIKey     string
+	HTTPClient *http.Client
+
+	// PollInterval is the initial delay between get-proof polls in
+	// WaitForProof; it doubles up to MaxPollInterval.
+	PollInterval    time.Duration
+	MaxPollInterval time.Duration
+}
+
+func New(baseURL string, apiKey string) *Client {
+	return &Client{
+		BaseURL:         strings.TrimSuffix(baseURL, "/"),
+		APIKey:          apiKey,
+		HTTPClient:      http.DefaultClient,
+		PollInterval:    defaultPollInterval,
+		MaxPollInterval: defaultMaxPollInterval,
+	}
+}
+
+// NewUnix creates a client of a server listening on the unix socket at
+// socketPath (UNIX_SOCKET).
+func NewUnix(socketPath string, apiKey string) *Client {
+	c := New("http://unix", apiKey)
+	c.HTTPClient = &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socketPath)
+		},
+	}}
+	return c
+}
+
+// StartProof submits a proof job and returns its job ID.
+func (c *Client) StartProof(ctx context.Context, request StartProofRequest) (string, error) {
+	started, err := c.Submit(ctx, request)
+	if err != nil {
+		return "", err
+	}
+	return started.JobId, nil
+}
+
+type StartProofResponse struct {
+	JobId string `json:"jobId"`
+	// Receipt is set when the server signs submission receipts; check it
+	// with receipt.Verify.
+	Receipt *receipt.Receipt `json:"receipt,omitempty"`
+}
+
+// Submit starts a proof job like StartProof, also returning its receipt.
+func (c *Client) Submit(ctx context.Context, request StartProofRequest) (*StartProofResponse, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	header := http.Header{}
+	if request.IdempotencyKey != "" {
+		header.Set("Idempotency-Key", request.IdempotencyKey)
+	}
+	var started StartProofResponse
+	if err := c.do(ctx, http.MethodPost, "/v1/start-proof", header, body, &started); err != nil {
+		return nil, err
+	}
+	return &started, nil
+}
+
+// RetryJob runs the failed job jobId again from the input the server kept,
+// under newJobId, or a new random jobId if it is empty. payment is a new
+// payment reference, when the server charges for proofs.
+func (c *Client) RetryJob(ctx context.Context, jobId string, newJobId string, payment string) (*StartProofResponse, error) {
+	body, err := json.Marshal(map[string]string{"jobId": newJobId, "payment": payment})
+	if err != nil {
+		return nil, err
+	}
+	var started StartProofResponse
+	if err := c.do(ctx, http.MethodPost, "/v1/jobs/"+url.PathEscape(jobId)+"/retry", nil, body, &started); err != nil {
+		return nil, err
+	}
+	return &started, nil
+}
+
+type DryRunResponse struct {
+	PublicInputs        []string `json:"publicInputs"`
+	PublicInputsHash    string   `json:"publicInputsHash"`
+	WitnessGenerationMs int64    `json:"witnessGenerationMs"`
+}
+
+// DryRun checks proof, the plonky2 proof as JSON, and returns the public
+// inputs a job of it would have, without proving it.
+func (c *Client) DryRun(ctx context.Context, proof string) (*DryRunResponse, error) {
+	body, err := json.Marshal(map[string]string{"proof": proof})
+	if err != nil {
+		return nil, err
+	}
+	var response DryRunResponse
+	if err := c.do(ctx, http.MethodPost, "/v1/prove/dry-run", nil, body, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+type DailyUsage struct {
+	Date   string `json:"date"`
+	Jobs   int64  `json:"jobs"`
+	Proves int64  `json:"proves"`
+	// EstimatedCPUSeconds is the prove time times the CPUs of a worker.
+	EstimatedCPUSeconds float64 `json:"estimatedCpuSeconds"`
+}
+
+type UsageReport struct {
+	Key string `json:"key"`
+	// KeyId is what the server counts the usage of the key by.
+	KeyId          string `json:"keyId"`
+	DailyQuota     int    `json:"dailyQuota,omitempty"`
+	DailySoftQuota int    `json:"dailySoftQuota,omitempty"`
+	// Days are the most recent first, today included.
+	Days []DailyUsage `json:"days"`
+}
+
+// Usage reports the usage of the client's API key over the last days days,
+// or the server's default when days is 0.
+func (c *Client) Usage(ctx context.Context, days int) (*UsageReport, error) {
+	path := "/v1/usage"
+	if days > 0 {
+		path += "?days=" + strconv.Itoa(days)
+	}
+	var report UsageReport
+	if err := c.do(ctx, http.MethodGet, path, nil, nil, &report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// GetProof fetches the current state of a job.
+func (c *Client) GetProof(ctx context.Context, jobId string) (*ProofResponse, error) {
+	var response ProofResponse
+	if err := c.do(ctx, http.MethodGet, "/v1/get-proof?jobId="+url.QueryEscape(jobId), nil, nil, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// WaitForProof polls get-proof with exponential backoff until the job
+// finishes or ctx is done. A failed job is returned as a *JobError, which
+// wraps ErrProofFailed, and a proof not matching its hashes as an error.
+func (c *Client) WaitForProof(ctx context.Context, jobId string) (*ProveResult, error) {
+	interval := c.PollInterval
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	maxInterval := c.MaxPollInterval
+	if maxInterval < interval {
+		maxInterval = interval
+	}
+	for {
+		response, err := c.GetProof(ctx, jobId)
+		if err != nil {
+			return nil, err
+		}
+		if !response.Success {
+			jobErr := &JobError{Code: response.ErrorCode}
+			if response.ErrorMessage != nil {
+				jobErr.Message = *response.ErrorMessage
+			}
+			return nil, jobErr
+		}
+		if response.Proof != nil && response.Proof.Encrypted == "" {
+			if err := CheckProofHashes(response.Proof); err != nil {
+				return nil, err
+			}
+			return response.Proof, nil
+		} else if response.Proof != nil {
+			return response.Proof, nil
+		}
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+		interval *= 2
+		if interval > maxInterval {
+			interval = maxInterval
+		}
+	}
+}
+
+func (c *Client) do(ctx context.Context, method string, path string, header http.Header, body []byte, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.APIKey != "" {
+		req.Header.Set("X-API-Key", c.APIKey)
+	}
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		httpErr := &HTTPError{}
+		if err := json.Unmarshal(body, httpErr); err != nil {
+			httpErr.Message = strings.TrimSpace(string(body))
+		}
+		httpErr.StatusCode = resp.StatusCode
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			httpErr.RetryAfter = time.Duration(seconds) * time.Second
+		}
+		return httpErr
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
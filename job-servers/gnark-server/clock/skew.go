@@ -0,0 +1,120 @@
+package clock
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ntpEpochOffset is the number of seconds between 1900-01-01 and 1970-01-01.
+const ntpEpochOffset = 2208988800
+
+var lastSkew atomic.Int64
+
+// LastSkew returns the most recently measured offset of the local clock.
+func LastSkew() time.Duration {
+	return time.Duration(lastSkew.Load())
+}
+
+// RedisSkew measures how far the local clock is from the Redis server clock,
+// which every replica shares for locks, leases and schedules.
+func RedisSkew(ctx context.Context, rdb redis.UniversalClient) (time.Duration, error) {
+	sent := time.Now()
+	remote, err := rdb.Time(ctx).Result()
+	if err != nil {
+		return 0, err
+	}
+	received := time.Now()
+	local := sent.Add(received.Sub(sent) / 2)
+	return local.Sub(remote), nil
+}
+
+// NTPSkew measures the local clock offset against an NTP server with a
+// single SNTP request.
+func NTPSkew(server string) (time.Duration, error) {
+	conn, err := net.DialTimeout("udp", net.JoinHostPort(server, "123"), 5*time.Second)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	req := make([]byte, 48)
+	req[0] = 0x1B // LI = 0, VN = 3, Mode = 3 (client)
+	sent := time.Now()
+	if _, err := conn.Write(req); err != nil {
+		return 0, err
+	}
+	resp := make([]byte, 48)
+	if _, err := conn.Read(resp); err != nil {
+		return 0, err
+	}
+	received := time.Now()
+	serverReceived := ntpTime(resp[32:40])
+	serverSent := ntpTime(resp[40:48])
+	// offset = ((T2 - T1) + (T3 - T4)) / 2, negated to express local - server.
+	offset := (serverReceived.Sub(sent) + serverSent.Sub(received)) / 2
+	return -offset, nil
+}
+
+func ntpTime(b []byte) time.Time {
+	seconds := binary.BigEndian.Uint32(b[0:4])
+	fraction := binary.BigEndian.Uint32(b[4:8])
+	nanos := (int64(fraction) * 1e9) >> 32
+	return time.Unix(int64(seconds)-ntpEpochOffset, nanos)
+}
+
+// Check measures the skew against Redis and, when configured, an NTP server,
+// and returns the largest one.
+func Check(ctx context.Context, rdb redis.UniversalClient, ntpServer string) (time.Duration, error) {
+	skew, err := RedisSkew(ctx, rdb)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read Redis time: %w", err)
+	}
+	if ntpServer != "" {
+		ntpSkew, err := NTPSkew(ntpServer)
+		if err != nil {
+			return 0, fmt.Errorf("failed to query NTP server %s: %w", ntpServer, err)
+		}
+		if abs(ntpSkew) > abs(skew) {
+			skew = ntpSkew
+		}
+	}
+	lastSkew.Store(int64(skew))
+	return skew, nil
+}
+
+// Monitor periodically checks the clock and logs an alert whenever the skew
+// exceeds maxSkew.
+func Monitor(ctx context.Context, rdb redis.UniversalClient, ntpServer string, maxSkew time.Duration, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		skew, err := Check(ctx, rdb, ntpServer)
+		if err != nil {
+			log.Printf("Clock skew check failed: %v\n", err)
+			continue
+		}
+		if abs(skew) > maxSkew {
+			log.Printf("ALERT clock skew of %s exceeds MAX_CLOCK_SKEW %s\n", skew, maxSkew)
+		}
+	}
+}
+
+func abs(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
@@ -0,0 +1,960 @@
+package config
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math"
+	"math/big"
+	"net/url"
+	"os"
+	"regexp"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"gnark-server/atrest"
+	"gnark-server/cors"
+	"gnark-server/ipfilter"
+	"gnark-server/prover"
+	"gnark-server/rediskey"
+)
+
+var redisKeyPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]*$`)
+
+type Config struct {
+	Port        string
+	RedisURL    string
+	APIKeysFile string
+	AdminAPIKey string
+
+	// UnixSocket is the path of a unix socket served besides PORT, or
+	// instead of it when PORT is unset, created with UnixSocketMode.
+	UnixSocket     string
+	UnixSocketMode os.FileMode
+
+	// RedisSentinelAddrs and RedisSentinelMaster select the master through
+	// Sentinel, and RedisClusterAddrs a Redis Cluster, instead of RedisURL.
+	// Both use RedisUsername, RedisPassword and RedisTLS, and Sentinel also
+	// RedisSentinelPassword and RedisDB, which also overrides the database of
+	// RedisURL when set.
+	RedisSentinelAddrs    []string
+	RedisSentinelMaster   string
+	RedisSentinelPassword string
+	RedisClusterAddrs     []string
+	RedisUsername         string
+	RedisPassword         string
+	RedisDB               int
+	RedisTLS              bool
+
+	// Every Redis key starts with RedisNamespace and a colon, when set, then
+	// RedisKeyPrefix, so that instances and environments can share a Redis.
+	RedisNamespace string
+	RedisKeyPrefix string
+
+	// Failed Redis commands are retried RedisMaxRetries times, the delay
+	// doubling from RedisMinRetryBackoff up to RedisMaxRetryBackoff. After
+	// RedisBreakerThreshold consecutive failures (0: never), commands fail
+	// right away for RedisBreakerCooldown.
+	RedisMaxRetries       int
+	RedisMinRetryBackoff  time.Duration
+	RedisMaxRetryBackoff  time.Duration
+	RedisBreakerThreshold int
+	RedisBreakerCooldown  time.Duration
+
+	// Final results that cannot be stored are kept in memory, up to
+	// ResultBufferSize of them for ResultBufferTTL, served from there and
+	// stored once Redis is back.
+	ResultBufferSize int
+	ResultBufferTTL  time.Duration
+	// ResultSpoolDir, when set, is where the results of successful jobs
+	// that cannot be stored are also written, until they are.
+	ResultSpoolDir string
+
+	// ResultTTL is how long job results, idempotency keys and cached results are kept.
+	ResultTTL time.Duration
+	// MaxResultTTL bounds the result retention a start-proof may ask for.
+	MaxResultTTL time.Duration
+	// MaxScheduleDelay bounds how far ahead the notBefore of a start-proof
+	// may be; scheduled jobs are kept in Redis for RESULT_TTL meanwhile.
+	MaxScheduleDelay time.Duration
+	// ResultStore is where job results are kept: redis, postgres, at
+	// PostgresURL with up to PostgresMaxConns connections, or memory, which
+	// keeps all Redis state in-process and needs no REDIS_URL. Postgres needs
+	// no Redis either, the other state then being kept in-process. Expired
+	// results stay in Postgres as job history for PostgresHistoryRetention.
+	ResultStore              string
+	PostgresURL              string
+	PostgresMaxConns         int
+	PostgresHistoryRetention time.Duration
+
+	// AuditLog is where the audit trail of submissions and admin actions is
+	// kept: redis, postgres (at PostgresURL), or nowhere when empty. Events
+	// older than AuditRetention are pruned, none if it is 0.
+	AuditLog       string
+	AuditRetention time.Duration
+
+	PreVerify bool
+	// SelfVerify verifies every produced proof against the verifying key
+	// before a job succeeds; SelfVerifyCircuits overrides it per circuit.
+	SelfVerify         bool
+	SelfVerifyCircuits map[string]bool
+
+	// CanaryCircuit, when set, is another version of the circuit, in its
+	// own data directory, loaded next to it to prove CanaryPercent of the
+	// jobs.
+	CanaryCircuit string
+	CanaryPercent float64
+	// StandbyCircuit, when set, is loaded next to the circuit to be made
+	// active with POST /admin/circuit/flip; a self-verification failure
+	// within FlipProbation of a flip flips back.
+	StandbyCircuit string
+	FlipProbation  time.Duration
+
+	LazyProvingKey bool
+	MmapProvingKey bool
+	ProverBackend  string
+	// ProverWorkers is the number of concurrent proves, sharing ProverCPUs
+	// cores (0: all cores).
+	ProverWorkers int
+	ProverCPUs    int
+	JobTimeout    time.Duration
+	// JobMaxAttempts bounds the runs of a timed-out job or one interrupted by
+	// a restart, and the writes of a job result.
+	JobMaxAttempts  int
+	JobRetryBackoff time.Duration
+	// JobHeartbeatInterval is how often running jobs refresh their
+	// heartbeat; jobs whose heartbeat is older than JobHeartbeatTTL are
+	// requeued by another node.
+	JobHeartbeatInterval time.Duration
+	JobHeartbeatTTL      time.Duration
+	// LeaderElection runs the singleton duties (webhook deliveries, the
+	// reaper, history pruning) on one replica, elected for LeaderLease.
+	LeaderElection bool
+	LeaderLease    time.Duration
+	// StartupRecovery is what a node does at startup with the jobs it had
+	// not finished: requeue or fail them.
+	StartupRecovery string
+	// QueueBackend holds the jobs waiting for a worker: memory, on this
+	// node, or nats, a JetStream stream at NATSURL shared by every node; with
+	// kafka, nodes also consume start-proof requests from Kafka.
+	QueueBackend string
+	NATSURL      string
+	NATSStream   string
+	NATSSubject  string
+	// NATSAckWait is how long a job of a stopped node waits before being
+	// delivered again.
+	NATSAckWait  time.Duration
+	NATSReplicas int
+
+	// KafkaTopics are consumed as members of KafkaGroup, from
+	// KafkaStartOffset (earliest or latest) when the group has no offset.
+	KafkaBrokers     []string
+	KafkaTopics      []string
+	KafkaGroup       string
+	KafkaTLS         bool
+	KafkaUsername    string
+	KafkaPassword    string
+	KafkaStartOffset string
+	// KafkaMaxInFlight bounds the records a node has read and not finished
+	// (0: twice ProverWorkers).
+	KafkaMaxInFlight    int
+	KafkaSessionTimeout time.Duration
+	KafkaTenant         string
+	// MaxQueueDepth bounds the jobs waiting for a worker (0: unbounded).
+	MaxQueueDepth   int
+	QueueRetryAfter time.Duration
+	// MaxPendingJobsPerKey is the default cap on the queued and running jobs
+	// of an API key (0: unbounded).
+	MaxPendingJobsPerKey int
+	// HighPriorityBurst is the number of high-priority jobs proven in a row
+	// before a waiting low-priority one.
+	HighPriorityBurst int
+	// ResultCompression is how large records (results, cached results, job
+	// inputs) are compressed in Redis: zstd, gzip or none.
+	ResultCompression string
+	// ResultEncryptionKey (32 bytes in hex or base64), or the key in
+	// ResultEncryptionKeyFile, or the data key decrypted by AWS KMS from
+	// ResultEncryptionKMSCiphertext, encrypts the records stored in Redis
+	// with AES-256-GCM. ResultEncryptionPreviousKeys only decrypt, for
+	// records written before a key rotation.
+	ResultEncryptionKey           string
+	ResultEncryptionKeyFile       string
+	ResultEncryptionKMSCiphertext string
+	ResultEncryptionKMSRegion     string
+	ResultEncryptionPreviousKeys  []string
+	// AWS credentials for KMS.
+	AWSAccessKeyID     string
+	AWSSecretAccessKey string
+	AWSSessionToken    string
+	// ObjectStoreEndpoint, when set, is an S3-compatible store the results
+	// of successful jobs of at least ObjectStoreMinBytes are uploaded to;
+	// Redis keeps a pointer to them for ObjectStoreResultTTL.
+	ObjectStoreEndpoint        string
+	ObjectStoreBucket          string
+	ObjectStoreRegion          string
+	ObjectStoreAccessKeyID     string
+	ObjectStoreSecretAccessKey string
+	ObjectStorePrefix          string
+	ObjectStoreMinBytes        int
+	ObjectStoreResultTTL       time.Duration
+
+	// DeadLetterTTL is how long failed jobs are kept, with their input, in
+	// the dead-letter queue.
+	DeadLetterTTL time.Duration
+
+	// JobInputTTL is how long the proof JSON of every job is kept, for
+	// /jobs/<jobId>/input (0: not kept).
+	JobInputTTL time.Duration
+
+	// UsageRetention is how long the daily usage counters of API keys are
+	// kept.
+	UsageRetention time.Duration
+
+	// WarmUpProve proves WarmUpProofFile (default: the circuit's sample
+	// proof) at startup before reporting ready.
+	WarmUpProve     bool
+	WarmUpProofFile string
+
+	WebhookMaxAttempts int
+	WebhookMaxAge      time.Duration
+
+	MaxRequestBodyBytes        int64
+	MaxInflightBodyMemoryBytes int64
+	BodySpoolDir               string
+	BodySpoolMaxBytes          int64
+
+	RacePeers      []string
+	RacePeerAPIKey string
+
+	RelayerRPCURL     string
+	RelayerPrivateKey string
+	RelayerContract   string
+	RelayerMethod     string
+	RelayerGasLimit   int64
+	// RelayerTimeout bounds the on-chain submission and the simulation of
+	// each proof.
+	RelayerTimeout time.Duration
+
+	SimulationRPCURL string
+	VerifierContract string
+
+	// PaymentVerifier, when set, requires start-proof requests to carry a
+	// payment reference checked before their jobs are queued: by the
+	// service at PaymentServiceURL (http), or as the hash of a transaction
+	// paying PaymentMinFee wei to PaymentRecipient on PaymentRPCURL with
+	// PaymentConfirmations (onchain).
+	PaymentVerifier      string
+	PaymentServiceURL    string
+	PaymentServiceToken  string
+	PaymentTimeout       time.Duration
+	PaymentRPCURL        string
+	PaymentRecipient     string
+	PaymentMinFee        string
+	PaymentConfirmations int
+
+	MaxClockSkew           time.Duration
+	ClockSkewCheckInterval time.Duration
+	NTPServer              string
+
+	ArtifactPeers            []string
+	ArtifactShareKey         string
+	ArtifactFetchParallelism int
+	// ArtifactStoreURL, an s3:// or gs:// URL, is where the node downloads
+	// the circuit artifacts it lacks or that changed at startup.
+	ArtifactStoreURL             string
+	ArtifactStoreEndpoint        string
+	ArtifactStoreRegion          string
+	ArtifactStoreAccessKeyID     string
+	ArtifactStoreSecretAccessKey string
+	// ArtifactManifestRequired refuses to load artifacts without a manifest
+	// file; with one they are always verified against it.
+	ArtifactManifestRequired bool
+
+	ServiceTokenSecret          string
+	ServiceTokenPreviousSecrets []string
+	ServiceTokenMaxTTL          time.Duration
+
+	// ReceiptSigningKey is the hex-encoded Ed25519 seed submission receipts
+	// are signed with; receipts are disabled when it is empty.
+	ReceiptSigningKey string
+	// ResultSigningKey is the hex-encoded secp256k1 private key proof
+	// results are signed with; results are not signed when it is empty.
+	ResultSigningKey string
+	// TEEAttestation is the trusted execution environment to attest: auto,
+	// sgx, sev-snp, tdx or nitro; attestation is disabled when empty.
+	TEEAttestation string
+
+	// NodeID identifies this replica in fleet reports.
+	NodeID              string
+	FleetReportInterval time.Duration
+
+	GCTuning         bool
+	ProveGCPercent   int
+	ProveMemoryLimit int64
+	IdleMemoryLimit  int64
+
+	// ProveMemoryFootprint is the memory one prove needs, in bytes; proves
+	// wait up to MemoryAdmissionTimeout for that much to be available.
+	ProveMemoryFootprint   int64
+	MemoryAdmissionTimeout time.Duration
+
+	// PprofEnabled serves the net/http/pprof profiles to admins.
+	PprofEnabled bool
+
+	// OpenAPIUI serves a Swagger UI for /openapi.json at /docs.
+	OpenAPIUI bool
+
+	// AccessLog logs a sample of AccessLogSampleRate of the requests, every
+	// failed request and those slower than AccessLogSlowThreshold.
+	// AccessLogSkipPaths are only logged when failed or slow.
+	AccessLog              bool
+	AccessLogSampleRate    float64
+	AccessLogSlowThreshold time.Duration
+	AccessLogSkipPaths     []string
+
+	// IPAllowlist, AdminIPAllowlist (for the admin API, /jobs and pprof)
+	// and IPAllowlistPaths (per path prefix) are the CIDRs allowed to reach
+	// the server; empty lists allow every address. Requests from
+	// TrustedProxies are filtered by the client address they forward.
+	IPAllowlist      []string
+	AdminIPAllowlist []string
+	IPAllowlistPaths map[string][]string
+	TrustedProxies   []string
+
+	// CORSAllowedOrigins are the browser origins allowed to call the server,
+	// none by default, with the methods and request headers they may use.
+	CORSAllowedOrigins []string
+	CORSAllowedMethods []string
+	CORSAllowedHeaders []string
+	CORSExposedHeaders []string
+	CORSMaxAge         time.Duration
+
+	// SLOPercentile of each tenant's job latency over SLOWindow must stay
+	// within SLOLatencyTarget.
+	SLOWindow        time.Duration
+	SLOLatencyTarget time.Duration
+	SLOPercentile    float64
+	SLOEvalInterval  time.Duration
+	SLOBreachPeriods int
+}
+
+func Load() (*Config, error) {
+	env := &envReader{}
+	cfg := &Config{
+		Port:        env.String("PORT", ""),
+		RedisURL:    env.String("REDIS_URL", ""),
+		APIKeysFile: env.String("API_KEYS_FILE", ""),
+		AdminAPIKey: env.String("ADMIN_API_KEY", ""),
+
+		UnixSocket:     env.String("UNIX_SOCKET", ""),
+		UnixSocketMode: env.FileMode("UNIX_SOCKET_MODE", 0660),
+
+		RedisSentinelAddrs:    env.List("REDIS_SENTINEL_ADDRS"),
+		RedisSentinelMaster:   env.String("REDIS_SENTINEL_MASTER", ""),
+		RedisSentinelPassword: env.String("REDIS_SENTINEL_PASSWORD", ""),
+		RedisClusterAddrs:     env.List("REDIS_CLUSTER_ADDRS"),
+		RedisUsername:         env.String("REDIS_USERNAME", ""),
+		RedisPassword:         env.String("REDIS_PASSWORD", ""),
+		RedisDB:               env.Int("REDIS_DB", 0),
+		RedisTLS:              env.Bool("REDIS_TLS", false),
+
+		RedisNamespace: env.String("REDIS_NAMESPACE", ""),
+		RedisKeyPrefix: env.String("REDIS_KEY_PREFIX", rediskey.DefaultPrefix),
+
+		RedisMaxRetries:       env.Int("REDIS_MAX_RETRIES", 3),
+		RedisMinRetryBackoff:  env.Duration("REDIS_MIN_RETRY_BACKOFF", 8*time.Millisecond),
+		RedisMaxRetryBackoff:  env.Duration("REDIS_MAX_RETRY_BACKOFF", 512*time.Millisecond),
+		RedisBreakerThreshold: env.Int("REDIS_BREAKER_THRESHOLD", 5),
+		RedisBreakerCooldown:  env.Duration("REDIS_BREAKER_COOLDOWN", 5*time.Second),
+
+		ResultBufferSize: env.Int("RESULT_BUFFER_SIZE", 1000),
+		ResultBufferTTL:  env.Duration("RESULT_BUFFER_TTL", 10*time.Minute),
+		ResultSpoolDir:   env.String("RESULT_SPOOL_DIR", ""),
+
+		ResultTTL:    env.Duration("RESULT_TTL", 24*time.Hour),
+		MaxResultTTL: env.Duration("MAX_RESULT_TTL", 7*24*time.Hour),
+		PreVerify:    env.Bool("PRE_VERIFY_PROOF", true),
+
+		MaxScheduleDelay: env.Duration("MAX_SCHEDULE_DELAY", 12*time.Hour),
+
+		ResultStore:              env.String("RESULT_STORE", "redis"),
+		PostgresURL:              env.String("POSTGRES_URL", ""),
+		PostgresMaxConns:         env.Int("POSTGRES_MAX_CONNS", 8),
+		PostgresHistoryRetention: env.Duration("POSTGRES_HISTORY_RETENTION", 30*24*time.Hour),
+
+		AuditLog:       env.String("AUDIT_LOG", ""),
+		AuditRetention: env.Duration("AUDIT_RETENTION", 0),
+
+		SelfVerify:         env.Bool("SELF_VERIFY", true),
+		SelfVerifyCircuits: env.BoolMap("SELF_VERIFY_CIRCUITS"),
+
+		CanaryCircuit: env.String("CANARY_CIRCUIT", ""),
+		CanaryPercent: env.Float64("CANARY_PERCENT", 10),
+
+		StandbyCircuit: env.String("STANDBY_CIRCUIT", ""),
+		FlipProbation:  env.Duration("FLIP_PROBATION", 10*time.Minute),
+
+		LazyProvingKey: env.Bool("LAZY_PROVING_KEY", false),
+		MmapProvingKey: env.Bool("MMAP_PROVING_KEY", false),
+		ProverBackend:  env.String("PROVER_BACKEND", "cpu"),
+		ProverWorkers:  env.Int("PROVER_WORKERS", 1),
+		ProverCPUs:     env.Int("PROVER_CPUS", 0),
+		JobTimeout:     env.Duration("JOB_TIMEOUT", 0),
+
+		JobMaxAttempts:  env.Int("JOB_MAX_ATTEMPTS", 3),
+		JobRetryBackoff: env.Duration("JOB_RETRY_BACKOFF", 10*time.Second),
+		DeadLetterTTL:   env.Duration("DEAD_LETTER_TTL", 7*24*time.Hour),
+		JobInputTTL:     env.Duration("JOB_INPUT_TTL", 24*time.Hour),
+		UsageRetention:  env.Duration("USAGE_RETENTION", 35*24*time.Hour),
+
+		JobHeartbeatInterval: env.Duration("JOB_HEARTBEAT_INTERVAL", 10*time.Second),
+		JobHeartbeatTTL:      env.Duration("JOB_HEARTBEAT_TTL", time.Minute),
+		StartupRecovery:      env.String("STARTUP_RECOVERY", "requeue"),
+
+		LeaderElection: env.Bool("LEADER_ELECTION", false),
+		LeaderLease:    env.Duration("LEADER_LEASE", 30*time.Second),
+
+		QueueBackend: env.String("QUEUE_BACKEND", "memory"),
+		NATSURL:      env.String("NATS_URL", "nats://localhost:4222"),
+		NATSStream:   env.String("NATS_STREAM", "GNARK_JOBS"),
+		NATSSubject:  env.String("NATS_SUBJECT", "gnark.jobs"),
+		NATSAckWait:  env.Duration("NATS_ACK_WAIT", time.Minute),
+		NATSReplicas: env.Int("NATS_REPLICAS", 1),
+
+		KafkaBrokers:        env.List("KAFKA_BROKERS"),
+		KafkaTopics:         env.List("KAFKA_TOPICS"),
+		KafkaGroup:          env.String("KAFKA_GROUP", "gnark-server"),
+		KafkaTLS:            env.Bool("KAFKA_TLS", false),
+		KafkaUsername:       env.String("KAFKA_USERNAME", ""),
+		KafkaPassword:       env.String("KAFKA_PASSWORD", ""),
+		KafkaStartOffset:    env.String("KAFKA_START_OFFSET", "earliest"),
+		KafkaMaxInFlight:    env.Int("KAFKA_MAX_IN_FLIGHT", 0),
+		KafkaSessionTimeout: env.Duration("KAFKA_SESSION_TIMEOUT", 30*time.Second),
+		KafkaTenant:         env.String("KAFKA_TENANT", "kafka"),
+
+		MaxQueueDepth:   env.Int("MAX_QUEUE_DEPTH", 0),
+		QueueRetryAfter: env.Duration("QUEUE_RETRY_AFTER", 30*time.Second),
+
+		ResultCompression: env.String("RESULT_COMPRESSION", "zstd"),
+
+		ResultEncryptionKey:           env.String("RESULT_ENCRYPTION_KEY", ""),
+		ResultEncryptionKeyFile:       env.String("RESULT_ENCRYPTION_KEY_FILE", ""),
+		ResultEncryptionKMSCiphertext: env.String("RESULT_ENCRYPTION_KMS_CIPHERTEXT", ""),
+		ResultEncryptionKMSRegion:     env.String("RESULT_ENCRYPTION_KMS_REGION", env.String("AWS_REGION", "us-east-1")),
+		ResultEncryptionPreviousKeys:  env.List("RESULT_ENCRYPTION_PREVIOUS_KEYS"),
+		AWSAccessKeyID:                env.String("AWS_ACCESS_KEY_ID", ""),
+		AWSSecretAccessKey:            env.String("AWS_SECRET_ACCESS_KEY", ""),
+		AWSSessionToken:               env.String("AWS_SESSION_TOKEN", ""),
+
+		ObjectStoreEndpoint:        env.String("OBJECT_STORE_ENDPOINT", ""),
+		ObjectStoreBucket:          env.String("OBJECT_STORE_BUCKET", ""),
+		ObjectStoreRegion:          env.String("OBJECT_STORE_REGION", "us-east-1"),
+		ObjectStoreAccessKeyID:     env.String("OBJECT_STORE_ACCESS_KEY_ID", ""),
+		ObjectStoreSecretAccessKey: env.String("OBJECT_STORE_SECRET_ACCESS_KEY", ""),
+		ObjectStorePrefix:          env.String("OBJECT_STORE_PREFIX", "gnark-server/"),
+		ObjectStoreMinBytes:        env.Int("OBJECT_STORE_MIN_BYTES", 0),
+		ObjectStoreResultTTL:       env.Duration("OBJECT_STORE_RESULT_TTL", 30*24*time.Hour),
+
+		MaxPendingJobsPerKey: env.Int("MAX_PENDING_JOBS_PER_KEY", 0),
+		HighPriorityBurst:    env.Int("HIGH_PRIORITY_BURST", 4),
+
+		WarmUpProve:     env.Bool("WARMUP_PROVE", false),
+		WarmUpProofFile: env.String("WARMUP_PROOF_FILE", ""),
+
+		WebhookMaxAttempts: env.Int("WEBHOOK_MAX_ATTEMPTS", 10),
+		WebhookMaxAge:      env.Duration("WEBHOOK_MAX_AGE", time.Hour),
+
+		MaxRequestBodyBytes:        env.Int64("MAX_REQUEST_BODY_BYTES", 64<<20),
+		MaxInflightBodyMemoryBytes: env.Int64("MAX_INFLIGHT_BODY_MEMORY_BYTES", 256<<20),
+		BodySpoolDir:               env.String("BODY_SPOOL_DIR", ""),
+		BodySpoolMaxBytes:          env.Int64("BODY_SPOOL_MAX_BYTES", 4<<30),
+
+		RacePeers:      env.List("RACE_PEERS"),
+		RacePeerAPIKey: env.String("RACE_PEER_API_KEY", ""),
+
+		RelayerRPCURL:     env.String("RELAYER_RPC_URL", ""),
+		RelayerPrivateKey: env.String("RELAYER_PRIVATE_KEY", ""),
+		RelayerContract:   env.String("RELAYER_CONTRACT", ""),
+		RelayerMethod:     env.String("RELAYER_METHOD", ""),
+		RelayerGasLimit:   env.Int64("RELAYER_GAS_LIMIT", 0),
+		RelayerTimeout:    env.Duration("RELAYER_TIMEOUT", 30*time.Second),
+
+		SimulationRPCURL: env.String("SIMULATION_RPC_URL", env.String("RELAYER_RPC_URL", "")),
+		VerifierContract: env.String("VERIFIER_CONTRACT", ""),
+
+		PaymentVerifier:      env.String("PAYMENT_VERIFIER", ""),
+		PaymentServiceURL:    env.String("PAYMENT_SERVICE_URL", ""),
+		PaymentServiceToken:  env.String("PAYMENT_SERVICE_TOKEN", ""),
+		PaymentTimeout:       env.Duration("PAYMENT_TIMEOUT", 10*time.Second),
+		PaymentRPCURL:        env.String("PAYMENT_RPC_URL", env.String("RELAYER_RPC_URL", "")),
+		PaymentRecipient:     env.String("PAYMENT_RECIPIENT", ""),
+		PaymentMinFee:        env.String("PAYMENT_MIN_FEE", "0"),
+		PaymentConfirmations: env.Int("PAYMENT_CONFIRMATIONS", 1),
+
+		MaxClockSkew:           env.Duration("MAX_CLOCK_SKEW", 2*time.Second),
+		ClockSkewCheckInterval: env.Duration("CLOCK_SKEW_CHECK_INTERVAL", 5*time.Minute),
+		NTPServer:              env.String("NTP_SERVER", ""),
+
+		ArtifactPeers:                env.List("ARTIFACT_PEERS"),
+		ArtifactShareKey:             env.String("ARTIFACT_SHARE_KEY", ""),
+		ArtifactFetchParallelism:     env.Int("ARTIFACT_FETCH_PARALLELISM", 4),
+		ArtifactStoreURL:             env.String("ARTIFACT_STORE_URL", ""),
+		ArtifactStoreEndpoint:        env.String("ARTIFACT_STORE_ENDPOINT", ""),
+		ArtifactStoreRegion:          env.String("ARTIFACT_STORE_REGION", ""),
+		ArtifactStoreAccessKeyID:     env.String("ARTIFACT_STORE_ACCESS_KEY_ID", env.String("OBJECT_STORE_ACCESS_KEY_ID", "")),
+		ArtifactStoreSecretAccessKey: env.String("ARTIFACT_STORE_SECRET_ACCESS_KEY", env.String("OBJECT_STORE_SECRET_ACCESS_KEY", "")),
+		ArtifactManifestRequired:     env.Bool("ARTIFACT_MANIFEST_REQUIRED", false),
+
+		ServiceTokenSecret:          env.String("SERVICE_TOKEN_SECRET", ""),
+		ServiceTokenPreviousSecrets: env.List("SERVICE_TOKEN_PREVIOUS_SECRETS"),
+		ServiceTokenMaxTTL:          env.Duration("SERVICE_TOKEN_MAX_TTL", 24*time.Hour),
+
+		ReceiptSigningKey: env.String("RECEIPT_SIGNING_KEY", ""),
+		ResultSigningKey:  env.String("RESULT_SIGNING_KEY", ""),
+		TEEAttestation:    env.String("TEE_ATTESTATION", ""),
+
+		NodeID:              env.String("NODE_ID", hostname()),
+		FleetReportInterval: env.Duration("FLEET_REPORT_INTERVAL", time.Minute),
+
+		GCTuning:         env.Bool("GC_TUNING", false),
+		ProveGCPercent:   env.Int("PROVE_GOGC", 400),
+		ProveMemoryLimit: env.Int64("PROVE_MEMORY_LIMIT", 0),
+		IdleMemoryLimit:  env.Int64("IDLE_MEMORY_LIMIT", 0),
+
+		ProveMemoryFootprint:   env.Int64("PROVE_MEMORY_FOOTPRINT", 0),
+		MemoryAdmissionTimeout: env.Duration("MEMORY_ADMISSION_TIMEOUT", 10*time.Minute),
+
+		PprofEnabled: env.Bool("PPROF_ENABLED", false),
+
+		OpenAPIUI: env.Bool("OPENAPI_UI", false),
+
+		AccessLog:              env.Bool("ACCESS_LOG", false),
+		AccessLogSampleRate:    env.Float64("ACCESS_LOG_SAMPLE_RATE", 1),
+		AccessLogSlowThreshold: env.Duration("ACCESS_LOG_SLOW_THRESHOLD", 5*time.Second),
+		AccessLogSkipPaths:     env.ListDefault("ACCESS_LOG_SKIP_PATHS", []string{"/health", "/ready", "/metrics"}),
+
+		IPAllowlist:      env.List("IP_ALLOWLIST"),
+		AdminIPAllowlist: env.List("ADMIN_IP_ALLOWLIST"),
+		IPAllowlistPaths: env.ListMap("IP_ALLOWLIST_PATHS"),
+		TrustedProxies:   env.List("TRUSTED_PROXIES"),
+
+		CORSAllowedOrigins: env.List("CORS_ALLOWED_ORIGINS"),
+		CORSAllowedMethods: env.ListDefault("CORS_ALLOWED_METHODS", []string{"GET", "POST"}),
+		CORSAllowedHeaders: env.ListDefault("CORS_ALLOWED_HEADERS", []string{"Content-Type", "Accept", "Authorization", "X-API-Key", "Idempotency-Key", "X-Request-Id"}),
+		CORSExposedHeaders: env.ListDefault("CORS_EXPOSED_HEADERS", []string{"Retry-After", "X-Request-Id"}),
+		CORSMaxAge:         env.Duration("CORS_MAX_AGE", 10*time.Minute),
+
+		SLOWindow:        env.Duration("SLO_WINDOW", time.Hour),
+		SLOLatencyTarget: env.Duration("SLO_LATENCY_TARGET", 10*time.Minute),
+		SLOPercentile:    env.Float64("SLO_PERCENTILE", 99),
+		SLOEvalInterval:  env.Duration("SLO_EVAL_INTERVAL", time.Minute),
+		SLOBreachPeriods: env.Int("SLO_BREACH_PERIODS", 5),
+	}
+	if env.err != nil {
+		return nil, env.err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (c *Config) redisModes() int {
+	modes := 0
+	for _, set := range []bool{c.RedisURL != "", len(c.RedisSentinelAddrs) > 0, len(c.RedisClusterAddrs) > 0} {
+		if set {
+			modes++
+		}
+	}
+	return modes
+}
+
+// SharedRedis reports whether the server state lives in a Redis shared by
+// the nodes rather than in-process: with RESULT_STORE=memory, or postgres
+// without REDIS_URL, REDIS_SENTINEL_ADDRS or REDIS_CLUSTER_ADDRS, it does not.
+func (c *Config) SharedRedis() bool {
+	return c.ResultStore != "memory" && c.redisModes() > 0
+}
+
+// Validate checks required settings and the relationships between TTLs,
+// timeouts and limits, so that a misconfiguration stops the server at
+// startup instead of surfacing as expired results or stuck jobs later.
+func (c *Config) Validate() error {
+	if c.Port == "" && c.UnixSocket == "" {
+		return fmt.Errorf("neither PORT nor UNIX_SOCKET environment variable is set")
+	}
+	modes := c.redisModes()
+	if modes > 1 {
+		return fmt.Errorf("only one of REDIS_URL, REDIS_SENTINEL_ADDRS and REDIS_CLUSTER_ADDRS may be set")
+	}
+	if modes == 0 && c.ResultStore == "redis" {
+		return fmt.Errorf("REDIS_URL environment variable is not set")
+	}
+	if len(c.RedisSentinelAddrs) > 0 && c.RedisSentinelMaster == "" {
+		return fmt.Errorf("REDIS_SENTINEL_MASTER is required with REDIS_SENTINEL_ADDRS")
+	}
+	if len(c.RedisClusterAddrs) > 0 && c.RedisDB != 0 {
+		return fmt.Errorf("REDIS_DB is not supported by Redis Cluster")
+	}
+	if c.RedisDB < 0 {
+		return fmt.Errorf("REDIS_DB must not be negative")
+	}
+	if !redisKeyPattern.MatchString(c.RedisNamespace) || strings.Contains(c.RedisNamespace, ":") {
+		return fmt.Errorf("REDIS_NAMESPACE must only contain letters, digits, '.', '_' and '-'")
+	}
+	if c.RedisKeyPrefix == "" || !redisKeyPattern.MatchString(c.RedisKeyPrefix) {
+		return fmt.Errorf("REDIS_KEY_PREFIX must be set and only contain letters, digits, '.', '_', '-' and ':'")
+	}
+	if c.RedisMaxRetries < 0 {
+		return fmt.Errorf("REDIS_MAX_RETRIES must not be negative")
+	}
+	if c.RedisMinRetryBackoff <= 0 || c.RedisMaxRetryBackoff < c.RedisMinRetryBackoff {
+		return fmt.Errorf("REDIS_MIN_RETRY_BACKOFF must be positive and at most REDIS_MAX_RETRY_BACKOFF")
+	}
+	if c.RedisBreakerThreshold < 0 {
+		return fmt.Errorf("REDIS_BREAKER_THRESHOLD must not be negative")
+	}
+	if c.RedisBreakerThreshold > 0 && c.RedisBreakerCooldown <= 0 {
+		return fmt.Errorf("REDIS_BREAKER_COOLDOWN must be positive")
+	}
+	if c.ResultBufferSize < 0 || c.ResultBufferTTL < 0 {
+		return fmt.Errorf("RESULT_BUFFER_SIZE and RESULT_BUFFER_TTL must not be negative")
+	}
+	if err := prover.ValidateBackend(c.ProverBackend); err != nil {
+		return err
+	}
+	if c.ProverWorkers <= 0 {
+		return fmt.Errorf("PROVER_WORKERS must be positive")
+	}
+	if c.ProverCPUs < 0 {
+		return fmt.Errorf("PROVER_CPUS must not be negative")
+	}
+	if c.ProverCPUs > 0 && c.ProverWorkers > c.ProverCPUs {
+		return fmt.Errorf("PROVER_WORKERS (%d) exceeds PROVER_CPUS (%d)", c.ProverWorkers, c.ProverCPUs)
+	}
+	if c.ResultTTL <= 0 {
+		return fmt.Errorf("RESULT_TTL must be positive")
+	}
+	if c.MaxResultTTL < c.ResultTTL {
+		return fmt.Errorf("MAX_RESULT_TTL (%s) must not be shorter than RESULT_TTL (%s)", c.MaxResultTTL, c.ResultTTL)
+	}
+	if c.CanaryPercent < 0 || c.CanaryPercent > 100 {
+		return fmt.Errorf("CANARY_PERCENT (%g) must be between 0 and 100", c.CanaryPercent)
+	}
+	if strings.ContainsAny(c.CanaryCircuit, "/\\") || strings.HasPrefix(c.CanaryCircuit, ".") {
+		return fmt.Errorf("CANARY_CIRCUIT must be a circuit name")
+	}
+	if strings.ContainsAny(c.StandbyCircuit, "/\\") || strings.HasPrefix(c.StandbyCircuit, ".") {
+		return fmt.Errorf("STANDBY_CIRCUIT must be a circuit name")
+	}
+	if c.FlipProbation < 0 {
+		return fmt.Errorf("FLIP_PROBATION must not be negative")
+	}
+	if c.MaxScheduleDelay < 0 || c.MaxScheduleDelay >= c.ResultTTL {
+		return fmt.Errorf("MAX_SCHEDULE_DELAY (%s) must be between 0 and RESULT_TTL (%s)", c.MaxScheduleDelay, c.ResultTTL)
+	}
+	switch c.ResultStore {
+	case "redis", "memory":
+	case "postgres":
+		if c.PostgresURL == "" {
+			return fmt.Errorf("POSTGRES_URL is required with RESULT_STORE=postgres")
+		}
+		if c.PostgresMaxConns <= 0 {
+			return fmt.Errorf("POSTGRES_MAX_CONNS must be positive")
+		}
+		if c.PostgresHistoryRetention < 0 {
+			return fmt.Errorf("POSTGRES_HISTORY_RETENTION must not be negative")
+		}
+	default:
+		return fmt.Errorf("RESULT_STORE must be redis, postgres or memory, not %q", c.ResultStore)
+	}
+	switch c.AuditLog {
+	case "", "redis":
+	case "postgres":
+		if c.PostgresURL == "" {
+			return fmt.Errorf("POSTGRES_URL is required with AUDIT_LOG=postgres")
+		}
+		if c.PostgresMaxConns <= 0 {
+			return fmt.Errorf("POSTGRES_MAX_CONNS must be positive")
+		}
+	default:
+		return fmt.Errorf("AUDIT_LOG must be redis or postgres, not %q", c.AuditLog)
+	}
+	if c.AuditRetention < 0 {
+		return fmt.Errorf("AUDIT_RETENTION must not be negative")
+	}
+	if c.JobTimeout < 0 {
+		return fmt.Errorf("JOB_TIMEOUT must not be negative")
+	}
+	if c.JobMaxAttempts <= 0 {
+		return fmt.Errorf("JOB_MAX_ATTEMPTS must be positive")
+	}
+	if c.JobRetryBackoff <= 0 {
+		return fmt.Errorf("JOB_RETRY_BACKOFF must be positive")
+	}
+	if c.JobHeartbeatInterval < 0 {
+		return fmt.Errorf("JOB_HEARTBEAT_INTERVAL must not be negative")
+	}
+	if c.JobHeartbeatInterval > 0 && c.JobHeartbeatTTL <= 2*c.JobHeartbeatInterval {
+		return fmt.Errorf("JOB_HEARTBEAT_TTL must be more than twice JOB_HEARTBEAT_INTERVAL")
+	}
+	if c.LeaderElection && c.LeaderLease < 3*time.Second {
+		return fmt.Errorf("LEADER_LEASE must be at least 3s")
+	}
+	if c.LeaderElection && !c.SharedRedis() {
+		return fmt.Errorf("LEADER_ELECTION needs a shared Redis")
+	}
+	if c.StartupRecovery != "requeue" && c.StartupRecovery != "fail" {
+		return fmt.Errorf("STARTUP_RECOVERY must be requeue or fail, not %q", c.StartupRecovery)
+	}
+	if c.QueueBackend != "memory" && c.QueueBackend != "nats" && c.QueueBackend != "kafka" {
+		return fmt.Errorf("QUEUE_BACKEND must be memory, nats or kafka, not %q", c.QueueBackend)
+	}
+	if c.QueueBackend == "kafka" {
+		if !c.SharedRedis() {
+			return fmt.Errorf("QUEUE_BACKEND=kafka needs a shared Redis")
+		}
+		if len(c.KafkaBrokers) == 0 || len(c.KafkaTopics) == 0 || c.KafkaGroup == "" {
+			return fmt.Errorf("QUEUE_BACKEND=kafka needs KAFKA_BROKERS, KAFKA_TOPICS and KAFKA_GROUP")
+		}
+		if c.KafkaStartOffset != "earliest" && c.KafkaStartOffset != "latest" {
+			return fmt.Errorf("KAFKA_START_OFFSET must be earliest or latest, not %q", c.KafkaStartOffset)
+		}
+		if c.KafkaMaxInFlight < 0 {
+			return fmt.Errorf("KAFKA_MAX_IN_FLIGHT must not be negative")
+		}
+		if c.KafkaSessionTimeout < 6*time.Second {
+			return fmt.Errorf("KAFKA_SESSION_TIMEOUT must be at least 6s")
+		}
+	}
+	if c.QueueBackend == "nats" {
+		if !c.SharedRedis() {
+			return fmt.Errorf("QUEUE_BACKEND=nats needs a shared Redis")
+		}
+		if c.NATSStream == "" || strings.ContainsAny(c.NATSStream, ".*> \t") {
+			return fmt.Errorf("NATS_STREAM must be a name without dots, wildcards or spaces")
+		}
+		if c.NATSSubject == "" || strings.ContainsAny(c.NATSSubject, "*> \t") {
+			return fmt.Errorf("NATS_SUBJECT must be a subject without wildcards or spaces")
+		}
+		if c.NATSAckWait < 3*time.Second {
+			return fmt.Errorf("NATS_ACK_WAIT must be at least 3s")
+		}
+		if c.NATSReplicas < 1 {
+			return fmt.Errorf("NATS_REPLICAS must be positive")
+		}
+	}
+	if c.MaxQueueDepth < 0 {
+		return fmt.Errorf("MAX_QUEUE_DEPTH must not be negative")
+	}
+	if c.MaxPendingJobsPerKey < 0 {
+		return fmt.Errorf("MAX_PENDING_JOBS_PER_KEY must not be negative")
+	}
+	if c.HighPriorityBurst <= 0 {
+		return fmt.Errorf("HIGH_PRIORITY_BURST must be positive")
+	}
+	if c.QueueRetryAfter <= 0 {
+		return fmt.Errorf("QUEUE_RETRY_AFTER must be positive")
+	}
+	switch c.ResultCompression {
+	case "zstd", "gzip", "none":
+	default:
+		return fmt.Errorf("RESULT_COMPRESSION must be zstd, gzip or none, not %q", c.ResultCompression)
+	}
+	keySources := 0
+	for _, set := range []bool{c.ResultEncryptionKey != "", c.ResultEncryptionKeyFile != "", c.ResultEncryptionKMSCiphertext != ""} {
+		if set {
+			keySources++
+		}
+	}
+	if keySources > 1 {
+		return fmt.Errorf("set only one of RESULT_ENCRYPTION_KEY, RESULT_ENCRYPTION_KEY_FILE and RESULT_ENCRYPTION_KMS_CIPHERTEXT")
+	}
+	if c.ResultEncryptionKey != "" {
+		if _, err := atrest.ParseKey(c.ResultEncryptionKey); err != nil {
+			return fmt.Errorf("RESULT_ENCRYPTION_KEY: %w", err)
+		}
+	}
+	for _, key := range c.ResultEncryptionPreviousKeys {
+		if _, err := atrest.ParseKey(key); err != nil {
+			return fmt.Errorf("RESULT_ENCRYPTION_PREVIOUS_KEYS: %w", err)
+		}
+	}
+	if len(c.ResultEncryptionPreviousKeys) > 0 && keySources == 0 {
+		return fmt.Errorf("RESULT_ENCRYPTION_PREVIOUS_KEYS needs a current encryption key")
+	}
+	if c.ResultEncryptionKMSCiphertext != "" && (c.AWSAccessKeyID == "" || c.AWSSecretAccessKey == "") {
+		return fmt.Errorf("RESULT_ENCRYPTION_KMS_CIPHERTEXT needs AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	if c.ObjectStoreEndpoint != "" && (c.ObjectStoreBucket == "" || c.ObjectStoreAccessKeyID == "" || c.ObjectStoreSecretAccessKey == "") {
+		return fmt.Errorf("OBJECT_STORE_BUCKET, OBJECT_STORE_ACCESS_KEY_ID and OBJECT_STORE_SECRET_ACCESS_KEY are required with OBJECT_STORE_ENDPOINT")
+	}
+	if c.ObjectStoreMinBytes < 0 {
+		return fmt.Errorf("OBJECT_STORE_MIN_BYTES must not be negative")
+	}
+	if c.ObjectStoreResultTTL < c.MaxResultTTL {
+		return fmt.Errorf("OBJECT_STORE_RESULT_TTL (%s) must not be shorter than MAX_RESULT_TTL (%s)", c.ObjectStoreResultTTL, c.MaxResultTTL)
+	}
+	if c.DeadLetterTTL <= 0 {
+		return fmt.Errorf("DEAD_LETTER_TTL must be positive")
+	}
+	if c.JobInputTTL < 0 {
+		return fmt.Errorf("JOB_INPUT_TTL must not be negative")
+	}
+	if c.UsageRetention < 24*time.Hour {
+		return fmt.Errorf("USAGE_RETENTION must be at least 24h")
+	}
+	if c.JobTimeout > c.ResultTTL {
+		return fmt.Errorf("JOB_TIMEOUT (%s) must not exceed RESULT_TTL (%s)", c.JobTimeout, c.ResultTTL)
+	}
+	if c.WebhookMaxAttempts <= 0 {
+		return fmt.Errorf("WEBHOOK_MAX_ATTEMPTS must be positive")
+	}
+	if c.WebhookMaxAge <= 0 {
+		return fmt.Errorf("WEBHOOK_MAX_AGE must be positive")
+	}
+	if c.WebhookMaxAge > c.ResultTTL {
+		return fmt.Errorf("WEBHOOK_MAX_AGE (%s) must not exceed RESULT_TTL (%s)", c.WebhookMaxAge, c.ResultTTL)
+	}
+	if c.MaxRequestBodyBytes <= 0 {
+		return fmt.Errorf("MAX_REQUEST_BODY_BYTES must be positive")
+	}
+	if c.MaxRequestBodyBytes > c.MaxInflightBodyMemoryBytes && c.MaxRequestBodyBytes > c.BodySpoolMaxBytes {
+		return fmt.Errorf("MAX_REQUEST_BODY_BYTES (%d) exceeds both MAX_INFLIGHT_BODY_MEMORY_BYTES and BODY_SPOOL_MAX_BYTES, so such bodies could never be accepted", c.MaxRequestBodyBytes)
+	}
+	if c.RelayerRPCURL != "" && c.RelayerMethod == "" {
+		return fmt.Errorf("RELAYER_METHOD environment variable is not set")
+	}
+	if c.VerifierContract != "" && c.SimulationRPCURL == "" {
+		return fmt.Errorf("SIMULATION_RPC_URL environment variable is not set")
+	}
+	if c.RelayerGasLimit < 0 {
+		return fmt.Errorf("RELAYER_GAS_LIMIT must not be negative")
+	}
+	if c.RelayerTimeout <= 0 {
+		return fmt.Errorf("RELAYER_TIMEOUT must be positive")
+	}
+	switch c.PaymentVerifier {
+	case "":
+	case "http":
+		if c.PaymentServiceURL == "" {
+			return fmt.Errorf("PAYMENT_SERVICE_URL is required with PAYMENT_VERIFIER=http")
+		}
+	case "onchain":
+		if c.PaymentRPCURL == "" || c.PaymentRecipient == "" {
+			return fmt.Errorf("PAYMENT_RPC_URL and PAYMENT_RECIPIENT are required with PAYMENT_VERIFIER=onchain")
+		}
+		if fee, ok := new(big.Int).SetString(c.PaymentMinFee, 10); !ok || fee.Sign() < 0 {
+			return fmt.Errorf("PAYMENT_MIN_FEE must be a non-negative amount of wei")
+		}
+		if c.PaymentConfirmations < 0 {
+			return fmt.Errorf("PAYMENT_CONFIRMATIONS must not be negative")
+		}
+	default:
+		return fmt.Errorf("unknown PAYMENT_VERIFIER %q (want http or onchain)", c.PaymentVerifier)
+	}
+	if len(c.ArtifactPeers) > 0 && c.ArtifactShareKey == "" {
+		return fmt.Errorf("ARTIFACT_SHARE_KEY is required to fetch artifacts from ARTIFACT_PEERS")
+	}
+	if c.ArtifactFetchParallelism <= 0 {
+		return fmt.Errorf("ARTIFACT_FETCH_PARALLELISM must be positive")
+	}
+	if c.ArtifactStoreURL != "" {
+		if u, err := url.Parse(c.ArtifactStoreURL); err != nil || (u.Scheme != "s3" && u.Scheme != "gs") || u.Host == "" {
+			return fmt.Errorf("ARTIFACT_STORE_URL must look like s3://bucket/prefix or gs://bucket/prefix")
+		}
+		if c.ArtifactStoreAccessKeyID == "" || c.ArtifactStoreSecretAccessKey == "" {
+			return fmt.Errorf("ARTIFACT_STORE_ACCESS_KEY_ID and ARTIFACT_STORE_SECRET_ACCESS_KEY are required with ARTIFACT_STORE_URL")
+		}
+	}
+	for name, cidrs := range map[string][]string{"IP_ALLOWLIST": c.IPAllowlist, "ADMIN_IP_ALLOWLIST": c.AdminIPAllowlist, "TRUSTED_PROXIES": c.TrustedProxies} {
+		if _, err := ipfilter.ParseCIDRs(cidrs); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	for prefix, cidrs := range c.IPAllowlistPaths {
+		if !strings.HasPrefix(prefix, "/") {
+			return fmt.Errorf("IP_ALLOWLIST_PATHS: %q is not a path prefix", prefix)
+		}
+		if _, err := ipfilter.ParseCIDRs(cidrs); err != nil {
+			return fmt.Errorf("IP_ALLOWLIST_PATHS: %s: %w", prefix, err)
+		}
+	}
+	for _, origin := range c.CORSAllowedOrigins {
+		if err := cors.ValidateOrigin(origin); err != nil {
+			return fmt.Errorf("CORS_ALLOWED_ORIGINS: %w", err)
+		}
+	}
+	if c.CORSMaxAge < 0 {
+		return fmt.Errorf("CORS_MAX_AGE must not be negative")
+	}
+	if c.AccessLogSampleRate < 0 || c.AccessLogSampleRate > 1 {
+		return fmt.Errorf("ACCESS_LOG_SAMPLE_RATE must be between 0 and 1")
+	}
+	if c.AccessLogSlowThreshold < 0 {
+		return fmt.Errorf("ACCESS_LOG_SLOW_THRESHOLD must not be negative")
+	}
+	if c.MaxClockSkew <= 0 {
+		return fmt.Errorf("MAX_CLOCK_SKEW must be positive")
+	}
+	if c.ServiceTokenSecret != "" && len(c.ServiceTokenSecret) < 32 {
+		return fmt.Errorf("SERVICE_TOKEN_SECRET must be at least 32 characters")
+	}
+	if c.ServiceTokenSecret == "" && len(c.ServiceTokenPreviousSecrets) > 0 {
+		return fmt.Errorf("SERVICE_TOKEN_PREVIOUS_SECRETS requires SERVICE_TOKEN_SECRET")
+	}
+	if c.ServiceTokenMaxTTL <= 0 {
+		return fmt.Errorf("SERVICE_TOKEN_MAX_TTL must be positive")
+	}
+	if c.ReceiptSigningKey != "" {
+		if seed, err := hex.DecodeString(c.ReceiptSigningKey); err != nil || len(seed) != 32 {
+			return fmt.Errorf("RECEIPT_SIGNING_KEY must be a hex-encoded 32-byte Ed25519 seed")
+		}
+	}
+	switch c.TEEAttestation {
+	case "", "auto", "sgx", "sev-snp", "tdx", "nitro":
+	default:
+		return fmt.Errorf("TEE_ATTESTATION must be one of auto, sgx, sev-snp, tdx or nitro")
+	}
+	if c.TEEAttestation != "" && c.ResultSigningKey == "" {
+		return fmt.Errorf("TEE_ATTESTATION requires RESULT_SIGNING_KEY, the key attestations vouch for")
+	}
+	if c.NodeID == "" {
+		return fmt.Errorf("NODE_ID environment variable is not set")
+	}
+	if c.FleetReportInterval <= 0 {
+		return fmt.Errorf("FLEET_REPORT_INTERVAL must be positive")
+	}
+	if c.ProveGCPercent <= 0 {
+		return fmt.Errorf("PROVE_GOGC must be positive")
+	}
+	if c.ProveMemoryLimit < 0 || c.IdleMemoryLimit < 0 {
+		return fmt.Errorf("PROVE_MEMORY_LIMIT and IDLE_MEMORY_LIMIT must not be negative")
+	}
+	// Without a memory limit, the heap grows to PROVE_GOGC/100+1 times the
+	// live heap of the proves before it is collected.
+	if c.GCTuning && c.ProveGCPercent > 100 && c.ProveMemoryLimit == 0 && debug.SetMemoryLimit(-1) == math.MaxInt64 {
+		return fmt.Errorf("PROVE_GOGC above 100 requires PROVE_MEMORY_LIMIT or GOMEMLIMIT")
+	}
+	if c.ProveMemoryFootprint < 0 {
+		return fmt.Errorf("PROVE_MEMORY_FOOTPRINT must not be negative")
+	}
+	if c.MemoryAdmissionTimeout <= 0 {
+		return fmt.Errorf("MEMORY_ADMISSION_TIMEOUT must be positive")
+	}
+	if c.SLOWindow <= 0 || c.SLOLatencyTarget <= 0 || c.SLOEvalInterval <= 0 {
+		return fmt.Errorf("SLO_WINDOW, SLO_LATENCY_TARGET and SLO_EVAL_INTERVAL must be positive")
+	}
+	if c.SLOPercentile <= 0 || c.SLOPercentile > 100 {
+		return fmt.Errorf("SLO_PERCENTILE must be in (0, 100]")
+	}
+	if c.SLOBreachPeriods <= 0 {
+		return fmt.Errorf("SLO_BREACH_PERIODS must be positive")
+	}
+	return nil
+}
+
+func hostname() string {
+	name, _ := os.Hostname()
+	return name
+}
@@ -0,0 +1,66 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// secretFields are reported only as set or unset.
+var secretFields = map[string]bool{
+	"RedisURL":                     true,
+	"AdminAPIKey":                  true,
+	"RacePeerAPIKey":               true,
+	"RelayerRPCURL":                true,
+	"SimulationRPCURL":             true,
+	"RelayerPrivateKey":            true,
+	"ArtifactShareKey":             true,
+	"ServiceTokenSecret":           true,
+	"ReceiptSigningKey":            true,
+	"ResultSigningKey":             true,
+	"ServiceTokenPreviousSecrets":  true,
+	"ObjectStoreSecretAccessKey":   true,
+	"PostgresURL":                  true,
+	"RedisPassword":                true,
+	"RedisSentinelPassword":        true,
+	"ArtifactStoreSecretAccessKey": true,
+	"NATSURL":                      true,
+	"KafkaPassword":                true,
+	"ResultEncryptionKey":          true,
+	"ResultEncryptionPreviousKeys": true,
+	"AWSSecretAccessKey":           true,
+	"AWSSessionToken":              true,
+	"PaymentServiceToken":          true,
+	"PaymentRPCURL":                true,
+}
+
+// nodeLocalFields legitimately differ between replicas and are left out.
+var nodeLocalFields = map[string]bool{
+	"NodeID":        true,
+	"RacePeers":     true,
+	"ArtifactPeers": true,
+	"BodySpoolDir":  true,
+}
+
+// Effective returns the settings that should be identical across replicas,
+// keyed by field name, with secrets masked.
+func (c *Config) Effective() map[string]string {
+	effective := make(map[string]string)
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Name
+		if nodeLocalFields[name] {
+			continue
+		}
+		value := fmt.Sprint(v.Field(i).Interface())
+		if secretFields[name] {
+			if v.Field(i).IsZero() {
+				value = "unset"
+			} else {
+				value = "set"
+			}
+		}
+		effective[name] = value
+	}
+	return effective
+}
@@ -0,0 +1,151 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envReader parses environment variables, keeping the first parsing error so
+// that Load can read every setting before reporting.
+type envReader struct {
+	err error
+}
+
+func (e *envReader) fail(name string, err error) {
+	if e.err == nil {
+		e.err = fmt.Errorf("%s parsing error: %w", name, err)
+	}
+}
+
+func (e *envReader) String(name string, defaultValue string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return defaultValue
+}
+
+func (e *envReader) List(name string) []string {
+	v := os.Getenv(name)
+	if v == "" {
+		return nil
+	}
+	return strings.Split(v, ",")
+}
+
+// ListDefault is List, defaultValue when the variable is unset.
+func (e *envReader) ListDefault(name string, defaultValue []string) []string {
+	if v := e.List(name); v != nil {
+		return v
+	}
+	return defaultValue
+}
+
+// BoolMap parses a comma-separated list of key=bool pairs.
+func (e *envReader) BoolMap(name string) map[string]bool {
+	m := make(map[string]bool)
+	for _, pair := range e.List(name) {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || key == "" {
+			e.fail(name, fmt.Errorf("%q is not a key=bool pair", pair))
+			continue
+		}
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			e.fail(name, err)
+			continue
+		}
+		m[key] = b
+	}
+	return m
+}
+
+// ListMap parses a comma-separated list of key=value pairs, each value a
+// space-separated list.
+func (e *envReader) ListMap(name string) map[string][]string {
+	m := make(map[string][]string)
+	for _, pair := range e.List(name) {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || key == "" {
+			e.fail(name, fmt.Errorf("%q is not a key=value pair", pair))
+			continue
+		}
+		m[key] = strings.Fields(value)
+	}
+	return m
+}
+
+func (e *envReader) Bool(name string, defaultValue bool) bool {
+	v := os.Getenv(name)
+	if v == "" {
+		return defaultValue
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		e.fail(name, err)
+	}
+	return b
+}
+
+func (e *envReader) Int(name string, defaultValue int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return defaultValue
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		e.fail(name, err)
+	}
+	return i
+}
+
+func (e *envReader) Int64(name string, defaultValue int64) int64 {
+	v := os.Getenv(name)
+	if v == "" {
+		return defaultValue
+	}
+	i, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		e.fail(name, err)
+	}
+	return i
+}
+
+func (e *envReader) Float64(name string, defaultValue float64) float64 {
+	v := os.Getenv(name)
+	if v == "" {
+		return defaultValue
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		e.fail(name, err)
+	}
+	return f
+}
+
+func (e *envReader) Duration(name string, defaultValue time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		e.fail(name, err)
+	}
+	return d
+}
+
+// FileMode parses an octal permission mode such as 0660.
+func (e *envReader) FileMode(name string, defaultValue os.FileMode) os.FileMode {
+	v := os.Getenv(name)
+	if v == "" {
+		return defaultValue
+	}
+	mode, err := strconv.ParseUint(v, 8, 32)
+	if err != nil || mode > 0777 {
+		e.fail(name, fmt.Errorf("%q is not an octal permission mode", v))
+	}
+	return os.FileMode(mode)
+}
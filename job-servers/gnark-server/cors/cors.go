@@ -0,0 +1,90 @@
+// Package cors answers CORS preflight requests and sets the CORS headers of
+// the responses to allowed origins, so that browser applications can call
+// the APIs directly.
+package cors
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Policy struct {
+	// Origins are the allowed origins: exact ones such as
+	// https://app.example.com, wildcard subdomains such as
+	// https://*.example.com, or * for any origin.
+	Origins        []string
+	Methods        []string
+	Headers        []string
+	ExposedHeaders []string
+	MaxAge         time.Duration
+}
+
+// ValidateOrigin checks that origin is *, or a scheme and host with
+// optionally a leading *. subdomain wildcard.
+func ValidateOrigin(origin string) error {
+	if origin == "*" {
+		return nil
+	}
+	u, err := url.Parse(strings.Replace(origin, "://*.", "://wildcard.", 1))
+	if err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" || u.RawQuery != "" {
+		return fmt.Errorf("invalid origin %q", origin)
+	}
+	return nil
+}
+
+func (p *Policy) allows(origin string) bool {
+	for _, allowed := range p.Origins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+		if scheme, host, ok := strings.Cut(allowed, "://*."); ok {
+			prefix, rest, ok := strings.Cut(origin, "://")
+			if ok && strings.EqualFold(prefix, scheme) && strings.HasSuffix(strings.ToLower(rest), "."+strings.ToLower(host)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Middleware adds the CORS headers for allowed origins and answers their
+// preflight requests with 204, without calling next. Requests from other
+// origins are served without CORS headers, so browsers block them.
+func (p *Policy) Middleware(next http.Handler) http.Handler {
+	if len(p.Origins) == 0 {
+		return next
+	}
+	methods := strings.Join(p.Methods, ", ")
+	headers := strings.Join(p.Headers, ", ")
+	exposed := strings.Join(p.ExposedHeaders, ", ")
+	maxAge := strconv.Itoa(int(p.MaxAge.Seconds()))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		if !p.allows(origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Add("Vary", "Access-Control-Request-Method, Access-Control-Request-Headers")
+			w.Header().Set("Access-Control-Allow-Methods", methods)
+			w.Header().Set("Access-Control-Allow-Headers", headers)
+			w.Header().Set("Access-Control-Max-Age", maxAge)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if exposed != "" {
+			w.Header().Set("Access-Control-Expose-Headers", exposed)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,222 @@
+// Package fleet lets replicas compare their effective configuration and
+// circuit artifacts through Redis.
+package fleet
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"gnark-server/apierror"
+	"gnark-server/artifacts"
+	"gnark-server/rediskey"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const redisFingerprintsKey = "fleet_fingerprints"
+
+// Fingerprint is what a node publishes about itself.
+type Fingerprint struct {
+	NodeId       string             `json:"nodeId"`
+	Circuit      string             `json:"circuit"`
+	ConfigHash   string             `json:"configHash"`
+	ManifestHash string             `json:"manifestHash"`
+	Config       map[string]string  `json:"config"`
+	Manifest     artifacts.Manifest `json:"manifest"`
+	ReportedAt   time.Time          `json:"reportedAt"`
+}
+
+// Drift lists a setting or artifact that differs between nodes serving the
+// same circuit, with the nodes holding each value.
+type Drift struct {
+	Circuit string              `json:"circuit"`
+	Field   string              `json:"field"`
+	Values  map[string][]string `json:"values"`
+}
+
+type Report struct {
+	Nodes []Fingerprint `json:"nodes"`
+	Drift []Drift       `json:"drift"`
+}
+
+type Reporter struct {
+	RedisClient redis.UniversalClient
+	NodeId      string
+	Config      map[string]string
+	DataDir     string
+	// Circuit returns the circuit currently loaded by the node.
+	Circuit  func() string
+	Interval time.Duration
+
+	mu        sync.Mutex
+	manifests map[string]artifacts.Manifest
+}
+
+func hashJSON(v interface{}) string {
+	data, _ := json.Marshal(v)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func (r *Reporter) manifest(circuit string) (artifacts.Manifest, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if manifest, ok := r.manifests[circuit]; ok {
+		return manifest, nil
+	}
+	manifest, err := artifacts.BuildManifest(r.DataDir, circuit)
+	if err != nil {
+		return manifest, err
+	}
+	if r.manifests == nil {
+		r.manifests = make(map[string]artifacts.Manifest)
+	}
+	r.manifests[circuit] = manifest
+	return manifest, nil
+}
+
+func (r *Reporter) Publish(ctx context.Context) error {
+	circuit := r.Circuit()
+	manifest, err := r.manifest(circuit)
+	if err != nil {
+		return err
+	}
+	fingerprint := Fingerprint{
+		NodeId:       r.NodeId,
+		Circuit:      circuit,
+		ConfigHash:   hashJSON(r.Config),
+		ManifestHash: hashJSON(manifest),
+		Config:       r.Config,
+		Manifest:     manifest,
+		ReportedAt:   time.Now().UTC(),
+	}
+	data, err := json.Marshal(fingerprint)
+	if err != nil {
+		return err
+	}
+	return r.RedisClient.HSet(ctx, rediskey.Key(redisFingerprintsKey), r.NodeId, data).Err()
+}
+
+// Report compares the fingerprints of all live nodes. Nodes that have not
+// reported for three intervals are considered gone and removed.
+func (r *Reporter) Report(ctx context.Context) (Report, error) {
+	var report Report
+	entries, err := r.RedisClient.HGetAll(ctx, rediskey.Key(redisFingerprintsKey)).Result()
+	if err != nil {
+		return report, err
+	}
+	cutoff := time.Now().Add(-3 * r.Interval)
+	byCircuit := make(map[string][]Fingerprint)
+	for nodeId, data := range entries {
+		var fingerprint Fingerprint
+		if err := json.Unmarshal([]byte(data), &fingerprint); err != nil || fingerprint.ReportedAt.Before(cutoff) {
+			r.RedisClient.HDel(ctx, rediskey.Key(redisFingerprintsKey), nodeId)
+			continue
+		}
+		report.Nodes = append(report.Nodes, fingerprint)
+		byCircuit[fingerprint.Circuit] = append(byCircuit[fingerprint.Circuit], fingerprint)
+	}
+	sort.Slice(report.Nodes, func(i, j int) bool { return report.Nodes[i].NodeId < report.Nodes[j].NodeId })
+
+	for circuit, nodes := range byCircuit {
+		report.Drift = append(report.Drift, compare(circuit, nodes)...)
+	}
+	sort.Slice(report.Drift, func(i, j int) bool {
+		if report.Drift[i].Circuit != report.Drift[j].Circuit {
+			return report.Drift[i].Circuit < report.Drift[j].Circuit
+		}
+		return report.Drift[i].Field < report.Drift[j].Field
+	})
+	return report, nil
+}
+
+func compare(circuit string, nodes []Fingerprint) []Drift {
+	var drift []Drift
+	add := func(field string, value func(Fingerprint) string) {
+		values := make(map[string][]string)
+		for _, node := range nodes {
+			values[value(node)] = append(values[value(node)], node.NodeId)
+		}
+		if len(values) > 1 {
+			drift = append(drift, Drift{Circuit: circuit, Field: field, Values: values})
+		}
+	}
+
+	fields := make(map[string]bool)
+	for _, node := range nodes {
+		for field := range node.Config {
+			fields[field] = true
+		}
+	}
+	for field := range fields {
+		field := field
+		add("config."+field, func(node Fingerprint) string { return node.Config[field] })
+	}
+	fileNames := make(map[string]bool)
+	for _, node := range nodes {
+		for _, file := range node.Manifest.Files {
+			fileNames[file.Name] = true
+		}
+	}
+	for name := range fileNames {
+		name := name
+		add("artifact."+name, func(node Fingerprint) string {
+			for _, file := range node.Manifest.Files {
+				if file.Name == name {
+					return file.SHA256
+				}
+			}
+			return ""
+		})
+	}
+	return drift
+}
+
+// Run publishes the node's fingerprint every interval and logs an ALERT
+// whenever replicas of the same circuit disagree.
+func (r *Reporter) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+	for {
+		if err := r.Publish(ctx); err != nil {
+			log.Printf("Failed to publish fleet fingerprint: %v\n", err)
+		} else if report, err := r.Report(ctx); err != nil {
+			log.Printf("Failed to check fleet drift: %v\n", err)
+		} else {
+			for _, drift := range report.Drift {
+				log.Printf("ALERT configuration drift circuit=%s field=%s values=%v\n", drift.Circuit, drift.Field, drift.Values)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ServeHTTP returns the fleet report, with status 409 if drift was found.
+func (r *Reporter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		apierror.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	report, err := r.Report(req.Context())
+	if err != nil {
+		log.Printf("Failed to check fleet drift: %v\n", err)
+		apierror.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if len(report.Drift) > 0 {
+		w.WriteHeader(http.StatusConflict)
+	}
+	json.NewEncoder(w).Encode(report)
+}
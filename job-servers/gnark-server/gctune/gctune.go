@@ -0,0 +1,142 @@
+// Package gctune adjusts the garbage collector around proving: collections
+// are made rarer while a prove is running, which allocates heavily but keeps
+// most of its memory live, and the heap is collected and returned to the OS
+// once the last concurrent prove finishes.
+package gctune
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// PhaseStats are collections and pause time accumulated in one phase.
+type PhaseStats struct {
+	NumGC      uint32        `json:"numGC"`
+	PauseTotal time.Duration `json:"pauseTotalNs"`
+	Duration   time.Duration `json:"durationNs"`
+}
+
+type Stats struct {
+	Enabled bool       `json:"enabled"`
+	Prove   PhaseStats `json:"prove"`
+	Idle    PhaseStats `json:"idle"`
+	// Heap in use before and after the collection at the end of the last
+	// proving phase.
+	LastHeapBeforeRelease uint64 `json:"lastHeapBeforeRelease"`
+	LastHeapAfterRelease  uint64 `json:"lastHeapAfterRelease"`
+	Releases              uint64 `json:"releases"`
+}
+
+type Tuner struct {
+	// ProveGCPercent is the GOGC value while at least one prove runs.
+	ProveGCPercent int
+	// ProveMemoryLimit and IdleMemoryLimit are soft memory limits in bytes;
+	// zero leaves the limit unchanged.
+	ProveMemoryLimit int64
+	IdleMemoryLimit  int64
+	Enabled          bool
+
+	mu            sync.Mutex
+	active        int
+	idleGCPercent int
+	phaseStart    time.Time
+	phaseNumGC    uint32
+	phasePause    uint64
+	stats         Stats
+
+	// baseMemoryLimit is the limit before Start, GOMEMLIMIT or none.
+	baseMemoryLimit int64
+}
+
+// Start applies the idle settings. It must be called once before Enter.
+func (t *Tuner) Start() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stats.Enabled = t.Enabled
+	t.idleGCPercent = debug.SetGCPercent(-1)
+	debug.SetGCPercent(t.idleGCPercent)
+	t.baseMemoryLimit = debug.SetMemoryLimit(-1)
+	if t.Enabled && t.IdleMemoryLimit > 0 {
+		debug.SetMemoryLimit(t.IdleMemoryLimit)
+	}
+	t.beginPhase()
+}
+
+func (t *Tuner) beginPhase() {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	t.phaseStart = time.Now()
+	t.phaseNumGC = m.NumGC
+	t.phasePause = m.PauseTotalNs
+}
+
+func (t *Tuner) endPhase(phase *PhaseStats) runtime.MemStats {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	phase.NumGC += m.NumGC - t.phaseNumGC
+	phase.PauseTotal += time.Duration(m.PauseTotalNs - t.phasePause)
+	phase.Duration += time.Since(t.phaseStart)
+	return m
+}
+
+// Enter marks the start of a prove and returns the function marking its
+// end. Concurrent proves share one proving phase.
+func (t *Tuner) Enter() func() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.active++
+	if t.active == 1 {
+		t.endPhase(&t.stats.Idle)
+		if t.Enabled {
+			debug.SetGCPercent(t.ProveGCPercent)
+			if t.ProveMemoryLimit > 0 {
+				debug.SetMemoryLimit(t.ProveMemoryLimit)
+			}
+		}
+		t.beginPhase()
+	}
+	var once sync.Once
+	return func() { once.Do(t.exit) }
+}
+
+func (t *Tuner) exit() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.active--
+	if t.active > 0 {
+		return
+	}
+	m := t.endPhase(&t.stats.Prove)
+	if t.Enabled {
+		debug.SetGCPercent(t.idleGCPercent)
+		if t.IdleMemoryLimit > 0 {
+			debug.SetMemoryLimit(t.IdleMemoryLimit)
+		} else if t.ProveMemoryLimit > 0 {
+			debug.SetMemoryLimit(t.baseMemoryLimit)
+		}
+		before := m.HeapInuse
+		debug.FreeOSMemory()
+		runtime.ReadMemStats(&m)
+		t.stats.LastHeapBeforeRelease = before
+		t.stats.LastHeapAfterRelease = m.HeapInuse
+		t.stats.Releases++
+		log.Printf("GC after proving: heap in use %d MiB -> %d MiB\n", before>>20, m.HeapInuse>>20)
+	}
+	t.beginPhase()
+}
+
+func (t *Tuner) Stats() Stats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.stats
+}
+
+func (t *Tuner) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(t.Stats())
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"gnark-server/client"
+)
+
+const usage = `Usage: gnark-cli <command> [flags]
+
+Commands:
+  prove   submit a proof, optionally waiting for the result
+  get     fetch the result of a job
+
+Run "gnark-cli <command> -h" for the flags of a command.
+The server URL and API key default to $GNARK_SERVER_URL and $GNARK_API_KEY.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var err error
+	switch os.Args[1] {
+	case "prove":
+		err = prove(ctx, os.Args[2:])
+	case "get":
+		err = get(ctx, os.Args[2:])
+	case "-h", "-help", "--help", "help":
+		fmt.Print(usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
+
+type commonFlags struct {
+	server string
+	apiKey string
+	output string
+}
+
+func (f *commonFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.server, "server", envOr("GNARK_SERVER_URL", "http://localhost:8080"), "server base URL")
+	fs.StringVar(&f.apiKey, "api-key", os.Getenv("GNARK_API_KEY"), "API key")
+	fs.StringVar(&f.output, "output", "", "write the result to this file instead of stdout")
+}
+
+func (f *commonFlags) client() *client.Client {
+	return client.New(f.server, f.apiKey)
+}
+
+func prove(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("prove", flag.ExitOnError)
+	var common commonFlags
+	common.register(fs)
+	input := fs.String("input", "", "plonky2 proof with public inputs (JSON file), or a start-proof request body")
+	wait := fs.Bool("wait", false, "wait for the proof and print the result instead of the job ID")
+	timeout := fs.Duration("timeout", 30*time.Minute, "maximum time to wait with --wait")
+	jobId := fs.String("job-id", "", "job ID (UUID) to use instead of a server-generated one")
+	idempotencyKey := fs.String("idempotency-key", "", "Idempotency-Key header")
+	webhookURL := fs.String("webhook-url", "", "URL notified when the proof is done")
+	format := fs.String("format", "", "additional output format (calldata, blob)")
+	race := fs.Bool("race", false, "race the prove against the server's peers")
+	priority := fs.String("priority", "", "queue lane: high (default) or low for batch jobs")
+	fs.Parse(args)
+
+	if *input == "" {
+		return fmt.Errorf("--input is required")
+	}
+	proof, err := readProof(*input)
+	if err != nil {
+		return err
+	}
+
+	c := common.client()
+	id, err := c.StartProof(ctx, client.StartProofRequest{
+		Proof:          proof,
+		JobId:          *jobId,
+		WebhookURL:     *webhookURL,
+		Race:           *race,
+		Format:         *format,
+		Priority:       *priority,
+		IdempotencyKey: *idempotencyKey,
+	})
+	if err != nil {
+		return err
+	}
+	if !*wait {
+		return writeOutput(common.output, map[string]string{"jobId": id})
+	}
+	fmt.Fprintln(os.Stderr, "Waiting for job", id)
+
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+	result, err := c.WaitForProof(ctx, id)
+	if err != nil {
+		return fmt.Errorf("job %s: %w", id, err)
+	}
+	return writeOutput(common.output, result)
+}
+
+func get(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("get", flag.ExitOnError)
+	var common commonFlags
+	common.register(fs)
+	jobId := fs.String("job-id", "", "job ID")
+	fs.Parse(args)
+
+	if *jobId == "" {
+		return fmt.Errorf("--job-id is required")
+	}
+	response, err := common.client().GetProof(ctx, *jobId)
+	if err != nil {
+		return err
+	}
+	return writeOutput(common.output, response)
+}
+
+// readProof returns the proof JSON to submit from path, which holds either
+// the plonky2 proof JSON itself or a start-proof body with a "proof" string.
+func readProof(path string) (json.RawMessage, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var body struct {
+		Proof json.RawMessage `json:"proof"`
+	}
+	if err := json.Unmarshal(data, &body); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	var proof string
+	if err := json.Unmarshal(body.Proof, &proof); err == nil {
+		return json.RawMessage(proof), nil
+	}
+	return data, nil
+}
+
+func writeOutput(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if path == "" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+func envOr(key string, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ProofResponseV2 is the get-proof response of /v2. The job's state
+// replaces success, which v1 also sets while the job is pending.
+type ProofResponseV2 struct {
+	State string `json:"state" openapi:"required,enum=pending|running|succeeded|failed"`
+	ProofResponse
+	// Success shadows the field of ProofResponse so that it is not sent.
+	Success *bool `json:"success,omitempty" openapi:"-"`
+}
+
+// jobState is the state of the job of response, telling a pending job from
+// a running one by the job index.
+func (s *State) jobState(ctx context.Context, jobId string, response ProofResponse) string {
+	if !response.Success {
+		return jobStateFailed
+	}
+	if response.Proof != nil {
+		return jobStateSucceeded
+	}
+	state, err := s.RedisClient.HGet(ctx, getJobMetaRedisKey(jobId), "state").Result()
+	if err != nil && err != redis.Nil {
+		log.Printf("Failed to read job state from Redis: %v\n", err)
+	}
+	if state == jobStateRunning {
+		return jobStateRunning
+	}
+	return jobStatePending
+}
+
+// writeJobRecordV2 is writeJobRecord for /v2.
+func writeJobRecordV2(w http.ResponseWriter, r *http.Request, response ProofResponse, state string) {
+	json.NewEncoder(w).Encode(ProofResponseV2{State: state, ProofResponse: redact(response, profileOf(r))})
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"gnark-server/apierror"
+	"gnark-server/attestation"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// attestationDomain prefixes the report data preimage, so that the report
+// data of an attestation cannot be mistaken for anything else.
+const attestationDomain = "gnark-server attestation v1\n"
+
+// maxAttestationNonceSize bounds the client nonce of an attestation.
+const maxAttestationNonceSize = 32
+
+// AttestationReport is the attestation evidence of the environment the
+// server runs in, with what its report data binds the measured build to.
+type AttestationReport struct {
+	// Platform is one of sgx, sev-snp, tdx and nitro.
+	Platform string `json:"platform"`
+	// Evidence is the SGX quote, SEV-SNP or TDX report, or Nitro
+	// attestation document, base64-encoded.
+	Evidence string `json:"evidence"`
+	// ReportData is the SHA-512 of the attestation domain, SignerPublicKey,
+	// VerifyingKeyHash and Nonce, as embedded in Evidence.
+	ReportData       string `json:"reportData"`
+	Signer           string `json:"signer"`
+	SignerPublicKey  string `json:"signerPublicKey"`
+	Circuit          string `json:"circuit"`
+	CircuitVersion   string `json:"circuitVersion,omitempty"`
+	VerifyingKeyHash string `json:"verifyingKeyKeccak256"`
+	Nonce            string `json:"nonce,omitempty"`
+}
+
+// attestationReportData is the report data binding the result signing key
+// and the verifying key of the loaded circuit to an attestation.
+func attestationReportData(signerPublicKey, verifyingKeyHash, nonce []byte) [attestation.ReportDataSize]byte {
+	digest := sha512.New()
+	digest.Write([]byte(attestationDomain))
+	digest.Write(signerPublicKey)
+	digest.Write(verifyingKeyHash)
+	digest.Write(nonce)
+	var reportData [attestation.ReportDataSize]byte
+	copy(reportData[:], digest.Sum(nil))
+	return reportData
+}
+
+// Attestation returns a fresh attestation report, bound to the optional
+// nonce query parameter of the client.
+func (s *State) Attestation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		apierror.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var nonce []byte
+	if raw := r.URL.Query().Get("nonce"); raw != "" {
+		var err error
+		nonce, err = hexutil.Decode(raw)
+		if err != nil || len(nonce) > maxAttestationNonceSize {
+			apierror.Error(w, fmt.Sprintf("nonce must be 0x-prefixed hex of at most %d bytes", maxAttestationNonceSize), http.StatusBadRequest)
+			return
+		}
+	}
+	circuitName, data := s.circuit()
+	_, vkHash, err := serializeVk(data)
+	if err != nil {
+		log.Printf("Failed to serialize verifying key: %v\n", err)
+		apierror.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	signerPublicKey := s.ResultSigner.PublicKey()
+	reportData := attestationReportData(signerPublicKey, hexutil.MustDecode(vkHash), nonce)
+	evidence, err := s.Attester.Attest(reportData)
+	if err != nil {
+		log.Printf("Failed to produce %s attestation: %v\n", s.Attester.Platform(), err)
+		apierror.Error(w, "Attestation failed", http.StatusInternalServerError)
+		return
+	}
+	report := AttestationReport{
+		Platform:         s.Attester.Platform(),
+		Evidence:         base64.StdEncoding.EncodeToString(evidence),
+		ReportData:       hexutil.Encode(reportData[:]),
+		Signer:           s.ResultSigner.Address().Hex(),
+		SignerPublicKey:  hexutil.Encode(signerPublicKey),
+		Circuit:          circuitName,
+		VerifyingKeyHash: vkHash,
+	}
+	if manifest := s.loadedManifest(); manifest.Circuit == circuitName {
+		report.CircuitVersion = manifest.Version
+	}
+	if nonce != nil {
+		report.Nonce = hexutil.Encode(nonce)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gnark-server/apierror"
+	"gnark-server/audit"
+	"gnark-server/auth"
+	"gnark-server/ipfilter"
+)
+
+const (
+	defaultAuditListLimit = 100
+	maxAuditListLimit     = 1000
+)
+
+// remoteHost is the client address of r, as resolved by ipfilter.
+func remoteHost(r *http.Request) string {
+	if ip := ipfilter.FromContext(r.Context()); ip != nil {
+		return ip.String()
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// audit logs an AUDIT line for an action of the caller of r and appends it
+// to the audit log, if there is one. Failing to append does not fail the
+// action; the log line is kept either way.
+func (s *State) audit(r *http.Request, action string, jobId string, details map[string]string) {
+	identity := auth.FromContext(r.Context())
+	event := audit.Event{
+		Time:    time.Now().UTC(),
+		Action:  action,
+		Actor:   identity.Name,
+		Key:     identity.Owner(),
+		Remote:  remoteHost(r),
+		JobId:   jobId,
+		Details: details,
+	}
+	line := fmt.Sprintf("AUDIT %s actor=%s remote=%s", action, event.Actor, event.Remote)
+	if jobId != "" {
+		line += " jobId=" + jobId
+	}
+	keys := make([]string, 0, len(details))
+	for key := range details {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := details[key]
+		if value == "" || strings.ContainsAny(value, " \t\n\"") {
+			value = strconv.Quote(value)
+		}
+		line += " " + key + "=" + value
+	}
+	log.Println(line)
+	if s.Audit == nil {
+		return
+	}
+	if err := s.Audit.Append(context.Background(), event); err != nil {
+		log.Printf("Failed to append audit event: %v\n", err)
+	}
+}
+
+// AuditLog lists audit events, newest first, optionally only those of
+// ?action= or ?actor=. The response's nextCursor continues the listing; it
+// is empty at the end.
+func (s *State) AuditLog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		apierror.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.Audit == nil {
+		apierror.Error(w, "Audit log is not enabled", http.StatusNotFound)
+		return
+	}
+	query := r.URL.Query()
+	q := audit.Query{Action: query.Get("action"), Actor: query.Get("actor"), Limit: defaultAuditListLimit}
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxAuditListLimit {
+			apierror.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxAuditListLimit), http.StatusBadRequest)
+			return
+		}
+		q.Limit = n
+	}
+	if cursor := query.Get("cursor"); cursor != "" {
+		before, err := strconv.ParseInt(cursor, 10, 64)
+		if err != nil || before <= 0 {
+			apierror.Error(w, "invalid cursor", http.StatusBadRequest)
+			return
+		}
+		q.Before = before
+	}
+	events, err := s.Audit.List(r.Context(), q)
+	if err != nil {
+		log.Printf("Failed to list audit events: %v\n", err)
+		apierror.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	nextCursor := ""
+	if len(events) == q.Limit {
+		nextCursor = strconv.FormatInt(events[len(events)-1].Id, 10)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"events": events, "nextCursor": nextCursor})
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+
+	"gnark-server/apierror"
+)
+
+// admit rejects a submission of n jobs with 429 when they would grow the
+// queue of jobs waiting for a worker beyond MaxQueueDepth, and with 503 while
+// the server is draining.
+func (s *State) admit(w http.ResponseWriter, n int) bool {
+	if s.rejectDraining(w) {
+		return false
+	}
+	if s.Memory != nil {
+		if fits, limit := s.Memory.Fits(); !fits {
+			apierror.WithDetails(w, "Not enough memory to prove on this node", http.StatusServiceUnavailable, map[string]int64{
+				"memoryLimit":    limit,
+				"proveFootprint": s.Memory.Footprint,
+			})
+			return false
+		}
+	}
+	if s.MaxQueueDepth <= 0 {
+		return true
+	}
+	depth := s.queue.len()
+	if depth+n <= s.MaxQueueDepth {
+		return true
+	}
+	log.Println("Submission rejected, queue depth", depth, "of", s.MaxQueueDepth)
+	w.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(s.QueueRetryAfter.Seconds()))))
+	apierror.WithDetails(w, "Too many pending jobs, retry later", http.StatusTooManyRequests, map[string]int{
+		"queueDepth":    depth,
+		"maxQueueDepth": s.MaxQueueDepth,
+	})
+	return false
+}
@@ -0,0 +1,271 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/metrics"
+	"strings"
+	"sync"
+	"time"
+
+	"gnark-server/apierror"
+	"gnark-server/circuitData"
+	"gnark-server/memadmit"
+	"gnark-server/slo"
+	"gnark-server/wrapper"
+
+	"github.com/qope/gnark-plonky2-verifier/types"
+)
+
+// memorySampleInterval is how often the memory of the process is read during
+// a calibration run.
+const memorySampleInterval = 100 * time.Millisecond
+
+// Calibration is the outcome of proving the sample proof of a circuit on this
+// node.
+type Calibration struct {
+	Circuit             string    `json:"circuit"`
+	At                  time.Time `json:"at"`
+	WitnessGenerationMs int64     `json:"witnessGenerationMs"`
+	ProveMs             int64     `json:"proveMs"`
+	VerifyMs            int64     `json:"verifyMs"`
+	// PeakMemoryBytes is the most memory the Go runtime held during the run,
+	// ProveMemoryBytes its growth over the start of the run.
+	PeakMemoryBytes  int64 `json:"peakMemoryBytes"`
+	ProveMemoryBytes int64 `json:"proveMemoryBytes"`
+	// ConcurrentJobs is the number of jobs proving at the start of the run,
+	// which then took longer and more memory than it would alone.
+	ConcurrentJobs int `json:"concurrentJobs"`
+}
+
+type benchMemory struct {
+	// FootprintBytes is PROVE_MEMORY_FOOTPRINT, the memory admission
+	// reserves per prove.
+	FootprintBytes int64 `json:"footprintBytes,omitempty"`
+	LimitBytes     int64 `json:"limitBytes,omitempty"`
+	AvailableBytes int64 `json:"availableBytes,omitempty"`
+}
+
+// CircuitBench is what GET /circuit/<name>/bench reports for capacity
+// planning.
+type CircuitBench struct {
+	Circuit        string `json:"circuit"`
+	NbConstraints  int    `json:"nbConstraints"`
+	NbPublicInputs int    `json:"nbPublicInputs"`
+	Workers        int    `json:"workers"`
+	CPUs           int    `json:"cpus"`
+	// EstimatedProveMs is the prove time of the calibration run or, without
+	// one, the median of recent jobs; EstimateSource tells which.
+	EstimatedProveMs int64          `json:"estimatedProveMs,omitempty"`
+	EstimateSource   string         `json:"estimateSource,omitempty" openapi:"enum=calibration|recent-jobs"`
+	Calibration      *Calibration   `json:"calibration"`
+	RecentProves     slo.ProveTimes `json:"recentProves"`
+	Memory           benchMemory    `json:"memory"`
+}
+
+// runtimeMemory is the memory mapped by the Go runtime.
+func runtimeMemory() int64 {
+	sample := []metrics.Sample{{Name: "/memory/classes/total:bytes"}}
+	metrics.Read(sample)
+	if sample[0].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return int64(sample[0].Value.Uint64())
+}
+
+// samplePeakMemory samples runtimeMemory in the background until the
+// returned function is called, which returns the highest sample.
+func samplePeakMemory(baseline int64) func() int64 {
+	done := make(chan struct{})
+	result := make(chan int64, 1)
+	go func() {
+		peak := baseline
+		ticker := time.NewTicker(memorySampleInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				result <- max(peak, runtimeMemory())
+				return
+			case <-ticker.C:
+				peak = max(peak, runtimeMemory())
+			}
+		}
+	}()
+	var once sync.Once
+	var peak int64
+	return func() int64 {
+		once.Do(func() {
+			close(done)
+			peak = <-result
+		})
+		return peak
+	}
+}
+
+// calibrate proves and verifies the sample proof at path, by default the one
+// bundled with the circuit, timing each step and sampling the memory.
+func (s *State) calibrate(path string) (Calibration, error) {
+	circuitName, data := s.circuit()
+	return s.calibrateCircuit(circuitName, data, path)
+}
+
+func (s *State) calibrateCircuit(circuitName string, data *circuitData.CircuitData, path string) (Calibration, error) {
+	if path == "" {
+		path = "data/" + circuitName + "/proof_with_public_inputs.json"
+	}
+	calibration := Calibration{Circuit: circuitName, At: time.Now().UTC()}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return calibration, err
+	}
+	var input types.ProofWithPublicInputsRaw
+	if err := json.Unmarshal(raw, &input); err != nil {
+		return calibration, fmt.Errorf("failed to parse sample proof: %w", err)
+	}
+	if s.queue != nil {
+		calibration.ConcurrentJobs = s.queue.inFlight()
+	}
+
+	baseline := runtimeMemory()
+	peakMemory := samplePeakMemory(baseline)
+	defer peakMemory()
+
+	start := time.Now()
+	witness, err := wrapper.NewWitness(data, input)
+	if err != nil {
+		return calibration, err
+	}
+	calibration.WitnessGenerationMs = time.Since(start).Milliseconds()
+	if s.GC != nil {
+		defer s.GC.Enter()()
+	}
+	start = time.Now()
+	proof, err := wrapper.ProveWitness(s.Prover, data, witness)
+	if err != nil {
+		return calibration, err
+	}
+	calibration.ProveMs = time.Since(start).Milliseconds()
+	start = time.Now()
+	if err := wrapper.Verify(data, proof); err != nil {
+		return calibration, fmt.Errorf("sample proof does not verify against the verifying key: %w", err)
+	}
+	calibration.VerifyMs = time.Since(start).Milliseconds()
+
+	calibration.PeakMemoryBytes = peakMemory()
+	calibration.ProveMemoryBytes = calibration.PeakMemoryBytes - baseline
+	return calibration, nil
+}
+
+func (s *State) setCalibration(calibration Calibration) {
+	s.warmUpMu.Lock()
+	defer s.warmUpMu.Unlock()
+	if s.calibrations == nil {
+		s.calibrations = make(map[string]Calibration)
+	}
+	s.calibrations[calibration.Circuit] = calibration
+}
+
+// currentCalibration returns the last calibration of circuitName.
+func (s *State) currentCalibration(circuitName string) *Calibration {
+	s.warmUpMu.Lock()
+	defer s.warmUpMu.Unlock()
+	calibration, ok := s.calibrations[circuitName]
+	if !ok {
+		return nil
+	}
+	return &calibration
+}
+
+// residentCircuit returns the data of circuitName if it is resident on this
+// node: the loaded circuit, the canary or the standby.
+func (s *State) residentCircuit(circuitName string) (*circuitData.CircuitData, bool) {
+	s.circuitMu.RLock()
+	defer s.circuitMu.RUnlock()
+	resident := map[string]*circuitData.CircuitData{s.CircuitName: s.CircuitData}
+	if s.canary != nil {
+		resident[s.canary.Name] = s.canary.Data
+	}
+	if s.standby != nil {
+		resident[s.standby.Name] = s.standby.Data
+	}
+	data, ok := resident[circuitName]
+	return data, ok
+}
+
+func (s *State) runCalibrate(r *http.Request) (interface{}, error) {
+	release, err := s.admitMemory(proofJob{JobId: "calibration"})
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	circuitName, data := s.circuit()
+	s.warmUpMu.Lock()
+	path := s.warmUpProofFile
+	s.warmUpMu.Unlock()
+	// Other resident circuits are calibrated with their bundled sample proof.
+	if v := r.URL.Query().Get("circuit"); v != "" && v != circuitName {
+		var ok bool
+		if data, ok = s.residentCircuit(v); !ok {
+			return nil, fmt.Errorf("circuit %s is not resident on this node", v)
+		}
+		circuitName, path = v, ""
+	}
+	calibration, err := protect(func() (Calibration, error) {
+		return s.calibrateCircuit(circuitName, data, path)
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.setCalibration(calibration)
+	log.Println("Calibration prove done in", time.Duration(calibration.ProveMs)*time.Millisecond)
+	return calibration, nil
+}
+
+// CircuitBench serves GET /circuit/<name>/bench for the circuits resident on
+// this node: the loaded one, the canary and the standby.
+func (s *State) CircuitBench(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		apierror.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	i := strings.Index(r.URL.Path, "/circuit/")
+	name, ok := strings.CutSuffix(r.URL.Path[i+len("/circuit/"):], "/bench")
+	if !ok || strings.Contains(name, "/") {
+		apierror.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	data, ok := s.residentCircuit(name)
+	if !ok {
+		apierror.Error(w, "circuit is not resident on this node", http.StatusNotFound)
+		return
+	}
+	bench := CircuitBench{
+		Circuit:        name,
+		NbConstraints:  data.Ccs.GetNbConstraints(),
+		NbPublicInputs: int(data.Vk.NbPublicVariables),
+		Workers:        s.workers,
+		CPUs:           runtime.GOMAXPROCS(0),
+		Calibration:    s.currentCalibration(name),
+	}
+	if s.SLO != nil {
+		bench.RecentProves = s.SLO.ProveTimes(name)
+	}
+	if bench.Calibration != nil {
+		bench.EstimatedProveMs, bench.EstimateSource = bench.Calibration.ProveMs, "calibration"
+	} else if bench.RecentProves.Jobs > 0 {
+		bench.EstimatedProveMs, bench.EstimateSource = bench.RecentProves.P50, "recent-jobs"
+	}
+	if s.Memory != nil {
+		bench.Memory.FootprintBytes = s.Memory.Footprint
+	}
+	if available, limit, err := memadmit.Available(); err == nil {
+		bench.Memory.AvailableBytes, bench.Memory.LimitBytes = available, limit
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(bench)
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"encoding/hex"
+
+	"gnark-server/relayer"
+)
+
+const (
+	blobFieldElements = 4096
+	// blobFieldElementBytes of every 32-byte field element carry payload; the
+	// leading byte stays zero so each element is below the BLS12-381 modulus.
+	blobFieldElementBytes = 31
+	blobCapacity          = blobFieldElements * blobFieldElementBytes
+)
+
+// encodeBlobs packs payload into EIP-4844 field elements, 31 bytes per
+// element, split into full blobs of 4096 elements, the unused elements of
+// the last one zero.
+func encodeBlobs(payload []byte) [][]byte {
+	var blobs [][]byte
+	for len(payload) > 0 {
+		chunk := payload
+		if len(chunk) > blobCapacity {
+			chunk = chunk[:blobCapacity]
+		}
+		payload = payload[len(chunk):]
+
+		elements := (len(chunk) + blobFieldElementBytes - 1) / blobFieldElementBytes
+		blob := make([]byte, blobFieldElements*32)
+		for i := 0; i < elements; i++ {
+			end := (i + 1) * blobFieldElementBytes
+			if end > len(chunk) {
+				end = len(chunk)
+			}
+			copy(blob[i*32+1:], chunk[i*blobFieldElementBytes:end])
+		}
+		blobs = append(blobs, blob)
+	}
+	return blobs
+}
+
+// applyBlobFormat sets the blob encoding of payload, the ABI-encoded
+// (bytes proof, uint256[] publicInputs), and the versioned hashes a blob
+// transaction carrying it would reference.
+func applyBlobFormat(result ProveResult, payload []byte) (ProveResult, error) {
+	blobs := encodeBlobs(payload)
+	hashes, err := relayer.BlobVersionedHashes(blobs)
+	if err != nil {
+		return result, err
+	}
+	result.Blobs = make([]string, len(blobs))
+	result.BlobVersionedHashes = make([]string, len(hashes))
+	for i := range blobs {
+		result.Blobs[i] = "0x" + hex.EncodeToString(blobs[i])
+		result.BlobVersionedHashes[i] = hashes[i].Hex()
+	}
+	return result, nil
+}
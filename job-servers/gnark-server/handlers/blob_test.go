@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncodeBlobsEmitsFullBlobs(t *testing.T) {
+	payload := bytes.Repeat([]byte{0xff}, blobCapacity+40)
+	blobs := encodeBlobs(payload)
+	if len(blobs) != 2 {
+		t.Fatalf("%d blobs, want 2", len(blobs))
+	}
+	for i, blob := range blobs {
+		if len(blob) != 131072 {
+			t.Fatalf("blob %d is %d bytes, want 131072", i, len(blob))
+		}
+	}
+	last := blobs[1]
+	if last[0] != 0 || !bytes.Equal(last[1:32], payload[blobCapacity:blobCapacity+31]) || !bytes.Equal(last[33:42], payload[blobCapacity+31:]) {
+		t.Fatalf("last blob starts %x, want the rest of the payload", last[:64])
+	}
+	if !bytes.Equal(last[42:], make([]byte, len(last)-42)) {
+		t.Fatal("unused elements of the last blob are not zero")
+	}
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"gnark-server/rediskey"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/qope/gnark-plonky2-verifier/types"
+)
+
+const redisResultCacheKeyPrefix = "result_cache:"
+
+func getResultCacheRedisKey(inputHash string) string {
+	return fmt.Sprintf("%s%s", rediskey.Key(redisResultCacheKeyPrefix), inputHash)
+}
+
+// hashProofInput returns the hex-encoded SHA-256 of the canonical JSON encoding
+// of the parsed proof, scoped to the circuit it is proven against and to the
+// hash of its verifying key, so that proofs cached before reload-circuit
+// rotates the key are not served after.
+func hashProofInput(circuitName string, vkHash string, input types.ProofWithPublicInputsRaw) (string, error) {
+	canonical, err := json.Marshal(input)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	h.Write([]byte(circuitName))
+	h.Write([]byte{0})
+	h.Write([]byte(vkHash))
+	h.Write([]byte{0})
+	h.Write(canonical)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (s *State) getCachedResult(ctx context.Context, inputHash string) (*ProveResult, error) {
+	record, err := s.RedisClient.Get(ctx, getResultCacheRedisKey(inputHash)).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	resultJSON, err := decodeRecord(s.RecordKeys, record)
+	if err != nil {
+		return nil, err
+	}
+	var result ProveResult
+	if err := json.Unmarshal(resultJSON, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (s *State) setCachedResult(ctx context.Context, inputHash string, result ProveResult) error {
+	resultJSON, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	return s.RedisClient.Set(ctx, getResultCacheRedisKey(inputHash), s.encodeRecord(resultJSON), s.ResultTTL).Err()
+}
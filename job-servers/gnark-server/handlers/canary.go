@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gnark-server/artifacts"
+	"gnark-server/circuitData"
+)
+
+// canaryCircuit is a second version of the circuit, proving Percent of the
+// new jobs next to the loaded one.
+type canaryCircuit struct {
+	Name     string
+	Data     *circuitData.CircuitData
+	Manifest artifacts.Manifest
+	Percent  float64
+}
+
+// CanaryStatus reports the canary circuit in the stats.
+type CanaryStatus struct {
+	Circuit string  `json:"circuit"`
+	Version string  `json:"version,omitempty"`
+	Percent float64 `json:"percent"`
+}
+
+// LoadCanary loads circuitName, whose artifacts are checked like those of
+// the loaded circuit, as the canary receiving percent of the new jobs.
+func (s *State) LoadCanary(circuitName string, percent float64) error {
+	if percent < 0 || percent > 100 {
+		return fmt.Errorf("canary percent must be between 0 and 100")
+	}
+	if primary, _ := s.circuit(); circuitName == primary {
+		return fmt.Errorf("the canary must be another circuit than the loaded one")
+	}
+	manifest, err := artifacts.CheckLocal("data", circuitName, s.RequireArtifactManifest)
+	if err != nil {
+		return err
+	}
+	data, err := circuitData.LoadCircuitData(circuitName, s.LoadOptions)
+	if err != nil {
+		return err
+	}
+	s.circuitMu.Lock()
+	s.canary = &canaryCircuit{Name: circuitName, Data: &data, Manifest: manifest, Percent: percent}
+	s.circuitMu.Unlock()
+	log.Printf("Loaded canary circuit %s (version %q), routing %g%% of jobs to it\n", circuitName, manifest.Version, percent)
+	return nil
+}
+
+// routeCircuit picks the circuit a new job is proven with.
+func (s *State) routeCircuit() (string, *circuitData.CircuitData) {
+	s.circuitMu.RLock()
+	defer s.circuitMu.RUnlock()
+	if s.canary != nil && rand.Float64()*100 < s.canary.Percent {
+		return s.canary.Name, s.canary.Data
+	}
+	return s.CircuitName, s.CircuitData
+}
+
+// jobCircuit is the circuit job was routed to, which may have been flipped
+// to standby since, or the loaded one if it is no longer loaded, and its
+// manifest version.
+func (s *State) jobCircuit(job proofJob) (string, *circuitData.CircuitData, string) {
+	s.circuitMu.RLock()
+	defer s.circuitMu.RUnlock()
+	if s.canary != nil && job.Circuit == s.canary.Name {
+		return s.canary.Name, s.canary.Data, s.canary.Manifest.Version
+	}
+	if s.standby != nil && job.Circuit == s.standby.Name {
+		return s.standby.Name, s.standby.Data, s.standby.Manifest.Version
+	}
+	var version string
+	if s.CircuitManifest.Circuit == s.CircuitName {
+		version = s.CircuitManifest.Version
+	}
+	return s.CircuitName, s.CircuitData, version
+}
+
+func (s *State) canaryStatus() *CanaryStatus {
+	s.circuitMu.RLock()
+	defer s.circuitMu.RUnlock()
+	if s.canary == nil {
+		return nil
+	}
+	return &CanaryStatus{Circuit: s.canary.Name, Version: s.canary.Manifest.Version, Percent: s.canary.Percent}
+}
+
+// runCanary loads ?circuit= as the canary, sets the share of jobs it gets to
+// ?percent=, or unloads it with ?unload=true. Jobs already routed to an
+// unloaded canary are proven with the loaded circuit.
+func (s *State) runCanary(r *http.Request) (interface{}, error) {
+	query := r.URL.Query()
+	if query.Get("unload") == "true" {
+		s.circuitMu.Lock()
+		s.canary = nil
+		s.circuitMu.Unlock()
+		log.Println("Unloaded the canary circuit")
+		return map[string]interface{}{"canary": nil}, nil
+	}
+	var percent float64
+	var hasPercent bool
+	if v := query.Get("percent"); v != "" {
+		var err error
+		if percent, err = strconv.ParseFloat(v, 64); err != nil || percent < 0 || percent > 100 {
+			return nil, fmt.Errorf("invalid percent")
+		}
+		hasPercent = true
+	}
+	if v := query.Get("circuit"); v != "" {
+		if strings.ContainsAny(v, "/\\") || strings.HasPrefix(v, ".") {
+			return nil, fmt.Errorf("invalid circuit name")
+		}
+		if !hasPercent {
+			return nil, fmt.Errorf("percent is required to load a canary")
+		}
+		start := time.Now()
+		if err := s.LoadCanary(v, percent); err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"canary": s.canaryStatus(), "loadMs": time.Since(start).Milliseconds()}, nil
+	}
+	if !hasPercent {
+		return map[string]interface{}{"canary": s.canaryStatus()}, nil
+	}
+	s.circuitMu.Lock()
+	if s.canary == nil {
+		s.circuitMu.Unlock()
+		return nil, fmt.Errorf("no canary circuit is loaded")
+	}
+	s.canary.Percent = percent
+	s.circuitMu.Unlock()
+	log.Printf("Routing %g%% of jobs to the canary circuit\n", percent)
+	return map[string]interface{}{"canary": s.canaryStatus()}, nil
+}
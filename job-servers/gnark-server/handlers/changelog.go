@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"gnark-server/apierror"
+	"gnark-server/rediskey"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+const (
+	redisChangelogKey         = "changelog"
+	redisChangelogVkKeyPrefix = "changelog_vk:"
+
+	ChangeVkRotation   = "vk_rotation"
+	ChangeSchemaChange = "schema_change"
+	ChangeAnnouncement = "announcement"
+)
+
+// ChangelogEntry announces a change clients may need to react to. Entries are
+// ordered by EffectiveAt, which can lie in the future for planned changes.
+type ChangelogEntry struct {
+	Id          string    `json:"id"`
+	Type        string    `json:"type"`
+	Circuit     string    `json:"circuit,omitempty"`
+	Breaking    bool      `json:"breaking"`
+	Description string    `json:"description"`
+	EffectiveAt time.Time `json:"effectiveAt"`
+	RecordedAt  time.Time `json:"recordedAt"`
+}
+
+func (s *State) addChangelogEntry(ctx context.Context, entry ChangelogEntry) error {
+	entryJSON, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return s.RedisClient.ZAdd(ctx, rediskey.Key(redisChangelogKey), &redis.Z{
+		Score:  float64(entry.EffectiveAt.UnixMilli()),
+		Member: entryJSON,
+	}).Err()
+}
+
+// RecordVkRotation adds a vk_rotation entry when the loaded verifying key of
+// the current circuit differs from the last one recorded.
+func (s *State) RecordVkRotation(ctx context.Context) error {
+	info, err := s.circuitInfo()
+	if err != nil {
+		return err
+	}
+	previous, err := s.RedisClient.GetSet(ctx, rediskey.Key(redisChangelogVkKeyPrefix)+info.Circuit, info.VerifyingKeyHash).Result()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+	if previous == info.VerifyingKeyHash {
+		return nil
+	}
+	now := time.Now().UTC()
+	description := fmt.Sprintf("verifying key is now %s", info.VerifyingKeyHash)
+	if previous != "" {
+		description = fmt.Sprintf("verifying key rotated from %s to %s", previous, info.VerifyingKeyHash)
+	}
+	return s.addChangelogEntry(ctx, ChangelogEntry{
+		Id:          uuid.NewString(),
+		Type:        ChangeVkRotation,
+		Circuit:     info.Circuit,
+		Breaking:    previous != "",
+		Description: description,
+		EffectiveAt: now,
+		RecordedAt:  now,
+	})
+}
+
+func (s *State) Changelog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		apierror.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	min := "-inf"
+	if v := r.URL.Query().Get("since"); v != "" {
+		since, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			apierror.Error(w, "Invalid since: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		min = fmt.Sprint(since.UnixMilli())
+	}
+	members, err := s.RedisClient.ZRangeByScore(r.Context(), rediskey.Key(redisChangelogKey), &redis.ZRangeBy{Min: min, Max: "+inf"}).Result()
+	if err != nil {
+		apierror.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	entries := make([]ChangelogEntry, 0, len(members))
+	for _, member := range members {
+		var entry ChangelogEntry
+		if err := json.Unmarshal([]byte(member), &entry); err != nil {
+			log.Printf("Skipping malformed changelog entry: %v\n", err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	json.NewEncoder(w).Encode(entries)
+}
+
+// AnnounceChange lets operators publish planned changes, such as a schema
+// bump or an upcoming vk rotation, ahead of their effective time.
+func (s *State) AnnounceChange(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apierror.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var entry ChangelogEntry
+	if err := json.NewDecoder(r.Body).Decode(&entry); err != nil {
+		apierror.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	switch entry.Type {
+	case ChangeVkRotation, ChangeSchemaChange, ChangeAnnouncement:
+	default:
+		apierror.Error(w, "Invalid type", http.StatusBadRequest)
+		return
+	}
+	if entry.Description == "" || entry.EffectiveAt.IsZero() {
+		apierror.Error(w, "description and effectiveAt are required", http.StatusBadRequest)
+		return
+	}
+	entry.Id = uuid.NewString()
+	entry.RecordedAt = time.Now().UTC()
+	if err := s.addChangelogEntry(r.Context(), entry); err != nil {
+		apierror.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	s.audit(r, "changelog-announce", "", map[string]string{"id": entry.Id, "type": entry.Type, "description": entry.Description})
+	json.NewEncoder(w).Encode(entry)
+}
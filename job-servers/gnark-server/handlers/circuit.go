@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"gnark-server/apierror"
+	"gnark-server/circuitData"
+
+	"golang.org/x/crypto/sha3"
+)
+
+type CircuitInfo struct {
+	Circuit           string `json:"circuit"`
+	VerifyingKey      string `json:"verifyingKey"`
+	VerifyingKeyHash  string `json:"verifyingKeyKeccak256"`
+	NbConstraints     int    `json:"nbConstraints"`
+	NbPublicVariables int    `json:"nbPublicInputs"`
+	// SelfVerify reports whether produced proofs are verified before a job
+	// succeeds.
+	SelfVerify bool `json:"selfVerify"`
+}
+
+// serializeVk returns the verifying key of data and its keccak256 hash.
+func serializeVk(data *circuitData.CircuitData) ([]byte, string, error) {
+	var vk bytes.Buffer
+	if _, err := data.Vk.WriteTo(&vk); err != nil {
+		return nil, "", err
+	}
+	digest := sha3.NewLegacyKeccak256()
+	digest.Write(vk.Bytes())
+	return vk.Bytes(), "0x" + hex.EncodeToString(digest.Sum(nil)), nil
+}
+
+func (s *State) circuitInfo() (CircuitInfo, error) {
+	circuitName, data := s.circuit()
+	vk, vkHash, err := serializeVk(data)
+	if err != nil {
+		return CircuitInfo{}, err
+	}
+	return CircuitInfo{
+		Circuit:           circuitName,
+		VerifyingKey:      hex.EncodeToString(vk),
+		VerifyingKeyHash:  vkHash,
+		NbConstraints:     data.Ccs.GetNbConstraints(),
+		NbPublicVariables: int(data.Vk.NbPublicVariables),
+		SelfVerify:        s.selfVerify(circuitName),
+	}, nil
+}
+
+func (s *State) CircuitInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		apierror.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	info, err := s.circuitInfo()
+	if err != nil {
+		log.Printf("Failed to serialize verifying key: %v\n", err)
+		apierror.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(info)
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+
+	"gnark-server/atrest"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+
+	// minCompressBytes keeps small records such as pending job records
+	// uncompressed, where the header would outweigh the savings.
+	minCompressBytes = 1024
+)
+
+// Compressed and encrypted records start with a zero byte, which can't
+// start a JSON record, followed by a byte naming the encoding.
+const (
+	headerGzip      byte = 'g'
+	headerZstd      byte = 'z'
+	headerEncrypted byte = 'e'
+)
+
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+// encodeRecord compresses a JSON record for Redis with the configured
+// compression, then encrypts it if RecordKeys is set.
+func (s *State) encodeRecord(record []byte) []byte {
+	return sealRecord(s.RecordKeys, compressRecord(s.Compression, record))
+}
+
+// sealRecord encrypts an encoded record, unless keys is nil.
+func sealRecord(keys *atrest.Keyring, record []byte) []byte {
+	if keys == nil {
+		return record
+	}
+	return keys.Seal([]byte{0, headerEncrypted}, record)
+}
+
+// compressRecord compresses a JSON record with compression, one of the
+// Compression constants. Records are stored as they are if compression fails.
+func compressRecord(compression string, record []byte) []byte {
+	if len(record) < minCompressBytes {
+		return record
+	}
+	switch compression {
+	case CompressionZstd:
+		return zstdEncoder.EncodeAll(record, []byte{0, headerZstd})
+	case CompressionGzip:
+		buf := bytes.NewBuffer([]byte{0, headerGzip})
+		writer := gzip.NewWriter(buf)
+		if _, err := writer.Write(record); err != nil {
+			log.Printf("Failed to compress record: %v\n", err)
+			return record
+		}
+		if err := writer.Close(); err != nil {
+			log.Printf("Failed to compress record: %v\n", err)
+			return record
+		}
+		return buf.Bytes()
+	}
+	return record
+}
+
+// decodeRecord returns the JSON of a record read from Redis, whatever the
+// compression it was stored with. Encrypted records are opened with keys;
+// plain records written before encryption was enabled are still read.
+func decodeRecord(keys *atrest.Keyring, record []byte) ([]byte, error) {
+	if len(record) < 2 || record[0] != 0 {
+		return record, nil
+	}
+	switch record[1] {
+	case headerEncrypted:
+		if keys == nil {
+			return nil, fmt.Errorf("record is encrypted and no encryption key is configured")
+		}
+		plaintext, err := keys.Open(record[2:])
+		if err != nil {
+			return nil, err
+		}
+		if len(plaintext) >= 2 && plaintext[0] == 0 && plaintext[1] == headerEncrypted {
+			return nil, fmt.Errorf("record is encrypted twice")
+		}
+		return decodeRecord(keys, plaintext)
+	case headerZstd:
+		return zstdDecoder.DecodeAll(record[2:], nil)
+	case headerGzip:
+		reader, err := gzip.NewReader(bytes.NewReader(record[2:]))
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		return io.ReadAll(reader)
+	}
+	return nil, fmt.Errorf("unknown record encoding %q", record[1])
+}
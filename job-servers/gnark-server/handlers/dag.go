@@ -0,0 +1,491 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gnark-server/accesslog"
+	"gnark-server/apierror"
+	"gnark-server/auth"
+	"gnark-server/rediskey"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+const (
+	redisDagKeyPrefix      = "dag:"
+	redisDagStateKeyPrefix = "dag_state:"
+	maxDagJobs             = 32
+
+	dagStatusRunning   = "running"
+	dagStatusSucceeded = "succeeded"
+	dagStatusFailed    = "failed"
+)
+
+type dagNode struct {
+	Name      string   `json:"name"`
+	JobId     string   `json:"jobId"`
+	DependsOn []string `json:"dependsOn,omitempty"`
+	// PublicInputsFrom maps indices of the job's public inputs to the
+	// public inputs of its dependencies they must equal, as "<name>.<index>".
+	PublicInputsFrom map[int]string `json:"publicInputsFrom,omitempty"`
+}
+
+type dagRecord struct {
+	DagId     string    `json:"dagId"`
+	Jobs      []dagNode `json:"jobs"`
+	CreatedAt time.Time `json:"createdAt"`
+	Owner     string    `json:"owner,omitempty"`
+}
+
+type DagJobStatus struct {
+	dagNode
+	Status       string  `json:"status" openapi:"required,enum=running|succeeded|failed"`
+	ErrorMessage *string `json:"errorMessage,omitempty"`
+	ErrorCode    string  `json:"errorCode,omitempty"`
+}
+
+type DagStatus struct {
+	DagId  string         `json:"dagId" openapi:"required,format=uuid"`
+	Status string         `json:"status" openapi:"required,enum=running|succeeded|failed"`
+	Jobs   []DagJobStatus `json:"jobs"`
+}
+
+type startDagRequest struct {
+	Jobs []startDagJob `json:"jobs" openapi:"required"`
+}
+
+type startDagJob struct {
+	Name             string         `json:"name" openapi:"required"`
+	DependsOn        []string       `json:"dependsOn" doc:"The names of the jobs this job waits for."`
+	PublicInputsFrom map[int]string `json:"publicInputsFrom" doc:"Public input indices of this job mapped to public inputs proven by its dependencies, as \"<name>.<index>\", that they must equal."`
+	startProofRequest
+}
+
+type startDagResponse struct {
+	DagId string `json:"dagId" openapi:"required,format=uuid"`
+	// Jobs maps job names to their job ids.
+	Jobs map[string]string `json:"jobs" openapi:"required"`
+}
+
+func getDagRedisKey(dagId string) string {
+	return fmt.Sprintf("%s%s", rediskey.Key(redisDagKeyPrefix), dagId)
+}
+
+// getDagStateRedisKey is the hash of the jobs of a DAG that were started or
+// cancelled, by name, to the node that did it.
+func getDagStateRedisKey(dagId string) string {
+	return fmt.Sprintf("%s%s", rediskey.Key(redisDagStateKeyPrefix), dagId)
+}
+
+// checkDag checks that job names are unique, every dependency names a job of
+// the DAG and that there are no cycles.
+func checkDag(nodes []dagNode) error {
+	remaining := make(map[string]int, len(nodes))
+	dependents := make(map[string][]string)
+	for _, node := range nodes {
+		if node.Name == "" {
+			return fmt.Errorf("every job needs a name")
+		}
+		if _, ok := remaining[node.Name]; ok {
+			return fmt.Errorf("duplicate job name %q", node.Name)
+		}
+		remaining[node.Name] = len(node.DependsOn)
+	}
+	var ready []string
+	for _, node := range nodes {
+		for _, dep := range node.DependsOn {
+			if _, ok := remaining[dep]; !ok {
+				return fmt.Errorf("job %q depends on unknown job %q", node.Name, dep)
+			}
+			dependents[dep] = append(dependents[dep], node.Name)
+		}
+		if len(node.DependsOn) == 0 {
+			ready = append(ready, node.Name)
+		}
+	}
+	visited := 0
+	for len(ready) > 0 {
+		name := ready[0]
+		ready = ready[1:]
+		visited++
+		for _, dependent := range dependents[name] {
+			remaining[dependent]--
+			if remaining[dependent] == 0 {
+				ready = append(ready, dependent)
+			}
+		}
+	}
+	if visited != len(nodes) {
+		return fmt.Errorf("dependencies contain a cycle")
+	}
+	return nil
+}
+
+// parseDagOutput splits a reference to a public input of another job,
+// "<name>.<index>".
+func parseDagOutput(ref string) (string, int, error) {
+	dot := strings.LastIndex(ref, ".")
+	if dot < 0 {
+		return "", 0, fmt.Errorf("%q is not <name>.<index>", ref)
+	}
+	index, err := strconv.Atoi(ref[dot+1:])
+	if err != nil || index < 0 {
+		return "", 0, fmt.Errorf("%q is not <name>.<index>", ref)
+	}
+	return ref[:dot], index, nil
+}
+
+// checkPublicInputsFrom checks that the public inputs node takes from other
+// jobs come from its dependencies, whose results are not encrypted, into
+// public inputs of job it does not expect values of already.
+func checkPublicInputsFrom(node dagNode, job proofJob, jobs map[string]proofJob) error {
+	for index, ref := range node.PublicInputsFrom {
+		name, _, err := parseDagOutput(ref)
+		if err != nil {
+			return err
+		}
+		if index < 0 || index >= len(job.Input.PublicInputs) {
+			return fmt.Errorf("public input index %d out of range [0, %d)", index, len(job.Input.PublicInputs))
+		}
+		if _, ok := job.ExpectedPublicInputs[index]; ok {
+			return fmt.Errorf("public input %d is both expected and taken from %q", index, name)
+		}
+		isDependency := false
+		for _, dep := range node.DependsOn {
+			isDependency = isDependency || dep == name
+		}
+		if !isDependency {
+			return fmt.Errorf("public input %d is taken from %q, which is not a dependency", index, name)
+		}
+		if len(jobs[name].ResultPublicKey) > 0 {
+			return fmt.Errorf("public input %d is taken from %q, whose result is encrypted", index, name)
+		}
+	}
+	return nil
+}
+
+// bindPublicInputs makes job expect the public inputs proven by its
+// dependencies that node takes, from their results.
+func bindPublicInputs(job *proofJob, node dagNode, results map[string]ProofResponse) error {
+	for index, ref := range node.PublicInputsFrom {
+		name, from, err := parseDagOutput(ref)
+		if err != nil {
+			return err
+		}
+		publicInputs := results[name].Proof.PublicInputs
+		if from >= len(publicInputs) {
+			return fmt.Errorf("public input %d is taken from %s, but %q has %d public inputs", index, ref, name, len(publicInputs))
+		}
+		value, ok := new(big.Int).SetString(publicInputs[from], 0)
+		if !ok {
+			return fmt.Errorf("public input %s is not a number: %q", ref, publicInputs[from])
+		}
+		if job.ExpectedPublicInputs == nil {
+			job.ExpectedPublicInputs = make(map[int]*big.Int, len(node.PublicInputsFrom))
+		}
+		job.ExpectedPublicInputs[index] = value
+	}
+	return nil
+}
+
+// StartDag accepts a set of proof jobs with dependencies between them. Each
+// job starts once all of its dependencies succeeded, on the node that
+// finished the last of them, and fails without being proven if one of them
+// failed.
+func (s *State) StartDag(w http.ResponseWriter, r *http.Request) {
+	if !s.authorize(w, r, auth.OperationStartProof) {
+		return
+	}
+	var request startDagRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		apierror.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(request.Jobs) == 0 || len(request.Jobs) > maxDagJobs {
+		apierror.Error(w, fmt.Sprintf("a DAG must have between 1 and %d jobs", maxDagJobs), http.StatusBadRequest)
+		return
+	}
+
+	profile := auth.FromContext(r.Context()).Profile
+	record := dagRecord{DagId: uuid.NewString(), CreatedAt: time.Now().UTC(), Owner: auth.FromContext(r.Context()).Owner()}
+	accesslog.SetDag(r.Context(), record.DagId)
+	jobs := make(map[string]proofJob, len(request.Jobs))
+	for _, rawJob := range request.Jobs {
+		job, status, err := s.buildJob(rawJob.startProofRequest, profile)
+		if err == nil && rawJob.NotBefore != nil {
+			status, err = http.StatusBadRequest, fmt.Errorf("notBefore is not supported in DAGs")
+		}
+		if err != nil {
+			details := map[string]interface{}{"job": rawJob.Name}
+			var schemaErr *inputSchemaError
+			if errors.As(err, &schemaErr) {
+				details["errors"] = schemaErr.Errors
+			}
+			apierror.WithDetails(w, fmt.Sprintf("job %q: %v", rawJob.Name, err), status, details)
+			return
+		}
+		job.RequestId = apierror.RequestID(r.Context())
+		job.Tenant = auth.FromContext(r.Context()).TenantName()
+		job.Owner = record.Owner
+		job.Key = auth.FromContext(r.Context()).Name
+		job.KeyId = auth.FromContext(r.Context()).KeyId()
+		job.DagId = record.DagId
+		record.Jobs = append(record.Jobs, dagNode{Name: rawJob.Name, JobId: job.JobId, DependsOn: rawJob.DependsOn, PublicInputsFrom: rawJob.PublicInputsFrom})
+		jobs[rawJob.Name] = job
+	}
+	if err := checkDag(record.Jobs); err != nil {
+		apierror.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, node := range record.Jobs {
+		if err := checkPublicInputsFrom(node, jobs[node.Name], jobs); err != nil {
+			apierror.WithDetails(w, fmt.Sprintf("job %q: %v", node.Name, err), http.StatusBadRequest, map[string]string{"job": node.Name})
+			return
+		}
+	}
+	if !s.admit(w, len(record.Jobs)) {
+		return
+	}
+
+	ctx := context.Background()
+	identity := auth.FromContext(r.Context())
+	pendingIds := make([]string, len(record.Jobs))
+	for i, node := range record.Jobs {
+		pendingIds[i] = node.JobId
+	}
+	if !s.claimUsage(ctx, w, identity, len(pendingIds)) {
+		return
+	}
+	claimed, ok := s.claimQuota(ctx, w, identity, pendingIds)
+	if !ok {
+		s.releaseUsage(ctx, identity, len(pendingIds))
+		return
+	}
+	for i, node := range record.Jobs {
+		if status, err := s.verifyPayment(ctx, jobs[node.Name], identity); err != nil {
+			for _, previous := range record.Jobs[:i] {
+				s.releasePayment(ctx, jobs[previous.Name])
+			}
+			s.releaseQuota(ctx, identity.TenantName(), claimed)
+			s.releaseUsage(ctx, identity, len(pendingIds))
+			s.writePaymentError(w, fmt.Sprintf("job %q: %v", node.Name, err), status)
+			return
+		}
+	}
+	// reject fails the jobs reserved so far, which would otherwise stay
+	// pending forever, and releases what the DAG claimed.
+	reject := func(reserved []dagNode) {
+		for _, node := range reserved {
+			s.failJob(ctx, jobs[node.Name], withCode(ErrorCodeCancelled, fmt.Errorf("DAG was rejected")))
+		}
+		for _, node := range record.Jobs {
+			s.releasePayment(ctx, jobs[node.Name])
+		}
+		s.releaseQuota(ctx, identity.TenantName(), claimed)
+		s.releaseUsage(ctx, identity, len(pendingIds))
+	}
+	for i, node := range record.Jobs {
+		reserved, err := s.reserveJob(ctx, jobs[node.Name])
+		if err != nil || !reserved {
+			reject(record.Jobs[:i])
+		}
+		if err != nil {
+			log.Printf("Failed to store proof response in Redis: %v\n", err)
+			s.storeError(w, err)
+			return
+		}
+		if !reserved {
+			apierror.WithDetails(w, fmt.Sprintf("job %q: jobId %s is already in use", node.Name, node.JobId), http.StatusConflict, map[string]string{"job": node.Name})
+			return
+		}
+	}
+	// The jobs wait in Redis for their dependencies, so that the node
+	// finishing the last of them starts them. The DAG is stored last: until
+	// then, finishing a job does not advance it.
+	for _, node := range record.Jobs {
+		if err := s.writeJobSpec(ctx, jobSpec{proofJob: jobs[node.Name], Node: s.NodeId, Waiting: true}); err != nil {
+			log.Printf("Failed to store job in Redis: %v\n", err)
+			reject(record.Jobs)
+			s.storeError(w, err)
+			return
+		}
+	}
+	recordJSON, err := json.Marshal(record)
+	if err != nil {
+		reject(record.Jobs)
+		apierror.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if err := s.RedisClient.Set(ctx, getDagRedisKey(record.DagId), recordJSON, s.ResultTTL).Err(); err != nil {
+		log.Printf("Failed to store DAG in Redis: %v\n", err)
+		reject(record.Jobs)
+		s.storeError(w, err)
+		return
+	}
+
+	s.advanceDag(ctx, record.DagId)
+	jobIds := make(map[string]string, len(record.Jobs))
+	idList := make([]string, len(record.Jobs))
+	for i, node := range record.Jobs {
+		jobIds[node.Name] = node.JobId
+		idList[i] = node.JobId
+	}
+	s.audit(r, "start-dag", "", map[string]string{"dagId": record.DagId, "jobIds": strings.Join(idList, ","), "requestId": apierror.RequestID(r.Context())})
+	json.NewEncoder(w).Encode(startDagResponse{DagId: record.DagId, Jobs: jobIds})
+	log.Println("StartDag", record.DagId, "jobs", len(record.Jobs), "requestId", apierror.RequestID(r.Context()))
+}
+
+func (s *State) getDag(ctx context.Context, dagId string) (dagRecord, error) {
+	var record dagRecord
+	recordJSON, err := s.RedisClient.Get(ctx, getDagRedisKey(dagId)).Bytes()
+	if err != nil {
+		return record, err
+	}
+	err = json.Unmarshal(recordJSON, &record)
+	return record, err
+}
+
+// advanceDag starts the waiting jobs of the DAG dagId whose dependencies all
+// succeeded, and cancels those with a failed dependency. It runs whenever a
+// job of the DAG finished, on the node that finished it; every job is claimed
+// in the DAG state first, so that only one node starts or cancels it.
+func (s *State) advanceDag(ctx context.Context, dagId string) {
+	if dagId == "" {
+		return
+	}
+	record, err := s.getDag(ctx, dagId)
+	if err == redis.Nil {
+		// The DAG was rejected, or has expired.
+		return
+	} else if err != nil {
+		log.Printf("Failed to read DAG %s from Redis: %v\n", dagId, err)
+		return
+	}
+	// results holds the jobs that finished, an expired result counting as
+	// a failure.
+	results := make(map[string]ProofResponse, len(record.Jobs))
+	for _, node := range record.Jobs {
+		response, err := s.getProofResponse(ctx, node.JobId)
+		if err == errResultNotFound {
+			results[node.Name] = ProofResponse{Success: false}
+		} else if err != nil {
+			log.Printf("Failed to read result of job %s from Redis: %v\n", node.JobId, err)
+			return
+		} else if !response.Success || response.Proof != nil {
+			results[node.Name] = response
+		}
+	}
+
+	for _, node := range record.Jobs {
+		if _, finished := results[node.Name]; finished {
+			continue
+		}
+		ready, failedDep := true, ""
+		for _, dep := range node.DependsOn {
+			result, finished := results[dep]
+			if !finished {
+				ready = false
+			} else if !result.Success {
+				failedDep = dep
+				break
+			}
+		}
+		if !ready && failedDep == "" {
+			continue
+		}
+		stateKey := getDagStateRedisKey(dagId)
+		claimed, err := s.RedisClient.HSetNX(ctx, stateKey, node.Name, s.NodeId).Result()
+		if err != nil {
+			log.Printf("Failed to claim job %s of DAG %s in Redis: %v\n", node.JobId, dagId, err)
+			return
+		}
+		if !claimed {
+			continue
+		}
+		s.RedisClient.Expire(ctx, stateKey, s.ResultTTL)
+
+		job := proofJob{JobId: node.JobId, DagId: dagId}
+		spec, err := s.getJobSpec(ctx, node.JobId)
+		if err == nil {
+			job = spec.proofJob
+		}
+		switch {
+		case failedDep != "":
+			s.failJob(ctx, job, withCode(ErrorCodeCancelled, fmt.Errorf("dependency %q failed", failedDep)))
+		case err != nil:
+			log.Printf("Failed to read job %s of DAG %s from Redis: %v\n", node.JobId, dagId, err)
+			s.failJob(ctx, job, withCode(ErrorCodeInternal, fmt.Errorf("the stored input of the job is gone")))
+		default:
+			if err := bindPublicInputs(&job, node, results); err != nil {
+				s.failJob(ctx, job, withCode(ErrorCodeInvalidInput, err))
+			} else if !s.finishFromCache(ctx, job) {
+				s.submit(job)
+			}
+		}
+	}
+}
+
+// GetDag reports the status of every job of a DAG.
+func (s *State) GetDag(w http.ResponseWriter, r *http.Request) {
+	if !s.authorize(w, r, auth.OperationGetProof) {
+		return
+	}
+	dagId := r.URL.Query().Get("dagId")
+	if _, err := uuid.Parse(dagId); err != nil {
+		apierror.Error(w, "Invalid dagId", http.StatusBadRequest)
+		return
+	}
+	ctx := r.Context()
+	record, err := s.getDag(ctx, dagId)
+	if err == redis.Nil {
+		apierror.Error(w, "DAG not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("Failed to read DAG from Redis: %v\n", err)
+		s.storeError(w, err)
+		return
+	}
+	if !ownedBy(record.Owner, r) {
+		apierror.Error(w, "DAG not found", http.StatusNotFound)
+		return
+	}
+
+	status := DagStatus{DagId: dagId, Status: dagStatusSucceeded}
+	for _, node := range record.Jobs {
+		jobStatus := DagJobStatus{dagNode: node, Status: dagStatusRunning}
+		response, err := s.getProofResponse(ctx, node.JobId)
+		switch {
+		case err == errResultNotFound:
+			jobStatus.Status = dagStatusFailed
+			errMsg := "job result expired"
+			jobStatus.ErrorMessage = &errMsg
+		case err != nil:
+			s.storeError(w, err)
+			return
+		case !response.Success:
+			jobStatus.Status = dagStatusFailed
+			jobStatus.ErrorMessage = response.ErrorMessage
+			jobStatus.ErrorCode = response.ErrorCode
+		case response.Proof != nil:
+			jobStatus.Status = dagStatusSucceeded
+		}
+		if jobStatus.Status == dagStatusFailed {
+			status.Status = dagStatusFailed
+		} else if jobStatus.Status == dagStatusRunning && status.Status != dagStatusFailed {
+			status.Status = dagStatusRunning
+		}
+		status.Jobs = append(status.Jobs, jobStatus)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"gnark-server/memstore"
+
+	"github.com/google/uuid"
+)
+
+// testDag stores the jobs of nodes as start-dag does, waiting for their
+// dependencies, and the DAG itself.
+func testDag(t *testing.T, s *State, nodes []dagNode) dagRecord {
+	t.Helper()
+	ctx := context.Background()
+	record := dagRecord{DagId: uuid.NewString(), Jobs: nodes}
+	for i := range record.Jobs {
+		record.Jobs[i].JobId = uuid.NewString()
+		job := proofJob{JobId: record.Jobs[i].JobId, DagId: record.DagId}
+		if reserved, err := s.reserveJob(ctx, job); err != nil || !reserved {
+			t.Fatalf("reserveJob: %v %v", reserved, err)
+		}
+		if err := s.writeJobSpec(ctx, jobSpec{proofJob: job, Node: s.NodeId, Waiting: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	recordJSON, _ := json.Marshal(record)
+	if err := s.RedisClient.Set(ctx, getDagRedisKey(record.DagId), recordJSON, time.Hour).Err(); err != nil {
+		t.Fatal(err)
+	}
+	return record
+}
+
+// queuedJobIds pops the jobs queued on s.
+func queuedJobIds(s *State) map[string]proofJob {
+	queued := make(map[string]proofJob)
+	for s.queue.len() > 0 {
+		job := s.queue.pop().job
+		queued[job.JobId] = job
+	}
+	return queued
+}
+
+func TestAdvanceDagStartsDependentsWithTheirInputs(t *testing.T) {
+	ctx := context.Background()
+	s := &State{RedisClient: newMemoryRedis(t), Results: memstore.NewResults(), ResultTTL: time.Hour, NodeId: "node", queue: newJobQueue(4)}
+	record := testDag(t, s, []dagNode{
+		{Name: "a"},
+		{Name: "b"},
+		{Name: "aggregate", DependsOn: []string{"a", "b"}, PublicInputsFrom: map[int]string{0: "a.1", 1: "b.0"}},
+	})
+	a, b, aggregate := record.Jobs[0], record.Jobs[1], record.Jobs[2]
+
+	s.advanceDag(ctx, record.DagId)
+	if queued := queuedJobIds(s); len(queued) != 2 || queued[a.JobId].JobId == "" || queued[b.JobId].JobId == "" {
+		t.Fatalf("queued %v, want the jobs without dependencies", queued)
+	}
+	// Advancing again, as another node would, starts nothing twice.
+	s.advanceDag(ctx, record.DagId)
+	if queued := queuedJobIds(s); len(queued) != 0 {
+		t.Fatalf("queued %v again", queued)
+	}
+
+	finish := func(jobId string, publicInputs ...string) {
+		t.Helper()
+		response := ProofResponse{Success: true, Proof: &ProveResult{PublicInputs: publicInputs}}
+		if err := s.finishJob(ctx, proofJob{JobId: jobId, DagId: record.DagId}, response); err != nil {
+			t.Fatal(err)
+		}
+	}
+	finish(a.JobId, "7", "11")
+	if queued := queuedJobIds(s); len(queued) != 0 {
+		t.Fatalf("queued %v before every dependency finished", queued)
+	}
+	finish(b.JobId, "13")
+	queued := queuedJobIds(s)
+	job, ok := queued[aggregate.JobId]
+	if !ok || len(queued) != 1 {
+		t.Fatalf("queued %v once its dependencies finished", queued)
+	}
+	if job.ExpectedPublicInputs[0].Int64() != 11 || job.ExpectedPublicInputs[1].Int64() != 13 {
+		t.Fatalf("expected public inputs %v, want those of the dependencies", job.ExpectedPublicInputs)
+	}
+}
+
+func TestAdvanceDagCancelsDependentsOfFailedJobs(t *testing.T) {
+	ctx := context.Background()
+	s := &State{RedisClient: newMemoryRedis(t), Results: memstore.NewResults(), ResultTTL: time.Hour, NodeId: "node", queue: newJobQueue(4)}
+	record := testDag(t, s, []dagNode{
+		{Name: "a"},
+		{Name: "b", DependsOn: []string{"a"}},
+		{Name: "c", DependsOn: []string{"b"}},
+	})
+	s.advanceDag(ctx, record.DagId)
+	queuedJobIds(s)
+
+	s.failJob(ctx, proofJob{JobId: record.Jobs[0].JobId, DagId: record.DagId}, withCode(ErrorCodeProveFailed, context.Canceled))
+	for _, node := range record.Jobs[1:] {
+		response, err := s.getProofResponse(ctx, node.JobId)
+		if err != nil || response.Success || response.ErrorCode != ErrorCodeCancelled {
+			t.Fatalf("job %s: %+v %v, want it cancelled", node.Name, response, err)
+		}
+	}
+	if queued := queuedJobIds(s); len(queued) != 0 {
+		t.Fatalf("queued %v after a dependency failed", queued)
+	}
+}
+
+func TestCheckPublicInputsFrom(t *testing.T) {
+	request, _ := testRequest(t)
+	job := proofJob{Input: request.Proof.proofInput}
+	jobs := map[string]proofJob{"a": {}, "sealed": {ResultPublicKey: []byte{1}}}
+	for ref, valid := range map[string]bool{"a.0": true, "a": false, "a.-1": false, "b.0": false, "sealed.0": false} {
+		node := dagNode{Name: "c", DependsOn: []string{"a", "sealed"}, PublicInputsFrom: map[int]string{0: ref}}
+		if err := checkPublicInputsFrom(node, job, jobs); (err == nil) != valid {
+			t.Errorf("%s: %v", ref, err)
+		}
+	}
+	node := dagNode{Name: "c", DependsOn: []string{"a"}, PublicInputsFrom: map[int]string{len(job.Input.PublicInputs): "a.0"}}
+	if err := checkPublicInputsFrom(node, job, jobs); err == nil {
+		t.Error("public input index out of range accepted")
+	}
+}
@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gnark-server/apierror"
+	"gnark-server/rediskey"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+const (
+	redisDeadLetterKey       = "dead_letter"
+	redisDeadLetterKeyPrefix = "dead_letter:"
+
+	defaultDeadLetterLimit = 100
+	maxDeadLetterLimit     = 1000
+)
+
+func getDeadLetterRedisKey(jobId string) string {
+	return rediskey.JobKey(redisDeadLetterKeyPrefix, jobId)
+}
+
+type DeadLetter struct {
+	JobId        string    `json:"jobId"`
+	ErrorCode    string    `json:"errorCode"`
+	ErrorMessage string    `json:"errorMessage"`
+	Attempts     int       `json:"attempts"`
+	Tenant       string    `json:"tenant,omitempty"`
+	FailedAt     time.Time `json:"failedAt"`
+}
+
+// deadLetterRecord is a dead letter with the job it was created from, input
+// included.
+type deadLetterRecord struct {
+	DeadLetter
+	Job proofJob `json:"job"`
+}
+
+// deadLetterable reports whether a failed job goes to the dead-letter queue:
+// it must have been submitted with its input, and cancelled jobs never ran.
+func deadLetterable(job proofJob, response ProofResponse) bool {
+	return !response.Success && job.Input.present() && response.ErrorCode != ErrorCodeCancelled
+}
+
+// queueDeadLetter adds the dead letter of a failed job to pipe, dropping
+// entries older than DeadLetterTTL from the index.
+func (s *State) queueDeadLetter(ctx context.Context, pipe redis.Pipeliner, job proofJob, response ProofResponse) error {
+	record := deadLetterRecord{
+		DeadLetter: DeadLetter{
+			JobId:     job.JobId,
+			ErrorCode: response.ErrorCode,
+			Attempts:  response.Attempts,
+			Tenant:    job.Tenant,
+			FailedAt:  time.Now().UTC(),
+		},
+		Job: job,
+	}
+	if response.ErrorMessage != nil {
+		record.ErrorMessage = *response.ErrorMessage
+	}
+	recordJSON, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	pipe.Set(ctx, getDeadLetterRedisKey(job.JobId), s.encodeRecord(recordJSON), s.DeadLetterTTL)
+	pipe.ZAdd(ctx, rediskey.Key(redisDeadLetterKey), &redis.Z{Score: float64(record.FailedAt.UnixMilli()), Member: job.JobId})
+	cutoff := record.FailedAt.Add(-s.DeadLetterTTL).UnixMilli()
+	pipe.ZRemRangeByScore(ctx, rediskey.Key(redisDeadLetterKey), "-inf", fmt.Sprint(cutoff))
+	return nil
+}
+
+func (s *State) getDeadLetter(ctx context.Context, jobId string) (deadLetterRecord, error) {
+	var record deadLetterRecord
+	stored, err := s.RedisClient.Get(ctx, getDeadLetterRedisKey(jobId)).Bytes()
+	if err != nil {
+		return record, err
+	}
+	recordJSON, err := decodeRecord(s.RecordKeys, stored)
+	if err != nil {
+		return record, err
+	}
+	err = json.Unmarshal(recordJSON, &record)
+	return record, err
+}
+
+// DeadLetters lists failed jobs, newest first, or with ?jobId= returns one of
+// them together with its original input.
+func (s *State) DeadLetters(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		apierror.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ctx := r.Context()
+	query := r.URL.Query()
+	w.Header().Set("Content-Type", "application/json")
+
+	if jobId := query.Get("jobId"); jobId != "" {
+		record, err := s.getDeadLetter(ctx, jobId)
+		if err == redis.Nil {
+			apierror.Error(w, "Dead letter not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			apierror.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"deadLetter": record.DeadLetter,
+			"request": startProofRequest{
+				Proof:                requestProof{proofInput: record.Job.Input},
+				JobId:                record.Job.JobId,
+				WebhookURL:           record.Job.WebhookURL,
+				ExpectedPublicInputs: formatExpectedPublicInputs(record.Job.ExpectedPublicInputs),
+				Race:                 record.Job.Race,
+				Format:               record.Job.Format,
+				Priority:             record.Job.Priority,
+				ResultTTL:            formatResultTTL(record.Job.ResultTTL),
+
+				ExpectedPublicInputsHash: formatExpectedPublicInputsHash(record.Job.ExpectedPublicInputsHash),
+				Metadata:                 record.Job.Metadata,
+			},
+		})
+		return
+	}
+
+	limit, offset := defaultDeadLetterLimit, 0
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxDeadLetterLimit {
+			apierror.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxDeadLetterLimit), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	if v := query.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			apierror.Error(w, "Invalid offset", http.StatusBadRequest)
+			return
+		}
+		offset = n
+	}
+	jobIds, err := s.RedisClient.ZRevRange(ctx, rediskey.Key(redisDeadLetterKey), int64(offset), int64(offset+limit-1)).Result()
+	if err != nil {
+		apierror.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	total, err := s.RedisClient.ZCard(ctx, rediskey.Key(redisDeadLetterKey)).Result()
+	if err != nil {
+		apierror.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	deadLetters := make([]DeadLetter, 0, len(jobIds))
+	for _, jobId := range jobIds {
+		record, err := s.getDeadLetter(ctx, jobId)
+		if err == redis.Nil {
+			continue
+		} else if err != nil {
+			apierror.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		deadLetters = append(deadLetters, record.DeadLetter)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"total": total, "deadLetters": deadLetters})
+}
+
+// RequeueJob runs a failed job again under its jobId, from a fresh attempt
+// count, with the input kept by its dead letter, and removes the dead letter.
+func (s *State) RequeueJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apierror.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	jobId := r.URL.Query().Get("jobId")
+	if _, err := uuid.Parse(jobId); err != nil {
+		apierror.Error(w, "Invalid jobId", http.StatusBadRequest)
+		return
+	}
+	ctx := r.Context()
+	record, err := s.getDeadLetter(ctx, jobId)
+	if err == redis.Nil {
+		apierror.Error(w, "No stored input for this job: it is not in the dead-letter queue", http.StatusNotFound)
+		return
+	} else if err != nil {
+		apierror.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	// The jobId may have been reused after the failed result expired.
+	if response, err := s.getProofResponse(ctx, jobId); err == nil && response.Success {
+		apierror.Error(w, "jobId is in use by another job", http.StatusConflict)
+		return
+	} else if err != nil && err != errResultNotFound {
+		apierror.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	job := record.Job
+	job.RequestId = apierror.RequestID(ctx)
+	if err := s.requeueJob(context.Background(), job); err != nil {
+		log.Printf("Failed to requeue dead letter in Redis: %v\n", err)
+		apierror.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	s.submit(job)
+	s.audit(r, "dead-letter-requeue", jobId, map[string]string{"errorCode": record.ErrorCode})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(startProofResponse{JobId: jobId})
+}
+
+// requeueJob marks a job pending again, counting it against the quota of its
+// tenant, and removes its dead letter.
+func (s *State) requeueJob(ctx context.Context, job proofJob) error {
+	jobId := job.JobId
+	responseJSON, err := json.Marshal(ProofResponse{Success: true, Proof: nil, Metadata: job.Metadata, Owner: job.Owner})
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	pipe := s.RedisClient.TxPipeline()
+	if err := s.setResult(ctx, pipe, jobId, responseJSON, s.ResultTTL); err != nil {
+		return err
+	}
+	pipe.ZAdd(ctx, rediskey.Key(redisPendingJobsKey), &redis.Z{Score: float64(now.UnixMilli()), Member: jobId})
+	s.indexJob(ctx, pipe, job, now)
+	countJob(ctx, pipe, job.Tenant, statSubmitted)
+	if job.Tenant != "" {
+		s.addPending(ctx, pipe, job.Tenant, jobId, now)
+	}
+	pipe.Del(ctx, getDeadLetterRedisKey(jobId))
+	pipe.ZRem(ctx, rediskey.Key(redisDeadLetterKey), jobId)
+	_, err = pipe.Exec(ctx)
+	return err
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"gnark-server/apierror"
+)
+
+const defaultDrainMessage = "Server is under maintenance, retry later"
+
+// drainStatus is set while the server is draining: it takes no new jobs
+// and reports not ready, but finishes those it has.
+type drainStatus struct {
+	Since   time.Time `json:"since"`
+	Message string    `json:"message"`
+}
+
+type DrainResponse struct {
+	Draining bool       `json:"draining"`
+	Since    *time.Time `json:"since,omitempty"`
+	Message  string     `json:"message,omitempty"`
+	// QueuedJobs and RunningJobs are the jobs left to finish.
+	QueuedJobs  int `json:"queuedJobs"`
+	RunningJobs int `json:"runningJobs"`
+}
+
+func (s *State) draining() *drainStatus {
+	s.drainMu.Lock()
+	defer s.drainMu.Unlock()
+	return s.drain
+}
+
+// rejectDraining answers 503 with the maintenance message while the server
+// is draining.
+func (s *State) rejectDraining(w http.ResponseWriter) bool {
+	drain := s.draining()
+	if drain == nil {
+		return false
+	}
+	w.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(s.QueueRetryAfter.Seconds()))))
+	apierror.Error(w, drain.Message, http.StatusServiceUnavailable)
+	return true
+}
+
+// Drain reports (GET), starts (POST, with an optional {"message": ...}) or
+// ends (DELETE) drain mode.
+func (s *State) Drain(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var body struct {
+			Message string `json:"message"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				apierror.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if body.Message == "" {
+			body.Message = defaultDrainMessage
+		}
+		s.drainMu.Lock()
+		if s.drain == nil {
+			s.drain = &drainStatus{Since: time.Now().UTC()}
+		}
+		s.drain.Message = body.Message
+		s.drainMu.Unlock()
+		s.audit(r, "drain-start", "", map[string]string{"message": body.Message})
+	case http.MethodDelete:
+		s.drainMu.Lock()
+		s.drain = nil
+		s.drainMu.Unlock()
+		s.audit(r, "drain-end", "", nil)
+	default:
+		apierror.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	response := DrainResponse{QueuedJobs: s.queue.len(), RunningJobs: s.queue.inFlight()}
+	if drain := s.draining(); drain != nil {
+		since := drain.Since
+		response.Draining, response.Since, response.Message = true, &since, drain.Message
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
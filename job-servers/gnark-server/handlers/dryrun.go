@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"gnark-server/apierror"
+	"gnark-server/auth"
+	"gnark-server/wrapper"
+)
+
+type dryRunRequest struct {
+	Proof                    requestProof   `json:"proof" openapi:"required" doc:"The plonky2 proof to check: the proof object, or that object serialized into a JSON string."`
+	ExpectedPublicInputs     map[int]string `json:"expectedPublicInputs"`
+	ExpectedPublicInputsHash string         `json:"expectedPublicInputsHash"`
+}
+
+// DryRunResponse is the preview of a start-proof with the same input: the
+// public inputs of the PLONK proof and their keccak256.
+type DryRunResponse struct {
+	PublicInputs        []string `json:"publicInputs" openapi:"required"`
+	PublicInputsHash    string   `json:"publicInputsHash" openapi:"required"`
+	WitnessGenerationMs int64    `json:"witnessGenerationMs"`
+}
+
+// DryRun builds the witness of a proof without proving it, answering with the
+// public inputs a job of it would have, or the error it would fail with.
+func (s *State) DryRun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apierror.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.authorize(w, r, auth.OperationStartProof) {
+		return
+	}
+	var request dryRunRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		apierror.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !request.Proof.Present {
+		apierror.Error(w, "proof is required", http.StatusBadRequest)
+		return
+	}
+	input := request.Proof.ProofWithPublicInputsRaw
+	_, data := s.circuit()
+	err := validateProofInput(input, data.CommonCircuitData)
+	if err == nil {
+		err = checkPublicInputRules(input.PublicInputs, data.PublicInputRules)
+	}
+	var schemaErr *inputSchemaError
+	if errors.As(err, &schemaErr) {
+		apierror.WithDetails(w, err.Error(), http.StatusBadRequest, schemaErr)
+		return
+	} else if err != nil {
+		apierror.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	expected, err := parseExpectedPublicInputs(request.ExpectedPublicInputs, len(input.PublicInputs))
+	if err != nil {
+		apierror.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	expectedHash, err := parseExpectedPublicInputsHash(request.ExpectedPublicInputsHash)
+	if err != nil {
+		apierror.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	start := time.Now()
+	witness, err := protect(func() (*wrapper.Witness, error) {
+		return wrapper.NewWitness(data, input)
+	})
+	if err != nil {
+		log.Println("Dry run failed to build the witness:", err)
+		apierror.Error(w, "Failed to build witness: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	response := DryRunResponse{
+		PublicInputs:        witness.PublicInputStrings(),
+		PublicInputsHash:    "0x" + hex.EncodeToString(publicInputsHash(witness.PublicInputs)),
+		WitnessGenerationMs: time.Since(start).Milliseconds(),
+	}
+	err = checkExpectedPublicInputs(expected, witness.PublicInputs)
+	if err == nil {
+		err = checkExpectedPublicInputsHash(expectedHash, witness.PublicInputs)
+	}
+	if err != nil {
+		apierror.WithDetails(w, err.Error(), http.StatusUnprocessableEntity, response)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,49 @@
+package handlers
+
+import "errors"
+
+// Error codes of failed jobs, reported in errorCode so that clients can
+// branch on the kind of failure.
+const (
+	ErrorCodeInvalidInput  = "INVALID_INPUT"
+	ErrorCodeWitnessFailed = "WITNESS_FAILED"
+	ErrorCodeProveFailed   = "PROVE_FAILED"
+	ErrorCodeTimeout       = "TIMEOUT"
+	ErrorCodeCancelled     = "CANCELLED"
+	ErrorCodeInternal      = "INTERNAL"
+
+	ErrorCodeInsufficientMemory = "INSUFFICIENT_MEMORY"
+	ErrorCodeRestarted          = "RESTARTED"
+)
+
+type codedError struct {
+	code string
+	err  error
+}
+
+func (e *codedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *codedError) Unwrap() error {
+	return e.err
+}
+
+// withCode attaches code to err, unless err already carries a more specific
+// code or is a recovered panic.
+func withCode(code string, err error) error {
+	var coded *codedError
+	var panicErr *panicError
+	if errors.As(err, &coded) || errors.As(err, &panicErr) {
+		return err
+	}
+	return &codedError{code: code, err: err}
+}
+
+func errorCodeOf(err error) string {
+	var coded *codedError
+	if errors.As(err, &coded) {
+		return coded.code
+	}
+	return ErrorCodeInternal
+}
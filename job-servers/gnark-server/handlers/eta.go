@@ -0,0 +1,37 @@
+package handlers
+
+import "time"
+
+// estimateCompletion sets the queue position of a pending job held by this
+// node and, once jobs of the circuit finished recently, its expected
+// completion time: the median prove duration after each wave of workers
+// ahead of it. Jobs held by other nodes or waiting for a retry get neither.
+func (s *State) estimateCompletion(response *ProofResponse, jobId string) {
+	if s.queue == nil {
+		return
+	}
+	ahead, startedAt, found := s.queue.position(jobId)
+	if !found {
+		return
+	}
+	now := time.Now()
+	if startedAt.IsZero() {
+		response.QueuePosition = ahead + 1
+	}
+	circuitName, _ := s.circuit()
+	duration, ok := s.SLO.ProveDuration(circuitName)
+	if !ok {
+		return
+	}
+	var eta time.Time
+	if startedAt.IsZero() {
+		eta = now.Add(duration * time.Duration(ahead/s.workers+1))
+	} else {
+		eta = startedAt.Add(duration)
+	}
+	if eta.Before(now) {
+		eta = now
+	}
+	eta = eta.UTC().Truncate(time.Second)
+	response.EstimatedCompletionAt = &eta
+}
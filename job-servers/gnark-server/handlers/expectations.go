@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// parseExpectedPublicInputs validates client-claimed public input values,
+// keyed by public input index, accepting decimal or 0x-prefixed hex.
+func parseExpectedPublicInputs(expected map[int]string, numPublicInputs int) (map[int]*big.Int, error) {
+	parsed := make(map[int]*big.Int, len(expected))
+	for index, value := range expected {
+		if index < 0 || index >= numPublicInputs {
+			return nil, fmt.Errorf("expected public input index %d out of range [0, %d)", index, numPublicInputs)
+		}
+		v, ok := new(big.Int).SetString(value, 0)
+		if !ok {
+			return nil, fmt.Errorf("expected public input %d is not a number: %q", index, value)
+		}
+		parsed[index] = v
+	}
+	return parsed, nil
+}
+
+func checkExpectedPublicInputs(expected map[int]*big.Int, publicInputs []*big.Int) error {
+	for index, want := range expected {
+		if index >= len(publicInputs) {
+			return fmt.Errorf("public input mismatch: index %d out of range, circuit has %d public inputs", index, len(publicInputs))
+		}
+		if got := publicInputs[index]; got.Cmp(want) != 0 {
+			return fmt.Errorf("public input mismatch at index %d: expected %s, got %s", index, want, got)
+		}
+	}
+	return nil
+}
+
+func parsePublicInputs(publicInputs []string) ([]*big.Int, error) {
+	parsed := make([]*big.Int, len(publicInputs))
+	for i, value := range publicInputs {
+		v, ok := new(big.Int).SetString(value, 10)
+		if !ok {
+			return nil, fmt.Errorf("public input %d is not a decimal number: %q", i, value)
+		}
+		parsed[i] = v
+	}
+	return parsed, nil
+}
+
+// formatExpectedPublicInputs is the inverse of parseExpectedPublicInputs.
+func formatExpectedPublicInputs(expected map[int]*big.Int) map[int]string {
+	if len(expected) == 0 {
+		return nil
+	}
+	formatted := make(map[int]string, len(expected))
+	for index, v := range expected {
+		formatted[index] = v.String()
+	}
+	return formatted
+}
+
+// parseExpectedPublicInputsHash validates a client-claimed digest of the
+// public inputs, as 0x-prefixed hex.
+func parseExpectedPublicInputsHash(expected string) ([]byte, error) {
+	if expected == "" {
+		return nil, nil
+	}
+	digest, err := hex.DecodeString(strings.TrimPrefix(expected, "0x"))
+	if err != nil || len(digest) != 32 || !strings.HasPrefix(expected, "0x") {
+		return nil, fmt.Errorf("expectedPublicInputsHash must be 32 bytes of 0x-prefixed hex")
+	}
+	return digest, nil
+}
+
+// publicInputsHash is the keccak256 of the public inputs as 32-byte
+// big-endian words, i.e. of abi.encodePacked(uint256[]) on-chain.
+func publicInputsHash(publicInputs []*big.Int) []byte {
+	digest := sha3.NewLegacyKeccak256()
+	for _, input := range publicInputs {
+		digest.Write(abiWord(input))
+	}
+	return digest.Sum(nil)
+}
+
+func checkExpectedPublicInputsHash(expected []byte, publicInputs []*big.Int) error {
+	if expected == nil {
+		return nil
+	}
+	if got := publicInputsHash(publicInputs); !bytes.Equal(got, expected) {
+		return fmt.Errorf("public inputs hash mismatch: expected 0x%x, got 0x%x", expected, got)
+	}
+	return nil
+}
+
+// formatExpectedPublicInputsHash is the inverse of
+// parseExpectedPublicInputsHash.
+func formatExpectedPublicInputsHash(expected []byte) string {
+	if expected == nil {
+		return ""
+	}
+	return "0x" + hex.EncodeToString(expected)
+}
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"net/http"
+
+	"gnark-server/apierror"
+
+	"golang.org/x/crypto/sha3"
+)
+
+const (
+	formatDefault  = ""
+	formatCalldata = "calldata"
+	formatBlob     = "blob"
+
+	// verifierFunction is the entry point of the Solidity verifier exported by gnark.
+	verifierFunction = "Verify(bytes,uint256[])"
+)
+
+func validateFormat(format string) error {
+	switch format {
+	case formatDefault, formatCalldata, formatBlob:
+		return nil
+	}
+	return fmt.Errorf("unknown format %q", format)
+}
+
+// applyFormat returns result with the hashes of its proof and the
+// representation requested by format added to it.
+func applyFormat(result ProveResult, format string) (ProveResult, error) {
+	proof, err := hex.DecodeString(result.Proof)
+	if err != nil {
+		return result, err
+	}
+	result.ProofKeccak256, result.ProofSHA256 = proofHashes(proof)
+	if format != formatCalldata && format != formatBlob {
+		return result, nil
+	}
+	publicInputs, err := parsePublicInputs(result.PublicInputs)
+	if err != nil {
+		return result, err
+	}
+	calldata := encodeProofCalldata(verifierFunction, proof, publicInputs)
+	if format == formatCalldata {
+		result.Calldata = "0x" + hex.EncodeToString(calldata)
+		return result, nil
+	}
+	return applyBlobFormat(result, calldata[4:])
+}
+
+// encodeProofCalldata ABI-encodes a call to a function taking
+// (bytes proof, uint256[] publicInputs), such as the verifier's Verify.
+func encodeProofCalldata(signature string, proof []byte, publicInputs []*big.Int) []byte {
+	selector := sha3.NewLegacyKeccak256()
+	selector.Write([]byte(signature))
+
+	paddedProofLen := (len(proof) + 31) / 32 * 32
+	calldata := make([]byte, 0, 4+32*4+paddedProofLen+32*len(publicInputs))
+	calldata = append(calldata, selector.Sum(nil)[:4]...)
+	calldata = append(calldata, abiWord(big.NewInt(64))...)
+	calldata = append(calldata, abiWord(big.NewInt(int64(64+32+paddedProofLen)))...)
+	calldata = append(calldata, abiWord(big.NewInt(int64(len(proof))))...)
+	calldata = append(calldata, proof...)
+	calldata = append(calldata, make([]byte, paddedProofLen-len(proof))...)
+	calldata = append(calldata, abiWord(big.NewInt(int64(len(publicInputs))))...)
+	for _, input := range publicInputs {
+		calldata = append(calldata, abiWord(input)...)
+	}
+	return calldata
+}
+
+// proofHashes returns the keccak256 and SHA-256 of the Solidity-encoded proof
+// bytes, 0x-prefixed hex.
+func proofHashes(proof []byte) (string, string) {
+	keccak := sha3.NewLegacyKeccak256()
+	keccak.Write(proof)
+	sha := sha256.Sum256(proof)
+	return "0x" + hex.EncodeToString(keccak.Sum(nil)), "0x" + hex.EncodeToString(sha[:])
+}
+
+func abiWord(v *big.Int) []byte {
+	word := make([]byte, 32)
+	return v.FillBytes(word)
+}
+
+const (
+	encodingHex     = "hex"
+	encodingBase64  = "base64"
+	encodingBinary  = "binary"
+	encodingDecimal = "decimal"
+)
+
+type outputOptions struct {
+	Format string
+	// ProofEncoding is one of hex (default), base64 or binary. binary returns
+	// the raw proof bytes as application/octet-stream.
+	ProofEncoding string
+	// PublicInputsEncoding is one of decimal (default) or hex.
+	PublicInputsEncoding string
+}
+
+func parseOutputOptions(r *http.Request) (outputOptions, error) {
+	query := r.URL.Query()
+	opts := outputOptions{
+		Format:               query.Get("format"),
+		ProofEncoding:        query.Get("proofEncoding"),
+		PublicInputsEncoding: query.Get("publicInputsEncoding"),
+	}
+	if err := validateFormat(opts.Format); err != nil {
+		return opts, err
+	}
+	if opts.ProofEncoding == "" {
+		opts.ProofEncoding = encodingHex
+		if r.Header.Get("Accept") == "application/octet-stream" {
+			opts.ProofEncoding = encodingBinary
+		}
+	}
+	switch opts.ProofEncoding {
+	case encodingHex, encodingBase64, encodingBinary:
+	default:
+		return opts, fmt.Errorf("unknown proofEncoding %q", opts.ProofEncoding)
+	}
+	if opts.PublicInputsEncoding == "" {
+		opts.PublicInputsEncoding = encodingDecimal
+	}
+	switch opts.PublicInputsEncoding {
+	case encodingDecimal, encodingHex:
+	default:
+		return opts, fmt.Errorf("unknown publicInputsEncoding %q", opts.PublicInputsEncoding)
+	}
+	return opts, nil
+}
+
+// renderResult applies the output format and encodings to a stored result,
+// which always holds a hex proof and decimal public inputs.
+func renderResult(result ProveResult, opts outputOptions) (ProveResult, error) {
+	result, err := applyFormat(result, opts.Format)
+	if err != nil {
+		return result, err
+	}
+	if opts.ProofEncoding == encodingBase64 {
+		proof, err := hex.DecodeString(result.Proof)
+		if err != nil {
+			return result, err
+		}
+		result.Proof = base64.StdEncoding.EncodeToString(proof)
+	}
+	if opts.PublicInputsEncoding == encodingHex {
+		publicInputs, err := parsePublicInputs(result.PublicInputs)
+		if err != nil {
+			return result, err
+		}
+		encoded := make([]string, len(publicInputs))
+		for i, v := range publicInputs {
+			encoded[i] = "0x" + v.Text(16)
+		}
+		result.PublicInputs = encoded
+	}
+	return result, nil
+}
+
+func writeProofBytes(w http.ResponseWriter, r *http.Request, result ProveResult) {
+	if !profileOf(r).Proof {
+		apierror.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	proof, err := hex.DecodeString(result.Proof)
+	if err != nil {
+		apierror.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	proofKeccak256, proofSHA256 := proofHashes(proof)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("X-Proof-Keccak256", proofKeccak256)
+	w.Header().Set("X-Proof-SHA256", proofSHA256)
+	w.Write(proof)
+}
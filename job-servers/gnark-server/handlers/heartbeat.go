@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"gnark-server/rediskey"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const redisJobHeartbeatKeyPrefix = "job_heartbeat:"
+
+func getJobHeartbeatRedisKey(jobId string) string {
+	return rediskey.JobKey(redisJobHeartbeatKeyPrefix, jobId)
+}
+
+// startHeartbeat keeps the heartbeat of a running job alive until the
+// returned function is called. The heartbeat expires HeartbeatTTL after the
+// last refresh, when the node running the job died. Stopping it leaves the
+// key: it is deleted once the job is no longer running in Redis, by
+// storeFinal with the final result or by clearHeartbeat, so that the reaper
+// never sees a running job without a heartbeat that is still alive.
+func (s *State) startHeartbeat(jobId string) func() {
+	if s.HeartbeatInterval <= 0 {
+		return func() {}
+	}
+	key := getJobHeartbeatRedisKey(jobId)
+	beat := func() {
+		if err := s.RedisClient.Set(context.Background(), key, s.NodeId, s.HeartbeatTTL).Err(); err != nil {
+			log.Printf("Failed to refresh the heartbeat of job %s: %v\n", jobId, err)
+		}
+	}
+	beat()
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(s.HeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				beat()
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// clearHeartbeat deletes the heartbeat of a job whose state no longer says
+// it runs: a refresh may have recreated it after storeFinal deleted it. The
+// heartbeat of a job whose result is buffered, not stored yet, is kept.
+func (s *State) clearHeartbeat(jobId string) {
+	if s.HeartbeatInterval <= 0 {
+		return
+	}
+	if _, buffered := s.bufferedResponse(jobId); buffered {
+		return
+	}
+	if err := s.RedisClient.Del(context.Background(), getJobHeartbeatRedisKey(jobId)).Err(); err != nil {
+		log.Printf("Failed to delete the heartbeat of job %s: %v\n", jobId, err)
+	}
+}
+
+// RunReaper looks for running jobs whose heartbeat went stale every
+// HeartbeatInterval until ctx is done, and requeues them on this node, or
+// fails them once out of attempts, so that clients don't poll forever for a
+// job whose worker crashed. It does nothing if the job backend redelivers
+// such jobs itself.
+func (s *State) RunReaper(ctx context.Context) {
+	if s.HeartbeatInterval <= 0 || s.queue.redelivers() {
+		return
+	}
+	ticker := time.NewTicker(s.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.reapStaleJobs(ctx); err != nil {
+				log.Printf("Failed to reap stale jobs: %v\n", err)
+			}
+		}
+	}
+}
+
+func (s *State) reapStaleJobs(ctx context.Context) error {
+	jobIds, err := s.RedisClient.ZRange(ctx, rediskey.Key(redisPendingJobsKey), 0, -1).Result()
+	if err != nil || len(jobIds) == 0 {
+		return err
+	}
+	pipe := s.RedisClient.Pipeline()
+	states := make([]*redis.StringCmd, len(jobIds))
+	beats := make([]*redis.IntCmd, len(jobIds))
+	for i, jobId := range jobIds {
+		states[i] = pipe.HGet(ctx, getJobMetaRedisKey(jobId), "state")
+		beats[i] = pipe.Exists(ctx, getJobHeartbeatRedisKey(jobId))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return err
+	}
+	for i, jobId := range jobIds {
+		if states[i].Val() != jobStateRunning || beats[i].Val() != 0 {
+			continue
+		}
+		// Claiming the heartbeat makes this node the only one to reap it.
+		claimed, err := s.RedisClient.SetNX(ctx, getJobHeartbeatRedisKey(jobId), s.NodeId, s.HeartbeatTTL).Result()
+		if err != nil {
+			return err
+		}
+		if claimed {
+			s.reapJob(ctx, jobId)
+			s.RedisClient.Del(ctx, getJobHeartbeatRedisKey(jobId))
+		}
+	}
+	return nil
+}
+
+func (s *State) reapJob(ctx context.Context, jobId string) {
+	spec, err := s.getJobSpec(ctx, jobId)
+	if err != nil {
+		log.Printf("Failing job %s with a stale heartbeat and no stored input: %v\n", jobId, err)
+		s.failJob(ctx, proofJob{JobId: jobId}, withCode(ErrorCodeInternal, fmt.Errorf("job abandoned: its worker stopped responding")))
+		return
+	}
+	job := spec.proofJob
+	log.Println("Job heartbeat is stale. jobId", jobId, "node", spec.Node, "attempt", job.Attempt)
+	if job.Attempt >= s.MaxAttempts {
+		s.failJob(ctx, job, withCode(ErrorCodeInternal, fmt.Errorf("job abandoned: its worker stopped responding after %d attempts", job.Attempt)))
+		return
+	}
+	job.Attempt++
+	if err := s.storeJobSpec(ctx, job); err != nil {
+		log.Printf("Failed to store job in Redis: %v\n", err)
+		return
+	}
+	if err := s.markAttempt(ctx, job); err != nil {
+		log.Printf("Failed to store proof response in Redis: %v\n", err)
+	}
+	s.queue.push(queuedJob{job: job, done: make(chan error, 1), queuedAt: time.Now()})
+}
@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gnark-server/memstore"
+)
+
+func TestHeartbeatOutlivesTheRunUntilTheResultIsStored(t *testing.T) {
+	ctx := context.Background()
+	s := &State{RedisClient: newMemoryRedis(t), Results: memstore.NewResults(), ResultTTL: time.Hour, NodeId: "node", HeartbeatInterval: time.Hour, HeartbeatTTL: time.Hour}
+	job := proofJob{JobId: "job"}
+	if reserved, err := s.reserveJob(ctx, job); err != nil || !reserved {
+		t.Fatalf("reserveJob: %v %v", reserved, err)
+	}
+	beating := func() bool {
+		n, err := s.RedisClient.Exists(ctx, getJobHeartbeatRedisKey(job.JobId)).Result()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n != 0
+	}
+
+	s.startHeartbeat(job.JobId)()
+	if !beating() {
+		t.Fatal("stopping the heartbeat deleted it before the job's state was stored")
+	}
+	if err := s.finishJob(ctx, job, ProofResponse{Success: true, Proof: &ProveResult{}}); err != nil {
+		t.Fatal(err)
+	}
+	if beating() {
+		t.Fatal("the heartbeat outlived the stored result")
+	}
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"gnark-server/apierror"
+	"gnark-server/rediskey"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+// redisJobInputKeyPrefix keeps the job as submitted, with its proof JSON
+// and settings, as an encoded record for InputTTL.
+const redisJobInputKeyPrefix = "job_input:"
+
+func getJobInputRedisKey(jobId string) string {
+	return rediskey.JobKey(redisJobInputKeyPrefix, jobId)
+}
+
+func (s *State) storeInput(ctx context.Context, pipe redis.Pipeliner, job proofJob) error {
+	if s.InputTTL <= 0 || !job.Input.present() {
+		return nil
+	}
+	jobJSON, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	pipe.Set(ctx, getJobInputRedisKey(job.JobId), s.encodeRecord(jobJSON), s.InputTTL)
+	return nil
+}
+
+// getStoredJob returns the job jobId was submitted as, without its parsed
+// input.
+func (s *State) getStoredJob(ctx context.Context, jobId string) (proofJob, error) {
+	var job proofJob
+	record, err := s.RedisClient.Get(ctx, getJobInputRedisKey(jobId)).Bytes()
+	if err != nil {
+		return job, err
+	}
+	jobJSON, err := decodeRecord(s.RecordKeys, record)
+	if err != nil {
+		return job, err
+	}
+	err = json.Unmarshal(jobJSON, &job)
+	return job, err
+}
+
+// jobAction splits a /jobs/<jobId>/<action> path, which may have a version
+// prefix.
+func jobAction(path string) (string, string, bool) {
+	i := strings.Index(path, "/jobs/")
+	if i < 0 {
+		return "", "", false
+	}
+	jobId, action, ok := strings.Cut(path[i+len("/jobs/"):], "/")
+	if !ok || strings.Contains(action, "/") {
+		return "", "", false
+	}
+	return jobId, action, true
+}
+
+// JobAction serves the admin actions on a job, /jobs/<jobId>/input and
+// /jobs/<jobId>/retry.
+func (s *State) JobAction(w http.ResponseWriter, r *http.Request) {
+	jobId, action, _ := jobAction(r.URL.Path)
+	switch action {
+	case "input":
+		s.jobInput(w, r, jobId)
+	case "retry":
+		s.retryJob(w, r, jobId, false)
+	default:
+		apierror.Error(w, "Not found", http.StatusNotFound)
+	}
+}
+
+// jobInput serves the proof JSON the job was submitted with, so that it can
+// be proven again locally.
+func (s *State) jobInput(w http.ResponseWriter, r *http.Request, jobId string) {
+	if r.Method != http.MethodGet {
+		apierror.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if _, err := uuid.Parse(jobId); err != nil {
+		apierror.Error(w, "Invalid jobId", http.StatusBadRequest)
+		return
+	}
+	job, err := s.getStoredJob(r.Context(), jobId)
+	if err == redis.Nil {
+		apierror.Error(w, "job input not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("Failed to read input of job %s from Redis: %v\n", jobId, err)
+		s.storeError(w, err)
+		return
+	}
+	s.audit(r, "job-input", jobId, nil)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+jobId+"_proof_with_public_inputs.json\"")
+	json.NewEncoder(w).Encode(job.Input)
+}
@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"context"
+	"crypto/ecdh"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"math/big"
+	"time"
+
+	"gnark-server/rediskey"
+	"gnark-server/resultbox"
+	"gnark-server/webhook"
+)
+
+type proofJob struct {
+	JobId      string
+	InputHash  string
+	Input      proofInput
+	Race       bool
+	Format     string
+	WebhookURL string
+	// Profile is the redaction profile of the submitter, applied to webhook payloads.
+	Profile string
+	// Circuit is the circuit the job was routed to, the loaded one or the
+	// canary.
+	Circuit string
+	// RequestId is the ID of the request that submitted the job, logged and
+	// forwarded to race peers.
+	RequestId string
+	// DagId is the DAG the job belongs to, if any, advanced when it finishes.
+	DagId string
+	// Tenant is the tenant of the submitting identity, whose queue, quota,
+	// stats and SLO the job counts in.
+	Tenant string
+	// Owner is the auth.Identity Owner of the submitter.
+	Owner string
+	// Key is the name of the submitting identity.
+	Key string
+	// KeyId is the auth.Identity KeyId of the submitter, whose usage its
+	// proves are metered in.
+	KeyId string
+	// PayloadHash is the receipt.PayloadHash of the proof as submitted.
+	PayloadHash string
+	// Payment is the payment reference the job was submitted with.
+	Payment string
+	// Attempt counts runs of the job, starting at 1.
+	Attempt int
+	// Priority is the queue lane of the job, high unless it is "low".
+	Priority string
+	// ResultTTL, when set, is how long the final result is kept instead of
+	// State.ResultTTL.
+	ResultTTL time.Duration
+	// NotBefore, when set, is when the job is queued at the earliest.
+	NotBefore time.Time
+	// QueuedAt is when the current attempt was queued for a worker.
+	QueuedAt time.Time `json:"-"`
+	// Metadata is the client's metadata, echoed in every response.
+	Metadata map[string]string
+
+	ExpectedPublicInputs map[int]*big.Int
+	// ExpectedPublicInputsHash, when set, is the publicInputsHash the
+	// proven public inputs must have.
+	ExpectedPublicInputsHash []byte
+
+	// ResultPublicKey, when set, is the X25519 key the result is sealed to.
+	ResultPublicKey []byte
+}
+
+type webhookPayload struct {
+	JobId string `json:"jobId"`
+	ProofResponse
+}
+
+// finishJob stores the final response of a job and, in the same transaction,
+// enqueues its completion webhook and dead-letters it if it failed. Failed writes are retried with backoff up
+// to MaxAttempts times, after which the result is kept in memory (and spooled
+// to disk, see bufferResult) until Redis is back, if the buffer has room.
+func (s *State) finishJob(ctx context.Context, job proofJob, response ProofResponse) error {
+	response.Attempts = job.Attempt
+	response.Metadata = job.Metadata
+	response.Owner = job.Owner
+	if len(job.ResultPublicKey) > 0 && response.Proof != nil && response.Proof.Encrypted == "" {
+		response = sealResponse(job, response)
+	}
+	for attempt := 1; ; attempt++ {
+		err := s.storeFinal(ctx, job, response)
+		if err == nil {
+			s.advanceDag(ctx, job.DagId)
+			return nil
+		}
+		if attempt >= s.MaxAttempts {
+			if s.bufferResult(job, response) {
+				log.Printf("Failed to store result of job %s, keeping it until Redis is back: %v\n", job.JobId, err)
+				return nil
+			}
+			return err
+		}
+		log.Printf("Failed to store result of job %s, retrying: %v\n", job.JobId, err)
+		time.Sleep(s.backoff(attempt))
+	}
+}
+
+// sealResponse encrypts the result of response to the job's result key. The
+// job fails if it cannot be, rather than store the result in the clear.
+func sealResponse(job proofJob, response ProofResponse) ProofResponse {
+	resultJSON, err := json.Marshal(response.Proof)
+	var sealed []byte
+	if err == nil {
+		var key *ecdh.PublicKey
+		if key, err = ecdh.X25519().NewPublicKey(job.ResultPublicKey); err == nil {
+			sealed, err = resultbox.Seal(key, job.JobId, resultJSON)
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to encrypt result of job %s: %v\n", job.JobId, err)
+		errMsg := "internal error: failed to encrypt the result"
+		return ProofResponse{
+			Success:      false,
+			ErrorMessage: &errMsg,
+			ErrorCode:    ErrorCodeInternal,
+			Attempts:     response.Attempts,
+			Metadata:     response.Metadata,
+		}
+	}
+	response.Proof = &ProveResult{Encrypted: base64.StdEncoding.EncodeToString(sealed)}
+	return response
+}
+
+func (s *State) storeFinal(ctx context.Context, job proofJob, response ProofResponse) error {
+	stored, ttl := s.offloadResult(ctx, job, response)
+	responseJSON, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	pipe := s.RedisClient.TxPipeline()
+	if err := s.setResult(ctx, pipe, job.JobId, responseJSON, ttl); err != nil {
+		return err
+	}
+	pipe.ZRem(ctx, rediskey.Key(redisPendingJobsKey), job.JobId)
+	pipe.ZRem(ctx, rediskey.Key(redisScheduledJobsKey), job.JobId)
+	pipe.Del(ctx, getJobRedisKey(job.JobId), getJobHeartbeatRedisKey(job.JobId))
+	if job.Tenant != "" {
+		s.removePending(ctx, pipe, job.Tenant, job.JobId)
+	}
+	state, stat := jobStateSucceeded, statSucceeded
+	if !response.Success {
+		state, stat = jobStateFailed, statFailed
+	}
+	countJob(ctx, pipe, job.Tenant, stat)
+	metaKey := getJobMetaRedisKey(job.JobId)
+	pipe.HSet(ctx, metaKey, "state", state, "finishedAt", time.Now().UnixMilli(), "attempts", response.Attempts, "errorCode", response.ErrorCode)
+	pipe.Expire(ctx, metaKey, ttl)
+	if deadLetterable(job, response) {
+		if err := s.queueDeadLetter(ctx, pipe, job, response); err != nil {
+			return err
+		}
+	}
+	if job.WebhookURL == "" || s.Webhooks == nil {
+		_, err = pipe.Exec(ctx)
+		return err
+	}
+	payload := webhookPayload{
+		JobId:         job.JobId,
+		ProofResponse: redact(response, redactionProfiles[job.Profile]),
+	}
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	if err := s.Webhooks.Enqueue(ctx, pipe, webhook.Delivery{
+		Id:        job.JobId,
+		URL:       job.WebhookURL,
+		Payload:   payloadJSON,
+		CreatedAt: time.Now(),
+	}); err != nil {
+		return err
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// failJob stores the failure of job, unless it is retried.
+func (s *State) failJob(ctx context.Context, job proofJob, cause error) error {
+	if s.willRetry(job, cause) {
+		return cause
+	}
+	errMsg := cause.Error()
+	resp := ProofResponse{
+		Success:      false,
+		Proof:        nil,
+		ErrorMessage: &errMsg,
+		ErrorCode:    errorCodeOf(cause),
+	}
+	var panicErr *panicError
+	if errors.As(cause, &panicErr) {
+		log.Printf("Job panicked. jobId %s: %v\n%s", job.JobId, panicErr.value, panicErr.stack)
+		resp.ErrorStack = &panicErr.stack
+	}
+	if err := s.finishJob(ctx, job, resp); err != nil {
+		log.Printf("Failed to store failure of job %s, dropping it: %v\n", job.JobId, err)
+	}
+	return cause
+}
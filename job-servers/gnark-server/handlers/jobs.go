@@ -0,0 +1,245 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gnark-server/apierror"
+	"gnark-server/rediskey"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	redisJobIndexKey      = "jobs"
+	redisJobMetaKeyPrefix = "job_meta:"
+
+	jobStatePending   = "pending"
+	jobStateRunning   = "running"
+	jobStateSucceeded = "succeeded"
+	jobStateFailed    = "failed"
+
+	defaultJobListLimit = 50
+	maxJobListLimit     = 500
+	// maxJobListScan bounds the index entries one listing request reads, so
+	// that a rare state doesn't scan a whole day of jobs at once.
+	maxJobListScan = 10000
+)
+
+func getJobMetaRedisKey(jobId string) string {
+	return rediskey.JobKey(redisJobMetaKeyPrefix, jobId)
+}
+
+// jobIndexTTL is how long the longest-kept result lives: that of
+// ResultTTL, the MaxResultTTL a request may ask for and OffloadedResultTTL.
+// Jobs stay in the index that long.
+func (s *State) jobIndexTTL() time.Duration {
+	ttl := s.ResultTTL
+	if s.MaxResultTTL > ttl {
+		ttl = s.MaxResultTTL
+	}
+	if s.Objects != nil && s.OffloadedResultTTL > ttl {
+		ttl = s.OffloadedResultTTL
+	}
+	return ttl
+}
+
+// indexJob adds a newly accepted job to the job index and records its
+// metadata, which expires with its result. Index entries older than
+// jobIndexTTL are dropped, their jobs having expired.
+func (s *State) indexJob(ctx context.Context, pipe redis.Pipeliner, job proofJob, now time.Time) {
+	key := getJobMetaRedisKey(job.JobId)
+	pipe.Del(ctx, key)
+	pipe.HSet(ctx, key,
+		"state", jobStatePending,
+		"tenant", job.Tenant,
+		"priority", job.Priority,
+		"submittedAt", now.UnixMilli(),
+		"node", s.NodeId,
+	)
+	pipe.Expire(ctx, key, s.resultTTL(job))
+	pipe.ZAdd(ctx, rediskey.Key(redisJobIndexKey), &redis.Z{Score: float64(now.UnixMilli()), Member: job.JobId})
+	pipe.ZRemRangeByScore(ctx, rediskey.Key(redisJobIndexKey), "-inf", fmt.Sprint(now.Add(-s.jobIndexTTL()).UnixMilli()))
+}
+
+// markJobState records a state change of an indexed job.
+func (s *State) markJobState(ctx context.Context, job proofJob, state string, fields ...interface{}) {
+	key := getJobMetaRedisKey(job.JobId)
+	pipe := s.RedisClient.TxPipeline()
+	pipe.HSet(ctx, key, append([]interface{}{"state", state}, fields...)...)
+	pipe.Expire(ctx, key, s.resultTTL(job))
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("Failed to store job state in Redis: %v\n", err)
+	}
+}
+
+type JobSummary struct {
+	JobId       string     `json:"jobId"`
+	State       string     `json:"state"`
+	Tenant      string     `json:"tenant,omitempty"`
+	Priority    string     `json:"priority,omitempty"`
+	Attempts    int        `json:"attempts,omitempty"`
+	ErrorCode   string     `json:"errorCode,omitempty"`
+	SubmittedAt time.Time  `json:"submittedAt"`
+	StartedAt   *time.Time `json:"startedAt,omitempty"`
+	FinishedAt  *time.Time `json:"finishedAt,omitempty"`
+	// QueueWaitMs is the time from submission to the start of the last
+	// attempt, ProveMs from that start to the end and TotalMs from
+	// submission to the end (or now, while the job is pending).
+	QueueWaitMs int64 `json:"queueWaitMs,omitempty"`
+	ProveMs     int64 `json:"proveMs,omitempty"`
+	TotalMs     int64 `json:"totalMs"`
+}
+
+func millisTime(v string) *time.Time {
+	ms, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return nil
+	}
+	t := time.UnixMilli(ms).UTC()
+	return &t
+}
+
+func jobSummary(jobId string, meta map[string]string, now time.Time) JobSummary {
+	summary := JobSummary{
+		JobId:      jobId,
+		State:      meta["state"],
+		Tenant:     meta["tenant"],
+		Priority:   meta["priority"],
+		ErrorCode:  meta["errorCode"],
+		StartedAt:  millisTime(meta["startedAt"]),
+		FinishedAt: millisTime(meta["finishedAt"]),
+	}
+	summary.Attempts, _ = strconv.Atoi(meta["attempts"])
+	if submittedAt := millisTime(meta["submittedAt"]); submittedAt != nil {
+		summary.SubmittedAt = *submittedAt
+	}
+	end := now
+	if summary.FinishedAt != nil {
+		end = *summary.FinishedAt
+	}
+	if summary.StartedAt != nil {
+		summary.QueueWaitMs = summary.StartedAt.Sub(summary.SubmittedAt).Milliseconds()
+		summary.ProveMs = end.Sub(*summary.StartedAt).Milliseconds()
+	}
+	summary.TotalMs = end.Sub(summary.SubmittedAt).Milliseconds()
+	return summary
+}
+
+// parseJobCursor splits a listing cursor, "<submittedAt ms>:<jobId>" of the
+// last job returned.
+func parseJobCursor(cursor string) (int64, string, error) {
+	ms, jobId, ok := strings.Cut(cursor, ":")
+	if !ok {
+		return 0, "", fmt.Errorf("invalid cursor")
+	}
+	score, err := strconv.ParseInt(ms, 10, 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid cursor")
+	}
+	return score, jobId, nil
+}
+
+// ListJobs lists jobs, newest first, optionally only those in ?state=. The
+// response's nextCursor continues the listing; it is empty at the end.
+func (s *State) ListJobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		apierror.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	state := query.Get("state")
+	switch state {
+	case "", jobStatePending, jobStateRunning, jobStateSucceeded, jobStateFailed:
+	default:
+		apierror.Error(w, fmt.Sprintf("unknown state %q", state), http.StatusBadRequest)
+		return
+	}
+	limit := defaultJobListLimit
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxJobListLimit {
+			apierror.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxJobListLimit), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	maxScore, afterJobId := "+inf", ""
+	var afterScore int64
+	if cursor := query.Get("cursor"); cursor != "" {
+		var err error
+		if afterScore, afterJobId, err = parseJobCursor(cursor); err != nil {
+			apierror.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		maxScore = fmt.Sprint(afterScore)
+	}
+
+	ctx := r.Context()
+	now := time.Now()
+	jobs := make([]JobSummary, 0, limit)
+	nextCursor := ""
+	scanned := 0
+	// offset skips the entries with the cursor's score already returned
+	// when there are more of them than one read.
+	var offset int64
+	for len(jobs) < limit && scanned < maxJobListScan {
+		entries, err := s.RedisClient.ZRevRangeByScoreWithScores(ctx, rediskey.Key(redisJobIndexKey), &redis.ZRangeBy{
+			Max:    maxScore,
+			Min:    "-inf",
+			Offset: offset,
+			Count:  int64(limit),
+		}).Result()
+		if err != nil {
+			apierror.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if len(entries) == 0 {
+			nextCursor = ""
+			break
+		}
+		read := len(entries)
+		// Entries with the cursor's score sort by descending jobId; those
+		// up to the cursor were already returned.
+		for len(entries) > 0 && afterJobId != "" && int64(entries[0].Score) == afterScore && entries[0].Member.(string) >= afterJobId {
+			entries = entries[1:]
+		}
+		if len(entries) == 0 {
+			offset += int64(read)
+			continue
+		}
+		offset = 0
+		pipe := s.RedisClient.Pipeline()
+		metas := make([]*redis.StringStringMapCmd, len(entries))
+		for i, entry := range entries {
+			metas[i] = pipe.HGetAll(ctx, getJobMetaRedisKey(entry.Member.(string)))
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			apierror.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		for i, entry := range entries {
+			jobId := entry.Member.(string)
+			afterScore, afterJobId = int64(entry.Score), jobId
+			maxScore = fmt.Sprint(afterScore)
+			nextCursor = fmt.Sprintf("%d:%s", afterScore, jobId)
+			scanned++
+			meta := metas[i].Val()
+			if len(meta) == 0 || (state != "" && meta["state"] != state) {
+				continue
+			}
+			jobs = append(jobs, jobSummary(jobId, meta, now))
+			if len(jobs) == limit {
+				break
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"jobs": jobs, "nextCursor": nextCursor})
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"gnark-server/rediskey"
+)
+
+func TestIndexJobKeepsJobsForTheLongestResultTTL(t *testing.T) {
+	ctx := context.Background()
+	s := &State{RedisClient: newMemoryRedis(t), ResultTTL: time.Hour, MaxResultTTL: 24 * time.Hour}
+	now := time.Now()
+	for _, indexed := range []struct {
+		jobId string
+		at    time.Time
+	}{{"old", now.Add(-2 * time.Hour)}, {"expired", now.Add(-25 * time.Hour)}, {"new", now}} {
+		pipe := s.RedisClient.TxPipeline()
+		s.indexJob(ctx, pipe, proofJob{JobId: indexed.jobId, ResultTTL: 2 * time.Hour}, indexed.at)
+		if _, err := pipe.Exec(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	jobIds, err := s.RedisClient.ZRange(ctx, rediskey.Key(redisJobIndexKey), 0, -1).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobIds) != 2 || jobIds[0] != "old" || jobIds[1] != "new" {
+		t.Fatalf("index %v, want the jobs within MaxResultTTL", jobIds)
+	}
+	if ttl := s.RedisClient.TTL(ctx, getJobMetaRedisKey("old")).Val(); ttl != 2*time.Hour {
+		t.Fatalf("metadata expires in %s, want the job's result TTL", ttl)
+	}
+}
+
+func TestListJobsPagesThroughJobsOfTheSameMillisecond(t *testing.T) {
+	ctx := context.Background()
+	s := &State{RedisClient: newMemoryRedis(t), ResultTTL: time.Hour}
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		pipe := s.RedisClient.TxPipeline()
+		s.indexJob(ctx, pipe, proofJob{JobId: fmt.Sprintf("job-%d", i)}, now)
+		if _, err := pipe.Exec(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	listed := make(map[string]bool)
+	cursor := ""
+	for page := 0; page < 5; page++ {
+		w := httptest.NewRecorder()
+		s.ListJobs(w, httptest.NewRequest(http.MethodGet, "/jobs?limit=2&cursor="+url.QueryEscape(cursor), nil))
+		var response struct {
+			Jobs       []JobSummary `json:"jobs"`
+			NextCursor string       `json:"nextCursor"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+		for _, job := range response.Jobs {
+			if listed[job.JobId] {
+				t.Fatalf("%s listed twice", job.JobId)
+			}
+			listed[job.JobId] = true
+		}
+		if cursor = response.NextCursor; cursor == "" {
+			break
+		}
+		if len(response.Jobs) == 0 {
+			t.Fatalf("empty page before the end, cursor %s", cursor)
+		}
+	}
+	if len(listed) != 5 {
+		t.Fatalf("listed %v, want every job", listed)
+	}
+}
@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"gnark-server/kafka"
+	"gnark-server/rediskey"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+// kafkaPollInterval is how often a redelivered record polls the job it
+// submitted before.
+const kafkaPollInterval = 5 * time.Second
+
+// ConsumeKafka proves the start-proof requests read by consumer, queued
+// like submissions, until ctx is done. A record is done once its job
+// finished, so that the requests of a node that stops are read again by
+// another member of the group; the job id, taken from the request or
+// derived from the record's position, makes the second read wait for the
+// job rather than prove it again.
+func (s *State) ConsumeKafka(ctx context.Context, consumer *kafka.Consumer) {
+	consumer.Paused = func() bool { return s.draining() != nil }
+	if err := consumer.Run(ctx, s.handleKafkaRecord); err != nil {
+		log.Println("Failed to consume from Kafka:", err)
+	}
+}
+
+func (s *State) handleKafkaRecord(record kafka.Record, done func()) {
+	source := fmt.Sprintf("%s/%d/%d", record.Topic, record.Partition, record.Offset)
+	var rawInput startProofRequest
+	if err := json.Unmarshal(record.Value, &rawInput); err != nil {
+		log.Printf("Skipping Kafka record %s: %v\n", source, err)
+		done()
+		return
+	}
+	if rawInput.JobId == "" {
+		rawInput.JobId = uuid.NewSHA1(uuid.NameSpaceURL, []byte("kafka:"+source)).String()
+	}
+	job, _, err := s.buildJob(rawInput, "")
+	if err != nil {
+		if _, parseErr := uuid.Parse(rawInput.JobId); parseErr != nil {
+			log.Printf("Skipping Kafka record %s: %v\n", source, err)
+			done()
+			return
+		}
+		// Fail the job, so that whoever polls it learns why.
+		job = proofJob{JobId: rawInput.JobId, Metadata: rawInput.Metadata}
+		err = withCode(ErrorCodeInvalidInput, err)
+	}
+	job.Tenant = s.KafkaTenant
+	job.RequestId = "kafka:" + source
+	go s.runKafkaJob(job, err, done)
+}
+
+// runKafkaJob submits the job of a record, retrying while Redis fails, and
+// calls done once it is finished.
+func (s *State) runKafkaJob(job proofJob, invalid error, done func()) {
+	defer done()
+	ctx := context.Background()
+	var reserved bool
+	for {
+		var err error
+		if reserved, err = s.reserveJob(ctx, job); err == nil {
+			break
+		}
+		log.Printf("Failed to store proof response in Redis: %v\n", err)
+		time.Sleep(s.RetryBackoff)
+	}
+	if !reserved {
+		log.Println("Kafka job already submitted, waiting for it. jobId", job.JobId, "requestId", job.RequestId)
+		s.awaitJob(ctx, job.JobId)
+		return
+	}
+	if invalid != nil {
+		s.failJob(ctx, job, invalid)
+		log.Println("Kafka job rejected. jobId", job.JobId, "requestId", job.RequestId, invalid)
+		return
+	}
+	if deferred(job) {
+		// Committed once scheduled: RunScheduler runs the job from Redis.
+		for {
+			err := s.schedule(ctx, job)
+			if err == nil {
+				break
+			}
+			log.Printf("Failed to schedule job in Redis: %v\n", err)
+			time.Sleep(s.RetryBackoff)
+		}
+		log.Println("Kafka job scheduled", job.JobId, "notBefore", job.NotBefore)
+		return
+	}
+	if s.finishFromCache(ctx, job) {
+		log.Println("Kafka job served from cache", job.JobId)
+		return
+	}
+	log.Println("Kafka job", job.JobId, "requestId", job.RequestId)
+	<-s.submit(job)
+}
+
+// awaitJob waits until jobId is no longer pending, whichever node runs it.
+func (s *State) awaitJob(ctx context.Context, jobId string) {
+	for {
+		err := s.RedisClient.ZScore(ctx, rediskey.Key(redisPendingJobsKey), jobId).Err()
+		if errors.Is(err, redis.Nil) {
+			return
+		} else if err != nil {
+			log.Printf("Failed to read pending jobs from Redis: %v\n", err)
+		}
+		time.Sleep(kafkaPollInterval)
+	}
+}
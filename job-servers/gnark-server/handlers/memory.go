@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// admitMemory waits until memory is available to prove job, returning the
+// function releasing it once the prove is done.
+func (s *State) admitMemory(job proofJob) (func(), error) {
+	if s.Memory == nil {
+		return func() {}, nil
+	}
+	start := time.Now()
+	release, err := s.Memory.Acquire(context.Background())
+	if err != nil {
+		log.Println("Memory admission failed. jobId", job.JobId, err)
+		return nil, withCode(ErrorCodeInsufficientMemory, err)
+	}
+	if waited := time.Since(start); waited >= time.Second {
+		log.Println("Waited", waited, "for memory. jobId", job.JobId)
+	}
+	return release, nil
+}
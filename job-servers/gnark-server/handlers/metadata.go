@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// Bounds of the metadata of a start-proof request, which is kept with the
+// job's result.
+const (
+	maxMetadataEntries     = 16
+	maxMetadataKeyLength   = 64
+	maxMetadataValueLength = 256
+)
+
+func validateMetadata(metadata map[string]string) error {
+	if len(metadata) > maxMetadataEntries {
+		return fmt.Errorf("metadata has more than %d entries", maxMetadataEntries)
+	}
+	for key, value := range metadata {
+		if key == "" || len(key) > maxMetadataKeyLength || !utf8.ValidString(key) {
+			return fmt.Errorf("metadata keys must be 1 to %d bytes of UTF-8", maxMetadataKeyLength)
+		}
+		if len(value) > maxMetadataValueLength || !utf8.ValidString(value) {
+			return fmt.Errorf("metadata %q: values must be at most %d bytes of UTF-8", key, maxMetadataValueLength)
+		}
+	}
+	return nil
+}
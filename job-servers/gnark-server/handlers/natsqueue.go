@@ -0,0 +1,386 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// natsTimeout bounds JetStream API requests and publishes.
+const natsTimeout = 10 * time.Second
+
+// NATSQueueConfig configures the JetStream job backend.
+type NATSQueueConfig struct {
+	// Stream is the JetStream stream holding queued jobs, created if
+	// missing, on the subjects <Subject>.high and <Subject>.low.
+	Stream  string
+	Subject string
+	// AckWait is how long a job taken by a worker may go without news before
+	// it is delivered again, to any node.
+	AckWait  time.Duration
+	Replicas int
+}
+
+// natsJobMessage is the message queued for a job. The input stays in the
+// stored job spec, as it may not fit the message size limit of NATS.
+type natsJobMessage struct {
+	JobId    string    `json:"jobId"`
+	QueuedAt time.Time `json:"queuedAt"`
+	// Origin is the subject the outcome of the job is reported to.
+	Origin string `json:"origin"`
+}
+
+type natsOutcome struct {
+	JobId string `json:"jobId"`
+	Error string `json:"error,omitempty"`
+	Code  string `json:"errorCode,omitempty"`
+}
+
+type natsRunning struct {
+	msg       jetstream.Msg
+	startedAt time.Time
+}
+
+// natsQueue is a jobBackend shared by every node through JetStream: one
+// durable pull consumer per priority lane, with explicit acknowledgement.
+// A job is acknowledged once its worker is done with it, its
+// acknowledgement timer being reset while it runs, so the jobs of a node
+// that stops are delivered again after AckWait.
+type natsQueue struct {
+	s         *State
+	conn      *nats.Conn
+	js        jetstream.JetStream
+	consumers map[string]jetstream.Consumer
+	cfg       NATSQueueConfig
+	origin    string
+
+	mu        sync.Mutex
+	highInRow int
+	running   map[string]natsRunning
+	// waiting holds the done channels of the jobs submitted by this node.
+	waiting map[string]chan error
+}
+
+var natsLanes = []string{priorityHigh, priorityLow}
+
+// UseJetStream makes the prover workers take jobs from a JetStream stream
+// shared by every node, instead of the in-memory queue of this node. It
+// must be called before StartWorkers.
+func (s *State) UseJetStream(ctx context.Context, conn *nats.Conn, cfg NATSQueueConfig) error {
+	js, err := jetstream.New(conn)
+	if err != nil {
+		return err
+	}
+	q := &natsQueue{
+		s:         s,
+		conn:      conn,
+		js:        js,
+		consumers: make(map[string]jetstream.Consumer),
+		cfg:       cfg,
+		origin:    cfg.Subject + ".outcomes." + uuid.NewString(),
+		running:   make(map[string]natsRunning),
+		waiting:   make(map[string]chan error),
+	}
+	ctx, cancel := context.WithTimeout(ctx, natsTimeout)
+	defer cancel()
+	// An existing stream is used as is.
+	if _, err := js.Stream(ctx, cfg.Stream); errors.Is(err, jetstream.ErrStreamNotFound) {
+		_, err = js.CreateStream(ctx, jetstream.StreamConfig{
+			Name:      cfg.Stream,
+			Subjects:  []string{cfg.Subject + ".high", cfg.Subject + ".low"},
+			Retention: jetstream.WorkQueuePolicy,
+			Storage:   jetstream.FileStorage,
+			Replicas:  cfg.Replicas,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create stream %s: %w", cfg.Stream, err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("failed to get stream %s: %w", cfg.Stream, err)
+	}
+	for _, lane := range natsLanes {
+		consumer, err := js.CreateOrUpdateConsumer(ctx, cfg.Stream, jetstream.ConsumerConfig{
+			Durable:       q.consumer(lane),
+			FilterSubject: cfg.Subject + "." + lane,
+			AckPolicy:     jetstream.AckExplicitPolicy,
+			AckWait:       cfg.AckWait,
+			DeliverPolicy: jetstream.DeliverAllPolicy,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create consumer %s: %w", q.consumer(lane), err)
+		}
+		q.consumers[lane] = consumer
+	}
+	if _, err := conn.Subscribe(q.origin, q.handleOutcome); err != nil {
+		return err
+	}
+	go q.keepAlive()
+	s.queue = q
+	log.Println("Queueing jobs in JetStream stream", cfg.Stream)
+	return nil
+}
+
+func (q *natsQueue) consumer(lane string) string {
+	return q.cfg.Stream + "_" + lane
+}
+
+func (q *natsQueue) push(job queuedJob) {
+	origin := job.origin
+	if origin == "" {
+		origin = q.origin
+	}
+	if origin == q.origin && job.done != nil {
+		q.mu.Lock()
+		q.waiting[job.job.JobId] = job.done
+		q.mu.Unlock()
+	}
+	lane := priorityHigh
+	if job.job.Priority == priorityLow {
+		lane = priorityLow
+	}
+	data, _ := json.Marshal(natsJobMessage{JobId: job.job.JobId, QueuedAt: job.queuedAt, Origin: origin})
+	subject := q.cfg.Subject + "." + lane
+	if err := q.publish(subject, data); err == nil {
+		return
+	}
+	// The job is accepted already: keep trying rather than lose it.
+	go func() {
+		for delay := time.Second; ; delay = min(2*delay, time.Minute) {
+			err := q.publish(subject, data)
+			if err == nil {
+				return
+			}
+			log.Printf("Failed to queue job %s in JetStream, retrying in %s: %v\n", job.job.JobId, delay, err)
+			time.Sleep(delay)
+		}
+	}()
+}
+
+// publish stores data in the stream once the server acknowledged it.
+func (q *natsQueue) publish(subject string, data []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), natsTimeout)
+	defer cancel()
+	_, err := q.js.Publish(ctx, subject, data)
+	return err
+}
+
+// pop pulls the next job, from the lanes in the order of the in-memory
+// queue.
+func (q *natsQueue) pop() queuedJob {
+	for {
+		q.mu.Lock()
+		lanes := natsLanes
+		if q.highInRow >= q.s.HighPriorityBurst {
+			lanes = []string{priorityLow, priorityHigh}
+		}
+		q.mu.Unlock()
+		msg, lane := q.next(lanes, 0)
+		if msg == nil {
+			msg, lane = q.next(lanes[:1], time.Second)
+		}
+		if msg == nil {
+			msg, lane = q.next(lanes[1:], time.Second)
+		}
+		if msg == nil {
+			continue
+		}
+		q.mu.Lock()
+		if lane == priorityHigh {
+			q.highInRow++
+		} else {
+			q.highInRow = 0
+		}
+		q.mu.Unlock()
+		if queued, ok := q.take(msg); ok {
+			return queued
+		}
+	}
+}
+
+// next pulls a message from the first of lanes that has one, waiting up to
+// wait for one in each (or not at all if wait is zero).
+func (q *natsQueue) next(lanes []string, wait time.Duration) (jetstream.Msg, string) {
+	for _, lane := range lanes {
+		var batch jetstream.MessageBatch
+		var err error
+		if wait > 0 {
+			batch, err = q.consumers[lane].Fetch(1, jetstream.FetchMaxWait(wait))
+		} else {
+			batch, err = q.consumers[lane].FetchNoWait(1)
+		}
+		if err == nil {
+			msg := <-batch.Messages()
+			err = batch.Error()
+			if msg != nil {
+				return msg, lane
+			}
+		}
+		if err != nil && !errors.Is(err, nats.ErrTimeout) && !errors.Is(err, jetstream.ErrNoMessages) {
+			log.Printf("Failed to pull jobs from JetStream: %v\n", err)
+			time.Sleep(time.Second)
+		}
+	}
+	return nil, ""
+}
+
+// take readies the job of msg for a worker, or settles the message if the
+// job can't run.
+func (q *natsQueue) take(msg jetstream.Msg) (queuedJob, bool) {
+	var message natsJobMessage
+	if err := json.Unmarshal(msg.Data(), &message); err != nil || message.JobId == "" {
+		log.Printf("Dropping invalid JetStream job message: %q\n", msg.Data())
+		msg.Ack()
+		return queuedJob{}, false
+	}
+	ctx := context.Background()
+	spec, err := q.s.getJobSpec(ctx, message.JobId)
+	if err == redis.Nil {
+		// Finished, or expired, while the message was delivered again.
+		msg.Ack()
+		return queuedJob{}, false
+	} else if err != nil {
+		log.Printf("Failed to get job %s from Redis: %v\n", message.JobId, err)
+		msg.NakWithDelay(q.s.RetryBackoff)
+		return queuedJob{}, false
+	}
+	queued := queuedJob{job: spec.proofJob, queuedAt: message.QueuedAt, origin: message.Origin}
+	if message.Origin == q.origin {
+		q.mu.Lock()
+		queued.done = q.waiting[message.JobId]
+		delete(q.waiting, message.JobId)
+		q.mu.Unlock()
+	}
+	// Every delivery after the first is a worker that stopped.
+	if metadata, err := msg.Metadata(); err == nil && metadata.NumDelivered > 1 {
+		queued.job.Attempt += int(metadata.NumDelivered) - 1
+	}
+	if queued.job.Attempt > q.s.MaxAttempts {
+		err := withCode(ErrorCodeRestarted, fmt.Errorf("job interrupted by node restarts after %d attempts", queued.job.Attempt-1))
+		q.complete(queued, q.s.failJob(ctx, queued.job, err))
+		msg.Ack()
+		return queuedJob{}, false
+	}
+	q.mu.Lock()
+	q.running[message.JobId] = natsRunning{msg: msg, startedAt: time.Now()}
+	q.mu.Unlock()
+	return queued, true
+}
+
+// keepAlive resets the acknowledgement timer of the jobs taken by this
+// node's workers, so that only the jobs of stopped nodes are delivered
+// again.
+func (q *natsQueue) keepAlive() {
+	ticker := time.NewTicker(q.cfg.AckWait / 3)
+	defer ticker.Stop()
+	for range ticker.C {
+		q.mu.Lock()
+		for jobId, running := range q.running {
+			if err := running.msg.InProgress(); err != nil {
+				log.Printf("Failed to extend JetStream job %s: %v\n", jobId, err)
+			}
+		}
+		q.mu.Unlock()
+	}
+}
+
+func (q *natsQueue) finish(jobId string) {
+	q.mu.Lock()
+	running, ok := q.running[jobId]
+	delete(q.running, jobId)
+	q.mu.Unlock()
+	if !ok {
+		return
+	}
+	if err := running.msg.Ack(); err != nil {
+		log.Printf("Failed to acknowledge JetStream job %s: %v\n", jobId, err)
+	}
+}
+
+// complete hands the outcome to the submitter of the job, which waits for
+// it if it runs a DAG.
+func (q *natsQueue) complete(job queuedJob, err error) {
+	if job.done != nil {
+		job.done <- err
+		return
+	}
+	if job.origin == "" {
+		return
+	}
+	outcome := natsOutcome{JobId: job.job.JobId}
+	if err != nil {
+		outcome.Error = err.Error()
+		outcome.Code = errorCodeOf(err)
+	}
+	data, _ := json.Marshal(outcome)
+	if err := q.conn.Publish(job.origin, data); err != nil {
+		log.Printf("Failed to report the outcome of job %s: %v\n", job.job.JobId, err)
+	}
+}
+
+func (q *natsQueue) handleOutcome(msg *nats.Msg) {
+	var outcome natsOutcome
+	if err := json.Unmarshal(msg.Data, &outcome); err != nil {
+		return
+	}
+	q.mu.Lock()
+	done, ok := q.waiting[outcome.JobId]
+	delete(q.waiting, outcome.JobId)
+	q.mu.Unlock()
+	if !ok {
+		return
+	}
+	var err error
+	if outcome.Error != "" {
+		err = errors.New(outcome.Error)
+		if outcome.Code != "" {
+			err = withCode(outcome.Code, err)
+		}
+	}
+	done <- err
+}
+
+// position only knows the jobs taken by this node's workers: the stream
+// does not tell where a message is.
+func (q *natsQueue) position(jobId string) (ahead int, startedAt time.Time, found bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if running, ok := q.running[jobId]; ok {
+		return 0, running.startedAt, true
+	}
+	return 0, time.Time{}, false
+}
+
+// len is the number of jobs of every node waiting for a worker.
+func (q *natsQueue) len() int {
+	depth := 0
+	for _, lane := range natsLanes {
+		ctx, cancel := context.WithTimeout(context.Background(), natsTimeout)
+		info, err := q.consumers[lane].Info(ctx)
+		cancel()
+		if err != nil {
+			log.Printf("Failed to get JetStream queue depth: %v\n", err)
+			continue
+		}
+		depth += int(info.NumPending)
+	}
+	return depth
+}
+
+func (q *natsQueue) inFlight() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.running)
+}
+
+func (q *natsQueue) redelivers() bool {
+	return true
+}
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+// startJetStream runs an in-process NATS server with JetStream, stopped
+// when the test ends, and returns its URL.
+func startJetStream(t *testing.T) string {
+	t.Helper()
+	srv, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      -1,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		NoLog:     true,
+		NoSigs:    true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Start()
+	if !srv.ReadyForConnections(10 * time.Second) {
+		t.Fatal("NATS server did not start")
+	}
+	t.Cleanup(srv.Shutdown)
+	return srv.ClientURL()
+}
+
+// natsNode is a node of the tests: its own connection and queue, sharing
+// the stream and Redis with the other nodes.
+func natsNode(t *testing.T, url string, rdb *redis.Client, ackWait time.Duration) (*State, *nats.Conn) {
+	t.Helper()
+	conn, err := nats.Connect(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(conn.Close)
+	s := &State{
+		RedisClient:       rdb,
+		NodeId:            uuid.NewString(),
+		HighPriorityBurst: 4,
+		MaxAttempts:       3,
+		RetryBackoff:      time.Second,
+		ResultTTL:         time.Hour,
+	}
+	err = s.UseJetStream(context.Background(), conn, NATSQueueConfig{
+		Stream:   "TEST_JOBS",
+		Subject:  "test.jobs",
+		AckWait:  ackWait,
+		Replicas: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, conn
+}
+
+// testProofJob returns a job of the proof of testdata, stored like a
+// submitted job.
+func testProofJob(t *testing.T, s *State, priority string) proofJob {
+	t.Helper()
+	request, _ := testRequest(t)
+	job := proofJob{JobId: uuid.NewString(), Input: request.Proof.proofInput, Priority: priority}
+	if err := s.storeJobSpec(context.Background(), job); err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+// popWithin pops the next job of s, failing the test if none comes within
+// timeout.
+func popWithin(t *testing.T, s *State, timeout time.Duration) queuedJob {
+	t.Helper()
+	popped := make(chan queuedJob, 1)
+	go func() { popped <- s.queue.pop() }()
+	select {
+	case queued := <-popped:
+		return queued
+	case <-time.After(timeout):
+		t.Fatal("no job was popped")
+		return queuedJob{}
+	}
+}
+
+func newMemoryRedis(t *testing.T) *redis.Client {
+	rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	return rdb
+}
+
+func TestNATSQueuePushPop(t *testing.T) {
+	url := startJetStream(t)
+	s, _ := natsNode(t, url, newMemoryRedis(t), time.Minute)
+
+	low := testProofJob(t, s, priorityLow)
+	high := testProofJob(t, s, priorityHigh)
+	done := make(chan error, 1)
+	s.queue.push(queuedJob{job: low, queuedAt: time.Now()})
+	s.queue.push(queuedJob{job: high, queuedAt: time.Now(), done: done})
+	if depth := s.queue.len(); depth != 2 {
+		t.Fatalf("len = %d, want 2", depth)
+	}
+
+	// The high priority lane is taken from first.
+	queued := popWithin(t, s, 10*time.Second)
+	if queued.job.JobId != high.JobId || queued.done != done {
+		t.Fatalf("popped %s, want the high priority job %s with its done channel", queued.job.JobId, high.JobId)
+	}
+	if queued.job.Attempt != 0 {
+		t.Fatalf("attempt = %d, want 0", queued.job.Attempt)
+	}
+	if _, _, found := s.queue.position(high.JobId); !found || s.queue.inFlight() != 1 {
+		t.Fatal("popped job is not running")
+	}
+	s.queue.finish(high.JobId)
+	if s.queue.inFlight() != 0 {
+		t.Fatal("finished job is still running")
+	}
+
+	queued = popWithin(t, s, 10*time.Second)
+	if queued.job.JobId != low.JobId {
+		t.Fatalf("popped %s, want %s", queued.job.JobId, low.JobId)
+	}
+	s.queue.finish(low.JobId)
+	if depth := s.queue.len(); depth != 0 {
+		t.Fatalf("len = %d, want 0", depth)
+	}
+}
+
+func TestNATSQueueRedeliversJobsOfStoppedNode(t *testing.T) {
+	url := startJetStream(t)
+	rdb := newMemoryRedis(t)
+	stopped, stoppedConn := natsNode(t, url, rdb, time.Second)
+	job := testProofJob(t, stopped, priorityHigh)
+	stopped.queue.push(queuedJob{job: job, queuedAt: time.Now()})
+	if queued := popWithin(t, stopped, 10*time.Second); queued.job.JobId != job.JobId {
+		t.Fatalf("popped %s, want %s", queued.job.JobId, job.JobId)
+	}
+
+	// The node stops without finishing the job: another node gets it once
+	// its acknowledgement timer runs out, as its second attempt.
+	stoppedConn.Close()
+	other, _ := natsNode(t, url, rdb, time.Second)
+	queued := popWithin(t, other, 10*time.Second)
+	if queued.job.JobId != job.JobId {
+		t.Fatalf("popped %s, want %s", queued.job.JobId, job.JobId)
+	}
+	if queued.job.Attempt != 1 {
+		t.Fatalf("attempt = %d, want 1", queued.job.Attempt)
+	}
+	other.queue.finish(job.JobId)
+}
+
+func TestNATSQueueReportsOutcomeToSubmitter(t *testing.T) {
+	url := startJetStream(t)
+	rdb := newMemoryRedis(t)
+	submitter, _ := natsNode(t, url, rdb, time.Minute)
+	worker, _ := natsNode(t, url, rdb, time.Minute)
+
+	job := testProofJob(t, submitter, priorityHigh)
+	done := make(chan error, 1)
+	submitter.queue.push(queuedJob{job: job, queuedAt: time.Now(), done: done})
+	queued := popWithin(t, worker, 10*time.Second)
+	if queued.job.JobId != job.JobId || queued.done != nil {
+		t.Fatalf("popped %s, want %s without a done channel", queued.job.JobId, job.JobId)
+	}
+	worker.queue.finish(job.JobId)
+	worker.queue.complete(queued, withCode(ErrorCodeInvalidInput, errors.New("bad proof")))
+
+	select {
+	case err := <-done:
+		if err == nil || errorCodeOf(err) != ErrorCodeInvalidInput || err.Error() != "bad proof" {
+			t.Fatalf("outcome = %v, want bad proof with %s", err, ErrorCodeInvalidInput)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("submitter was not told the outcome")
+	}
+}
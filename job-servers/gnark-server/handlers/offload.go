@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+func (s *State) getResultObjectKey(jobId string) string {
+	return s.ObjectStorePrefix + "results/" + jobId + ".json"
+}
+
+// offloadResult uploads the result of a successful job to the object store
+// and returns the record to keep in Redis in its place, with its TTL: the
+// public inputs and reports, and a pointer to the object. Results stay in
+// Redis when no object store is configured, when they are smaller than
+// OffloadMinBytes or when the upload fails. A result retention asked for by
+// the job applies to the pointer too.
+func (s *State) offloadResult(ctx context.Context, job proofJob, response ProofResponse) (ProofResponse, time.Duration) {
+	jobId, ttl := job.JobId, s.resultTTL(job)
+	if s.Objects == nil || !response.Success || response.Proof == nil {
+		return response, ttl
+	}
+	resultJSON, err := json.Marshal(response.Proof)
+	if err != nil {
+		log.Printf("Failed to serialize result of %s: %v\n", jobId, err)
+		return response, ttl
+	}
+	if len(resultJSON) < s.OffloadMinBytes {
+		return response, ttl
+	}
+	key := s.getResultObjectKey(jobId)
+	start := time.Now()
+	if err := s.Objects.Put(ctx, key, resultJSON); err != nil {
+		log.Printf("Failed to upload result of %s, keeping it in Redis: %v\n", jobId, err)
+		return response, ttl
+	}
+	log.Printf("Uploaded result of %s (%d bytes) in %s\n", jobId, len(resultJSON), time.Since(start))
+	response.Proof = &ProveResult{
+		PublicInputs: response.Proof.PublicInputs,
+		Race:         response.Proof.Race,
+		Relay:        response.Proof.Relay,
+		Simulation:   response.Proof.Simulation,
+	}
+	response.ProofObject = key
+	if job.ResultTTL > 0 {
+		return response, ttl
+	}
+	return response, s.OffloadedResultTTL
+}
+
+// loadOffloadedResult replaces the stub result of a record whose result was
+// offloaded with the one in the object store.
+func (s *State) loadOffloadedResult(ctx context.Context, response *ProofResponse) error {
+	if response.ProofObject == "" {
+		return nil
+	}
+	if s.Objects == nil {
+		return fmt.Errorf("result of the job is in the object store, which is not configured")
+	}
+	resultJSON, err := s.Objects.Get(ctx, response.ProofObject)
+	if err != nil {
+		return err
+	}
+	var result ProveResult
+	if err := json.Unmarshal(resultJSON, &result); err != nil {
+		return err
+	}
+	response.Proof = &result
+	response.ProofObject = ""
+	return nil
+}
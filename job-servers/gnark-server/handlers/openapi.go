@@ -0,0 +1,247 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"gnark-server/apierror"
+	"gnark-server/apiversion"
+	"gnark-server/openapi"
+)
+
+const swaggerUIVersion = "5.17.14"
+
+var clientSecurity = []map[string][]string{{"apiKey": {}}, {"bearerToken": {}}}
+
+func errorResponses(b *openapi.Builder, statuses ...int) map[string]*openapi.Response {
+	responses := map[string]*openapi.Response{}
+	for _, status := range statuses {
+		responses[strconv.Itoa(status)] = &openapi.Response{Description: http.StatusText(status), Content: b.JSON(apierror.Envelope{})}
+	}
+	return responses
+}
+
+func withResponse(responses map[string]*openapi.Response, status int, response *openapi.Response) map[string]*openapi.Response {
+	responses[strconv.Itoa(status)] = response
+	return responses
+}
+
+func queryParameter(name string, description string, required bool, schema *openapi.Schema) openapi.Parameter {
+	return openapi.Parameter{Name: name, In: "query", Description: description, Required: required, Schema: schema}
+}
+
+// OpenAPIDocument describes the public APIs and the client APIs of version.
+// The admin API is left out, as it is not meant for generated clients.
+func (s *State) OpenAPIDocument(version int) *openapi.Document {
+	circuitName, _ := s.circuit()
+	prefix := apiversion.Prefix(version)
+	b := openapi.NewBuilder(openapi.Info{
+		Title:       "gnark-server",
+		Version:     strconv.Itoa(version),
+		Description: "Wraps plonky2 proofs of the " + circuitName + " circuit in gnark PLONK proofs.",
+	})
+	b.AddSecurityScheme("apiKey", &openapi.SecurityScheme{Type: "apiKey", In: "header", Name: "X-API-Key"})
+	b.AddSecurityScheme("bearerToken", &openapi.SecurityScheme{Type: "http", Scheme: "bearer"})
+	uuidSchema := &openapi.Schema{Type: "string", Format: "uuid"}
+
+	b.Add(http.MethodGet, "/health", &openapi.Operation{
+		OperationId: "health",
+		Summary:     "Report that the server is up",
+		Tags:        []string{"status"},
+		Responses:   map[string]*openapi.Response{"200": {Description: "The server is up"}},
+	})
+	b.Add(http.MethodGet, "/ready", &openapi.Operation{
+		OperationId: "ready",
+		Summary:     "Report whether the node can take proof jobs",
+		Tags:        []string{"status"},
+		Responses: map[string]*openapi.Response{
+			"200": {Description: "Ready", Content: b.JSON(ReadyStatus{})},
+			"503": {Description: "Not ready", Content: b.JSON(ReadyStatus{})},
+		},
+	})
+	b.Add(http.MethodGet, prefix+"/circuit/info", &openapi.Operation{
+		OperationId: "circuitInfo",
+		Summary:     "Describe the loaded circuit and its verifying key",
+		Tags:        []string{"circuit"},
+		Responses:   withResponse(errorResponses(b, 500), 200, &openapi.Response{Description: "The circuit", Content: b.JSON(CircuitInfo{})}),
+	})
+	b.Add(http.MethodGet, prefix+"/circuit/{name}/bench", &openapi.Operation{
+		OperationId: "circuitBench",
+		Summary:     "Report the size, expected prove time and memory of a circuit resident on this node",
+		Tags:        []string{"circuit"},
+		Parameters:  []openapi.Parameter{{Name: "name", In: "path", Required: true, Schema: &openapi.Schema{Type: "string"}}},
+		Responses:   withResponse(errorResponses(b, 404), 200, &openapi.Response{Description: "The figures", Content: b.JSON(CircuitBench{})}),
+	})
+	b.Add(http.MethodGet, prefix+"/verifier/solidity", &openapi.Operation{
+		OperationId: "verifierSolidity",
+		Summary:     "Export the Solidity verifier of the loaded circuit",
+		Tags:        []string{"circuit"},
+		Responses: withResponse(errorResponses(b, 500), 200, &openapi.Response{
+			Description: "The verifier contract",
+			Content:     map[string]*openapi.MediaType{"text/plain": {Schema: &openapi.Schema{Type: "string"}}},
+		}),
+	})
+	b.Add(http.MethodGet, prefix+"/version", &openapi.Operation{
+		OperationId: "version",
+		Summary:     "Report the server build and the loaded circuit version",
+		Tags:        []string{"status"},
+		Responses:   withResponse(errorResponses(b, 500), 200, &openapi.Response{Description: "The version", Content: b.JSON(VersionInfo{})}),
+	})
+	b.Add(http.MethodGet, prefix+"/changelog", &openapi.Operation{
+		OperationId: "changelog",
+		Summary:     "List the recorded and announced changes",
+		Tags:        []string{"circuit"},
+		Parameters: []openapi.Parameter{
+			queryParameter("since", "Only changes effective at or after this time.", false, &openapi.Schema{Type: "string", Format: "date-time"}),
+		},
+		Responses: withResponse(errorResponses(b, 400, 500), 200, &openapi.Response{Description: "The changes, oldest first", Content: b.JSON([]ChangelogEntry{})}),
+	})
+	if s.Receipts != nil {
+		b.Add(http.MethodGet, prefix+"/receipt/public-key", &openapi.Operation{
+			OperationId: "receiptPublicKey",
+			Summary:     "Return the public key receipts are signed with",
+			Tags:        []string{"proofs"},
+			Responses:   map[string]*openapi.Response{"200": {Description: "The key", Content: b.JSON(map[string]string{})}},
+		})
+	}
+	if s.ResultSigner != nil {
+		b.Add(http.MethodGet, prefix+"/result/signer", &openapi.Operation{
+			OperationId: "resultSigner",
+			Summary:     "Return the key proof results are signed with",
+			Tags:        []string{"proofs"},
+			Responses:   map[string]*openapi.Response{"200": {Description: "The key", Content: b.JSON(map[string]string{})}},
+		})
+	}
+	if s.Attester != nil {
+		b.Add(http.MethodGet, prefix+"/attestation", &openapi.Operation{
+			OperationId: "attestation",
+			Summary:     "Attest the trusted execution environment the server runs in",
+			Tags:        []string{"circuit"},
+			Parameters: []openapi.Parameter{
+				queryParameter("nonce", "Hex nonce of at most 32 bytes, bound into the report data.", false, &openapi.Schema{Type: "string"}),
+			},
+			Responses: withResponse(errorResponses(b, 400, 500), 200, &openapi.Response{Description: "The attestation report", Content: b.JSON(AttestationReport{})}),
+		})
+	}
+
+	b.Add(http.MethodPost, prefix+"/start-proof", &openapi.Operation{
+		OperationId: "startProof",
+		Summary:     "Start a proof job",
+		Tags:        []string{"proofs"},
+		Parameters: []openapi.Parameter{
+			{Name: "Idempotency-Key", In: "header", Description: "Retries with the same key return the job of the first request.", Schema: &openapi.Schema{Type: "string"}},
+		},
+		RequestBody: &openapi.RequestBody{Required: true, Content: b.JSON(startProofRequest{})},
+		Responses:   withResponse(errorResponses(b, 400, 401, 402, 403, 409, 413, 429, 500, 503), 200, &openapi.Response{Description: "The job was accepted", Content: b.JSON(startProofResponse{})}),
+		Security:    clientSecurity,
+	})
+	b.Add(http.MethodPost, prefix+"/prove/dry-run", &openapi.Operation{
+		OperationId: "dryRun",
+		Summary:     "Build the witness of a proof and return its public inputs, without proving",
+		Tags:        []string{"proofs"},
+		RequestBody: &openapi.RequestBody{Required: true, Content: b.JSON(dryRunRequest{})},
+		Responses:   withResponse(errorResponses(b, 400, 401, 403, 413, 422, 500), 200, &openapi.Response{Description: "The public inputs", Content: b.JSON(DryRunResponse{})}),
+		Security:    clientSecurity,
+	})
+	proofResponse := b.Schema(ProofResponse{})
+	if version >= apiversion.V2 {
+		proofResponse = b.Schema(ProofResponseV2{})
+	}
+	b.Add(http.MethodGet, prefix+"/get-proof", &openapi.Operation{
+		OperationId: "getProof",
+		Summary:     "Get the status or result of a proof job",
+		Tags:        []string{"proofs"},
+		Parameters: []openapi.Parameter{
+			queryParameter("jobId", "", true, uuidSchema),
+			queryParameter("format", "", false, &openapi.Schema{Type: "string", Enum: []string{formatCalldata, formatBlob}}),
+			queryParameter("proofEncoding", "", false, &openapi.Schema{Type: "string", Enum: []string{encodingHex, encodingBase64, encodingBinary}}),
+			queryParameter("publicInputsEncoding", "", false, &openapi.Schema{Type: "string", Enum: []string{encodingDecimal, encodingHex}}),
+		},
+		Responses: withResponse(errorResponses(b, 400, 401, 403, 404, 406, 500, 503), 200, &openapi.Response{
+			Description: "The job; proof is null while it is pending",
+			Content: map[string]*openapi.MediaType{
+				"application/json":         {Schema: proofResponse},
+				"application/octet-stream": {Schema: &openapi.Schema{Type: "string", Format: "binary"}},
+			},
+		}),
+		Security: clientSecurity,
+	})
+	b.Add(http.MethodPost, prefix+"/start-dag", &openapi.Operation{
+		OperationId: "startDag",
+		Summary:     "Start proof jobs with dependencies between them",
+		Tags:        []string{"proofs"},
+		RequestBody: &openapi.RequestBody{Required: true, Content: b.JSON(startDagRequest{})},
+		Responses:   withResponse(errorResponses(b, 400, 401, 402, 403, 409, 413, 429, 500, 503), 200, &openapi.Response{Description: "The jobs were accepted", Content: b.JSON(startDagResponse{})}),
+		Security:    clientSecurity,
+	})
+	b.Add(http.MethodGet, prefix+"/get-dag", &openapi.Operation{
+		OperationId: "getDag",
+		Summary:     "Get the status of the jobs of a DAG",
+		Tags:        []string{"proofs"},
+		Parameters:  []openapi.Parameter{queryParameter("dagId", "", true, uuidSchema)},
+		Responses:   withResponse(errorResponses(b, 400, 401, 403, 404, 500, 503), 200, &openapi.Response{Description: "The DAG", Content: b.JSON(DagStatus{})}),
+		Security:    clientSecurity,
+	})
+	b.Add(http.MethodGet, prefix+"/usage", &openapi.Operation{
+		OperationId: "usage",
+		Summary:     "Report the daily usage and quotas of the caller's API key",
+		Tags:        []string{"proofs"},
+		Parameters:  []openapi.Parameter{queryParameter("days", "The number of days reported, today included; 7 by default.", false, &openapi.Schema{Type: "integer"})},
+		Responses:   withResponse(errorResponses(b, 400, 401, 500, 503), 200, &openapi.Response{Description: "The usage, most recent day first", Content: b.JSON(UsageReport{})}),
+		Security:    clientSecurity,
+	})
+	b.Add(http.MethodPost, prefix+"/jobs/{jobId}/retry", &openapi.Operation{
+		OperationId: "retryJob",
+		Summary:     "Run a failed job again from its stored input and settings",
+		Tags:        []string{"proofs"},
+		Parameters:  []openapi.Parameter{{Name: "jobId", In: "path", Required: true, Schema: uuidSchema}},
+		RequestBody: &openapi.RequestBody{Content: b.JSON(retryJobRequest{})},
+		Responses:   withResponse(errorResponses(b, 400, 401, 403, 404, 409, 422, 429, 500, 503), 200, &openapi.Response{Description: "The job was accepted", Content: b.JSON(startProofResponse{})}),
+		Security:    clientSecurity,
+	})
+	return b.Document()
+}
+
+func (s *State) OpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		apierror.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.OpenAPIDocument(apiversion.FromContext(r.Context())))
+}
+
+var swaggerUIPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>gnark-server API</title>
+<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@` + swaggerUIVersion + `/swagger-ui.css">
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="https://unpkg.com/swagger-ui-dist@` + swaggerUIVersion + `/swagger-ui-bundle.js"></script>
+<script src="https://unpkg.com/swagger-ui-dist@` + swaggerUIVersion + `/swagger-ui-standalone-preset.js"></script>
+<script>SwaggerUIBundle({urls: %s, dom_id: "#swagger-ui", presets: [SwaggerUIBundle.presets.apis, SwaggerUIStandalonePreset], layout: "StandaloneLayout"});</script>
+</body>
+</html>
+`
+
+// SwaggerUI serves a Swagger UI page browsing the OpenAPI document of each
+// API version, loading its assets from unpkg.
+func SwaggerUI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		apierror.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var urls []map[string]string
+	for _, version := range apiversion.Supported {
+		prefix := apiversion.Prefix(version)
+		urls = append(urls, map[string]string{"name": prefix[1:], "url": prefix + "/openapi.json"})
+	}
+	urlsJSON, _ := json.Marshal(urls)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, swaggerUIPage, urlsJSON)
+}
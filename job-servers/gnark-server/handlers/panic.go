@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// panicError is a panic recovered while running a job.
+type panicError struct {
+	value interface{}
+	stack string
+}
+
+func (e *panicError) Error() string {
+	return fmt.Sprintf("internal error: panic: %v", e.value)
+}
+
+// protect runs fn, returning a panic in it as a *panicError. Job steps that
+// run on their own goroutine must go through it, since a panic there would
+// otherwise crash the server.
+func protect[T any](fn func() (T, error)) (value T, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &panicError{value: r, stack: string(debug.Stack())}
+		}
+	}()
+	return fn()
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+
+	"gnark-server/apierror"
+	"gnark-server/auth"
+	"gnark-server/payment"
+	"gnark-server/rediskey"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// redisPaymentKeyPrefix maps each payment reference to the job it paid for,
+// so that a reference pays for one job only. The keys do not expire.
+const redisPaymentKeyPrefix = "payment:"
+
+func getPaymentRedisKey(reference string) string {
+	return rediskey.Key(redisPaymentKeyPrefix) + reference
+}
+
+// paymentReference is the spelling under which reference is claimed, the
+// one Payments normalizes it to if it is a payment.Normalizer.
+func (s *State) paymentReference(reference string) string {
+	if normalizer, ok := s.Payments.(payment.Normalizer); ok {
+		return normalizer.Normalize(reference)
+	}
+	return reference
+}
+
+// verifyPayment claims the payment reference of job and has it checked by
+// Payments, unless payments are not required or identity is exempt. It
+// returns the status to answer with if the job may not be queued. A
+// resubmission of the same jobId may reuse the reference it claimed.
+func (s *State) verifyPayment(ctx context.Context, job proofJob, identity auth.Identity) (int, error) {
+	if s.Payments == nil || identity.PaymentExempt {
+		return http.StatusOK, nil
+	}
+	if job.Payment == "" {
+		return http.StatusPaymentRequired, fmt.Errorf("payment is required")
+	}
+	key := getPaymentRedisKey(s.paymentReference(job.Payment))
+	claimed, err := s.RedisClient.SetNX(ctx, key, job.JobId, 0).Result()
+	if err != nil {
+		log.Printf("Failed to claim payment reference in Redis: %v\n", err)
+		return http.StatusServiceUnavailable, fmt.Errorf("payment could not be verified, retry later")
+	}
+	if !claimed {
+		paidJobId, err := s.RedisClient.Get(ctx, key).Result()
+		if err != nil && err != redis.Nil {
+			log.Printf("Failed to read payment reference from Redis: %v\n", err)
+			return http.StatusServiceUnavailable, fmt.Errorf("payment could not be verified, retry later")
+		}
+		if paidJobId != job.JobId {
+			return http.StatusPaymentRequired, fmt.Errorf("payment was already used for another job")
+		}
+	}
+	err = s.Payments.Verify(ctx, payment.Request{
+		Reference: job.Payment,
+		JobId:     job.JobId,
+		Circuit:   job.Circuit,
+		InputHash: job.InputHash,
+		Key:       job.Key,
+		Tenant:    job.Tenant,
+		Senders:   identity.PaymentAddresses,
+	})
+	if err == nil {
+		return http.StatusOK, nil
+	}
+	if claimed {
+		s.releasePayment(ctx, job)
+	}
+	if errors.Is(err, payment.ErrUnpaid) {
+		log.Println("Unpaid submission rejected. jobId", job.JobId, "key", job.Key, err)
+		return http.StatusPaymentRequired, err
+	}
+	log.Printf("Failed to verify payment of job %s: %v\n", job.JobId, err)
+	return http.StatusServiceUnavailable, fmt.Errorf("payment could not be verified, retry later")
+}
+
+// releasePayment frees the payment reference of a job that was not queued
+// after all, so that it can pay for a resubmission.
+func (s *State) releasePayment(ctx context.Context, job proofJob) {
+	if s.Payments == nil || job.Payment == "" {
+		return
+	}
+	if err := s.RedisClient.Del(ctx, getPaymentRedisKey(s.paymentReference(job.Payment))).Err(); err != nil {
+		log.Printf("Failed to release payment reference in Redis: %v\n", err)
+	}
+}
+
+// writePaymentError answers a submission verifyPayment refused.
+func (s *State) writePaymentError(w http.ResponseWriter, message string, status int) {
+	if status == http.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(s.QueueRetryAfter.Seconds()))))
+	}
+	apierror.Error(w, message, status)
+}
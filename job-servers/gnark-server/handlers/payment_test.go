@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"gnark-server/auth"
+	"gnark-server/payment"
+)
+
+// hexPayments accepts any reference and normalizes it as a hex string.
+type hexPayments struct{}
+
+func (hexPayments) Verify(ctx context.Context, request payment.Request) error { return nil }
+
+func (hexPayments) Normalize(reference string) string {
+	return "0x" + strings.ToLower(strings.TrimPrefix(reference, "0x"))
+}
+
+func TestVerifyPaymentClaimsNormalizedReference(t *testing.T) {
+	ctx := context.Background()
+	s := &State{RedisClient: newMemoryRedis(t), Payments: hexPayments{}}
+	if status, err := s.verifyPayment(ctx, proofJob{JobId: "a", Payment: "0xABC"}, auth.Anonymous); err != nil {
+		t.Fatalf("first payment: %d %v", status, err)
+	}
+	for _, reference := range []string{"0xABC", "0xabc", "abc"} {
+		if status, err := s.verifyPayment(ctx, proofJob{JobId: "b", Payment: reference}, auth.Anonymous); status != http.StatusPaymentRequired {
+			t.Errorf("%s paid for a second job: %d %v", reference, status, err)
+		}
+	}
+	s.releasePayment(ctx, proofJob{JobId: "a", Payment: "abc"})
+	if status, err := s.verifyPayment(ctx, proofJob{JobId: "b", Payment: "0xAbC"}, auth.Anonymous); err != nil {
+		t.Fatalf("released payment: %d %v", status, err)
+	}
+}
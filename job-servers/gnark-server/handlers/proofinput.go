@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"gnark-server/receipt"
+
+	"github.com/qope/gnark-plonky2-verifier/types"
+)
+
+// proofInput is a plonky2 proof with its public inputs, decoded straight
+// into the types the witness is built from. It is encoded back in the
+// format it was submitted in, which the types do not do by themselves, so
+// that jobs can be stored and sent to peers without their raw JSON.
+type proofInput struct {
+	types.ProofWithPublicInputsRaw
+}
+
+// present reports whether p holds a proof, which jobs failed before their
+// input was read do not.
+func (p proofInput) present() bool {
+	return p.PublicInputs != nil
+}
+
+func (p *proofInput) UnmarshalJSON(data []byte) (err error) {
+	// The decoder of Merkle proofs panics on malformed ones.
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Failed to parse proof JSON: invalid merkle proof: %v", r)
+		}
+	}()
+	if err := json.Unmarshal(data, &p.ProofWithPublicInputsRaw); err != nil {
+		return fmt.Errorf("Failed to parse proof JSON: %w", err)
+	}
+	return nil
+}
+
+// evalProofJSON is the submitted format of a types.EvalProofRaw: its leaf
+// elements and Merkle proof as a pair.
+type evalProofJSON struct {
+	leafElements []uint64
+	siblings     []string
+}
+
+func (e evalProofJSON) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]interface{}{e.leafElements, struct {
+		Siblings []string `json:"siblings"`
+	}{e.siblings}})
+}
+
+func (p proofInput) MarshalJSON() ([]byte, error) {
+	type queryRoundJSON struct {
+		InitialTreesProof struct {
+			EvalsProofs []evalProofJSON `json:"evals_proofs"`
+		} `json:"initial_trees_proof"`
+		Steps interface{} `json:"steps"`
+	}
+	proof := p.Proof
+	var rounds []queryRoundJSON
+	if proof.OpeningProof.QueryRoundProofs != nil {
+		rounds = make([]queryRoundJSON, len(proof.OpeningProof.QueryRoundProofs))
+	}
+	for i, round := range proof.OpeningProof.QueryRoundProofs {
+		rounds[i].Steps = round.Steps
+		if round.InitialTreesProof.EvalsProofs == nil {
+			continue
+		}
+		evals := make([]evalProofJSON, len(round.InitialTreesProof.EvalsProofs))
+		for j, eval := range round.InitialTreesProof.EvalsProofs {
+			evals[j] = evalProofJSON{leafElements: eval.LeafElements, siblings: eval.MerkleProof.Hash}
+		}
+		rounds[i].InitialTreesProof.EvalsProofs = evals
+	}
+
+	var document struct {
+		Proof struct {
+			WiresCap                  []string    `json:"wires_cap"`
+			PlonkZsPartialProductsCap []string    `json:"plonk_zs_partial_products_cap"`
+			QuotientPolysCap          []string    `json:"quotient_polys_cap"`
+			Openings                  interface{} `json:"openings"`
+			OpeningProof              struct {
+				CommitPhaseMerkleCaps [][]string       `json:"commit_phase_merkle_caps"`
+				QueryRoundProofs      []queryRoundJSON `json:"query_round_proofs"`
+				FinalPoly             interface{}      `json:"final_poly"`
+				PowWitness            uint64           `json:"pow_witness"`
+			} `json:"opening_proof"`
+		} `json:"proof"`
+		PublicInputs []uint64 `json:"public_inputs"`
+	}
+	document.Proof.WiresCap = proof.WiresCap
+	document.Proof.PlonkZsPartialProductsCap = proof.PlonkZsPartialProductsCap
+	document.Proof.QuotientPolysCap = proof.QuotientPolysCap
+	document.Proof.Openings = proof.Openings
+	document.Proof.OpeningProof.CommitPhaseMerkleCaps = proof.OpeningProof.CommitPhaseMerkleCaps
+	document.Proof.OpeningProof.QueryRoundProofs = rounds
+	document.Proof.OpeningProof.FinalPoly = proof.OpeningProof.FinalPoly
+	document.Proof.OpeningProof.PowWitness = proof.OpeningProof.PowWitness
+	document.PublicInputs = p.PublicInputs
+	return json.Marshal(document)
+}
+
+// requestProof is the proof field of a request: the proof object or, as
+// clients originally sent it, the object serialized into a JSON string.
+type requestProof struct {
+	proofInput
+	// Present is whether the request has a proof that is not null.
+	Present bool
+	// PayloadHash is the receipt.PayloadHash of the proof JSON as
+	// submitted: the object, or the contents of the string.
+	PayloadHash string
+}
+
+func (p *requestProof) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	if len(data) > 0 && data[0] == '"' {
+		var serialized string
+		if err := json.Unmarshal(data, &serialized); err != nil {
+			return fmt.Errorf("Failed to parse proof JSON: %w", err)
+		}
+		data = []byte(serialized)
+	}
+	if err := p.proofInput.UnmarshalJSON(data); err != nil {
+		return err
+	}
+	p.Present = true
+	p.PayloadHash = receipt.PayloadHash(data)
+	return nil
+}
+
+func (p requestProof) MarshalJSON() ([]byte, error) {
+	return p.proofInput.MarshalJSON()
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// testRequest returns the start-proof request of testdata, whose proof is
+// the legacy string, and the proof object it holds.
+func testRequest(t *testing.T) (startProofRequest, []byte) {
+	t.Helper()
+	data, err := os.ReadFile("../testdata/claim_proof.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var request startProofRequest
+	if err := json.Unmarshal(data, &request); err != nil {
+		t.Fatal(err)
+	}
+	var legacy struct {
+		Proof string `json:"proof"`
+	}
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		t.Fatal(err)
+	}
+	return request, []byte(legacy.Proof)
+}
+
+func TestRequestProofForms(t *testing.T) {
+	request, object := testRequest(t)
+	if !request.Proof.Present {
+		t.Fatal("proof string is not present")
+	}
+	var fromObject startProofRequest
+	if err := json.Unmarshal([]byte(`{"proof":`+string(object)+`}`), &fromObject); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fromObject.Proof, request.Proof) {
+		t.Fatal("the proof object and the proof string decode differently")
+	}
+
+	var missing startProofRequest
+	if err := json.Unmarshal([]byte(`{"proof":null}`), &missing); err != nil || missing.Proof.Present {
+		t.Fatalf("null proof: present %v, error %v", missing.Proof.Present, err)
+	}
+}
+
+func TestProofInputRoundTrip(t *testing.T) {
+	request, _ := testRequest(t)
+	encoded, err := json.Marshal(request.Proof.proofInput)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded proofInput
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, request.Proof.proofInput) {
+		t.Fatal("the encoded proof decodes differently")
+	}
+}
+
+func TestValidateProofInputRequiresLists(t *testing.T) {
+	request, _ := testRequest(t)
+	input := request.Proof.ProofWithPublicInputsRaw
+	if err := validateProofInput(input, nil); err != nil {
+		t.Fatalf("valid input: %v", err)
+	}
+	input.Proof.Openings.Wires = nil
+	var schemaErr *inputSchemaError
+	if err := validateProofInput(input, nil); !errors.As(err, &schemaErr) || schemaErr.Errors[0].Field != "proof.openings.wires" {
+		t.Fatalf("input without wires: %v", err)
+	}
+}
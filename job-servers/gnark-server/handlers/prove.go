@@ -2,49 +2,390 @@ package handlers
 
 import (
 	"context"
-	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math/big"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 
-	verifierCircuit "gnark-server/circuit"
+	"gnark-server/accesslog"
+	"gnark-server/apierror"
+	"gnark-server/apiversion"
+	"gnark-server/artifacts"
+	"gnark-server/atrest"
+	"gnark-server/attestation"
+	"gnark-server/audit"
+	"gnark-server/auth"
 	"gnark-server/circuitData"
-	"gnark-server/utils"
+	"gnark-server/gctune"
+	"gnark-server/memadmit"
+	"gnark-server/objectstore"
+	"gnark-server/payment"
+	"gnark-server/prover"
+	"gnark-server/receipt"
+	"gnark-server/redisbreaker"
+	"gnark-server/rediskey"
+	"gnark-server/relayer"
+	"gnark-server/resultbox"
+	"gnark-server/resultsig"
+	"gnark-server/slo"
+	"gnark-server/webhook"
+	"gnark-server/wrapper"
 
-	"github.com/consensys/gnark-crypto/ecc"
-	plonk_bn254 "github.com/consensys/gnark/backend/plonk/bn254"
-	"github.com/consensys/gnark/frontend"
 	"github.com/go-redis/redis/v8"
 	"github.com/google/uuid"
-	"github.com/qope/gnark-plonky2-verifier/types"
-	"github.com/qope/gnark-plonky2-verifier/variables"
 )
 
 const (
-	redisKeyPrefix = "gnark_proof_result:"
-	expiration     = 24 * time.Hour
+	redisKeyPrefix            = "proof_result:"
+	redisIdempotencyKeyPrefix = "idempotency_key:"
+	redisPendingJobsKey       = "pending_jobs"
 )
 
 type ProveResult struct {
-	PublicInputs []string `json:"publicInputs"`
-	Proof        string   `json:"proof"`
+	PublicInputs []string    `json:"publicInputs"`
+	Proof        string      `json:"proof"`
+	Race         *RaceReport `json:"race,omitempty"`
+	// ProofKeccak256 and ProofSHA256 are the hashes of the Solidity-encoded
+	// proof bytes, whatever the proof encoding.
+	ProofKeccak256 string `json:"proofKeccak256,omitempty"`
+	ProofSHA256    string `json:"proofSha256,omitempty"`
+	// Calldata is the ABI-encoded verifier call, set when requested with format=calldata.
+	Calldata string `json:"calldata,omitempty"`
+	// Blobs and BlobVersionedHashes are set when requested with format=blob.
+	Blobs               []string          `json:"blobs,omitempty"`
+	BlobVersionedHashes []string          `json:"blobVersionedHashes,omitempty"`
+	Relay               *RelayReport      `json:"relay,omitempty"`
+	Simulation          *SimulationReport `json:"simulation,omitempty"`
+	// Signature, set when results are signed, binds the proof and public
+	// inputs to the job.
+	Signature *resultsig.Signature `json:"signature,omitempty"`
+
+	// Circuit and CircuitVersion, the version of its artifact manifest,
+	// are those the proof was made with.
+	Circuit        string `json:"circuit,omitempty"`
+	CircuitVersion string `json:"circuitVersion,omitempty"`
+
+	// The timing of the job's last attempt: QueueWaitMs waiting for a
+	// worker, WitnessGenerationMs building the witness, ProveMs proving
+	// (racing, if enabled) and TotalMs from being queued to the result.
+	// They are not set on results served from the cache.
+	QueueWaitMs         int64 `json:"queueWaitMs,omitempty"`
+	WitnessGenerationMs int64 `json:"witnessGenerationMs,omitempty"`
+	ProveMs             int64 `json:"proveMs,omitempty"`
+	TotalMs             int64 `json:"totalMs,omitempty"`
+
+	// Encrypted is the result of a job submitted with a resultPublicKey,
+	// sealed to that key with resultbox, base64-encoded; the other fields
+	// are then empty.
+	Encrypted string `json:"encrypted,omitempty"`
 }
 
 type ProofResponse struct {
-	Success      bool         `json:"success"`
+	Success      bool         `json:"success" openapi:"required"`
 	Proof        *ProveResult `json:"proof"`
 	ErrorMessage *string      `json:"errorMessage"`
+	// ErrorCode is one of the ErrorCode constants when the job failed.
+	ErrorCode string `json:"errorCode,omitempty"`
+	// Attempts is the number of times the job was run.
+	Attempts int `json:"attempts,omitempty"`
+	// ErrorStack is the stack of a job that failed with a panic.
+	ErrorStack *string `json:"errorStack,omitempty"`
+	// ProofObject is the object store key of an offloaded result; Proof
+	// then holds only its public inputs and reports.
+	ProofObject string `json:"proofObject,omitempty"`
+
+	// QueuePosition (1 for the next job) and EstimatedCompletionAt are set
+	// by get-proof while the job is pending.
+	QueuePosition         int        `json:"queuePosition,omitempty"`
+	EstimatedCompletionAt *time.Time `json:"estimatedCompletionAt,omitempty"`
+	// NotBefore is the notBefore of a pending job that was scheduled.
+	NotBefore *time.Time `json:"notBefore,omitempty"`
+
+	// Metadata is the metadata of the start-proof request, echoed back.
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	// Owner is the auth.Identity Owner of the submitter, the only caller
+	// get-proof returns the job to when set. It is never sent to clients.
+	Owner string `json:"owner,omitempty" openapi:"-"`
 }
 
 type State struct {
-	CircuitData circuitData.CircuitData
-	RedisClient *redis.Client
+	circuitMu   sync.RWMutex
+	CircuitName string
+	CircuitData *circuitData.CircuitData
+	// LoadOptions are used when the circuit is reloaded.
+	LoadOptions circuitData.LoadOptions
+	// CircuitManifest lists the artifacts of the loaded circuit as verified
+	// when it was loaded.
+	CircuitManifest artifacts.Manifest
+
+	// RequireArtifactManifest refuses to reload a circuit whose artifacts
+	// have no manifest file to be verified against.
+	RequireArtifactManifest bool
+
+	RedisClient redis.UniversalClient
+	ResultTTL   time.Duration
+	// RedisBreaker, when set, is the circuit breaker of RedisClient.
+	RedisBreaker *redisbreaker.Breaker
+	// Results that cannot be stored are kept in memory, up to
+	// ResultBufferSize of them for ResultBufferTTL.
+	ResultBufferSize int
+	ResultBufferTTL  time.Duration
+	// ResultSpoolDir, when set, also keeps the results of successful jobs
+	// that cannot be stored on disk, until they are, across restarts.
+	ResultSpoolDir string
+	// MaxResultTTL bounds the resultTtl of start-proof requests.
+	MaxResultTTL time.Duration
+	// FlipProbation is how long after a flip to the standby circuit a
+	// self-verification failure flips back.
+	FlipProbation time.Duration
+	// MaxScheduleDelay bounds how far ahead the notBefore of a start-proof
+	// request may be.
+	MaxScheduleDelay time.Duration
+	// Results keeps the job records; the other job state is in Redis.
+	Results ResultStore
+	// Pending counts the pending jobs of each tenant in-process instead of in
+	// Redis, when set.
+	Pending  PendingStore
+	Webhooks *webhook.Outbox
+	// PreVerify solves the constraint system, which checks the plonky2 proof
+	// against the verifier data, before starting the BN254 prove.
+	PreVerify bool
+	// SelfVerify verifies produced proofs against the verifying key, unless
+	// SelfVerifyCircuits says otherwise for the circuit.
+	SelfVerify         bool
+	SelfVerifyCircuits map[string]bool
+	// RacePeers are base URLs of other gnark servers that race the local
+	// prover for jobs submitted with "race": true.
+	RacePeers      []string
+	RacePeerAPIKey string
+	// Relayer, when set, submits every freshly proven result on-chain.
+	Relayer *relayer.Relayer
+	// Simulator, when set, dry-runs every fresh proof against the deployed
+	// verifier contract.
+	Simulator *relayer.Simulator
+	// RelayTimeout bounds each relay and simulation, which run in the
+	// worker after the prove.
+	RelayTimeout time.Duration
+
+	// Tokens mints service tokens with a lifetime of at most MaxTokenTTL.
+	Tokens      *auth.TokenIssuer
+	MaxTokenTTL time.Duration
+
+	GC *gctune.Tuner
+	// Memory, if set, holds proves back until memory is available for them.
+	Memory *memadmit.Controller
+
+	Prover prover.Backend
+	SLO    *slo.Recorder
+	// JobTimeout bounds solving and proving of a job; zero disables it.
+	JobTimeout time.Duration
+	// StartedAt is when the server started, for the reported uptime.
+	StartedAt time.Time
+	// NodeId identifies this node in stored jobs, so that it recovers its own
+	// jobs after a restart.
+	NodeId string
+	// MaxAttempts bounds the runs of a timed-out or interrupted job and the
+	// writes of its result; retries wait RetryBackoff, doubled every attempt.
+	MaxAttempts  int
+	RetryBackoff time.Duration
+	// Running jobs refresh a heartbeat every HeartbeatInterval, which
+	// expires after HeartbeatTTL; zero disables heartbeats and the reaper.
+	HeartbeatInterval time.Duration
+	HeartbeatTTL      time.Duration
+	// FailInterruptedJobs fails the jobs interrupted by a restart of this
+	// node instead of queuing them again.
+	FailInterruptedJobs bool
+	// MaxQueueDepth bounds the jobs waiting for a worker (0: unbounded);
+	// submissions beyond it are told to retry after QueueRetryAfter.
+	MaxQueueDepth   int
+	QueueRetryAfter time.Duration
+	// MaxPendingJobsPerKey caps the queued and running jobs of an API key
+	// without its own maxPendingJobs (0: unbounded).
+	MaxPendingJobsPerKey int
+	// HighPriorityBurst is the number of high-priority jobs taken in a row
+	// before a waiting low-priority one.
+	HighPriorityBurst int
+	// Compression is the encoding of large records stored in Redis (one of
+	// the Compression constants).
+	Compression string
+	// Objects, when set, stores the results of successful jobs of at least
+	// OffloadMinBytes under ObjectStorePrefix; Redis keeps a pointer for
+	// OffloadedResultTTL.
+	Objects            *objectstore.Client
+	ObjectStorePrefix  string
+	OffloadMinBytes    int
+	OffloadedResultTTL time.Duration
+	// DeadLetterTTL is how long failed jobs stay in the dead-letter queue.
+	DeadLetterTTL time.Duration
+	// InputTTL is how long the submitted proof JSON of jobs is kept (0: not
+	// kept).
+	InputTTL time.Duration
+	// UsageRetention is how long the daily usage counters of keys are kept.
+	UsageRetention time.Duration
+	// Receipts, when set, signs a receipt for every accepted job.
+	Receipts *receipt.Issuer
+	// ResultSigner, when set, signs the result of every successful job.
+	ResultSigner *resultsig.Signer
+	// Attester, when set, attests the environment the server runs in; it
+	// requires ResultSigner.
+	Attester attestation.Provider
+	// KafkaTenant is the tenant of the jobs read from Kafka.
+	KafkaTenant string
+	// RecordKeys, when set, encrypts the records stored in Redis.
+	RecordKeys *atrest.Keyring
+	// Audit, when set, records submissions and admin actions.
+	Audit audit.Store
+	// Payments, when set, checks the payment of every job submitted through
+	// the API before it is queued.
+	Payments payment.Verifier
+
+	queue   jobBackend
+	workers int
+
+	// canary, standby and flip are guarded by circuitMu.
+	canary  *canaryCircuit
+	standby *standbyCircuit
+	flip    *circuitFlip
+
+	warmUpMu        sync.Mutex
+	warmUpStatus    string
+	warmUpProofFile string
+	// calibrations are the last calibration of each circuit, by name.
+	calibrations map[string]Calibration
+
+	drainMu sync.Mutex
+	drain   *drainStatus
+
+	buffer resultBuffer
+}
+
+func (s *State) selfVerify(circuitName string) bool {
+	if verify, ok := s.SelfVerifyCircuits[circuitName]; ok {
+		return verify
+	}
+	return s.SelfVerify
+}
+
+func (s *State) circuit() (string, *circuitData.CircuitData) {
+	s.circuitMu.RLock()
+	defer s.circuitMu.RUnlock()
+	return s.CircuitName, s.CircuitData
+}
+
+// LoadedCircuit returns the name of the circuit currently served.
+func (s *State) LoadedCircuit() string {
+	circuitName, _ := s.circuit()
+	return circuitName
+}
+
+func (s *State) setCircuit(circuitName string, data *circuitData.CircuitData, manifest artifacts.Manifest) {
+	s.circuitMu.Lock()
+	defer s.circuitMu.Unlock()
+	s.CircuitName = circuitName
+	s.CircuitData = data
+	s.CircuitManifest = manifest
+	if s.canary != nil && s.canary.Name == circuitName {
+		// Promoted: the canary now gets every job.
+		s.canary = nil
+	}
+	if s.standby != nil && s.standby.Name == circuitName {
+		s.standby = nil
+	}
+	s.flip = nil
 }
 
 func getRedisKey(jobId string) string {
-	return fmt.Sprintf("%s%s", redisKeyPrefix, jobId)
+	return rediskey.JobKey(redisKeyPrefix, jobId)
+}
+
+// getIdempotencyRedisKey namespaces idempotencyKey by tenant, so that
+// tenants choosing the same key do not get each other's jobs.
+func getIdempotencyRedisKey(tenant string, idempotencyKey string) string {
+	return fmt.Sprintf("%s%s:%s", rediskey.Key(redisIdempotencyKeyPrefix), tenant, idempotencyKey)
+}
+
+// reserveJob stores the pending response for jobId unless the job already exists.
+func (s *State) reserveJob(ctx context.Context, job proofJob) (bool, error) {
+	response := ProofResponse{Success: true, Proof: nil, Metadata: job.Metadata, Owner: job.Owner}
+	if deferred(job) {
+		response.NotBefore = &job.NotBefore
+	}
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		return false, err
+	}
+	ok, err := s.Results.Reserve(ctx, job.JobId, responseJSON, s.ResultTTL)
+	if err != nil || !ok {
+		return ok, err
+	}
+	now := time.Now()
+	pipe := s.RedisClient.TxPipeline()
+	pipe.ZAdd(ctx, rediskey.Key(redisPendingJobsKey), &redis.Z{Score: float64(now.UnixMilli()), Member: job.JobId})
+	s.indexJob(ctx, pipe, job, now)
+	if err := s.storeInput(ctx, pipe, job); err != nil {
+		return true, err
+	}
+	countJob(ctx, pipe, job.Tenant, statSubmitted)
+	_, err = pipe.Exec(ctx)
+	return true, err
+}
+
+// errIdempotencyKeyReused is returned by resolveIdempotencyKey for a key
+// seen before with another proof.
+var errIdempotencyKeyReused = errors.New("Idempotency-Key was already used with a different proof")
+
+// resolveIdempotencyKey binds idempotencyKey to jobId and the payload hash of
+// its proof, returning the jobId already bound to it if the key was seen
+// before with the same proof, or errIdempotencyKeyReused.
+func (s *State) resolveIdempotencyKey(ctx context.Context, tenant string, idempotencyKey string, jobId string, payloadHash string) (string, bool, error) {
+	key := getIdempotencyRedisKey(tenant, idempotencyKey)
+	ok, err := s.RedisClient.SetNX(ctx, key, jobId+" "+payloadHash, s.ResultTTL).Result()
+	if err != nil {
+		return "", false, err
+	}
+	if ok {
+		return jobId, false, nil
+	}
+	binding, err := s.RedisClient.Get(ctx, key).Result()
+	if err != nil {
+		return "", false, err
+	}
+	// Keys bound before payload hashes were stored hold the jobId alone.
+	existingJobId, existingHash, hashed := strings.Cut(binding, " ")
+	if hashed && existingHash != payloadHash {
+		return "", false, errIdempotencyKeyReused
+	}
+	return existingJobId, true, nil
+}
+
+// resultTTL is how long the final result of job is kept.
+func (s *State) resultTTL(job proofJob) time.Duration {
+	if job.ResultTTL > 0 {
+		return job.ResultTTL
+	}
+	return s.ResultTTL
+}
+
+func formatResultTTL(ttl time.Duration) string {
+	if ttl <= 0 {
+		return ""
+	}
+	return ttl.String()
+}
+
+// retention is how long records kept from a job's submission until its
+// result expires, such as its receipt, are kept.
+func (s *State) retention(job proofJob) time.Duration {
+	if job.ResultTTL > s.ResultTTL {
+		return job.ResultTTL
+	}
+	return s.ResultTTL
 }
 
 func (s *State) setProofResponse(ctx context.Context, jobId string, response ProofResponse) error {
@@ -52,128 +393,570 @@ func (s *State) setProofResponse(ctx context.Context, jobId string, response Pro
 	if err != nil {
 		return err
 	}
-	return s.RedisClient.Set(ctx, getRedisKey(jobId), responseJSON, expiration).Err()
+	return s.Results.Set(ctx, jobId, responseJSON, s.ResultTTL)
 }
 
 func (s *State) getProofResponse(ctx context.Context, jobId string) (ProofResponse, error) {
+	if response, ok := s.bufferedResponse(jobId); ok {
+		return response, nil
+	}
 	var response ProofResponse
-	responseJSON, err := s.RedisClient.Get(ctx, getRedisKey(jobId)).Result()
+	responseJSON, ok, err := s.Results.Get(ctx, jobId)
 	if err != nil {
 		return response, err
 	}
-	err = json.Unmarshal([]byte(responseJSON), &response)
+	if !ok {
+		return response, errResultNotFound
+	}
+	err = json.Unmarshal(responseJSON, &response)
 	return response, err
 }
 
-func (s *State) prove(jobId string, proofRaw types.ProofWithPublicInputsRaw) error {
-	proofWithPis := variables.DeserializeProofWithPublicInputs(proofRaw)
-	assignment := verifierCircuit.VerifierCircuit{
-		Proof:                   proofWithPis.Proof,
-		PublicInputs:            proofWithPis.PublicInputs,
-		VerifierOnlyCircuitData: s.CircuitData.VerifierOnlyCircuitData,
-	}
-	witness, err := frontend.NewWitness(&assignment, ecc.BN254.ScalarField())
+// prove proves job and stores its result. Solving and proving are abandoned
+// when jobCtx is done; storing the result and the on-chain simulation and
+// relay are not bound by it.
+func (s *State) prove(jobCtx context.Context, job proofJob) error {
 	ctx := context.Background()
+	circuitName, data, circuitVersion := s.jobCircuit(job)
+	start := time.Now()
+	queuedAt := job.QueuedAt
+	if queuedAt.IsZero() {
+		queuedAt = start
+	}
+	witness, err := wrapper.NewWitness(data, job.Input.ProofWithPublicInputsRaw)
 	if err != nil {
-		errMsg := err.Error()
-		resp := ProofResponse{
-			Success:      false,
-			Proof:        nil,
-			ErrorMessage: &errMsg,
+		return s.failJob(ctx, job, withCode(ErrorCodeWitnessFailed, err))
+	}
+	witnessGeneration := time.Since(start)
+	if err := checkExpectedPublicInputs(job.ExpectedPublicInputs, witness.PublicInputs); err != nil {
+		return s.failJob(ctx, job, withCode(ErrorCodeInvalidInput, err))
+	}
+	if s.PreVerify {
+		start := time.Now()
+		_, err := untilDone(jobCtx, job.JobId, func() (struct{}, error) {
+			return struct{}{}, wrapper.Solve(data, witness)
+		})
+		if err != nil && jobCtx.Err() != nil {
+			return s.failJob(ctx, job, s.timeoutError(err))
 		}
-		s.setProofResponse(ctx, jobId, resp)
-		return err
+		if err != nil {
+			log.Println("Pre-verification failed. jobId", job.JobId, err)
+			return s.failJob(ctx, job, withCode(ErrorCodeInvalidInput, fmt.Errorf("plonky2 proof verification failed: %w", err)))
+		}
+		log.Println("Pre-verification done. jobId", job.JobId, "took", time.Since(start))
 	}
-	proof, err := plonk_bn254.Prove(&s.CircuitData.Ccs, &s.CircuitData.Pk, witness)
-	if err != nil {
-		errMsg := err.Error()
-		resp := ProofResponse{
-			Success:      false,
-			Proof:        nil,
-			ErrorMessage: &errMsg,
+	publicInputsStr := witness.PublicInputStrings()
+	proveLocal := func() (ProveResult, error) {
+		if s.GC != nil {
+			defer s.GC.Enter()()
 		}
-		s.setProofResponse(ctx, jobId, resp)
-		return err
+		if _, err := data.ProvingKey(); err != nil {
+			return ProveResult{}, withCode(ErrorCodeInternal, err)
+		}
+		proof, err := wrapper.ProveWitness(s.Prover, data, witness)
+		if err != nil {
+			return ProveResult{}, err
+		}
+		if s.selfVerify(circuitName) {
+			if err := wrapper.Verify(data, proof); err != nil {
+				log.Println("Self-verification failed. jobId", job.JobId, err)
+				s.selfVerificationFailed(circuitName)
+				return ProveResult{}, withCode(ErrorCodeInternal, fmt.Errorf("internal error: produced proof does not verify against the verifying key: %w", err))
+			}
+		}
+		return ProveResult{
+			PublicInputs: proof.PublicInputStrings(),
+			Proof:        proof.SolidityHex(),
+		}, nil
+	}
+	var result ProveResult
+	proveStart := time.Now()
+	// Race peers prove with the loaded circuit, not a canary.
+	if job.Race && len(s.RacePeers) > 0 && circuitName == s.LoadedCircuit() {
+		var report *RaceReport
+		verifyPeer := func(result ProveResult) error {
+			proof, err := wrapper.ParseSolidityHex(data, result.Proof, result.PublicInputs)
+			if err != nil {
+				return err
+			}
+			return wrapper.Verify(data, proof)
+		}
+		result, report, err = s.raceProve(jobCtx, job, publicInputsStr, proveLocal, verifyPeer)
+		result.Race = report
+	} else {
+		result, err = untilDone(jobCtx, job.JobId, proveLocal)
+	}
+	s.meterProve(ctx, job, time.Since(proveStart))
+	if err != nil && jobCtx.Err() != nil {
+		return s.failJob(ctx, job, s.timeoutError(err))
 	}
-	proofHex := hex.EncodeToString(proof.MarshalSolidity())
-	publicInputs, err := utils.ExtractPublicInputs(witness)
 	if err != nil {
-		errMsg := err.Error()
-		resp := ProofResponse{
-			Success:      false,
-			Proof:        nil,
-			ErrorMessage: &errMsg,
+		return s.failJob(ctx, job, withCode(ErrorCodeProveFailed, err))
+	}
+	result.ProveMs = time.Since(proveStart).Milliseconds()
+	result.Circuit, result.CircuitVersion = circuitName, circuitVersion
+	// The digest is checked against the public inputs of the proof actually
+	// produced, which a race peer with other circuit data might not share.
+	if job.ExpectedPublicInputsHash != nil {
+		proven, err := parsePublicInputs(result.PublicInputs)
+		if err == nil {
+			err = checkExpectedPublicInputsHash(job.ExpectedPublicInputsHash, proven)
 		}
-		s.setProofResponse(ctx, jobId, resp)
-		return err
+		if err != nil {
+			return s.failJob(ctx, job, withCode(ErrorCodeInvalidInput, err))
+		}
+	}
+	if s.Simulator != nil {
+		result.Simulation = s.simulate(ctx, job, result)
 	}
-	publicInputsStr := make([]string, len(publicInputs))
-	for i, bi := range publicInputs {
-		publicInputsStr[i] = bi.String()
+	if s.Relayer != nil && (result.Simulation == nil || result.Simulation.Verified) {
+		result.Relay = s.relay(ctx, job, result)
 	}
-	result := ProveResult{
-		PublicInputs: publicInputsStr,
-		Proof:        proofHex,
+	result.QueueWaitMs = start.Sub(queuedAt).Milliseconds()
+	result.WitnessGenerationMs = witnessGeneration.Milliseconds()
+	result.TotalMs = time.Since(queuedAt).Milliseconds()
+	if err := s.signResult(job.JobId, &result); err != nil {
+		return s.failJob(ctx, job, withCode(ErrorCodeInternal, err))
+	}
+	formatted, err := applyFormat(result, job.Format)
+	if err != nil {
+		return s.failJob(ctx, job, withCode(ErrorCodeInternal, err))
 	}
 	resp := ProofResponse{
 		Success: true,
-		Proof:   &result,
+		Proof:   &formatted,
+	}
+	if err := s.finishJob(ctx, job, resp); err != nil {
+		log.Printf("Failed to store proof response in Redis: %v\n", err)
+	}
+	if len(job.ResultPublicKey) > 0 {
+		// A plaintext copy in the cache would defeat the encryption.
+		log.Println("Prove done. jobId", job.JobId, "requestId", job.RequestId)
+		return nil
+	}
+	if job.Circuit != "" && job.Circuit != circuitName {
+		// InputHash is that of the unloaded canary it was routed to.
+		log.Println("Prove done. jobId", job.JobId, "requestId", job.RequestId)
+		return nil
+	}
+	cachedResult := result
+	cachedResult.Race = nil
+	cachedResult.Relay = nil
+	cachedResult.Simulation = nil
+	cachedResult.Signature = nil
+	cachedResult.QueueWaitMs, cachedResult.WitnessGenerationMs, cachedResult.ProveMs, cachedResult.TotalMs = 0, 0, 0, 0
+	if err := s.setCachedResult(ctx, job.InputHash, cachedResult); err != nil {
+		log.Printf("Failed to cache proof result in Redis: %v\n", err)
 	}
-	s.setProofResponse(ctx, jobId, resp)
-	log.Println("Prove done. jobId", jobId)
+	log.Println("Prove done. jobId", job.JobId, "requestId", job.RequestId)
 	return nil
 }
 
-func (s *State) StartProof(w http.ResponseWriter, r *http.Request) {
-	_jobId, err := uuid.NewRandom()
+type startProofRequest struct {
+	Proof      requestProof `json:"proof" openapi:"required" doc:"The plonky2 proof to wrap: the proof object, or that object serialized into a JSON string."`
+	JobId      string       `json:"jobId" openapi:"format=uuid" doc:"The job id, generated by the server if empty."`
+	WebhookURL string       `json:"webhookUrl" doc:"Called with the result once the job finishes."`
+	// ExpectedPublicInputs maps public input indices to the values the
+	// client expects the proof to expose.
+	ExpectedPublicInputs map[int]string `json:"expectedPublicInputs"`
+	Race                 bool           `json:"race"`
+	Format               string         `json:"format" openapi:"enum=calldata|blob"`
+	// Priority is "high" (default) for interactive jobs or "low" for batch
+	// jobs, which wait behind them.
+	Priority string `json:"priority" openapi:"enum=high|low"`
+	// ResultTTL is how long the result is kept once the job finishes, as a
+	// duration such as "10m", up to MaxResultTTL; ResultTTL by default.
+	ResultTTL string `json:"resultTtl"`
+	// ExpectedPublicInputsHash is the keccak256 the client expects of the
+	// proven public inputs, checked once the proof is done.
+	ExpectedPublicInputsHash string `json:"expectedPublicInputsHash"`
+	// Metadata is opaque to the server and returned with the job's result
+	// and webhook, within the bounds of validateMetadata.
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	// ResultPublicKey is an X25519 public key, in hex or base64, the result
+	// is encrypted to before it is stored.
+	ResultPublicKey string `json:"resultPublicKey,omitempty"`
+
+	NotBefore *time.Time `json:"notBefore,omitempty" doc:"The job is queued no earlier than this, up to MAX_SCHEDULE_DELAY ahead."`
+
+	Payment string `json:"payment,omitempty" doc:"The payment reference of the job, required when the server charges for proofs."`
+}
+
+// buildJob validates a start-proof request and turns it into a job,
+// returning the HTTP status to answer with if it is invalid.
+func (s *State) buildJob(rawInput startProofRequest, profile string) (proofJob, int, error) {
+	if !rawInput.Proof.Present {
+		return proofJob{}, http.StatusBadRequest, fmt.Errorf("proof is required")
+	}
+	input := rawInput.Proof.ProofWithPublicInputsRaw
+	circuitName, data := s.routeCircuit()
+	if err := validateProofInput(input, data.CommonCircuitData); err != nil {
+		return proofJob{}, http.StatusBadRequest, err
+	}
+	if err := checkPublicInputRules(input.PublicInputs, data.PublicInputRules); err != nil {
+		return proofJob{}, http.StatusBadRequest, err
+	}
+
+	expectedPublicInputs, err := parseExpectedPublicInputs(rawInput.ExpectedPublicInputs, len(input.PublicInputs))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return proofJob{}, http.StatusBadRequest, err
+	}
+
+	expectedPublicInputsHash, err := parseExpectedPublicInputsHash(rawInput.ExpectedPublicInputsHash)
+	if err != nil {
+		return proofJob{}, http.StatusBadRequest, err
+	}
+
+	if err := validateFormat(rawInput.Format); err != nil {
+		return proofJob{}, http.StatusBadRequest, err
 	}
-	jobId := _jobId.String()
 
-	var rawInput struct {
-		Proof string `json:"proof"`
+	if err := validatePriority(rawInput.Priority); err != nil {
+		return proofJob{}, http.StatusBadRequest, err
 	}
+
+	if err := validateMetadata(rawInput.Metadata); err != nil {
+		return proofJob{}, http.StatusBadRequest, err
+	}
+
+	var resultPublicKey []byte
+	if rawInput.ResultPublicKey != "" {
+		key, err := resultbox.ParsePublicKey(rawInput.ResultPublicKey)
+		if err != nil {
+			return proofJob{}, http.StatusBadRequest, fmt.Errorf("Invalid resultPublicKey: %w", err)
+		}
+		resultPublicKey = key.Bytes()
+	}
+
+	var resultTTL time.Duration
+	if rawInput.ResultTTL != "" {
+		resultTTL, err = time.ParseDuration(rawInput.ResultTTL)
+		if err != nil || resultTTL <= 0 {
+			return proofJob{}, http.StatusBadRequest, fmt.Errorf("Invalid resultTtl")
+		}
+		if resultTTL > s.MaxResultTTL {
+			return proofJob{}, http.StatusBadRequest, fmt.Errorf("resultTtl exceeds %s", s.MaxResultTTL)
+		}
+	}
+
+	var notBefore time.Time
+	if rawInput.NotBefore != nil {
+		notBefore = rawInput.NotBefore.UTC()
+		if time.Until(notBefore) > s.MaxScheduleDelay {
+			return proofJob{}, http.StatusBadRequest, fmt.Errorf("notBefore is more than %s ahead", s.MaxScheduleDelay)
+		}
+	}
+
+	if rawInput.WebhookURL != "" {
+		if s.Webhooks == nil {
+			return proofJob{}, http.StatusBadRequest, fmt.Errorf("Webhooks are not enabled")
+		}
+		if err := webhook.ValidateURL(rawInput.WebhookURL); err != nil {
+			return proofJob{}, http.StatusBadRequest, fmt.Errorf("Invalid webhookUrl: %w", err)
+		}
+	}
+
+	_, vkHash, err := serializeVk(data)
+	if err != nil {
+		return proofJob{}, http.StatusInternalServerError, err
+	}
+	inputHash, err := hashProofInput(circuitName, vkHash, input)
+	if err != nil {
+		return proofJob{}, http.StatusInternalServerError, err
+	}
+
+	jobId := rawInput.JobId
+	if jobId != "" {
+		if _, err := uuid.Parse(jobId); err != nil {
+			return proofJob{}, http.StatusBadRequest, fmt.Errorf("Invalid JobId")
+		}
+	} else {
+		_jobId, err := uuid.NewRandom()
+		if err != nil {
+			return proofJob{}, http.StatusInternalServerError, err
+		}
+		jobId = _jobId.String()
+	}
+
+	return proofJob{
+		JobId:      jobId,
+		InputHash:  inputHash,
+		Circuit:    circuitName,
+		Input:      rawInput.Proof.proofInput,
+		Race:       rawInput.Race,
+		Format:     rawInput.Format,
+		WebhookURL: rawInput.WebhookURL,
+		Profile:    profile,
+		Priority:   rawInput.Priority,
+		ResultTTL:  resultTTL,
+		NotBefore:  notBefore,
+		Payment:    rawInput.Payment,
+
+		PayloadHash: rawInput.Proof.PayloadHash,
+
+		ExpectedPublicInputs:     expectedPublicInputs,
+		ExpectedPublicInputsHash: expectedPublicInputsHash,
+		Metadata:                 rawInput.Metadata,
+
+		ResultPublicKey: resultPublicKey,
+	}, http.StatusOK, nil
+}
+
+// finishFromCache completes job with a cached result for the same input, if
+// there is one, and reports whether it did.
+func (s *State) finishFromCache(ctx context.Context, job proofJob) bool {
+	cached, err := s.getCachedResult(ctx, job.InputHash)
+	if err != nil {
+		log.Printf("Failed to read cached proof result from Redis: %v\n", err)
+	}
+	if cached == nil {
+		return false
+	}
+	if err := s.signResult(job.JobId, cached); err != nil {
+		log.Printf("Failed to sign cached proof result: %v\n", err)
+		return false
+	}
+	formatted, err := applyFormat(*cached, job.Format)
+	resp := ProofResponse{
+		Success: true,
+		Proof:   &formatted,
+	}
+	if err == nil {
+		var publicInputs []*big.Int
+		if publicInputs, err = parsePublicInputs(cached.PublicInputs); err == nil {
+			err = checkExpectedPublicInputs(job.ExpectedPublicInputs, publicInputs)
+		}
+		if err == nil {
+			err = checkExpectedPublicInputsHash(job.ExpectedPublicInputsHash, publicInputs)
+		}
+	}
+	if err != nil {
+		errMsg := err.Error()
+		resp = ProofResponse{
+			Success:      false,
+			Proof:        nil,
+			ErrorMessage: &errMsg,
+			ErrorCode:    ErrorCodeInvalidInput,
+		}
+	}
+	if err := s.finishJob(ctx, job, resp); err != nil {
+		log.Printf("Failed to store proof response in Redis: %v\n", err)
+		return false
+	}
+	return true
+}
+
+func (s *State) StartProof(w http.ResponseWriter, r *http.Request) {
+	if !s.authorize(w, r, auth.OperationStartProof) {
+		return
+	}
+	var rawInput startProofRequest
 	if err := json.NewDecoder(r.Body).Decode(&rawInput); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		apierror.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	var input types.ProofWithPublicInputsRaw
-	if err := json.Unmarshal([]byte(rawInput.Proof), &input); err != nil {
-		http.Error(w, "Failed to parse proof JSON: "+err.Error(), http.StatusBadRequest)
+	job, status, err := s.buildJob(rawInput, auth.FromContext(r.Context()).Profile)
+	var schemaErr *inputSchemaError
+	if errors.As(err, &schemaErr) {
+		apierror.WithDetails(w, err.Error(), status, schemaErr)
+		return
+	} else if err != nil {
+		apierror.Error(w, err.Error(), status)
+		return
+	}
+	job.RequestId = apierror.RequestID(r.Context())
+	job.Tenant = auth.FromContext(r.Context()).TenantName()
+	job.Owner = auth.FromContext(r.Context()).Owner()
+	job.Key = auth.FromContext(r.Context()).Name
+	job.KeyId = auth.FromContext(r.Context()).KeyId()
+	jobId := job.JobId
+	accesslog.SetJob(r.Context(), jobId)
+	if !s.admit(w, 1) {
 		return
 	}
 
-	resp := ProofResponse{
-		Success: true,
-		Proof:   nil,
+	ctx := context.Background()
+	idempotencyKey := r.Header.Get("Idempotency-Key")
+	if idempotencyKey != "" {
+		existingJobId, found, err := s.resolveIdempotencyKey(ctx, job.Tenant, idempotencyKey, jobId, job.PayloadHash)
+		if err == errIdempotencyKeyReused {
+			apierror.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		} else if err != nil {
+			log.Printf("Failed to resolve idempotency key in Redis: %v\n", err)
+			s.storeError(w, err)
+			return
+		}
+		if found {
+			if err := s.writeDuplicate(ctx, w, r, existingJobId); err == errDuplicateNotOwned {
+				// The job of the key is gone: let a retry submit it anew.
+				s.RedisClient.Del(ctx, getIdempotencyRedisKey(job.Tenant, idempotencyKey))
+				apierror.Error(w, err.Error(), http.StatusConflict)
+				return
+			} else if err != nil {
+				s.storeError(w, err)
+				return
+			}
+			accesslog.SetJob(r.Context(), existingJobId)
+			log.Println("StartProof duplicate", existingJobId)
+			return
+		}
 	}
-	if err := s.setProofResponse(context.Background(), jobId, resp); err != nil {
+
+	if !s.claimUsage(ctx, w, auth.FromContext(r.Context()), 1) {
+		if idempotencyKey != "" {
+			s.RedisClient.Del(ctx, getIdempotencyRedisKey(job.Tenant, idempotencyKey))
+		}
+		return
+	}
+	claimed, ok := s.claimQuota(ctx, w, auth.FromContext(r.Context()), []string{jobId})
+	if !ok {
+		s.releaseUsage(ctx, auth.FromContext(r.Context()), 1)
+		// Let a retry with the same key submit the job.
+		if idempotencyKey != "" {
+			s.RedisClient.Del(ctx, getIdempotencyRedisKey(job.Tenant, idempotencyKey))
+		}
+		return
+	}
+
+	if status, err := s.verifyPayment(ctx, job, auth.FromContext(r.Context())); err != nil {
+		s.releaseQuota(ctx, job.Tenant, claimed)
+		s.releaseUsage(ctx, auth.FromContext(r.Context()), 1)
+		if idempotencyKey != "" {
+			s.RedisClient.Del(ctx, getIdempotencyRedisKey(job.Tenant, idempotencyKey))
+		}
+		s.writePaymentError(w, err.Error(), status)
+		return
+	}
+
+	reserved, err := s.reserveJob(ctx, job)
+	if err != nil {
 		log.Printf("Failed to store proof response in Redis: %v\n", err)
 	}
+	if redisbreaker.Unavailable(err) {
+		// The job could be neither tracked nor deduplicated.
+		s.releaseQuota(ctx, job.Tenant, claimed)
+		s.releaseUsage(ctx, auth.FromContext(r.Context()), 1)
+		s.releasePayment(ctx, job)
+		if idempotencyKey != "" {
+			s.RedisClient.Del(ctx, getIdempotencyRedisKey(job.Tenant, idempotencyKey))
+		}
+		s.storeError(w, err)
+		return
+	}
+	if err == nil && !reserved {
+		s.releaseQuota(ctx, job.Tenant, claimed)
+		s.releaseUsage(ctx, auth.FromContext(r.Context()), 1)
+		if err := s.writeDuplicate(ctx, w, r, jobId); err != nil {
+			// A client-supplied jobId taken in another tenant's namespace.
+			s.releasePayment(ctx, job)
+			if idempotencyKey != "" {
+				s.RedisClient.Del(ctx, getIdempotencyRedisKey(job.Tenant, idempotencyKey))
+			}
+			if err == errDuplicateNotOwned {
+				apierror.Error(w, err.Error(), http.StatusConflict)
+			} else {
+				s.storeError(w, err)
+			}
+			return
+		}
+		log.Println("StartProof duplicate", jobId)
+		return
+	}
+	issued, err := s.issueReceipt(ctx, job)
+	if err != nil {
+		log.Printf("Failed to issue receipt: %v\n", err)
+		s.failJob(ctx, job, withCode(ErrorCodeInternal, fmt.Errorf("failed to issue receipt")))
+		apierror.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	s.audit(r, "start-proof", jobId, map[string]string{"circuit": job.Circuit, "inputHash": job.InputHash, "priority": job.Priority, "requestId": job.RequestId})
+
+	if deferred(job) {
+		if err := s.schedule(ctx, job); err != nil {
+			log.Printf("Failed to schedule job in Redis: %v\n", err)
+			s.failJob(ctx, job, withCode(ErrorCodeInternal, fmt.Errorf("failed to schedule job")))
+			s.storeError(w, err)
+			return
+		}
+		json.NewEncoder(w).Encode(startProofResponse{JobId: jobId, Receipt: issued})
+		log.Println("StartProof scheduled", jobId, "notBefore", job.NotBefore, "requestId", job.RequestId)
+		return
+	}
+
+	if s.finishFromCache(ctx, job) {
+		json.NewEncoder(w).Encode(startProofResponse{JobId: jobId, Receipt: issued})
+		log.Println("StartProof served from cache", jobId)
+		return
+	}
 
-	go s.prove(jobId, input)
-	json.NewEncoder(w).Encode(map[string]string{"jobId": jobId})
-	log.Println("StartProof", jobId)
+	s.submit(job)
+	json.NewEncoder(w).Encode(startProofResponse{JobId: jobId, Receipt: issued})
+	log.Println("StartProof", jobId, "requestId", job.RequestId)
 }
 
 func (s *State) GetProof(w http.ResponseWriter, r *http.Request) {
+	if !s.authorize(w, r, auth.OperationGetProof) {
+		return
+	}
 	jobId := r.URL.Query().Get("jobId")
 	log.Println("GetProof", jobId)
 	_, err := uuid.Parse(jobId)
 	if err != nil {
-		http.Error(w, "Invalid JobId", http.StatusBadRequest)
+		apierror.Error(w, "Invalid JobId", http.StatusBadRequest)
+		return
+	}
+	opts, err := parseOutputOptions(r)
+	if err != nil {
+		apierror.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 	response, err := s.getProofResponse(r.Context(), jobId)
-	if err == redis.Nil {
-		http.Error(w, "job not found", http.StatusNotFound)
+	if err == nil && !ownedBy(response.Owner, r) {
+		// Answered like an unknown job, so that job ids cannot be probed.
+		log.Println("GetProof of a job submitted by another key", jobId)
+		err = errResultNotFound
+	}
+	if err == errResultNotFound {
+		apierror.Error(w, "job not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("Failed to read result of %s: %v\n", jobId, err)
+		s.storeError(w, err)
+		return
+	}
+	if err := s.loadOffloadedResult(r.Context(), &response); err == objectstore.ErrNotFound {
+		apierror.Error(w, "job result is no longer stored", http.StatusNotFound)
 		return
 	} else if err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		log.Printf("Failed to load result of %s from the object store: %v\n", jobId, err)
+		apierror.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if response.Proof != nil && response.Proof.Encrypted != "" {
+		if opts.ProofEncoding == encodingBinary {
+			apierror.Error(w, "job result is encrypted to the submitter's key", http.StatusNotAcceptable)
+			return
+		}
+	} else if response.Proof != nil {
+		if opts.ProofEncoding == encodingBinary {
+			writeProofBytes(w, r, *response.Proof)
+			return
+		}
+		rendered, err := renderResult(*response.Proof, opts)
+		if err != nil {
+			apierror.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		response.Proof = &rendered
+	} else if response.Success {
+		s.estimateCompletion(&response, jobId)
+	}
+	if apiversion.FromContext(r.Context()) >= apiversion.V2 {
+		writeJobRecordV2(w, r, response, s.jobState(r.Context(), jobId, response))
 		return
 	}
-	json.NewEncoder(w).Encode(response)
+	writeJobRecord(w, r, response)
 }
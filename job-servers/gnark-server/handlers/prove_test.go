@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestResolveIdempotencyKey(t *testing.T) {
+	ctx := context.Background()
+	s := &State{RedisClient: newMemoryRedis(t), ResultTTL: time.Hour}
+
+	if jobId, found, err := s.resolveIdempotencyKey(ctx, "tenant", "key", "job-1", "hash-1"); err != nil || found || jobId != "job-1" {
+		t.Fatalf("first use = %s, %v, %v", jobId, found, err)
+	}
+	if jobId, found, err := s.resolveIdempotencyKey(ctx, "tenant", "key", "job-2", "hash-1"); err != nil || !found || jobId != "job-1" {
+		t.Fatalf("reuse with the same proof = %s, %v, %v", jobId, found, err)
+	}
+	if _, _, err := s.resolveIdempotencyKey(ctx, "tenant", "key", "job-3", "hash-2"); err != errIdempotencyKeyReused {
+		t.Fatalf("reuse with another proof = %v", err)
+	}
+	// Keys of other tenants are apart.
+	if _, found, err := s.resolveIdempotencyKey(ctx, "other", "key", "job-4", "hash-2"); err != nil || found {
+		t.Fatalf("use by another tenant = %v, %v", found, err)
+	}
+}
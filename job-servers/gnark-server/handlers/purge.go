@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gnark-server/apierror"
+	"gnark-server/rediskey"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+const purgeBatchSize = 500
+
+// isPending reports whether a job record belongs to a queued or running job,
+// whose result a worker has yet to store.
+func isPending(response ProofResponse) bool {
+	return response.Success && response.Proof == nil
+}
+
+// deleteJob removes every record of a finished job: result, offloaded
+// result, receipt, metadata, input and dead letter.
+func (s *State) deleteJob(ctx context.Context, jobId string) error {
+	if s.Objects != nil {
+		if err := s.Objects.Delete(ctx, s.getResultObjectKey(jobId)); err != nil {
+			return err
+		}
+	}
+	pipe := s.RedisClient.TxPipeline()
+	if err := s.deleteResult(ctx, pipe, jobId); err != nil {
+		return err
+	}
+	pipe.Del(ctx,
+		getReceiptRedisKey(jobId),
+		getJobMetaRedisKey(jobId),
+		getJobRedisKey(jobId),
+		getJobInputRedisKey(jobId),
+		getDeadLetterRedisKey(jobId),
+	)
+	pipe.ZRem(ctx, rediskey.Key(redisJobIndexKey), jobId)
+	pipe.ZRem(ctx, rediskey.Key(redisDeadLetterKey), jobId)
+	pipe.ZRem(ctx, rediskey.Key(redisScheduledJobsKey), jobId)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// DeleteJob deletes the result and records of the finished job ?jobId=.
+func (s *State) DeleteJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		apierror.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	jobId := r.URL.Query().Get("jobId")
+	if _, err := uuid.Parse(jobId); err != nil {
+		apierror.Error(w, "Invalid jobId", http.StatusBadRequest)
+		return
+	}
+	ctx := r.Context()
+	response, err := s.getProofResponse(ctx, jobId)
+	if err == errResultNotFound {
+		apierror.Error(w, "job not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		apierror.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if isPending(response) {
+		apierror.Error(w, "job is still pending", http.StatusConflict)
+		return
+	}
+	if err := s.deleteJob(ctx, jobId); err != nil {
+		log.Printf("Failed to delete job in Redis: %v\n", err)
+		apierror.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	s.audit(r, "job-delete", jobId, nil)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"deleted": jobId})
+}
+
+// PurgeJobs deletes the finished jobs submitted more than ?olderThan= ago.
+// Pending jobs are kept.
+func (s *State) PurgeJobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apierror.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	v := r.URL.Query().Get("olderThan")
+	if v == "" {
+		apierror.Error(w, "olderThan is required", http.StatusBadRequest)
+		return
+	}
+	olderThan, err := time.ParseDuration(v)
+	if err != nil || olderThan < 0 {
+		apierror.Error(w, "Invalid olderThan", http.StatusBadRequest)
+		return
+	}
+	ctx := r.Context()
+	cutoff := fmt.Sprint(time.Now().Add(-olderThan).UnixMilli())
+
+	purged, kept := 0, 0
+	for {
+		entries, err := s.RedisClient.ZRangeByScoreWithScores(ctx, rediskey.Key(redisJobIndexKey), &redis.ZRangeBy{
+			Min:    "-inf",
+			Max:    cutoff,
+			Offset: int64(kept),
+			Count:  purgeBatchSize,
+		}).Result()
+		if err != nil {
+			s.audit(r, "job-purge", "", map[string]string{"olderThan": olderThan.String(), "purged": strconv.Itoa(purged), "error": err.Error()})
+			apierror.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if len(entries) == 0 {
+			break
+		}
+		for _, entry := range entries {
+			jobId := entry.Member.(string)
+			response, err := s.getProofResponse(ctx, jobId)
+			if err == nil && isPending(response) {
+				kept++
+				continue
+			}
+			if err != nil && err != errResultNotFound {
+				s.audit(r, "job-purge", "", map[string]string{"olderThan": olderThan.String(), "purged": strconv.Itoa(purged), "error": err.Error()})
+				apierror.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			if err := s.deleteJob(ctx, jobId); err != nil {
+				s.audit(r, "job-purge", "", map[string]string{"olderThan": olderThan.String(), "purged": strconv.Itoa(purged), "error": err.Error()})
+				apierror.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			purged++
+		}
+	}
+	s.audit(r, "job-purge", "", map[string]string{"olderThan": olderThan.String(), "purged": strconv.Itoa(purged), "kept": strconv.Itoa(kept)})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"purged": purged, "pendingKept": kept})
+}
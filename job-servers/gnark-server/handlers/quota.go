@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"gnark-server/apierror"
+	"gnark-server/auth"
+	"gnark-server/rediskey"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const redisTenantPendingKeyPrefix = "tenant_pending:"
+
+func getTenantPendingRedisKey(tenant string) string {
+	return rediskey.Key(redisTenantPendingKeyPrefix) + tenant
+}
+
+// claimQuotaScript adds the jobIds of ARGV[5..] to the pending set of a
+// tenant unless that would grow it beyond ARGV[1]. Members older than the
+// result TTL can't be pending any more and are dropped first. It replies
+// {1, count, newly added jobIds...} or {0, count}.
+var claimQuotaScript = redis.NewScript(`
+local key = KEYS[1]
+redis.call('ZREMRANGEBYSCORE', key, '-inf', ARGV[3])
+local count = redis.call('ZCARD', key)
+local new = {}
+for i = 5, #ARGV do
+	if not redis.call('ZSCORE', key, ARGV[i]) then
+		table.insert(new, ARGV[i])
+	end
+end
+if count + #new > tonumber(ARGV[1]) then
+	return {0, count}
+end
+for _, jobId in ipairs(new) do
+	redis.call('ZADD', key, ARGV[2], jobId)
+end
+redis.call('PEXPIRE', key, ARGV[4])
+local reply = {1, count + #new}
+for _, jobId in ipairs(new) do
+	table.insert(reply, jobId)
+end
+return reply
+`)
+
+// PendingStore counts the pending jobs of each tenant in-process, in place
+// of the tenant_pending sorted sets and claimQuotaScript.
+type PendingStore interface {
+	// Claim adds the jobIds not pending yet to the pending jobs of tenant,
+	// unless that would make more than limit, after dropping those submitted
+	// before now minus ttl. It returns the jobIds added and the pending
+	// count.
+	Claim(tenant string, limit int, jobIds []string, now time.Time, ttl time.Duration) ([]string, int, bool)
+	Add(tenant string, jobId string, now time.Time)
+	Remove(tenant string, jobIds ...string)
+}
+
+// addPending counts a job as pending for its tenant within pipe's
+// transaction when pending jobs are counted in Redis, and right away
+// otherwise.
+func (s *State) addPending(ctx context.Context, pipe redis.Pipeliner, tenant string, jobId string, now time.Time) {
+	if s.Pending != nil {
+		s.Pending.Add(tenant, jobId, now)
+		return
+	}
+	pipe.ZAdd(ctx, getTenantPendingRedisKey(tenant), &redis.Z{Score: float64(now.UnixMilli()), Member: jobId})
+}
+
+// removePending stops counting a job as pending for its tenant within
+// pipe's transaction when pending jobs are counted in Redis, and right away
+// otherwise.
+func (s *State) removePending(ctx context.Context, pipe redis.Pipeliner, tenant string, jobId string) {
+	if s.Pending != nil {
+		s.Pending.Remove(tenant, jobId)
+		return
+	}
+	pipe.ZRem(ctx, getTenantPendingRedisKey(tenant), jobId)
+}
+
+// pendingLimit is the number of queued and running jobs identity may have.
+func (s *State) pendingLimit(identity auth.Identity) int {
+	limit := identity.MaxPendingJobs
+	if limit == 0 {
+		limit = s.MaxPendingJobsPerKey
+	}
+	if limit <= 0 {
+		return math.MaxInt
+	}
+	return limit
+}
+
+// claimQuota counts jobIds against the pending-job cap of the caller, which
+// applies to all the pending jobs of its tenant, answering 429 if they
+// exceed it. It returns the jobIds that were not counted yet, to be released
+// if they are not submitted after all. Redis errors are logged and the jobs
+// are let through.
+func (s *State) claimQuota(ctx context.Context, w http.ResponseWriter, identity auth.Identity, jobIds []string) ([]string, bool) {
+	limit := s.pendingLimit(identity)
+	if limit == math.MaxInt {
+		return nil, true
+	}
+	now := time.Now()
+	if s.Pending != nil {
+		claimed, count, ok := s.Pending.Claim(identity.TenantName(), limit, jobIds, now, s.ResultTTL)
+		if !ok {
+			s.rejectOverQuota(w, identity, int64(count), limit)
+		}
+		return claimed, ok
+	}
+	args := []interface{}{limit, now.UnixMilli(), now.Add(-s.ResultTTL).UnixMilli(), s.ResultTTL.Milliseconds()}
+	for _, jobId := range jobIds {
+		args = append(args, jobId)
+	}
+	reply, err := claimQuotaScript.Run(ctx, s.RedisClient, []string{getTenantPendingRedisKey(identity.TenantName())}, args...).Slice()
+	if err != nil || len(reply) < 2 {
+		log.Printf("Failed to check pending-job quota in Redis: %v\n", err)
+		return nil, true
+	}
+	count, _ := reply[1].(int64)
+	if ok, _ := reply[0].(int64); ok == 0 {
+		s.rejectOverQuota(w, identity, count, limit)
+		return nil, false
+	}
+	claimed := make([]string, 0, len(reply)-2)
+	for _, jobId := range reply[2:] {
+		if jobId, ok := jobId.(string); ok {
+			claimed = append(claimed, jobId)
+		}
+	}
+	return claimed, true
+}
+
+func (s *State) rejectOverQuota(w http.ResponseWriter, identity auth.Identity, count int64, limit int) {
+	log.Println("Submission rejected, tenant", identity.TenantName(), "has", count, "of", limit, "pending jobs")
+	w.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(s.QueueRetryAfter.Seconds()))))
+	apierror.WithDetails(w, "Too many pending jobs for this API key, retry later", http.StatusTooManyRequests, map[string]int64{
+		"pendingJobs":    count,
+		"maxPendingJobs": int64(limit),
+	})
+}
+
+func (s *State) releaseQuota(ctx context.Context, tenant string, jobIds []string) {
+	if len(jobIds) == 0 {
+		return
+	}
+	if s.Pending != nil {
+		s.Pending.Remove(tenant, jobIds...)
+		return
+	}
+	members := make([]interface{}, len(jobIds))
+	for i, jobId := range jobIds {
+		members[i] = jobId
+	}
+	if err := s.RedisClient.ZRem(ctx, getTenantPendingRedisKey(tenant), members...).Err(); err != nil {
+		log.Printf("Failed to release pending-job quota in Redis: %v\n", err)
+	}
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gnark-server/auth"
+	"gnark-server/memstore"
+)
+
+// testQuota claims jobs of a tenant capped at two pending jobs, as counted
+// by s, and releases them.
+func testQuota(t *testing.T, s *State) {
+	ctx := context.Background()
+	identity := auth.Identity{Name: "client", Tenant: "tenant", MaxPendingJobs: 2}
+
+	claim := func(jobIds ...string) ([]string, int) {
+		w := httptest.NewRecorder()
+		claimed, ok := s.claimQuota(ctx, w, identity, jobIds)
+		if ok != (w.Code == http.StatusOK) {
+			t.Fatalf("claim of %v answered %d and returned %v", jobIds, w.Code, ok)
+		}
+		return claimed, w.Code
+	}
+
+	if claimed, code := claim("a", "b"); code != http.StatusOK || len(claimed) != 2 {
+		t.Fatalf("claim of a and b = %v, %d", claimed, code)
+	}
+	if claimed, code := claim("a"); code != http.StatusOK || len(claimed) != 0 {
+		t.Fatalf("claim of pending a = %v, %d", claimed, code)
+	}
+	if _, code := claim("c"); code != http.StatusTooManyRequests {
+		t.Fatalf("claim over the cap answered %d", code)
+	}
+	s.releaseQuota(ctx, identity.TenantName(), []string{"a"})
+	if claimed, code := claim("c"); code != http.StatusOK || len(claimed) != 1 {
+		t.Fatalf("claim after a release = %v, %d", claimed, code)
+	}
+}
+
+func TestQuotaInRedis(t *testing.T) {
+	testQuota(t, &State{RedisClient: newMemoryRedis(t), ResultTTL: time.Hour, QueueRetryAfter: time.Second})
+}
+
+func TestQuotaInMemory(t *testing.T) {
+	testQuota(t, &State{Pending: memstore.NewPending(), ResultTTL: time.Hour, QueueRetryAfter: time.Second})
+}
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"gnark-server/apierror"
+	"gnark-server/rediskey"
+)
+
+const (
+	localBackend       = "local"
+	peerPollInterval   = 2 * time.Second
+	redisRaceCostKey   = "race_duplicated_ms"
+	peerRequestTimeout = 30 * time.Second
+)
+
+// RaceReport describes a job that was proven concurrently by several backends.
+type RaceReport struct {
+	Winner     string `json:"winner"`
+	Contenders int    `json:"contenders"`
+	WinnerMs   int64  `json:"winnerMs"`
+}
+
+type raceOutcome struct {
+	backend string
+	result  ProveResult
+	err     error
+	elapsed time.Duration
+}
+
+// raceProve runs the local prover and every configured peer in parallel and
+// returns the first successful result. A peer's result only counts if it
+// has publicInputs and passes verifyPeer, so that a peer with other circuit
+// data cannot win with a proof the verifier rejects; the race then waits
+// for the local result. Peers that lose are no longer polled;
+// the local prove cannot be interrupted, so when it loses its result is
+// discarded once it finishes. The time spent by losers is added to the
+// gnark_race_duplicated_ms counter.
+func (s *State) raceProve(jobCtx context.Context, job proofJob, publicInputs []string, local func() (ProveResult, error), verifyPeer func(ProveResult) error) (ProveResult, *RaceReport, error) {
+	ctx, cancel := context.WithCancel(jobCtx)
+	start := time.Now()
+	contenders := 1 + len(s.RacePeers)
+	outcomes := make(chan raceOutcome, contenders)
+
+	stepDone := startBackgroundStep(jobCtx)
+	go func() {
+		defer stepDone()
+		result, err := protect(local)
+		outcomes <- raceOutcome{backend: localBackend, result: result, err: err, elapsed: time.Since(start)}
+	}()
+	for _, peer := range s.RacePeers {
+		go func(peer string) {
+			result, err := s.proveOnPeer(ctx, peer, job)
+			if err == nil && !equalStrings(result.PublicInputs, publicInputs) {
+				err = fmt.Errorf("peer returned mismatching public inputs")
+			}
+			if err == nil {
+				if err = verifyPeer(result); err != nil {
+					err = fmt.Errorf("peer returned a proof that does not verify against the verifying key: %w", err)
+				}
+			}
+			outcomes <- raceOutcome{backend: peer, result: result, err: err, elapsed: time.Since(start)}
+		}(peer)
+	}
+
+	var localErr error
+	for received := 0; received < contenders; received++ {
+		var outcome raceOutcome
+		select {
+		case outcome = <-outcomes:
+		case <-jobCtx.Done():
+			cancel()
+			go s.accountRaceLosers(job.JobId, outcomes, contenders-received)
+			return ProveResult{}, nil, jobCtx.Err()
+		}
+		if outcome.err != nil {
+			log.Println("Race contender failed. jobId", job.JobId, "backend", outcome.backend, outcome.err)
+			if outcome.backend == localBackend {
+				localErr = outcome.err
+			}
+			continue
+		}
+		cancel()
+		go s.accountRaceLosers(job.JobId, outcomes, contenders-received-1)
+		report := &RaceReport{
+			Winner:     outcome.backend,
+			Contenders: contenders,
+			WinnerMs:   outcome.elapsed.Milliseconds(),
+		}
+		return outcome.result, report, nil
+	}
+	cancel()
+	if localErr == nil {
+		localErr = fmt.Errorf("all race contenders failed")
+	}
+	return ProveResult{}, nil, localErr
+}
+
+func (s *State) accountRaceLosers(jobId string, outcomes <-chan raceOutcome, remaining int) {
+	for i := 0; i < remaining; i++ {
+		outcome := <-outcomes
+		log.Println("Race loser finished. jobId", jobId, "backend", outcome.backend, "elapsed", outcome.elapsed)
+		if err := s.RedisClient.IncrBy(context.Background(), rediskey.Key(redisRaceCostKey), outcome.elapsed.Milliseconds()).Err(); err != nil {
+			log.Printf("Failed to record race cost in Redis: %v\n", err)
+		}
+	}
+}
+
+func (s *State) proveOnPeer(ctx context.Context, peer string, job proofJob) (ProveResult, error) {
+	ctx = apierror.WithRequestID(ctx, job.RequestId)
+	body, err := json.Marshal(map[string]proofInput{"proof": job.Input})
+	if err != nil {
+		return ProveResult{}, err
+	}
+	var started struct {
+		JobId string `json:"jobId"`
+	}
+	if err := s.peerRequest(ctx, http.MethodPost, strings.TrimSuffix(peer, "/")+"/start-proof", body, &started); err != nil {
+		return ProveResult{}, err
+	}
+
+	ticker := time.NewTicker(peerPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ProveResult{}, ctx.Err()
+		case <-ticker.C:
+		}
+		var response ProofResponse
+		if err := s.peerRequest(ctx, http.MethodGet, strings.TrimSuffix(peer, "/")+"/get-proof?jobId="+started.JobId, nil, &response); err != nil {
+			return ProveResult{}, err
+		}
+		if !response.Success {
+			if response.ErrorMessage != nil {
+				return ProveResult{}, fmt.Errorf("peer prove failed: %s", *response.ErrorMessage)
+			}
+			return ProveResult{}, fmt.Errorf("peer prove failed")
+		}
+		if response.Proof != nil {
+			return *response.Proof, nil
+		}
+	}
+}
+
+func (s *State) peerRequest(ctx context.Context, method string, url string, body []byte, out interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, peerRequestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.RacePeerAPIKey != "" {
+		req.Header.Set("X-API-Key", s.RacePeerAPIKey)
+	}
+	if requestId := apierror.RequestID(ctx); requestId != "" {
+		req.Header.Set(apierror.RequestIDHeader, requestId)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("peer %s returned status %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRaceProveFallsBackOnUnverifiedPeerResult(t *testing.T) {
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/start-proof" {
+			json.NewEncoder(w).Encode(map[string]string{"jobId": "peer-job"})
+			return
+		}
+		json.NewEncoder(w).Encode(ProofResponse{Success: true, Proof: &ProveResult{PublicInputs: []string{"7"}, Proof: "forged"}})
+	}))
+	defer peer.Close()
+	s := &State{RedisClient: newMemoryRedis(t), RacePeers: []string{peer.URL}}
+
+	peerChecked := make(chan struct{})
+	local := func() (ProveResult, error) {
+		<-peerChecked
+		return ProveResult{PublicInputs: []string{"7"}, Proof: "local"}, nil
+	}
+	verifyPeer := func(result ProveResult) error {
+		defer close(peerChecked)
+		return fmt.Errorf("proof %s does not verify", result.Proof)
+	}
+	result, report, err := s.raceProve(context.Background(), proofJob{JobId: "job"}, []string{"7"}, local, verifyPeer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Proof != "local" || report.Winner != localBackend {
+		t.Fatalf("won by %s with %q, want the local result", report.Winner, result.Proof)
+	}
+}
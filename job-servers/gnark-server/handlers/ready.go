@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"gnark-server/circuitData"
+)
+
+type ReadyStatus struct {
+	Ready      bool   `json:"ready" openapi:"required"`
+	Circuit    string `json:"circuit"`
+	ProvingKey string `json:"provingKey"`
+	Prover     string `json:"prover"`
+	WarmUp     string `json:"warmUp,omitempty"`
+	Draining   bool   `json:"draining,omitempty"`
+	// RedisUnavailable is set while the Redis circuit breaker is open.
+	RedisUnavailable bool `json:"redisUnavailable,omitempty"`
+}
+
+// Ready reports whether the node can take proof jobs. A proving key that is
+// loaded lazily counts as ready until its loading fails; its state is
+// reported in provingKey. When a warm-up prove is configured, the node is
+// ready once it has succeeded. It is not ready while Redis is unreachable.
+func (s *State) Ready(w http.ResponseWriter, r *http.Request) {
+	circuitName, data := s.circuit()
+	status := data.ProvingKeyStatus()
+	ready := status == circuitData.ProvingKeyLoaded || (s.LoadOptions.LazyProvingKey && status != circuitData.ProvingKeyFailed)
+	warmUp := s.warmUpState()
+	if warmUp != "" && warmUp != WarmUpDone {
+		ready = false
+	}
+	drain := s.draining()
+	if drain != nil {
+		ready = false
+	}
+	redisUnavailable := s.RedisBreaker != nil && s.RedisBreaker.Open()
+	if redisUnavailable {
+		ready = false
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(ReadyStatus{
+		Ready:      ready,
+		Circuit:    circuitName,
+		ProvingKey: status,
+		Prover:     s.Prover.Name(),
+		WarmUp:     warmUp,
+		Draining:   drain != nil,
+
+		RedisUnavailable: redisUnavailable,
+	})
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"gnark-server/receipt"
+	"gnark-server/rediskey"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const redisReceiptKeyPrefix = "receipt:"
+
+func getReceiptRedisKey(jobId string) string {
+	return rediskey.JobKey(redisReceiptKeyPrefix, jobId)
+}
+
+type startProofResponse struct {
+	JobId   string           `json:"jobId" openapi:"required,format=uuid"`
+	Receipt *receipt.Receipt `json:"receipt,omitempty"`
+}
+
+// issueReceipt issues and stores the receipt of an accepted job. It returns
+// nil when receipts are disabled.
+func (s *State) issueReceipt(ctx context.Context, job proofJob) (*receipt.Receipt, error) {
+	if s.Receipts == nil {
+		return nil, nil
+	}
+	circuitName, _, _ := s.jobCircuit(job)
+	issued, err := s.Receipts.Issue(ctx, job.JobId, circuitName, job.PayloadHash)
+	if err != nil {
+		return nil, err
+	}
+	receiptJSON, err := json.Marshal(issued)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.RedisClient.Set(ctx, getReceiptRedisKey(job.JobId), receiptJSON, s.retention(job)).Err(); err != nil {
+		return nil, err
+	}
+	return &issued, nil
+}
+
+// storedReceipt returns the receipt issued when jobId was accepted, so that
+// duplicate submissions are answered with the original receipt.
+func (s *State) storedReceipt(ctx context.Context, jobId string) (*receipt.Receipt, error) {
+	if s.Receipts == nil {
+		return nil, nil
+	}
+	receiptJSON, err := s.RedisClient.Get(ctx, getReceiptRedisKey(jobId)).Result()
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var stored receipt.Receipt
+	if err := json.Unmarshal([]byte(receiptJSON), &stored); err != nil {
+		return nil, err
+	}
+	return &stored, nil
+}
+
+// errDuplicateNotOwned is returned by writeDuplicate for a job of another
+// owner, or one whose record is gone.
+var errDuplicateNotOwned = errors.New("jobId is already in use")
+
+// writeDuplicate answers a submission of a job that was already accepted
+// with its jobId and receipt. It answers nothing and returns
+// errDuplicateNotOwned unless the caller of r owns the job, or the error
+// reading its record.
+func (s *State) writeDuplicate(ctx context.Context, w http.ResponseWriter, r *http.Request, jobId string) error {
+	existing, err := s.getProofResponse(ctx, jobId)
+	if err == errResultNotFound || (err == nil && !ownedBy(existing.Owner, r)) {
+		return errDuplicateNotOwned
+	} else if err != nil {
+		return err
+	}
+	stored, err := s.storedReceipt(ctx, jobId)
+	if err != nil {
+		log.Printf("Failed to read receipt from Redis: %v\n", err)
+	}
+	json.NewEncoder(w).Encode(startProofResponse{JobId: jobId, Receipt: stored})
+	return nil
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gnark-server/auth"
+	"gnark-server/memstore"
+)
+
+func TestWriteDuplicateChecksOwner(t *testing.T) {
+	keysFile := filepath.Join(t.TempDir(), "keys.json")
+	keys := `[{"name": "a", "key": "key-a"}, {"name": "b", "key": "key-b"}]`
+	if err := os.WriteFile(keysFile, []byte(keys), 0600); err != nil {
+		t.Fatal(err)
+	}
+	keyStore, err := auth.LoadKeyStore(keysFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	owner, _ := keyStore.Lookup("key-a")
+
+	ctx := context.Background()
+	s := &State{RedisClient: newMemoryRedis(t), Results: memstore.NewResults(), ResultTTL: time.Hour}
+	pending, _ := json.Marshal(ProofResponse{Success: true, Owner: owner.Owner()})
+	if _, err := s.Results.Reserve(ctx, "job", pending, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	duplicate := func(key string, jobId string) (*httptest.ResponseRecorder, error) {
+		var err error
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/start-proof", nil)
+		r.Header.Set("X-API-Key", key)
+		keyStore.Middleware(func(w http.ResponseWriter, r *http.Request) {
+			err = s.writeDuplicate(ctx, w, r, jobId)
+		})(w, r)
+		return w, err
+	}
+	if w, err := duplicate("key-a", "job"); err != nil || w.Body.Len() == 0 {
+		t.Fatalf("duplicate of the owner: %v, %q", err, w.Body)
+	}
+	if w, err := duplicate("key-b", "job"); err != errDuplicateNotOwned || w.Body.Len() != 0 {
+		t.Fatalf("duplicate of another key: %v, %q", err, w.Body)
+	}
+	if _, err := duplicate("key-a", "gone"); err != errDuplicateNotOwned {
+		t.Fatalf("duplicate of a missing job: %v", err)
+	}
+}
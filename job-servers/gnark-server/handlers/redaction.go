@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"gnark-server/auth"
+)
+
+type redactionProfile struct {
+	Proof        bool
+	PublicInputs bool
+	ErrorMessage bool
+}
+
+// redactionProfiles lists which ProofResponse fields each consumer profile may see.
+var redactionProfiles = map[string]redactionProfile{
+	"explorer": {PublicInputs: true},
+	"relayer":  {Proof: true, PublicInputs: true, ErrorMessage: true},
+}
+
+func ValidateRedactionProfile(profile string) error {
+	if _, ok := redactionProfiles[profile]; !ok {
+		return fmt.Errorf("unknown redaction profile %q", profile)
+	}
+	return nil
+}
+
+func redact(response ProofResponse, profile redactionProfile) ProofResponse {
+	redacted := ProofResponse{
+		Success:               response.Success,
+		Attempts:              response.Attempts,
+		QueuePosition:         response.QueuePosition,
+		EstimatedCompletionAt: response.EstimatedCompletionAt,
+		NotBefore:             response.NotBefore,
+	}
+	if profile.ErrorMessage {
+		redacted.ErrorMessage = response.ErrorMessage
+		redacted.ErrorCode = response.ErrorCode
+		redacted.ErrorStack = response.ErrorStack
+	}
+	if profile.Proof {
+		redacted.Metadata = response.Metadata
+	}
+	if response.Proof != nil && (profile.Proof || profile.PublicInputs) {
+		result := ProveResult{Circuit: response.Proof.Circuit, CircuitVersion: response.Proof.CircuitVersion}
+		if profile.Proof {
+			result.Proof = response.Proof.Proof
+			result.ProofKeccak256 = response.Proof.ProofKeccak256
+			result.ProofSHA256 = response.Proof.ProofSHA256
+			result.Calldata = response.Proof.Calldata
+			result.Blobs = response.Proof.Blobs
+			result.BlobVersionedHashes = response.Proof.BlobVersionedHashes
+			result.Race = response.Proof.Race
+			result.Relay = response.Proof.Relay
+			result.Simulation = response.Proof.Simulation
+			result.Signature = response.Proof.Signature
+			result.Encrypted = response.Proof.Encrypted
+			result.QueueWaitMs = response.Proof.QueueWaitMs
+			result.WitnessGenerationMs = response.Proof.WitnessGenerationMs
+			result.ProveMs = response.Proof.ProveMs
+			result.TotalMs = response.Proof.TotalMs
+		}
+		if profile.PublicInputs {
+			result.PublicInputs = response.Proof.PublicInputs
+		}
+		redacted.Proof = &result
+	}
+	return redacted
+}
+
+// writeJobRecord serializes a job record to the caller, applying the
+// redaction profile bound to the caller's identity.
+func writeJobRecord(w http.ResponseWriter, r *http.Request, response ProofResponse) {
+	json.NewEncoder(w).Encode(redact(response, profileOf(r)))
+}
+
+// profileOf returns the redaction profile of the caller. Unknown profiles
+// see nothing but the job status.
+func profileOf(r *http.Request) redactionProfile {
+	return redactionProfiles[auth.FromContext(r.Context()).Profile]
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// writtenJobRecord returns what writeJobRecord sends the default profile for
+// response.
+func writtenJobRecord(t *testing.T, response ProofResponse) ProofResponse {
+	t.Helper()
+	w := httptest.NewRecorder()
+	writeJobRecord(w, httptest.NewRequest(http.MethodGet, "/get-proof", nil), response)
+	var written ProofResponse
+	if err := json.NewDecoder(w.Body).Decode(&written); err != nil {
+		t.Fatal(err)
+	}
+	return written
+}
+
+func TestWriteJobRecordKeepsTimings(t *testing.T) {
+	written := writtenJobRecord(t, ProofResponse{Success: true, Proof: &ProveResult{
+		Proof:               "0x01",
+		QueueWaitMs:         1,
+		WitnessGenerationMs: 2,
+		ProveMs:             3,
+		TotalMs:             6,
+	}})
+	if written.Proof == nil {
+		t.Fatal("the result was redacted")
+	}
+	if p := written.Proof; p.QueueWaitMs != 1 || p.WitnessGenerationMs != 2 || p.ProveMs != 3 || p.TotalMs != 6 {
+		t.Fatalf("timings %+v, want those of the result", *p)
+	}
+}
+
+func TestWriteJobRecordKeepsCircuitVersion(t *testing.T) {
+	written := writtenJobRecord(t, ProofResponse{Success: true, Proof: &ProveResult{
+		Proof:          "0x01",
+		Circuit:        "withdrawal_circuit_data",
+		CircuitVersion: "v2",
+	}})
+	if written.Proof == nil || written.Proof.Circuit != "withdrawal_circuit_data" || written.Proof.CircuitVersion != "v2" {
+		t.Fatalf("result %+v, want the circuit and version it was proven with", written.Proof)
+	}
+}
+
+func TestWriteJobRecordKeepsNotBefore(t *testing.T) {
+	notBefore := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	written := writtenJobRecord(t, ProofResponse{Success: true, NotBefore: &notBefore})
+	if written.NotBefore == nil || !written.NotBefore.Equal(notBefore) {
+		t.Fatalf("notBefore %v, want %v", written.NotBefore, notBefore)
+	}
+}
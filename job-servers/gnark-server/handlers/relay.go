@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"context"
+	"encoding/hex"
+	"log"
+)
+
+type RelayReport struct {
+	From   string `json:"from"`
+	TxHash string `json:"txHash,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// relay submits the proof on-chain through the configured relayer. Failures
+// are reported in the result rather than failing the job, since the proof
+// itself is valid and can still be submitted by the client.
+func (s *State) relay(ctx context.Context, job proofJob, result ProveResult) *RelayReport {
+	report := &RelayReport{From: s.Relayer.From().Hex()}
+	proof, err := hex.DecodeString(result.Proof)
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	publicInputs, err := parsePublicInputs(result.PublicInputs)
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.RelayTimeout)
+	defer cancel()
+	txHash, err := s.Relayer.Submit(ctx, encodeProofCalldata(s.Relayer.Method, proof, publicInputs))
+	if err != nil {
+		log.Println("Relay failed. jobId", job.JobId, err)
+		report.Error = err.Error()
+		return report
+	}
+	log.Println("Relayed. jobId", job.JobId, "txHash", txHash.Hex())
+	report.TxHash = txHash.Hex()
+	return report
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"gnark-server/accesslog"
+	"gnark-server/apierror"
+	"gnark-server/auth"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+type retryJobRequest struct {
+	JobId   string `json:"jobId,omitempty" openapi:"format=uuid" doc:"jobId of the new run, the failed job's own to replace its result (default: a new random one)"`
+	Payment string `json:"payment,omitempty" doc:"A new payment reference for the run, required when the server charges for proofs; admin retries are not charged."`
+}
+
+// RetryJob serves /v<N>/jobs/<jobId>/retry, which runs a failed job of the
+// caller again from its stored input and settings.
+func (s *State) RetryJob(w http.ResponseWriter, r *http.Request) {
+	if !s.authorize(w, r, auth.OperationStartProof) {
+		return
+	}
+	jobId, action, ok := jobAction(r.URL.Path)
+	if !ok || action != "retry" {
+		apierror.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	s.retryJob(w, r, jobId, true)
+}
+
+// retryJob runs the failed job jobId again, from a fresh attempt count. Jobs
+// of other callers are answered like unknown ones when checkOwner is set,
+// and the run is paid for like a submission; admins retry any job, outside
+// of the pending-job quota and without paying.
+func (s *State) retryJob(w http.ResponseWriter, r *http.Request, jobId string, checkOwner bool) {
+	if r.Method != http.MethodPost {
+		apierror.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if _, err := uuid.Parse(jobId); err != nil {
+		apierror.Error(w, "Invalid jobId", http.StatusBadRequest)
+		return
+	}
+	var request retryJobRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil && err != io.EOF {
+		apierror.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	newJobId := request.JobId
+	if newJobId == "" {
+		newJobId = uuid.NewString()
+	} else if _, err := uuid.Parse(newJobId); err != nil {
+		apierror.Error(w, "Invalid JobId", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+	job, err := s.getStoredJob(ctx, jobId)
+	if err == nil && checkOwner && !ownedBy(job.Owner, r) {
+		err = redis.Nil
+	}
+	if err == redis.Nil {
+		apierror.Error(w, "No stored input for this job", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("Failed to read input of job %s from Redis: %v\n", jobId, err)
+		s.storeError(w, err)
+		return
+	}
+	// A job whose result expired may have succeeded: only a failed result
+	// that is still stored allows a retry.
+	response, err := s.getProofResponse(ctx, jobId)
+	if err == errResultNotFound || (err == nil && response.Success) {
+		apierror.Error(w, "Only failed jobs can be retried", http.StatusConflict)
+		return
+	} else if err != nil {
+		s.storeError(w, err)
+		return
+	}
+	previousPayment := job.Payment
+	job.JobId = newJobId
+	job.Payment = request.Payment
+	job.RequestId = apierror.RequestID(r.Context())
+	// A retry runs right away, even if the job was scheduled.
+	job.NotBefore = time.Time{}
+	accesslog.SetJob(r.Context(), newJobId)
+	if !s.admit(w, 1) {
+		return
+	}
+
+	var claimed []string
+	if checkOwner {
+		if !s.claimUsage(ctx, w, auth.FromContext(r.Context()), 1) {
+			return
+		}
+		var ok bool
+		if claimed, ok = s.claimQuota(ctx, w, auth.FromContext(r.Context()), []string{newJobId}); !ok {
+			s.releaseUsage(ctx, auth.FromContext(r.Context()), 1)
+			return
+		}
+		// The reference that paid for the failed run is claimed by its
+		// jobId, which a retry under the same jobId would pass as its own.
+		if job.Payment != "" && s.paymentReference(job.Payment) == s.paymentReference(previousPayment) {
+			s.releaseQuota(ctx, job.Tenant, claimed)
+			s.releaseUsage(ctx, auth.FromContext(r.Context()), 1)
+			s.writePaymentError(w, "payment was already used for another job", http.StatusPaymentRequired)
+			return
+		}
+		if status, err := s.verifyPayment(ctx, job, auth.FromContext(r.Context())); err != nil {
+			s.releaseQuota(ctx, job.Tenant, claimed)
+			s.releaseUsage(ctx, auth.FromContext(r.Context()), 1)
+			s.writePaymentError(w, err.Error(), status)
+			return
+		}
+	}
+	if newJobId == jobId {
+		err = s.requeueJob(ctx, job)
+	} else {
+		var reserved bool
+		if reserved, err = s.reserveJob(ctx, job); err == nil && !reserved {
+			s.releaseQuota(ctx, job.Tenant, claimed)
+			if checkOwner {
+				s.releaseUsage(ctx, auth.FromContext(r.Context()), 1)
+			}
+			s.releasePayment(ctx, job)
+			apierror.Error(w, "jobId is already in use", http.StatusConflict)
+			return
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to store retried job in Redis: %v\n", err)
+		s.releaseQuota(ctx, job.Tenant, claimed)
+		if checkOwner {
+			s.releaseUsage(ctx, auth.FromContext(r.Context()), 1)
+		}
+		s.releasePayment(ctx, job)
+		s.storeError(w, err)
+		return
+	}
+	issued, err := s.issueReceipt(ctx, job)
+	if err != nil {
+		log.Printf("Failed to issue receipt: %v\n", err)
+		s.failJob(ctx, job, withCode(ErrorCodeInternal, fmt.Errorf("failed to issue receipt")))
+		apierror.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	s.submit(job)
+	s.audit(r, "job-retry", newJobId, map[string]string{"retriedJobId": jobId, "errorCode": response.ErrorCode, "requestId": job.RequestId})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(startProofResponse{JobId: newJobId, Receipt: issued})
+	log.Println("RetryJob", jobId, "as", newJobId, "requestId", job.RequestId)
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"gnark-server/apierror"
+	"gnark-server/redisbreaker"
+)
+
+type bufferedResult struct {
+	job      proofJob
+	response ProofResponse
+	since    time.Time
+	// spooled results are also in ResultSpoolDir and kept until stored.
+	spooled bool
+}
+
+// resultBuffer holds the final results that could not be stored, so that
+// get-proof serves them from this node meanwhile and RunResultBuffer stores
+// them once Redis is back.
+type resultBuffer struct {
+	mu      sync.Mutex
+	results map[string]bufferedResult
+}
+
+// bufferResult keeps the result of job in memory, unless ResultBufferSize
+// results already are. The results of successful jobs are also spooled to
+// ResultSpoolDir when it is set, which the size does not bound.
+func (s *State) bufferResult(job proofJob, response ProofResponse) bool {
+	buffered := bufferedResult{job: job, response: response, since: time.Now()}
+	if s.ResultSpoolDir != "" && response.Success {
+		if err := s.spoolResult(job, response, buffered.since); err != nil {
+			log.Printf("Failed to spool result of job %s: %v\n", job.JobId, err)
+		} else {
+			buffered.spooled = true
+		}
+	}
+	s.buffer.mu.Lock()
+	defer s.buffer.mu.Unlock()
+	if s.buffer.results == nil {
+		s.buffer.results = make(map[string]bufferedResult)
+	}
+	if _, ok := s.buffer.results[job.JobId]; !ok && !buffered.spooled && len(s.buffer.results) >= s.ResultBufferSize {
+		return false
+	}
+	s.buffer.results[job.JobId] = buffered
+	return true
+}
+
+func (s *State) bufferedResponse(jobId string) (ProofResponse, bool) {
+	s.buffer.mu.Lock()
+	defer s.buffer.mu.Unlock()
+	buffered, ok := s.buffer.results[jobId]
+	return buffered.response, ok
+}
+
+// RunResultBuffer stores the buffered results every interval until ctx is
+// done, dropping those still not stored after ResultBufferTTL unless they
+// are spooled.
+func (s *State) RunResultBuffer(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.flushResultBuffer(ctx)
+		}
+	}
+}
+
+func (s *State) flushResultBuffer(ctx context.Context) {
+	s.buffer.mu.Lock()
+	pending := make([]bufferedResult, 0, len(s.buffer.results))
+	for _, buffered := range s.buffer.results {
+		pending = append(pending, buffered)
+	}
+	s.buffer.mu.Unlock()
+	for _, buffered := range pending {
+		err := s.storeFinal(ctx, buffered.job, buffered.response)
+		if err != nil && (buffered.spooled || time.Since(buffered.since) < s.ResultBufferTTL) {
+			continue
+		}
+		if err == nil {
+			log.Println("Stored the buffered result of job", buffered.job.JobId)
+			s.advanceDag(ctx, buffered.job.DagId)
+		} else {
+			log.Printf("Dropping the result of job %s, not stored within %v: %v\n", buffered.job.JobId, s.ResultBufferTTL, err)
+		}
+		s.buffer.mu.Lock()
+		if current, ok := s.buffer.results[buffered.job.JobId]; ok && current.since.Equal(buffered.since) {
+			delete(s.buffer.results, buffered.job.JobId)
+			if current.spooled {
+				s.unspoolResult(buffered.job.JobId)
+			}
+		}
+		s.buffer.mu.Unlock()
+	}
+}
+
+// storeError answers a request whose read or write of the job state failed:
+// with 503 and a Retry-After while Redis is unreachable, 500 otherwise.
+func (s *State) storeError(w http.ResponseWriter, err error) {
+	if !redisbreaker.Unavailable(err) {
+		apierror.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	retryAfter := time.Second
+	if s.RedisBreaker != nil && s.RedisBreaker.Cooldown > retryAfter {
+		retryAfter = s.RedisBreaker.Cooldown
+	}
+	w.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(retryAfter.Seconds()))))
+	apierror.Error(w, "Job store is unavailable, retry later", http.StatusServiceUnavailable)
+}
@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"encoding/hex"
+)
+
+// signResult signs result as the result of jobId, unless results are not
+// signed. A result served from the cache is signed again, for its own job.
+func (s *State) signResult(jobId string, result *ProveResult) error {
+	result.Signature = nil
+	if s.ResultSigner == nil {
+		return nil
+	}
+	proof, err := hex.DecodeString(result.Proof)
+	if err != nil {
+		return err
+	}
+	publicInputs, err := parsePublicInputs(result.PublicInputs)
+	if err != nil {
+		return err
+	}
+	signature, err := s.ResultSigner.Sign(jobId, proof, publicInputsHash(publicInputs))
+	if err != nil {
+		return err
+	}
+	result.Signature = &signature
+	return nil
+}
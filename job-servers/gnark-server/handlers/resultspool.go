@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const resultSpoolSuffix = ".result"
+
+// spooledResult is the file a result is spooled to, encoded like a Redis
+// record.
+type spooledResult struct {
+	Job      proofJob
+	Response ProofResponse
+	Since    time.Time
+}
+
+func (s *State) spoolPath(jobId string) string {
+	return filepath.Join(s.ResultSpoolDir, jobId+resultSpoolSuffix)
+}
+
+// spoolResult writes the result of job to ResultSpoolDir, replacing the file
+// atomically so that a crash never leaves a partial one.
+func (s *State) spoolResult(job proofJob, response ProofResponse, since time.Time) error {
+	recordJSON, err := json.Marshal(spooledResult{Job: job, Response: response, Since: since})
+	if err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(s.ResultSpoolDir, job.JobId+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	_, err = file.Write(s.encodeRecord(recordJSON))
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), s.spoolPath(job.JobId))
+}
+
+func (s *State) unspoolResult(jobId string) {
+	if err := os.Remove(s.spoolPath(jobId)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to remove spooled result of job %s: %v\n", jobId, err)
+	}
+}
+
+// LoadResultSpool buffers the results spooled to ResultSpoolDir before a
+// restart, to be stored by RunResultBuffer.
+func (s *State) LoadResultSpool() error {
+	if s.ResultSpoolDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(s.ResultSpoolDir, 0o700); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(s.ResultSpoolDir)
+	if err != nil {
+		return err
+	}
+	loaded := 0
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), resultSpoolSuffix) {
+			continue
+		}
+		path := filepath.Join(s.ResultSpoolDir, entry.Name())
+		record, err := os.ReadFile(path)
+		var spooled spooledResult
+		if err == nil {
+			record, err = decodeRecord(s.RecordKeys, record)
+		}
+		if err == nil {
+			err = json.Unmarshal(record, &spooled)
+		}
+		if err != nil {
+			// Left in place, for an operator to recover.
+			log.Printf("Failed to read spooled result %s: %v\n", path, err)
+			continue
+		}
+		s.buffer.mu.Lock()
+		if s.buffer.results == nil {
+			s.buffer.results = make(map[string]bufferedResult)
+		}
+		s.buffer.results[spooled.Job.JobId] = bufferedResult{job: spooled.Job, response: spooled.Response, since: spooled.Since, spooled: true}
+		s.buffer.mu.Unlock()
+		loaded++
+	}
+	if loaded > 0 {
+		log.Printf("Loaded %d spooled results from %s\n", loaded, s.ResultSpoolDir)
+	}
+	return nil
+}
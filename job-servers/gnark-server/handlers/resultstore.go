@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gnark-server/atrest"
+
+	"github.com/go-redis/redis/v8"
+)
+
+var errResultNotFound = errors.New("job result not found")
+
+// ResultStore keeps the job records served by get-proof: the pending record
+// of an accepted job and its final result. Records are JSON; a negative ttl
+// (redis.KeepTTL) keeps the record's current expiry.
+type ResultStore interface {
+	// Reserve stores record unless an unexpired record of jobId exists.
+	Reserve(ctx context.Context, jobId string, record []byte, ttl time.Duration) (bool, error)
+	Get(ctx context.Context, jobId string) ([]byte, bool, error)
+	Set(ctx context.Context, jobId string, record []byte, ttl time.Duration) error
+	Delete(ctx context.Context, jobId string) error
+}
+
+// redisResultStore keeps job records under rediskey.JobKey("proof_result:",
+// jobId), prefixed and, on a cluster, hash-tagged like the other keys of the
+// job, with large records compressed, and every record encrypted if keys is
+// set.
+type redisResultStore struct {
+	client      redis.UniversalClient
+	compression string
+	keys        *atrest.Keyring
+}
+
+func NewRedisResultStore(client redis.UniversalClient, compression string, keys *atrest.Keyring) ResultStore {
+	return &redisResultStore{client: client, compression: compression, keys: keys}
+}
+
+func (r *redisResultStore) encode(record []byte) []byte {
+	return sealRecord(r.keys, compressRecord(r.compression, record))
+}
+
+func (r *redisResultStore) Reserve(ctx context.Context, jobId string, record []byte, ttl time.Duration) (bool, error) {
+	return r.client.SetNX(ctx, getRedisKey(jobId), r.encode(record), ttl).Result()
+}
+
+func (r *redisResultStore) Get(ctx context.Context, jobId string) ([]byte, bool, error) {
+	record, err := r.client.Get(ctx, getRedisKey(jobId)).Bytes()
+	if err == redis.Nil {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	record, err = decodeRecord(r.keys, record)
+	return record, err == nil, err
+}
+
+func (r *redisResultStore) Set(ctx context.Context, jobId string, record []byte, ttl time.Duration) error {
+	return r.client.Set(ctx, getRedisKey(jobId), r.encode(record), ttl).Err()
+}
+
+func (r *redisResultStore) Delete(ctx context.Context, jobId string) error {
+	return r.client.Del(ctx, getRedisKey(jobId)).Err()
+}
+
+// setResult stores a job record within pipe's transaction when results are
+// kept in Redis, and right away otherwise.
+func (s *State) setResult(ctx context.Context, pipe redis.Pipeliner, jobId string, record []byte, ttl time.Duration) error {
+	if store, ok := s.Results.(*redisResultStore); ok {
+		pipe.Set(ctx, getRedisKey(jobId), store.encode(record), ttl)
+		return nil
+	}
+	return s.Results.Set(ctx, jobId, record, ttl)
+}
+
+// deleteResult deletes a job record within pipe's transaction when results
+// are kept in Redis, and right away otherwise.
+func (s *State) deleteResult(ctx context.Context, pipe redis.Pipeliner, jobId string) error {
+	if _, ok := s.Results.(*redisResultStore); ok {
+		pipe.Del(ctx, getRedisKey(jobId))
+		return nil
+	}
+	return s.Results.Delete(ctx, jobId)
+}
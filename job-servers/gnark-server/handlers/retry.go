@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"gnark-server/rediskey"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const redisJobKeyPrefix = "job:"
+
+func getJobRedisKey(jobId string) string {
+	return rediskey.JobKey(redisJobKeyPrefix, jobId)
+}
+
+// jobSpec is stored while a job is queued or running, so that the node that
+// accepted it can run it again after a restart.
+type jobSpec struct {
+	proofJob
+	Node string
+	// Waiting is set while a job of a DAG waits for its dependencies, which
+	// start it once they finished: it is not queued meanwhile.
+	Waiting bool
+}
+
+func (s *State) storeJobSpec(ctx context.Context, job proofJob) error {
+	return s.writeJobSpec(ctx, jobSpec{proofJob: job, Node: s.NodeId})
+}
+
+func (s *State) writeJobSpec(ctx context.Context, spec jobSpec) error {
+	specJSON, err := json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+	return s.RedisClient.Set(ctx, getJobRedisKey(spec.JobId), s.encodeRecord(specJSON), s.ResultTTL).Err()
+}
+
+func (s *State) getJobSpec(ctx context.Context, jobId string) (jobSpec, error) {
+	var spec jobSpec
+	record, err := s.RedisClient.Get(ctx, getJobRedisKey(jobId)).Bytes()
+	if err != nil {
+		return spec, err
+	}
+	specJSON, err := decodeRecord(s.RecordKeys, record)
+	if err != nil {
+		return spec, err
+	}
+	err = json.Unmarshal(specJSON, &spec)
+	return spec, err
+}
+
+// markAttempt records the attempt a pending job is on in its job record.
+func (s *State) markAttempt(ctx context.Context, job proofJob) error {
+	responseJSON, err := json.Marshal(ProofResponse{Success: true, Attempts: job.Attempt, Metadata: job.Metadata, Owner: job.Owner})
+	if err != nil {
+		return err
+	}
+	if err := s.Results.Set(ctx, job.JobId, responseJSON, redis.KeepTTL); err != nil {
+		return err
+	}
+	s.markJobState(ctx, job, jobStatePending, "attempts", job.Attempt)
+	return nil
+}
+
+// willRetry reports whether a job that failed with err is run again rather
+// than failed. Only timeouts and waits for memory are retried; the other
+// failures would recur.
+func (s *State) willRetry(job proofJob, err error) bool {
+	code := errorCodeOf(err)
+	return (code == ErrorCodeTimeout || code == ErrorCodeInsufficientMemory) && job.Attempt < s.MaxAttempts
+}
+
+// backoff is the delay before attempt+1, doubling with every attempt.
+func (s *State) backoff(attempt int) time.Duration {
+	return s.RetryBackoff << (attempt - 1)
+}
+
+// retry queues the next attempt of a job after the backoff delay.
+func (s *State) retry(queued queuedJob) {
+	delay := s.backoff(queued.job.Attempt)
+	queued.job.Attempt++
+	ctx := context.Background()
+	if err := s.storeJobSpec(ctx, queued.job); err != nil {
+		log.Printf("Failed to store job in Redis: %v\n", err)
+	}
+	if err := s.markAttempt(ctx, queued.job); err != nil {
+		log.Printf("Failed to store proof response in Redis: %v\n", err)
+	} else {
+		s.clearHeartbeat(queued.job.JobId)
+	}
+	log.Println("Retrying job. jobId", queued.job.JobId, "attempt", queued.job.Attempt, "in", delay)
+	time.AfterFunc(delay, func() {
+		queued.queuedAt = time.Now()
+		s.queue.push(queued)
+	})
+}
+
+// RecoverJobs reconciles the jobs this node accepted but had not finished
+// when it last stopped, for example because it was OOM-killed: queued or
+// running jobs are queued again, unless FailInterruptedJobs is set, each
+// restart counting as an attempt. Jobs out of attempts, and jobs that were
+// never queued and have no stored input, are failed with RESTARTED. A backend
+// that redelivers jobs itself keeps the others, scheduled jobs stay
+// scheduled, and the DAGs of jobs waiting for their dependencies are
+// advanced, in case a node stopped before starting them.
+func (s *State) RecoverJobs(ctx context.Context) error {
+	jobIds, err := s.RedisClient.ZRange(ctx, rediskey.Key(redisPendingJobsKey), 0, -1).Result()
+	if err != nil {
+		return err
+	}
+	recovered, failed := 0, 0
+	dagIds := make(map[string]bool)
+	for _, jobId := range jobIds {
+		spec, err := s.getJobSpec(ctx, jobId)
+		if err == redis.Nil {
+			// Only the job index tells which node accepted a job never queued.
+			node, err := s.RedisClient.HGet(ctx, getJobMetaRedisKey(jobId), "node").Result()
+			if err != nil && err != redis.Nil {
+				return err
+			}
+			if node == s.NodeId {
+				s.failJob(ctx, proofJob{JobId: jobId}, withCode(ErrorCodeRestarted, fmt.Errorf("job was not started before the node restarted")))
+				failed++
+			}
+			continue
+		} else if err != nil {
+			return err
+		}
+		if spec.Waiting {
+			dagIds[spec.DagId] = true
+			continue
+		}
+		if spec.Node != s.NodeId || s.queue.redelivers() {
+			continue
+		}
+		// RunScheduler queues the jobs still waiting for their notBefore.
+		if scheduled, err := s.isScheduled(ctx, jobId); err != nil {
+			return err
+		} else if scheduled {
+			continue
+		}
+		job := spec.proofJob
+		if s.FailInterruptedJobs {
+			s.failJob(ctx, job, withCode(ErrorCodeRestarted, fmt.Errorf("job interrupted by a node restart")))
+			failed++
+			continue
+		}
+		if job.Attempt >= s.MaxAttempts {
+			s.failJob(ctx, job, withCode(ErrorCodeRestarted, fmt.Errorf("job interrupted by node restarts after %d attempts", job.Attempt)))
+			failed++
+			continue
+		}
+		job.Attempt++
+		if err := s.storeJobSpec(ctx, job); err != nil {
+			return err
+		}
+		if err := s.markAttempt(ctx, job); err != nil {
+			return err
+		}
+		s.queue.push(queuedJob{job: job, done: make(chan error, 1), queuedAt: time.Now()})
+		recovered++
+	}
+	for dagId := range dagIds {
+		s.advanceDag(ctx, dagId)
+	}
+	log.Println("Recovered", recovered, "interrupted jobs, failed", failed)
+	return nil
+}
@@ -0,0 +1,285 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"gnark-server/apierror"
+	"gnark-server/artifacts"
+	"gnark-server/circuitData"
+	"gnark-server/rediskey"
+
+	"github.com/go-redis/redis/v8"
+)
+
+type runbookProcedure struct {
+	Description string
+	Run         func(s *State, r *http.Request) (interface{}, error)
+}
+
+// runbookProcedures bundles the recovery procedures operators run by hand
+// into single calls of POST /admin/runbook/{name}.
+var runbookProcedures = map[string]runbookProcedure{
+	"requeue-stuck-jobs": {
+		Description: "Queue again on this node the jobs pending for longer than ?olderThan= (default 1h) that no worker is running",
+		Run:         (*State).runRequeueStuckJobs,
+	},
+	"fail-stuck-jobs": {
+		Description: "Mark jobs pending for longer than ?olderThan= (default 1h) as failed",
+		Run:         (*State).runFailStuckJobs,
+	},
+	"clear-dead-letters": {
+		Description: "Delete the dead-letter entries of jobs of ?circuit=",
+		Run:         (*State).runClearDeadLetters,
+	},
+	"rotate-signing-key": {
+		Description: "Sign further receipts (?signer=receipt) or results (?signer=result) on this node with the key of the body's \"key\"",
+		Run:         (*State).runRotateSigningKey,
+	},
+	"calibrate": {
+		Description: "Prove the sample proof of the circuit, or of the resident ?circuit=, to measure its prove time and memory on this node",
+		Run:         (*State).runCalibrate,
+	},
+	"canary": {
+		Description: "Load ?circuit= as a canary circuit getting ?percent= of the jobs, change its ?percent=, or ?unload=true it",
+		Run:         (*State).runCanary,
+	},
+	"reload-circuit": {
+		Description: "Reload circuit data from disk, optionally switching to ?circuit=",
+		Run:         (*State).runReloadCircuit,
+	},
+}
+
+func (s *State) Runbook(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/admin/runbook/")
+	if r.Method == http.MethodGet && name == "" {
+		names := make([]string, 0, len(runbookProcedures))
+		for name := range runbookProcedures {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		procedures := make([]map[string]string, len(names))
+		for i, name := range names {
+			procedures[i] = map[string]string{"name": name, "description": runbookProcedures[name].Description}
+		}
+		json.NewEncoder(w).Encode(procedures)
+		return
+	}
+	if r.Method != http.MethodPost {
+		apierror.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	procedure, ok := runbookProcedures[name]
+	if !ok {
+		apierror.Error(w, "Unknown runbook procedure", http.StatusNotFound)
+		return
+	}
+
+	result, err := procedure.Run(s, r)
+	if err != nil {
+		s.audit(r, "runbook", "", map[string]string{"procedure": name, "query": r.URL.RawQuery, "error": err.Error()})
+		apierror.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.audit(r, "runbook", "", map[string]string{"procedure": name, "query": r.URL.RawQuery})
+	json.NewEncoder(w).Encode(map[string]interface{}{"procedure": name, "result": result})
+}
+
+// stuckJobs returns the jobs pending since before ?olderThan= (default 1h),
+// scheduled ones excepted, and that duration.
+func (s *State) stuckJobs(r *http.Request) ([]string, time.Duration, error) {
+	olderThan := time.Hour
+	if v := r.URL.Query().Get("olderThan"); v != "" {
+		var err error
+		if olderThan, err = time.ParseDuration(v); err != nil {
+			return nil, 0, fmt.Errorf("invalid olderThan: %w", err)
+		}
+	}
+	ctx := r.Context()
+	cutoff := time.Now().Add(-olderThan).UnixMilli()
+	jobIds, err := s.RedisClient.ZRangeByScore(ctx, rediskey.Key(redisPendingJobsKey), &redis.ZRangeBy{
+		Min: "-inf",
+		Max: fmt.Sprint(cutoff),
+	}).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+	stuck := jobIds[:0]
+	for _, jobId := range jobIds {
+		if scheduled, err := s.isScheduled(ctx, jobId); err != nil {
+			return nil, 0, err
+		} else if !scheduled {
+			stuck = append(stuck, jobId)
+		}
+	}
+	return stuck, olderThan, nil
+}
+
+// runRequeueStuckJobs queues stuck jobs again from their stored input, from
+// a fresh attempt count. Jobs with a live heartbeat are running and left
+// alone, as are DAG jobs still waiting for their dependencies, whose DAG is
+// advanced instead; those whose input is gone can only be failed.
+func (s *State) runRequeueStuckJobs(r *http.Request) (interface{}, error) {
+	jobIds, _, err := s.stuckJobs(r)
+	if err != nil {
+		return nil, err
+	}
+	ctx := r.Context()
+	requeued := make([]string, 0, len(jobIds))
+	noInput := make([]string, 0)
+	for _, jobId := range jobIds {
+		running, err := s.RedisClient.Exists(ctx, getJobHeartbeatRedisKey(jobId)).Result()
+		if err != nil {
+			return map[string]interface{}{"requeued": requeued, "noInput": noInput}, err
+		} else if running != 0 {
+			continue
+		}
+		spec, err := s.getJobSpec(ctx, jobId)
+		if err == redis.Nil {
+			noInput = append(noInput, jobId)
+			continue
+		} else if err != nil {
+			return map[string]interface{}{"requeued": requeued, "noInput": noInput}, err
+		}
+		// A DAG job waiting for its dependencies is started by its DAG.
+		if spec.Waiting {
+			s.advanceDag(context.Background(), spec.DagId)
+			continue
+		}
+		job := spec.proofJob
+		job.Attempt = 1
+		job.RequestId = apierror.RequestID(ctx)
+		// The job is pending from now on, so that it is not stuck again at once.
+		if err := s.RedisClient.ZAddXX(ctx, rediskey.Key(redisPendingJobsKey), &redis.Z{Score: float64(time.Now().UnixMilli()), Member: jobId}).Err(); err != nil {
+			return map[string]interface{}{"requeued": requeued, "noInput": noInput}, err
+		}
+		if err := s.markAttempt(ctx, job); err != nil {
+			log.Printf("Failed to store proof response in Redis: %v\n", err)
+		}
+		s.submit(job)
+		requeued = append(requeued, jobId)
+	}
+	return map[string]interface{}{"requeued": requeued, "noInput": noInput}, nil
+}
+
+func (s *State) runFailStuckJobs(r *http.Request) (interface{}, error) {
+	jobIds, olderThan, err := s.stuckJobs(r)
+	if err != nil {
+		return nil, err
+	}
+	ctx := r.Context()
+	errMsg := fmt.Sprintf("job abandoned: pending for more than %s", olderThan)
+	failed := make([]string, 0, len(jobIds))
+	for _, jobId := range jobIds {
+		resp := ProofResponse{Success: false, ErrorMessage: &errMsg, ErrorCode: ErrorCodeTimeout}
+		// The stored job, if any, carries the input into the dead-letter queue.
+		job := proofJob{JobId: jobId}
+		if spec, err := s.getJobSpec(ctx, jobId); err == nil {
+			job = spec.proofJob
+		}
+		if err := s.finishJob(context.Background(), job, resp); err != nil {
+			return map[string]interface{}{"failed": failed}, err
+		}
+		failed = append(failed, jobId)
+	}
+	return map[string]interface{}{"failed": failed}, nil
+}
+
+// runClearDeadLetters deletes the dead letters of jobs routed to ?circuit=,
+// typically once a broken circuit was replaced and its failures will not be
+// requeued.
+func (s *State) runClearDeadLetters(r *http.Request) (interface{}, error) {
+	circuitName := r.URL.Query().Get("circuit")
+	if circuitName == "" {
+		return nil, fmt.Errorf("circuit is required")
+	}
+	ctx := r.Context()
+	jobIds, err := s.RedisClient.ZRange(ctx, rediskey.Key(redisDeadLetterKey), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	cleared := make([]string, 0)
+	for _, jobId := range jobIds {
+		record, err := s.getDeadLetter(ctx, jobId)
+		if err == redis.Nil {
+			continue
+		} else if err != nil {
+			return map[string]interface{}{"cleared": cleared}, err
+		}
+		if record.Job.Circuit != circuitName {
+			continue
+		}
+		pipe := s.RedisClient.TxPipeline()
+		pipe.Del(ctx, getDeadLetterRedisKey(jobId))
+		pipe.ZRem(ctx, rediskey.Key(redisDeadLetterKey), jobId)
+		if _, err := pipe.Exec(ctx); err != nil {
+			return map[string]interface{}{"cleared": cleared}, err
+		}
+		cleared = append(cleared, jobId)
+	}
+	return map[string]interface{}{"cleared": cleared}, nil
+}
+
+// runRotateSigningKey switches the key of a signer of this node. The key is
+// read from the body rather than the query, which is audited; other nodes
+// and restarts keep their configured key until it is changed there too.
+func (s *State) runRotateSigningKey(r *http.Request) (interface{}, error) {
+	var request struct {
+		Key string `json:"key"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Key == "" {
+		return nil, fmt.Errorf("the body must be {\"key\": \"<hex-encoded key>\"}")
+	}
+	switch signer := r.URL.Query().Get("signer"); signer {
+	case "receipt":
+		if s.Receipts == nil {
+			return nil, fmt.Errorf("receipts are not enabled")
+		}
+		keyId, err := s.Receipts.Rotate(request.Key)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"signer": signer, "keyId": keyId}, nil
+	case "result":
+		if s.ResultSigner == nil {
+			return nil, fmt.Errorf("result signing is not enabled")
+		}
+		address, err := s.ResultSigner.Rotate(request.Key)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"signer": signer, "address": address.Hex()}, nil
+	default:
+		return nil, fmt.Errorf("signer must be receipt or result")
+	}
+}
+
+func (s *State) runReloadCircuit(r *http.Request) (interface{}, error) {
+	circuitName, _ := s.circuit()
+	if v := r.URL.Query().Get("circuit"); v != "" {
+		if strings.ContainsAny(v, "/\\") || strings.HasPrefix(v, ".") {
+			return nil, fmt.Errorf("invalid circuit name")
+		}
+		circuitName = v
+	}
+	start := time.Now()
+	manifest, err := artifacts.CheckLocal("data", circuitName, s.RequireArtifactManifest)
+	if err != nil {
+		return nil, err
+	}
+	data, err := circuitData.LoadCircuitData(circuitName, s.LoadOptions)
+	if err != nil {
+		return nil, err
+	}
+	s.setCircuit(circuitName, &data, manifest)
+	if err := s.RecordVkRotation(context.Background()); err != nil {
+		log.Printf("Failed to record vk rotation: %v\n", err)
+	}
+	return map[string]interface{}{"circuit": circuitName, "loadMs": time.Since(start).Milliseconds()}, nil
+}
@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gnark-server/rediskey"
+)
+
+func TestClearDeadLettersOfCircuit(t *testing.T) {
+	ctx := context.Background()
+	s := &State{RedisClient: newMemoryRedis(t), DeadLetterTTL: time.Hour}
+	for jobId, circuit := range map[string]string{"a": "broken", "b": "other", "c": "broken"} {
+		pipe := s.RedisClient.TxPipeline()
+		if err := s.queueDeadLetter(ctx, pipe, proofJob{JobId: jobId, Circuit: circuit}, ProofResponse{ErrorCode: ErrorCodeInternal}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/admin/runbook/clear-dead-letters?circuit=broken", nil)
+	result, err := s.runClearDeadLetters(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cleared := result.(map[string]interface{})["cleared"].([]string); len(cleared) != 2 {
+		t.Fatalf("cleared %v, want the two jobs of the circuit", cleared)
+	}
+	if _, err := s.getDeadLetter(ctx, "b"); err != nil {
+		t.Fatalf("dead letter of another circuit: %v", err)
+	}
+	if remaining, _ := s.RedisClient.ZRange(ctx, rediskey.Key(redisDeadLetterKey), 0, -1).Result(); len(remaining) != 1 || remaining[0] != "b" {
+		t.Fatalf("dead-letter index %v, want [b]", remaining)
+	}
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"gnark-server/rediskey"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// redisScheduledJobsKey holds the jobs submitted with a notBefore still in
+// the future, scored by it in unix milliseconds.
+const redisScheduledJobsKey = "scheduled_jobs"
+
+// deferred reports whether job is not due yet.
+func deferred(job proofJob) bool {
+	return !job.NotBefore.IsZero() && time.Now().Before(job.NotBefore)
+}
+
+// schedule stores job to be queued by RunScheduler once it is due, on
+// whichever node picks it up first.
+func (s *State) schedule(ctx context.Context, job proofJob) error {
+	job.Attempt = 1
+	if err := s.storeJobSpec(ctx, job); err != nil {
+		return err
+	}
+	return s.RedisClient.ZAdd(ctx, rediskey.Key(redisScheduledJobsKey), &redis.Z{Score: float64(job.NotBefore.UnixMilli()), Member: job.JobId}).Err()
+}
+
+func (s *State) isScheduled(ctx context.Context, jobId string) (bool, error) {
+	err := s.RedisClient.ZScore(ctx, rediskey.Key(redisScheduledJobsKey), jobId).Err()
+	if err == redis.Nil {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// RunScheduler queues the scheduled jobs that are due every interval until
+// ctx is done.
+func (s *State) RunScheduler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if s.draining() != nil {
+				continue
+			}
+			if err := s.submitDue(ctx); err != nil {
+				log.Printf("Failed to queue scheduled jobs: %v\n", err)
+			}
+		}
+	}
+}
+
+func (s *State) submitDue(ctx context.Context) error {
+	key := rediskey.Key(redisScheduledJobsKey)
+	now := time.Now()
+	jobIds, err := s.RedisClient.ZRangeByScore(ctx, key, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: fmt.Sprint(now.UnixMilli()),
+	}).Result()
+	if err != nil {
+		return err
+	}
+	for _, jobId := range jobIds {
+		// Removing the job claims it, so that one node only queues it.
+		removed, err := s.RedisClient.ZRem(ctx, key, jobId).Result()
+		if err != nil {
+			return err
+		}
+		if removed == 0 {
+			continue
+		}
+		spec, err := s.getJobSpec(ctx, jobId)
+		if err == redis.Nil {
+			log.Println("Scheduled job expired before it was due. jobId", jobId)
+			continue
+		} else if err != nil {
+			s.RedisClient.ZAdd(ctx, key, &redis.Z{Score: float64(now.UnixMilli()), Member: jobId})
+			return err
+		}
+		job := spec.proofJob
+		if s.finishFromCache(ctx, job) {
+			log.Println("Scheduled job served from cache", jobId)
+			continue
+		}
+		log.Println("Scheduled job due. jobId", jobId, "notBefore", job.NotBefore)
+		s.submit(job)
+	}
+	return nil
+}
@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"fmt"
+	"math/big"
+
+	"gnark-server/pubinputs"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/qope/gnark-plonky2-verifier/types"
+)
+
+// goldilocksModulus is the order of the field plonky2 proofs are over.
+const goldilocksModulus = 18446744069414584321
+
+// maxSchemaErrors bounds the field errors reported for one input.
+const maxSchemaErrors = 20
+
+// fieldError reports one invalid field of a proof input, by its JSON path.
+type fieldError struct {
+	Field string `json:"field"`
+	Error string `json:"error"`
+}
+
+// inputSchemaError lists the problems of a structurally invalid proof input,
+// returned as the details of the 400 answer.
+type inputSchemaError struct {
+	Errors []fieldError `json:"errors"`
+}
+
+func (e *inputSchemaError) Error() string {
+	msg := "Invalid proof: " + e.Errors[0].Field + ": " + e.Errors[0].Error
+	if len(e.Errors) > 1 {
+		msg += fmt.Sprintf(" (and %d more)", len(e.Errors)-1)
+	}
+	return msg
+}
+
+type schemaChecker struct {
+	errors []fieldError
+}
+
+func (c *schemaChecker) fail(field string, format string, args ...interface{}) {
+	if len(c.errors) < maxSchemaErrors {
+		c.errors = append(c.errors, fieldError{Field: field, Error: fmt.Sprintf(format, args...)})
+	}
+}
+
+// length checks that a list has the expected length, unless expected is
+// negative (not known without the circuit's common data).
+func (c *schemaChecker) length(field string, n int, expected int) bool {
+	if expected >= 0 && n != expected {
+		c.fail(field, "expected %d elements, got %d", expected, n)
+		return false
+	}
+	return true
+}
+
+func (c *schemaChecker) elements(field string, values []uint64) {
+	for i, v := range values {
+		if v >= goldilocksModulus {
+			c.fail(fmt.Sprintf("%s[%d]", field, i), "%d is not a Goldilocks field element", v)
+		}
+	}
+}
+
+// extensions checks a list of degree 2 extension field elements.
+func (c *schemaChecker) extensions(field string, values [][]uint64, expected int) {
+	if !c.length(field, len(values), expected) {
+		return
+	}
+	for i, v := range values {
+		name := fmt.Sprintf("%s[%d]", field, i)
+		if c.length(name, len(v), 2) {
+			c.elements(name, v)
+		}
+	}
+}
+
+// hashes checks a list of BN254 Poseidon hashes in decimal.
+func (c *schemaChecker) hashes(field string, values []string, expected int) {
+	if !c.length(field, len(values), expected) {
+		return
+	}
+	modulus := ecc.BN254.ScalarField()
+	for i, v := range values {
+		n, ok := new(big.Int).SetString(v, 10)
+		if !ok || n.Sign() < 0 || n.Cmp(modulus) >= 0 {
+			c.fail(fmt.Sprintf("%s[%d]", field, i), "%q is not a decimal BN254 field element", v)
+		}
+	}
+}
+
+// checkPresence checks that the lists of input are present, as decoding
+// leaves those absent or null nil and an empty list would not be rejected
+// otherwise. Objects are checked through their lists; pow_witness, a number,
+// is left to the verifier.
+func checkPresence(c *schemaChecker, input types.ProofWithPublicInputsRaw) {
+	proof := input.Proof
+	required := []struct {
+		field   string
+		present bool
+	}{
+		{"proof.wires_cap", proof.WiresCap != nil},
+		{"proof.plonk_zs_partial_products_cap", proof.PlonkZsPartialProductsCap != nil},
+		{"proof.quotient_polys_cap", proof.QuotientPolysCap != nil},
+		{"proof.openings.constants", proof.Openings.Constants != nil},
+		{"proof.openings.plonk_sigmas", proof.Openings.PlonkSigmas != nil},
+		{"proof.openings.wires", proof.Openings.Wires != nil},
+		{"proof.openings.plonk_zs", proof.Openings.PlonkZs != nil},
+		{"proof.openings.plonk_zs_next", proof.Openings.PlonkZsNext != nil},
+		{"proof.openings.partial_products", proof.Openings.PartialProducts != nil},
+		{"proof.openings.quotient_polys", proof.Openings.QuotientPolys != nil},
+		{"proof.opening_proof.commit_phase_merkle_caps", proof.OpeningProof.CommitPhaseMerkleCaps != nil},
+		{"proof.opening_proof.query_round_proofs", proof.OpeningProof.QueryRoundProofs != nil},
+		{"proof.opening_proof.final_poly.coeffs", proof.OpeningProof.FinalPoly.Coeffs != nil},
+		{"public_inputs", input.PublicInputs != nil},
+	}
+	for _, r := range required {
+		if !r.present {
+			c.fail(r.field, "is required")
+		}
+	}
+}
+
+// validateProofInput checks the structure of a parsed proof input: required
+// fields, field element ranges and, when the common data of the circuit is
+// known, every list length, so that malformed inputs are rejected before a
+// job is queued rather than when its witness is built.
+func validateProofInput(input types.ProofWithPublicInputsRaw, common *types.CommonCircuitDataRaw) error {
+	c := &schemaChecker{}
+	checkPresence(c, input)
+	if len(c.errors) > 0 {
+		return &inputSchemaError{Errors: c.errors}
+	}
+
+	// Expected lengths, or -1 when unknown.
+	capLen, numConstants, numRoutedWires, numWires, numChallenges := -1, -1, -1, -1, -1
+	numPartialProducts, quotientDegree, numQueryRounds, numPublicInputs := -1, -1, -1, -1
+	merkleDepth, finalPolyLen := -1, -1
+	var arityBits []uint64
+	if common != nil {
+		capLen = 1 << common.Config.FriConfig.CapHeight
+		numConstants = int(common.NumConstants)
+		numRoutedWires = int(common.Config.NumRoutedWires)
+		numWires = int(common.Config.NumWires)
+		numChallenges = int(common.Config.NumChallenges)
+		numPartialProducts = numChallenges * int(common.NumPartialProducts)
+		quotientDegree = numChallenges * int(common.QuotientDegreeFactor)
+		numQueryRounds = int(common.Config.FriConfig.NumQueryRounds)
+		numPublicInputs = int(common.NumPublicInputs)
+		arityBits = common.FriParams.ReductionArityBits
+		merkleDepth = int(common.FriParams.DegreeBits + common.Config.FriConfig.RateBits - common.Config.FriConfig.CapHeight)
+		finalPolyBits := int(common.FriParams.DegreeBits)
+		for _, bits := range arityBits {
+			finalPolyBits -= int(bits)
+		}
+		finalPolyLen = 1 << finalPolyBits
+	}
+	proof := input.Proof
+	c.hashes("proof.wires_cap", proof.WiresCap, capLen)
+	c.hashes("proof.plonk_zs_partial_products_cap", proof.PlonkZsPartialProductsCap, capLen)
+	c.hashes("proof.quotient_polys_cap", proof.QuotientPolysCap, capLen)
+
+	openings := proof.Openings
+	c.extensions("proof.openings.constants", openings.Constants, numConstants)
+	c.extensions("proof.openings.plonk_sigmas", openings.PlonkSigmas, numRoutedWires)
+	c.extensions("proof.openings.wires", openings.Wires, numWires)
+	c.extensions("proof.openings.plonk_zs", openings.PlonkZs, numChallenges)
+	c.extensions("proof.openings.plonk_zs_next", openings.PlonkZsNext, numChallenges)
+	c.extensions("proof.openings.partial_products", openings.PartialProducts, numPartialProducts)
+	c.extensions("proof.openings.quotient_polys", openings.QuotientPolys, quotientDegree)
+
+	openingProof := proof.OpeningProof
+	numSteps := -1
+	if common != nil {
+		numSteps = len(arityBits)
+	}
+	if c.length("proof.opening_proof.commit_phase_merkle_caps", len(openingProof.CommitPhaseMerkleCaps), numSteps) {
+		for i, merkleCap := range openingProof.CommitPhaseMerkleCaps {
+			c.hashes(fmt.Sprintf("proof.opening_proof.commit_phase_merkle_caps[%d]", i), merkleCap, capLen)
+		}
+	}
+	// The initial trees are the constants and sigmas, the wires, the zs and
+	// partial products, and the quotient polynomials. Leaves of hiding
+	// proofs are salted.
+	leafSizes := []int{-1, -1, -1, -1}
+	if common != nil && !common.FriParams.Hiding {
+		leafSizes = []int{numConstants + numRoutedWires, numWires, numChallenges + numPartialProducts, quotientDegree}
+	}
+	if c.length("proof.opening_proof.query_round_proofs", len(openingProof.QueryRoundProofs), numQueryRounds) {
+		for i, round := range openingProof.QueryRoundProofs {
+			name := fmt.Sprintf("proof.opening_proof.query_round_proofs[%d]", i)
+			evalsProofs := round.InitialTreesProof.EvalsProofs
+			if c.length(name+".initial_trees_proof.evals_proofs", len(evalsProofs), len(leafSizes)) {
+				for j, evalsProof := range evalsProofs {
+					tree := fmt.Sprintf("%s.initial_trees_proof.evals_proofs[%d]", name, j)
+					if c.length(tree+"[0]", len(evalsProof.LeafElements), leafSizes[j]) {
+						c.elements(tree+"[0]", evalsProof.LeafElements)
+					}
+					c.hashes(tree+"[1].siblings", evalsProof.MerkleProof.Hash, merkleDepth)
+				}
+			}
+			if !c.length(name+".steps", len(round.Steps), numSteps) {
+				continue
+			}
+			depth := merkleDepth
+			for j, step := range round.Steps {
+				stepName := fmt.Sprintf("%s.steps[%d]", name, j)
+				evals := -1
+				if common != nil {
+					evals = 1 << arityBits[j]
+					depth -= int(arityBits[j])
+				}
+				c.extensions(stepName+".evals", step.Evals, evals)
+				c.hashes(stepName+".merkle_proof.siblings", step.MerkleProof.Siblings, depth)
+			}
+		}
+	}
+	c.extensions("proof.opening_proof.final_poly.coeffs", openingProof.FinalPoly.Coeffs, finalPolyLen)
+	if openingProof.PowWitness >= goldilocksModulus {
+		c.fail("proof.opening_proof.pow_witness", "%d is not a Goldilocks field element", openingProof.PowWitness)
+	}
+
+	if c.length("public_inputs", len(input.PublicInputs), numPublicInputs) {
+		c.elements("public_inputs", input.PublicInputs)
+	}
+	if len(c.errors) > 0 {
+		return &inputSchemaError{Errors: c.errors}
+	}
+	return nil
+}
+
+// checkPublicInputRules rejects public inputs breaking the rules declared by
+// the circuit, reported like schema errors.
+func checkPublicInputRules(publicInputs []uint64, rules *pubinputs.Rules) error {
+	if rules == nil {
+		return nil
+	}
+	violations := rules.Check(publicInputs)
+	if len(violations) == 0 {
+		return nil
+	}
+	c := &schemaChecker{}
+	for _, violation := range violations {
+		field := "public_inputs"
+		if violation.Index >= 0 {
+			field = fmt.Sprintf("public_inputs[%d]", violation.Index)
+		}
+		c.fail(field, "%s", violation.Error)
+	}
+	return &inputSchemaError{Errors: c.errors}
+}
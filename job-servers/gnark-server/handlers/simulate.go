@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"context"
+	"encoding/hex"
+	"log"
+)
+
+type SimulationReport struct {
+	Contract    string `json:"contract"`
+	Verified    bool   `json:"verified"`
+	GasEstimate uint64 `json:"gasEstimate,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// simulate checks the proof against the deployed verifier with eth_call, so a
+// mismatch between the loaded circuit and the on-chain contract shows up in
+// the result before anything is broadcast.
+func (s *State) simulate(ctx context.Context, job proofJob, result ProveResult) *SimulationReport {
+	report := &SimulationReport{Contract: s.Simulator.Contract().Hex()}
+	proof, err := hex.DecodeString(result.Proof)
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	publicInputs, err := parsePublicInputs(result.PublicInputs)
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.RelayTimeout)
+	defer cancel()
+	verified, gas, err := s.Simulator.Simulate(ctx, encodeProofCalldata(verifierFunction, proof, publicInputs))
+	report.Verified = verified
+	report.GasEstimate = gas
+	if err != nil {
+		report.Error = err.Error()
+	}
+	if !verified {
+		log.Println("On-chain verification simulation failed. jobId", job.JobId, report.Error)
+	}
+	return report
+}
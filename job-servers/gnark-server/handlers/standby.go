@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"gnark-server/apierror"
+	"gnark-server/artifacts"
+	"gnark-server/circuitData"
+)
+
+// standbyCircuit is circuit data kept resident next to the active circuit,
+// to serve new jobs as soon as it is flipped in.
+type standbyCircuit struct {
+	Name     string
+	Data     *circuitData.CircuitData
+	Manifest artifacts.Manifest
+}
+
+// circuitFlip is the last flip, which is rolled back if a proof of the
+// flipped-in circuit fails self-verification before probationUntil.
+type circuitFlip struct {
+	At             time.Time
+	From           string
+	To             string
+	probationUntil time.Time
+}
+
+type CircuitSlots struct {
+	Active         string     `json:"active"`
+	ActiveVersion  string     `json:"activeVersion,omitempty"`
+	Standby        string     `json:"standby,omitempty"`
+	StandbyVersion string     `json:"standbyVersion,omitempty"`
+	FlippedAt      *time.Time `json:"flippedAt,omitempty"`
+	ProbationUntil *time.Time `json:"probationUntil,omitempty"`
+}
+
+// LoadStandby loads circuitName, whose artifacts are checked like those of
+// the active circuit, as the standby, replacing the previous one.
+func (s *State) LoadStandby(circuitName string) error {
+	if active, _ := s.circuit(); circuitName == active {
+		return fmt.Errorf("the standby must be another circuit than the active one")
+	}
+	manifest, err := artifacts.CheckLocal("data", circuitName, s.RequireArtifactManifest)
+	if err != nil {
+		return err
+	}
+	data, err := circuitData.LoadCircuitData(circuitName, s.LoadOptions)
+	if err != nil {
+		return err
+	}
+	s.circuitMu.Lock()
+	s.standby = &standbyCircuit{Name: circuitName, Data: &data, Manifest: manifest}
+	s.circuitMu.Unlock()
+	log.Printf("Loaded standby circuit %s (version %q)\n", circuitName, manifest.Version)
+	return nil
+}
+
+// swapStandby makes the standby the active circuit and the active circuit
+// the standby, so that it can be flipped back at once.
+func (s *State) swapStandby(expectedStandby string, probation time.Duration) (circuitFlip, error) {
+	s.circuitMu.Lock()
+	defer s.circuitMu.Unlock()
+	if s.standby == nil || s.standby.Name != expectedStandby {
+		return circuitFlip{}, fmt.Errorf("the standby circuit changed during the flip")
+	}
+	previous := standbyCircuit{Name: s.CircuitName, Data: s.CircuitData, Manifest: s.CircuitManifest}
+	s.CircuitName, s.CircuitData, s.CircuitManifest = s.standby.Name, s.standby.Data, s.standby.Manifest
+	s.standby = &previous
+	if s.canary != nil && s.canary.Name == s.CircuitName {
+		s.canary = nil
+	}
+	now := time.Now().UTC()
+	s.flip = &circuitFlip{At: now, From: previous.Name, To: s.CircuitName, probationUntil: now.Add(probation)}
+	return *s.flip, nil
+}
+
+// selfVerificationFailed flips back to the previous circuit when circuitName
+// was flipped in and is still on probation.
+func (s *State) selfVerificationFailed(circuitName string) {
+	s.circuitMu.RLock()
+	flip := s.flip
+	s.circuitMu.RUnlock()
+	if flip == nil || flip.To != circuitName || time.Now().After(flip.probationUntil) {
+		return
+	}
+	if _, err := s.swapStandby(flip.From, 0); err != nil {
+		log.Printf("ALERT circuit %s failed self-verification after a flip and could not be rolled back: %v\n", circuitName, err)
+		return
+	}
+	log.Printf("ALERT circuit %s failed self-verification after a flip, rolled back to %s\n", circuitName, flip.From)
+	if err := s.RecordVkRotation(context.Background()); err != nil {
+		log.Printf("Failed to record vk rotation: %v\n", err)
+	}
+}
+
+func (s *State) circuitSlots() CircuitSlots {
+	s.circuitMu.RLock()
+	defer s.circuitMu.RUnlock()
+	slots := CircuitSlots{Active: s.CircuitName}
+	if s.CircuitManifest.Circuit == s.CircuitName {
+		slots.ActiveVersion = s.CircuitManifest.Version
+	}
+	if s.standby != nil {
+		slots.Standby, slots.StandbyVersion = s.standby.Name, s.standby.Manifest.Version
+	}
+	if s.flip != nil {
+		at := s.flip.At
+		slots.FlippedAt = &at
+		if time.Now().Before(s.flip.probationUntil) {
+			until := s.flip.probationUntil
+			slots.ProbationUntil = &until
+		}
+	}
+	return slots
+}
+
+// CircuitAdmin serves the blue/green circuit slots: GET /admin/circuit
+// reports them, POST /admin/circuit/standby?circuit= loads the standby,
+// DELETE /admin/circuit/standby unloads it and POST /admin/circuit/flip
+// makes it the active circuit.
+func (s *State) CircuitAdmin(w http.ResponseWriter, r *http.Request) {
+	switch action := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/admin/circuit"), "/"); {
+	case action == "" && r.Method == http.MethodGet:
+		json.NewEncoder(w).Encode(s.circuitSlots())
+	case action == "/standby" && r.Method == http.MethodPost:
+		s.loadStandby(w, r)
+	case action == "/standby" && r.Method == http.MethodDelete:
+		s.circuitMu.Lock()
+		s.standby = nil
+		s.circuitMu.Unlock()
+		s.audit(r, "unload-standby", "", nil)
+		json.NewEncoder(w).Encode(s.circuitSlots())
+	case action == "/flip" && r.Method == http.MethodPost:
+		s.flipCircuit(w, r)
+	case action == "" || action == "/standby" || action == "/flip":
+		apierror.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	default:
+		apierror.Error(w, "Not found", http.StatusNotFound)
+	}
+}
+
+func (s *State) loadStandby(w http.ResponseWriter, r *http.Request) {
+	circuitName := r.URL.Query().Get("circuit")
+	if circuitName == "" || strings.ContainsAny(circuitName, "/\\") || strings.HasPrefix(circuitName, ".") {
+		apierror.Error(w, "invalid circuit name", http.StatusBadRequest)
+		return
+	}
+	start := time.Now()
+	if err := s.LoadStandby(circuitName); err != nil {
+		s.audit(r, "load-standby", "", map[string]string{"circuit": circuitName, "error": err.Error()})
+		apierror.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.audit(r, "load-standby", "", map[string]string{"circuit": circuitName})
+	json.NewEncoder(w).Encode(map[string]interface{}{"circuits": s.circuitSlots(), "loadMs": time.Since(start).Milliseconds()})
+}
+
+// flipCircuit proves the bundled sample proof with the standby, unless
+// ?verify=false, and makes it the active circuit if the proof verifies.
+func (s *State) flipCircuit(w http.ResponseWriter, r *http.Request) {
+	s.circuitMu.RLock()
+	standby := s.standby
+	s.circuitMu.RUnlock()
+	if standby == nil {
+		apierror.Error(w, "no standby circuit is loaded", http.StatusConflict)
+		return
+	}
+	details := map[string]string{"circuit": standby.Name}
+	if r.URL.Query().Get("verify") != "false" {
+		release, err := s.admitMemory(proofJob{JobId: "flip"})
+		if err != nil {
+			apierror.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		calibration, err := protect(func() (Calibration, error) {
+			return s.calibrateCircuit(standby.Name, standby.Data, "")
+		})
+		release()
+		if err != nil {
+			details["error"] = err.Error()
+			s.audit(r, "flip-circuit", "", details)
+			log.Printf("Standby circuit %s failed its check, not flipped: %v\n", standby.Name, err)
+			apierror.Error(w, "standby circuit failed its check: "+err.Error(), http.StatusConflict)
+			return
+		}
+		s.setCalibration(calibration)
+	}
+	flip, err := s.swapStandby(standby.Name, s.FlipProbation)
+	if err != nil {
+		apierror.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err := s.RecordVkRotation(context.Background()); err != nil {
+		log.Printf("Failed to record vk rotation: %v\n", err)
+	}
+	details["from"] = flip.From
+	s.audit(r, "flip-circuit", "", details)
+	log.Printf("Flipped the active circuit from %s to %s\n", flip.From, flip.To)
+	json.NewEncoder(w).Encode(s.circuitSlots())
+}
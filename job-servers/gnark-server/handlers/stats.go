@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"gnark-server/apierror"
+	"gnark-server/rediskey"
+	"gnark-server/slo"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// redisStatsKey holds the fleet-wide job counters, incremented in the same
+// transactions that accept and finish jobs. Each tenant has the same
+// counters under redisTenantStatsKeyPrefix, and redisStatsTenantsKey holds
+// the tenants that have some, scored by when they were last counted.
+const (
+	redisStatsKey             = "stats"
+	redisTenantStatsKeyPrefix = "stats:tenant:"
+	redisStatsTenantsKey      = "stats_tenants"
+)
+
+func getTenantStatsRedisKey(tenant string) string {
+	return rediskey.Key(redisTenantStatsKeyPrefix) + tenant
+}
+
+// countJob increments the stat counter, fleet-wide and of tenant, in pipe.
+func countJob(ctx context.Context, pipe redis.Pipeliner, tenant string, stat string) {
+	pipe.HIncrBy(ctx, rediskey.Key(redisStatsKey), stat, 1)
+	if tenant != "" {
+		pipe.HIncrBy(ctx, getTenantStatsRedisKey(tenant), stat, 1)
+		pipe.ZAdd(ctx, rediskey.Key(redisStatsTenantsKey), &redis.Z{Score: float64(time.Now().UnixMilli()), Member: tenant})
+	}
+}
+
+const (
+	statSubmitted = "submitted"
+	statSucceeded = "succeeded"
+	statFailed    = "failed"
+)
+
+type jobStats struct {
+	Submitted   int64   `json:"submitted"`
+	Succeeded   int64   `json:"succeeded"`
+	Failed      int64   `json:"failed"`
+	SuccessRate float64 `json:"successRate"`
+	FailureRate float64 `json:"failureRate"`
+}
+
+func parseJobStats(counters map[string]string) jobStats {
+	var stats jobStats
+	stats.Submitted, _ = strconv.ParseInt(counters[statSubmitted], 10, 64)
+	stats.Succeeded, _ = strconv.ParseInt(counters[statSucceeded], 10, 64)
+	stats.Failed, _ = strconv.ParseInt(counters[statFailed], 10, 64)
+	if finished := stats.Succeeded + stats.Failed; finished > 0 {
+		stats.SuccessRate = float64(stats.Succeeded) / float64(finished)
+		stats.FailureRate = float64(stats.Failed) / float64(finished)
+	}
+	return stats
+}
+
+// tenantStats are the job counters of a tenant and its jobs waiting in this
+// node's queue.
+type tenantStats struct {
+	Tenant     string   `json:"tenant"`
+	Jobs       jobStats `json:"jobs"`
+	QueueDepth int      `json:"queueDepth"`
+}
+
+type queueStats struct {
+	Depth    int `json:"depth"`
+	InFlight int `json:"inFlight"`
+	Workers  int `json:"workers"`
+}
+
+type circuitStats struct {
+	Name             string `json:"name"`
+	VerifyingKeyHash string `json:"verifyingKeyKeccak256"`
+}
+
+type Stats struct {
+	NodeId        string         `json:"nodeId"`
+	StartedAt     time.Time      `json:"startedAt"`
+	UptimeSeconds int64          `json:"uptimeSeconds"`
+	Circuit       circuitStats   `json:"circuit"`
+	Jobs          jobStats       `json:"jobs"`
+	Queue         queueStats     `json:"queue"`
+	ProveTimes    slo.ProveTimes `json:"proveTimes"`
+	// Canary is set while a canary circuit is loaded, with the prove times
+	// of its jobs.
+	Canary *canaryStats `json:"canary,omitempty"`
+	// Tenants breaks the job counters down by tenant.
+	Tenants []tenantStats `json:"tenants"`
+}
+
+type canaryStats struct {
+	CanaryStatus
+	ProveTimes slo.ProveTimes `json:"proveTimes"`
+}
+
+// Stats reports aggregate counters for dashboards. Job counts are fleet-wide;
+// the queue and prove times are this node's.
+func (s *State) Stats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		apierror.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	counters, err := s.RedisClient.HGetAll(r.Context(), rediskey.Key(redisStatsKey)).Result()
+	if err != nil {
+		apierror.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	info, err := s.circuitInfo()
+	if err != nil {
+		log.Printf("Failed to serialize verifying key: %v\n", err)
+		apierror.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	stats := Stats{
+		NodeId:        s.NodeId,
+		StartedAt:     s.StartedAt.UTC(),
+		UptimeSeconds: int64(time.Since(s.StartedAt).Seconds()),
+		Circuit:       circuitStats{Name: info.Circuit, VerifyingKeyHash: info.VerifyingKeyHash},
+		Queue:         queueStats{Workers: s.workers},
+		ProveTimes:    s.SLO.ProveTimes(info.Circuit),
+	}
+	stats.Jobs = parseJobStats(counters)
+	if stats.Tenants, err = s.tenantStats(r.Context()); err != nil {
+		log.Printf("Failed to read tenant stats from Redis: %v\n", err)
+		apierror.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if canary := s.canaryStatus(); canary != nil {
+		stats.Canary = &canaryStats{CanaryStatus: *canary, ProveTimes: s.SLO.ProveTimes(canary.Circuit)}
+	}
+	if s.queue != nil {
+		stats.Queue.Depth = s.queue.len()
+		stats.Queue.InFlight = s.queue.inFlight()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// tenantStats reads the job counters of every tenant, with the queue depth of
+// each on this node, sorted by tenant.
+func (s *State) tenantStats(ctx context.Context) ([]tenantStats, error) {
+	tenants, err := s.RedisClient.ZRange(ctx, rediskey.Key(redisStatsTenantsKey), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(tenants)
+	pipe := s.RedisClient.Pipeline()
+	counters := make([]*redis.StringStringMapCmd, len(tenants))
+	for i, tenant := range tenants {
+		counters[i] = pipe.HGetAll(ctx, getTenantStatsRedisKey(tenant))
+	}
+	if len(tenants) > 0 {
+		if _, err := pipe.Exec(ctx); err != nil {
+			return nil, err
+		}
+	}
+	var depths map[string]int
+	if queue, ok := s.queue.(*jobQueue); ok {
+		depths = queue.tenantDepths()
+	}
+	stats := make([]tenantStats, len(tenants))
+	for i, tenant := range tenants {
+		stats[i] = tenantStats{Tenant: tenant, Jobs: parseJobStats(counters[i].Val()), QueueDepth: depths[tenant]}
+	}
+	return stats, nil
+}
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"gnark-server/apierror"
+	"gnark-server/auth"
+)
+
+const defaultTokenTTL = time.Hour
+
+// authorize checks the caller's scopes against the loaded circuit and
+// writes a 403 if operation is not allowed.
+func (s *State) authorize(w http.ResponseWriter, r *http.Request, operation string) bool {
+	circuitName, _ := s.circuit()
+	if !auth.FromContext(r.Context()).Allows(circuitName, operation) {
+		apierror.Error(w, "Forbidden", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// ownedBy reports whether the caller may read a job or DAG recorded with
+// owner. Records without an owner, submitted anonymously or before owners
+// were recorded, are readable by any caller.
+func ownedBy(owner string, r *http.Request) bool {
+	return owner == "" || owner == auth.FromContext(r.Context()).Owner()
+}
+
+// MintToken issues a service token for an internal caller.
+func (s *State) MintToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apierror.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.Tokens == nil {
+		apierror.Error(w, "Service tokens are not enabled", http.StatusNotFound)
+		return
+	}
+	var request struct {
+		Subject    string   `json:"subject"`
+		Profile    string   `json:"profile"`
+		Circuits   []string `json:"circuits"`
+		Operations []string `json:"operations"`
+		Tenant     string   `json:"tenant"`
+		TTL        string   `json:"ttl"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		apierror.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if request.Subject == "" {
+		apierror.Error(w, "subject is required", http.StatusBadRequest)
+		return
+	}
+	if request.Profile == "" {
+		request.Profile = auth.DefaultProfile
+	}
+	if err := ValidateRedactionProfile(request.Profile); err != nil {
+		apierror.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := auth.ValidateOperations(request.Operations); err != nil {
+		apierror.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := auth.ValidateTenant(request.Tenant); err != nil {
+		apierror.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ttl := defaultTokenTTL
+	if request.TTL != "" {
+		var err error
+		ttl, err = time.ParseDuration(request.TTL)
+		if err != nil || ttl <= 0 {
+			apierror.Error(w, "Invalid ttl", http.StatusBadRequest)
+			return
+		}
+	}
+	if ttl > s.MaxTokenTTL {
+		apierror.Error(w, "ttl exceeds "+s.MaxTokenTTL.String(), http.StatusBadRequest)
+		return
+	}
+
+	expiresAt := time.Now().Add(ttl)
+	token, err := s.Tokens.Mint(auth.TokenClaims{
+		Subject:    request.Subject,
+		Profile:    request.Profile,
+		Circuits:   request.Circuits,
+		Operations: request.Operations,
+		Tenant:     request.Tenant,
+		ExpiresAt:  expiresAt.Unix(),
+	})
+	if err != nil {
+		apierror.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	s.audit(r, "token-mint", "", map[string]string{
+		"subject":    request.Subject,
+		"circuits":   strings.Join(request.Circuits, ","),
+		"operations": strings.Join(request.Operations, ","),
+		"tenant":     request.Tenant,
+		"expiresAt":  expiresAt.UTC().Format(time.RFC3339),
+	})
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"token":     token,
+		"expiresAt": expiresAt.UTC().Format(time.RFC3339),
+	})
+}
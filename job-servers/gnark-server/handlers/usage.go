@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"runtime"
+	"strconv"
+	"time"
+
+	"gnark-server/apierror"
+	"gnark-server/auth"
+	"gnark-server/rediskey"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Each API key has a hash of usage counters per UTC day, by its
+// auth.Identity KeyId, kept for UsageRetention.
+const redisUsageKeyPrefix = "usage:"
+
+const (
+	usageJobs   = "jobs"
+	usageProves = "proves"
+	usageCPUMs  = "cpuMs"
+)
+
+const (
+	defaultUsageDays = 7
+	usageDateLayout  = "2006-01-02"
+)
+
+func getUsageRedisKey(keyId string, day time.Time) string {
+	return rediskey.Key(redisUsageKeyPrefix) + keyId + ":" + day.UTC().Format(usageDateLayout)
+}
+
+// claimUsageScript adds ARGV[2] to the ARGV[1] counter of a day's usage,
+// taking it back if that makes more than ARGV[3] when it is positive. It
+// replies {1, count} or {0, count}.
+var claimUsageScript = redis.NewScript(`
+local count = redis.call('HINCRBY', KEYS[1], ARGV[1], ARGV[2])
+local quota = tonumber(ARGV[3])
+if quota > 0 and count > quota then
+	count = redis.call('HINCRBY', KEYS[1], ARGV[1], -tonumber(ARGV[2]))
+	return {0, count}
+end
+redis.call('PEXPIRE', KEYS[1], ARGV[4])
+return {1, count}
+`)
+
+// DailyUsage is what an API key used on a UTC day: the jobs it submitted,
+// the proves run for them and an estimate of the CPU time those took, their
+// duration times the CPUs of a worker, which is not measured per prove.
+type DailyUsage struct {
+	Date                string  `json:"date"`
+	Jobs                int64   `json:"jobs"`
+	Proves              int64   `json:"proves"`
+	EstimatedCPUSeconds float64 `json:"estimatedCpuSeconds"`
+}
+
+type UsageReport struct {
+	// Key is the name of the API key, and KeyId its auth.Identity KeyId
+	// its usage is counted by.
+	Key            string `json:"key,omitempty"`
+	KeyId          string `json:"keyId"`
+	DailyQuota     int    `json:"dailyQuota,omitempty"`
+	DailySoftQuota int    `json:"dailySoftQuota,omitempty"`
+	// Days are the most recent first, today included.
+	Days []DailyUsage `json:"days"`
+}
+
+// claimUsage counts n jobs in today's usage of identity, unless they exceed
+// its hard daily quota, answering 429 then, and warns in an X-Usage-Warning
+// header if they exceed its soft one. The check and the count are one
+// script, so concurrent submissions can't overshoot the quota; jobs that
+// are not accepted after all are taken back with releaseUsage. Redis errors
+// are logged and the jobs are let through.
+func (s *State) claimUsage(ctx context.Context, w http.ResponseWriter, identity auth.Identity, n int) bool {
+	now := time.Now().UTC()
+	reply, err := claimUsageScript.Run(ctx, s.RedisClient, []string{getUsageRedisKey(identity.KeyId(), now)},
+		usageJobs, n, identity.DailyQuota, s.UsageRetention.Milliseconds()).Int64Slice()
+	if err != nil {
+		log.Printf("Failed to count usage in Redis: %v\n", err)
+		return true
+	}
+	used := reply[1]
+	if reply[0] == 0 {
+		log.Println("Submission rejected, key", identity.Name, "used", used, "of its daily quota of", identity.DailyQuota)
+		tomorrow := now.Truncate(24 * time.Hour).Add(24 * time.Hour)
+		w.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(tomorrow.Sub(now).Seconds()))))
+		apierror.WithDetails(w, "Daily quota of this API key exceeded", http.StatusTooManyRequests, map[string]int64{
+			"usedToday":  used,
+			"dailyQuota": int64(identity.DailyQuota),
+		})
+		return false
+	}
+	if identity.DailySoftQuota > 0 && used > int64(identity.DailySoftQuota) {
+		log.Println("Key", identity.Name, "is over its daily soft quota of", identity.DailySoftQuota)
+		w.Header().Set("X-Usage-Warning", fmt.Sprintf("daily soft quota of %d jobs exceeded", identity.DailySoftQuota))
+	}
+	return true
+}
+
+// releaseUsage takes back n jobs claimed by claimUsage today that were not
+// accepted.
+func (s *State) releaseUsage(ctx context.Context, identity auth.Identity, n int) {
+	if err := s.RedisClient.HIncrBy(ctx, getUsageRedisKey(identity.KeyId(), time.Now()), usageJobs, int64(-n)).Err(); err != nil {
+		log.Printf("Failed to release usage in Redis: %v\n", err)
+	}
+}
+
+// meterProve counts a prove of job that took elapsed in the usage of the key
+// that submitted it. Its CPU time is estimated as elapsed times the CPUs
+// each worker has, as proves running at once share the process.
+func (s *State) meterProve(ctx context.Context, job proofJob, elapsed time.Duration) {
+	if job.KeyId == "" {
+		return
+	}
+	cpus := float64(runtime.GOMAXPROCS(0))
+	if s.workers > 1 {
+		cpus /= float64(s.workers)
+	}
+	key := getUsageRedisKey(job.KeyId, time.Now())
+	pipe := s.RedisClient.TxPipeline()
+	pipe.HIncrBy(ctx, key, usageProves, 1)
+	pipe.HIncrBy(ctx, key, usageCPUMs, int64(float64(elapsed.Milliseconds())*cpus))
+	pipe.Expire(ctx, key, s.UsageRetention)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("Failed to meter prove in Redis: %v\n", err)
+	}
+}
+
+func (s *State) usage(ctx context.Context, keyId string, days int) ([]DailyUsage, error) {
+	now := time.Now().UTC()
+	pipe := s.RedisClient.Pipeline()
+	usage := make([]DailyUsage, days)
+	counters := make([]*redis.StringStringMapCmd, days)
+	for i := range usage {
+		day := now.AddDate(0, 0, -i)
+		usage[i].Date = day.Format(usageDateLayout)
+		counters[i] = pipe.HGetAll(ctx, getUsageRedisKey(keyId, day))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	for i, cmd := range counters {
+		usage[i].Jobs, _ = strconv.ParseInt(cmd.Val()[usageJobs], 10, 64)
+		usage[i].Proves, _ = strconv.ParseInt(cmd.Val()[usageProves], 10, 64)
+		cpuMs, _ := strconv.ParseInt(cmd.Val()[usageCPUMs], 10, 64)
+		usage[i].EstimatedCPUSeconds = float64(cpuMs) / 1000
+	}
+	return usage, nil
+}
+
+// parseUsageDays reads the days query parameter, bounded by UsageRetention.
+func (s *State) parseUsageDays(r *http.Request) (int, error) {
+	maxDays := int(s.UsageRetention / (24 * time.Hour))
+	raw := r.URL.Query().Get("days")
+	if raw == "" {
+		if defaultUsageDays < maxDays {
+			return defaultUsageDays, nil
+		}
+		return maxDays, nil
+	}
+	days, err := strconv.Atoi(raw)
+	if err != nil || days <= 0 || days > maxDays {
+		return 0, fmt.Errorf("days must be between 1 and %d", maxDays)
+	}
+	return days, nil
+}
+
+// Usage reports the daily usage and quotas of the caller's API key.
+func (s *State) Usage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		apierror.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	identity := auth.FromContext(r.Context())
+	s.writeUsage(w, r, UsageReport{Key: identity.Name, KeyId: identity.KeyId(), DailyQuota: identity.DailyQuota, DailySoftQuota: identity.DailySoftQuota})
+}
+
+// UsageAdmin reports the daily usage of the API key whose auth.Identity
+// KeyId is the keyId query parameter.
+func (s *State) UsageAdmin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		apierror.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	keyId := r.URL.Query().Get("keyId")
+	if keyId == "" {
+		apierror.Error(w, "keyId is required", http.StatusBadRequest)
+		return
+	}
+	s.writeUsage(w, r, UsageReport{KeyId: keyId})
+}
+
+func (s *State) writeUsage(w http.ResponseWriter, r *http.Request, report UsageReport) {
+	days, err := s.parseUsageDays(r)
+	if err != nil {
+		apierror.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if report.Days, err = s.usage(r.Context(), report.KeyId, days); err != nil {
+		log.Printf("Failed to read usage from Redis: %v\n", err)
+		s.storeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gnark-server/auth"
+)
+
+func TestClaimUsage(t *testing.T) {
+	ctx := context.Background()
+	s := &State{RedisClient: newMemoryRedis(t), UsageRetention: 48 * time.Hour}
+	identity := auth.Identity{Name: "client", DailyQuota: 3, DailySoftQuota: 1}
+
+	claim := func(n int) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		if ok := s.claimUsage(ctx, w, identity, n); ok != (w.Code == http.StatusOK) {
+			t.Fatalf("claim of %d answered %d and returned %v", n, w.Code, ok)
+		}
+		return w
+	}
+	used := func() int64 {
+		usage, err := s.usage(ctx, identity.KeyId(), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return usage[0].Jobs
+	}
+
+	if w := claim(1); w.Code != http.StatusOK || w.Header().Get("X-Usage-Warning") != "" {
+		t.Fatalf("claim within the soft quota answered %d, warning %q", w.Code, w.Header().Get("X-Usage-Warning"))
+	}
+	if w := claim(1); w.Header().Get("X-Usage-Warning") == "" {
+		t.Fatal("claim over the soft quota was not warned about")
+	}
+	// A claim over the hard quota is rejected and not counted.
+	if w := claim(2); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("claim over the quota answered %d", w.Code)
+	}
+	if n := used(); n != 2 {
+		t.Fatalf("used = %d, want 2", n)
+	}
+	s.releaseUsage(ctx, identity, 1)
+	if w := claim(2); w.Code != http.StatusOK {
+		t.Fatalf("claim after a release answered %d", w.Code)
+	}
+	if n := used(); n != 3 {
+		t.Fatalf("used = %d, want 3", n)
+	}
+}
@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+
+	"gnark-server/apierror"
+)
+
+func (s *State) VerifierSolidity(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		apierror.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	circuitName, data := s.circuit()
+	var buf bytes.Buffer
+	if err := data.Vk.ExportSolidity(&buf); err != nil {
+		log.Printf("Failed to export Solidity verifier: %v\n", err)
+		apierror.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", "inline; filename=\""+circuitName+"_verifier.sol\"")
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"gnark-server/apierror"
+	"gnark-server/artifacts"
+	"gnark-server/buildinfo"
+)
+
+type VersionInfo struct {
+	buildinfo.Info
+	Circuit CircuitVersion `json:"circuit"`
+}
+
+type CircuitVersion struct {
+	Name string `json:"name"`
+	// Version and Artifacts, the SHA-256 of each artifact, come from the
+	// manifest the artifacts were verified against when loaded, if any.
+	Version          string            `json:"version,omitempty"`
+	Artifacts        map[string]string `json:"artifacts,omitempty"`
+	VerifyingKeyHash string            `json:"verifyingKeyKeccak256"`
+}
+
+func (s *State) loadedManifest() artifacts.Manifest {
+	s.circuitMu.RLock()
+	defer s.circuitMu.RUnlock()
+	return s.CircuitManifest
+}
+
+func (s *State) Version(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		apierror.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	circuitName, data := s.circuit()
+	_, vkHash, err := serializeVk(data)
+	if err != nil {
+		log.Printf("Failed to serialize verifying key: %v\n", err)
+		apierror.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	info := VersionInfo{
+		Info:    buildinfo.Read(),
+		Circuit: CircuitVersion{Name: circuitName, VerifyingKeyHash: vkHash},
+	}
+	if manifest := s.loadedManifest(); manifest.Circuit == circuitName {
+		info.Circuit.Version = manifest.Version
+		for _, file := range manifest.Files {
+			if info.Circuit.Artifacts == nil {
+				info.Circuit.Artifacts = make(map[string]string)
+			}
+			info.Circuit.Artifacts[file.Name] = file.SHA256
+		}
+	}
+	json.NewEncoder(w).Encode(info)
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"log"
+	"time"
+)
+
+const (
+	WarmUpRunning = "running"
+	WarmUpDone    = "done"
+	WarmUpFailed  = "failed"
+)
+
+// WarmUp proves and verifies the sample proof at path, by default the one
+// bundled with the circuit, so that the first real job does not pay for
+// paging in the proving key and cold caches. It returns immediately; the
+// node is not ready until the prove succeeds. The prove is the first
+// calibration of the circuit, see CircuitBench.
+func (s *State) WarmUp(path string) {
+	s.warmUpMu.Lock()
+	s.warmUpProofFile = path
+	s.warmUpMu.Unlock()
+	s.setWarmUp(WarmUpRunning)
+	go func() {
+		start := time.Now()
+		calibration, err := protect(func() (Calibration, error) {
+			return s.calibrate(path)
+		})
+		if err != nil {
+			log.Printf("Warm-up prove failed: %v\n", err)
+			s.setWarmUp(WarmUpFailed)
+			return
+		}
+		s.setCalibration(calibration)
+		log.Println("Warm-up prove done in", time.Since(start))
+		s.setWarmUp(WarmUpDone)
+	}()
+}
+
+func (s *State) setWarmUp(status string) {
+	s.warmUpMu.Lock()
+	defer s.warmUpMu.Unlock()
+	s.warmUpStatus = status
+}
+
+// warmUpState returns the warm-up status, empty when warm-up is disabled.
+func (s *State) warmUpState() string {
+	s.warmUpMu.Lock()
+	defer s.warmUpMu.Unlock()
+	return s.warmUpStatus
+}
@@ -0,0 +1,353 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+type queuedJob struct {
+	job      proofJob
+	done     chan error
+	queuedAt time.Time
+	// origin is where the outcome of a job taken from a shared backend is
+	// reported, if not to this node.
+	origin string
+}
+
+// jobBackend holds the jobs waiting for a prover worker.
+type jobBackend interface {
+	push(job queuedJob)
+	// pop blocks until a job is available and hands it to the caller.
+	pop() queuedJob
+	// finish records that a worker is done with the job it took.
+	finish(jobId string)
+	// complete reports the outcome of a finished job to whoever submitted it.
+	complete(job queuedJob, err error)
+	position(jobId string) (ahead int, startedAt time.Time, found bool)
+	len() int
+	inFlight() int
+	// redelivers reports whether the backend itself runs again the jobs of
+	// workers that stopped without finishing them, in place of RecoverJobs
+	// and the reaper.
+	redelivers() bool
+}
+
+const (
+	priorityHigh = "high"
+	priorityLow  = "low"
+)
+
+func validatePriority(priority string) error {
+	switch priority {
+	case "", priorityHigh, priorityLow:
+		return nil
+	}
+	return fmt.Errorf("unknown priority %q", priority)
+}
+
+// jobQueue holds the jobs waiting for a prover worker in two lanes.
+// High-priority jobs are taken first, but after burst of them in a row a
+// waiting low-priority job is taken, so that batch jobs are not starved.
+type jobQueue struct {
+	mu        sync.Mutex
+	cond      *sync.Cond
+	high      *tenantLane
+	low       *tenantLane
+	burst     int
+	highInRow int
+	// running maps the jobs taken by workers to when they were taken.
+	running map[string]time.Time
+}
+
+// tenantLane keeps a FIFO queue per tenant and takes from the tenants in
+// turn, so that a tenant with a backlog does not hold up the others.
+type tenantLane struct {
+	// tenants lists the tenants with waiting jobs, the next to take from
+	// first.
+	tenants []string
+	jobs    map[string][]queuedJob
+	size    int
+}
+
+func newTenantLane() *tenantLane {
+	return &tenantLane{jobs: make(map[string][]queuedJob)}
+}
+
+func (l *tenantLane) push(job queuedJob) {
+	tenant := job.job.Tenant
+	if len(l.jobs[tenant]) == 0 {
+		l.tenants = append(l.tenants, tenant)
+	}
+	l.jobs[tenant] = append(l.jobs[tenant], job)
+	l.size++
+}
+
+func (l *tenantLane) pop() queuedJob {
+	tenant := l.tenants[0]
+	job := l.jobs[tenant][0]
+	l.tenants = l.tenants[1:]
+	if rest := l.jobs[tenant][1:]; len(rest) > 0 {
+		l.jobs[tenant] = rest
+		l.tenants = append(l.tenants, tenant)
+	} else {
+		delete(l.jobs, tenant)
+	}
+	l.size--
+	return job
+}
+
+// clone returns a copy of l that can be popped without affecting l.
+func (l *tenantLane) clone() *tenantLane {
+	c := &tenantLane{tenants: append([]string(nil), l.tenants...), jobs: make(map[string][]queuedJob, len(l.jobs)), size: l.size}
+	for tenant, jobs := range l.jobs {
+		c.jobs[tenant] = jobs
+	}
+	return c
+}
+
+func newJobQueue(burst int) *jobQueue {
+	q := &jobQueue{high: newTenantLane(), low: newTenantLane(), burst: burst, running: make(map[string]time.Time)}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+func (q *jobQueue) push(job queuedJob) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if job.job.Priority == priorityLow {
+		q.low.push(job)
+	} else {
+		q.high.push(job)
+	}
+	q.cond.Signal()
+}
+
+func (q *jobQueue) pop() queuedJob {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for q.high.size+q.low.size == 0 {
+		q.cond.Wait()
+	}
+	var job queuedJob
+	if takeHigh(q.high.size, q.low.size, q.highInRow, q.burst) {
+		job = q.high.pop()
+		if q.low.size > 0 {
+			q.highInRow++
+		}
+	} else {
+		job = q.low.pop()
+		q.highInRow = 0
+	}
+	q.running[job.job.JobId] = time.Now()
+	return job
+}
+
+func takeHigh(high int, low int, highInRow int, burst int) bool {
+	return high > 0 && (low == 0 || highInRow < burst)
+}
+
+func (q *jobQueue) inFlight() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.running)
+}
+
+func (q *jobQueue) finish(jobId string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.running, jobId)
+}
+
+// position reports when a worker took jobId or, if it is waiting, how many
+// jobs workers will take before it. found is false if the job is neither.
+func (q *jobQueue) position(jobId string) (ahead int, startedAt time.Time, found bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if startedAt, ok := q.running[jobId]; ok {
+		return 0, startedAt, true
+	}
+	high, low, highInRow := q.high.clone(), q.low.clone(), q.highInRow
+	for ahead = 0; high.size+low.size > 0; ahead++ {
+		var job queuedJob
+		if takeHigh(high.size, low.size, highInRow, q.burst) {
+			job = high.pop()
+			if low.size > 0 {
+				highInRow++
+			}
+		} else {
+			job = low.pop()
+			highInRow = 0
+		}
+		if job.job.JobId == jobId {
+			return ahead, time.Time{}, true
+		}
+	}
+	return 0, time.Time{}, false
+}
+
+func (q *jobQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.high.size + q.low.size
+}
+
+// tenantDepths counts the waiting jobs of every tenant.
+func (q *jobQueue) tenantDepths() map[string]int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	depths := make(map[string]int)
+	for _, lane := range []*tenantLane{q.high, q.low} {
+		for tenant, jobs := range lane.jobs {
+			depths[tenant] += len(jobs)
+		}
+	}
+	return depths
+}
+
+func (q *jobQueue) complete(job queuedJob, err error) {
+	job.done <- err
+}
+
+func (q *jobQueue) redelivers() bool {
+	return false
+}
+
+// StartWorkers starts n workers proving queued jobs, which bounds the number
+// of concurrent proves. Jobs are taken from the in-memory queue unless
+// UseJetStream was called first.
+func (s *State) StartWorkers(n int) {
+	if s.queue == nil {
+		s.queue = newJobQueue(s.HighPriorityBurst)
+	}
+	s.workers = n
+	for i := 0; i < n; i++ {
+		go func() {
+			for {
+				queued := s.queue.pop()
+				release, err := s.admitMemory(queued.job)
+				if err != nil {
+					err = s.failJob(context.Background(), queued.job, err)
+					s.queue.finish(queued.job.JobId)
+					if s.willRetry(queued.job, err) {
+						s.retry(queued)
+					} else {
+						s.queue.complete(queued, err)
+					}
+					continue
+				}
+				started := time.Now()
+				stopHeartbeat := s.startHeartbeat(queued.job.JobId)
+				s.markJobState(context.Background(), queued.job, jobStateRunning, "startedAt", started.UnixMilli(), "attempts", queued.job.Attempt)
+				ctx, cancel := context.WithCancel(context.Background())
+				if s.JobTimeout > 0 {
+					ctx, cancel = context.WithTimeout(context.Background(), s.JobTimeout)
+				}
+				ctx, steps := withBackgroundSteps(ctx)
+				queued.job.QueuedAt = queued.queuedAt
+				err = s.runJob(ctx, queued.job)
+				abandoned := ctx.Err() != nil
+				cancel()
+				// An abandoned solve or prove, or a local prove that lost a
+				// race, still holds its memory and cores: the worker keeps its
+				// slot and memory admission, and does not retry the job, until
+				// it returns.
+				if abandoned {
+					log.Println("Waiting for the abandoned prove step to finish. jobId", queued.job.JobId)
+				}
+				steps.Wait()
+				stopHeartbeat()
+				release()
+				s.queue.finish(queued.job.JobId)
+				if err != nil && s.willRetry(queued.job, err) {
+					s.retry(queued)
+					continue
+				}
+				s.clearHeartbeat(queued.job.JobId)
+				circuitName, _, _ := s.jobCircuit(queued.job)
+				s.SLO.Record(queued.job.Tenant, circuitName, started.Sub(queued.queuedAt), time.Since(queued.queuedAt))
+				s.queue.complete(queued, err)
+			}
+		}()
+	}
+	log.Println("Started", n, "prover workers")
+}
+
+// runJob proves job, failing it instead of crashing the worker if the prove
+// panics.
+func (s *State) runJob(ctx context.Context, job proofJob) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = s.failJob(context.Background(), job, &panicError{value: r, stack: string(debug.Stack())})
+		}
+	}()
+	return s.prove(ctx, job)
+}
+
+// submit queues job for proving. The returned channel receives the outcome
+// of prove once a worker has run it.
+func (s *State) submit(job proofJob) <-chan error {
+	job.Attempt = 1
+	if err := s.storeJobSpec(context.Background(), job); err != nil {
+		log.Printf("Failed to store job in Redis: %v\n", err)
+	}
+	done := make(chan error, 1)
+	s.queue.push(queuedJob{job: job, done: done, queuedAt: time.Now()})
+	return done
+}
+
+type backgroundStepsKey struct{}
+
+// withBackgroundSteps returns a context under which the solve and prove
+// steps run in the background by untilDone and raceProve are counted, and
+// the WaitGroup that waits for them, abandoned or not.
+func withBackgroundSteps(ctx context.Context) (context.Context, *sync.WaitGroup) {
+	steps := &sync.WaitGroup{}
+	return context.WithValue(ctx, backgroundStepsKey{}, steps), steps
+}
+
+// startBackgroundStep counts a step started under ctx, returning the
+// function to call once it returned.
+func startBackgroundStep(ctx context.Context) func() {
+	steps, ok := ctx.Value(backgroundStepsKey{}).(*sync.WaitGroup)
+	if !ok {
+		return func() {}
+	}
+	steps.Add(1)
+	return steps.Done
+}
+
+// untilDone runs fn in the background and returns its outcome, or ctx's error
+// if ctx is done first. gnark solving and proving cannot be interrupted, so an
+// abandoned fn keeps running until it finishes and its outcome is discarded.
+func untilDone[T any](ctx context.Context, jobId string, fn func() (T, error)) (T, error) {
+	type outcome struct {
+		value T
+		err   error
+	}
+	done := make(chan outcome, 1)
+	start := time.Now()
+	stepDone := startBackgroundStep(ctx)
+	go func() {
+		defer stepDone()
+		value, err := protect(fn)
+		done <- outcome{value: value, err: err}
+		if ctx.Err() != nil {
+			log.Println("Abandoned prove step finished. jobId", jobId, "elapsed", time.Since(start))
+		}
+	}()
+	select {
+	case o := <-done:
+		return o.value, o.err
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
+
+func (s *State) timeoutError(err error) error {
+	return withCode(ErrorCodeTimeout, fmt.Errorf("job timed out after %s: %w", s.JobTimeout, err))
+}
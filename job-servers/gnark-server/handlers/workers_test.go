@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestUntilDoneCountsAbandonedSteps(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	ctx, steps := withBackgroundSteps(ctx)
+	release := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		_, err := untilDone(ctx, "job", func() (int, error) {
+			<-release
+			return 1, nil
+		})
+		if err != context.DeadlineExceeded {
+			t.Errorf("untilDone = %v, want the deadline", err)
+		}
+		close(finished)
+	}()
+	<-finished
+
+	// The step was abandoned but still runs: waiting for it blocks until it
+	// returns.
+	waited := make(chan struct{})
+	go func() {
+		steps.Wait()
+		close(waited)
+	}()
+	select {
+	case <-waited:
+		t.Fatal("steps.Wait returned while the abandoned step runs")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	select {
+	case <-waited:
+	case <-time.After(time.Second):
+		t.Fatal("steps.Wait did not return once the step returned")
+	}
+}
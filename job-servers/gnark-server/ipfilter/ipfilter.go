@@ -0,0 +1,166 @@
+// Package ipfilter restricts the source addresses allowed to reach the
+// server, globally and per path prefix, and resolves the client address of
+// requests relayed by trusted proxies.
+package ipfilter
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+
+	"gnark-server/apierror"
+)
+
+type contextKey struct{}
+
+type exemptKey struct{}
+
+// ParseCIDRs parses CIDRs, taking a bare address as a network of its own.
+func ParseCIDRs(values []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", value)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", value)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+func contains(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+type rule struct {
+	prefix   string
+	networks []*net.IPNet
+}
+
+// Filter allows a request if its client address is in the list of the
+// longest path prefix matching it, or in the global list if no prefix does.
+// An empty global list allows every address.
+type Filter struct {
+	global  []*net.IPNet
+	rules   []rule
+	trusted []*net.IPNet
+}
+
+// New builds a filter from CIDR lists: global, per path prefix, and the
+// proxies whose X-Forwarded-For is trusted.
+func New(global []string, paths map[string][]string, trustedProxies []string) (*Filter, error) {
+	f := &Filter{}
+	var err error
+	if f.global, err = ParseCIDRs(global); err != nil {
+		return nil, err
+	}
+	if f.trusted, err = ParseCIDRs(trustedProxies); err != nil {
+		return nil, err
+	}
+	for prefix, values := range paths {
+		networks, err := ParseCIDRs(values)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", prefix, err)
+		}
+		f.rules = append(f.rules, rule{prefix: prefix, networks: networks})
+	}
+	sort.Slice(f.rules, func(i, j int) bool { return len(f.rules[i].prefix) > len(f.rules[j].prefix) })
+	return f, nil
+}
+
+// ClientIP is the address of the peer of r or, if the peer is a trusted
+// proxy, the last address of X-Forwarded-For that is not one.
+func (f *Filter) ClientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !contains(f.trusted, ip) {
+		return ip
+	}
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !contains(f.trusted, hop) {
+			break
+		}
+	}
+	return ip
+}
+
+func (f *Filter) allows(path string, ip net.IP) bool {
+	networks := f.global
+	for _, rule := range f.rules {
+		if strings.HasPrefix(path, rule.prefix) {
+			networks = rule.networks
+			break
+		}
+	}
+	return len(networks) == 0 || (ip != nil && contains(networks, ip))
+}
+
+// Exempt marks the requests of next as not filtered, for listeners without
+// network addresses, such as unix sockets, whose access is controlled by
+// other means.
+func Exempt(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), exemptKey{}, true)))
+	})
+}
+
+// Middleware rejects the requests the filter does not allow with 403 and
+// stores the client address of the others in their context.
+func (f *Filter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if exempt, _ := r.Context().Value(exemptKey{}).(bool); exempt {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ip := f.ClientIP(r)
+		if !f.allows(r.URL.Path, ip) {
+			log.Println("Rejected request from a disallowed address", ip, r.URL.Path)
+			apierror.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if ip != nil {
+			r = r.WithContext(context.WithValue(r.Context(), contextKey{}, ip))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// FromContext returns the client address stored by Middleware, or nil.
+func FromContext(ctx context.Context) net.IP {
+	ip, _ := ctx.Value(contextKey{}).(net.IP)
+	return ip
+}
@@ -0,0 +1,328 @@
+// Package kafka consumes topics as a member of a consumer group, with
+// offsets committed only for records their handler is done with. It wraps
+// the franz-go client.
+package kafka
+
+import (
+	"context"
+	"crypto/tls"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/kmsg"
+	"github.com/twmb/franz-go/pkg/sasl/plain"
+)
+
+// pollWait is how long Run waits before polling again while paused or
+// with MaxInFlight records not done.
+const pollWait = 500 * time.Millisecond
+
+type Config struct {
+	// Brokers are host:port bootstrap addresses.
+	Brokers  []string
+	ClientId string
+	TLS      bool
+	// Username and Password authenticate with SASL/PLAIN when Username is
+	// set.
+	Username string
+	Password string
+}
+
+// Record is a message of a topic partition.
+type Record struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+	Timestamp time.Time
+	Key       []byte
+	Value     []byte
+	Headers   map[string]string
+}
+
+// Consumer consumes Topics as a member of Group, the partitions being
+// shared among the members. Offsets are committed up to the first record
+// whose handler is not done, so a record is delivered again, possibly to
+// another member, unless it was done before a rebalance or a crash: delivery
+// is at least once.
+type Consumer struct {
+	Config Config
+	Group  string
+	Topics []string
+	// SessionTimeout is how long the group waits for the heartbeat of a
+	// member before moving its partitions, RebalanceTimeout how long it waits
+	// for members to rejoin during a rebalance.
+	SessionTimeout   time.Duration
+	RebalanceTimeout time.Duration
+	CommitInterval   time.Duration
+	// FromLatest starts partitions without a committed offset at their end
+	// instead of their beginning.
+	FromLatest bool
+	// MaxInFlight bounds the records handed to the handler and not done yet,
+	// and those buffered ahead of them.
+	MaxInFlight int
+	// Paused, when set, stops polling while it returns true.
+	Paused func() bool
+
+	tracker *tracker
+}
+
+// options are the client options of the consumer.
+func (c *Consumer) options() []kgo.Opt {
+	resetOffset := kgo.NewOffset().AtStart()
+	if c.FromLatest {
+		resetOffset = kgo.NewOffset().AtEnd()
+	}
+	opts := []kgo.Opt{
+		kgo.SeedBrokers(c.Config.Brokers...),
+		kgo.ConsumerGroup(c.Group),
+		kgo.ConsumeTopics(c.Topics...),
+		kgo.ConsumeResetOffset(resetOffset),
+		kgo.DisableAutoCommit(),
+		kgo.MaxBufferedRecords(c.MaxInFlight),
+		kgo.OnPartitionsRevoked(c.revoked),
+		kgo.OnPartitionsLost(c.lost),
+	}
+	if c.Config.ClientId != "" {
+		opts = append(opts, kgo.ClientID(c.Config.ClientId))
+	}
+	if c.SessionTimeout > 0 {
+		opts = append(opts, kgo.SessionTimeout(c.SessionTimeout))
+	}
+	if c.RebalanceTimeout > 0 {
+		opts = append(opts, kgo.RebalanceTimeout(c.RebalanceTimeout))
+	}
+	if c.Config.TLS {
+		opts = append(opts, kgo.DialTLSConfig(&tls.Config{MinVersion: tls.VersionTLS12}))
+	}
+	if c.Config.Username != "" {
+		opts = append(opts, kgo.SASL(plain.Auth{User: c.Config.Username, Pass: c.Config.Password}.AsMechanism()))
+	}
+	return opts
+}
+
+// Run consumes until ctx is done, handing every record to handle with a
+// done function to call, from any goroutine, once the record may be
+// committed. Fetch errors are logged and retried by the client.
+func (c *Consumer) Run(ctx context.Context, handle func(Record, func())) error {
+	c.tracker = newTracker()
+	client, err := kgo.NewClient(c.options()...)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	lastCommit := time.Now()
+	for ctx.Err() == nil {
+		if time.Since(lastCommit) >= c.CommitInterval {
+			c.commit(ctx, client, nil)
+			lastCommit = time.Now()
+		}
+		room := c.MaxInFlight - c.tracker.inFlight()
+		if (c.Paused != nil && c.Paused()) || room <= 0 {
+			sleep(ctx, pollWait)
+			continue
+		}
+		pollCtx, cancel := context.WithTimeout(ctx, pollWait)
+		fetches := client.PollRecords(pollCtx, room)
+		cancel()
+		fetches.EachError(func(topic string, partition int32, err error) {
+			if ctx.Err() == nil && err != context.DeadlineExceeded {
+				log.Printf("Failed to fetch %s/%d from Kafka: %v\n", topic, partition, err)
+			}
+		})
+		fetches.EachRecord(func(r *kgo.Record) {
+			done := c.tracker.deliver(r.Topic, r.Partition, r.Offset)
+			handle(recordOf(r), done)
+		})
+	}
+	c.commit(context.Background(), client, nil)
+	return nil
+}
+
+// commit commits, for the partitions of only (or every partition if only
+// is nil), the offsets up to their first record not done.
+func (c *Consumer) commit(ctx context.Context, client *kgo.Client, only map[string][]int32) {
+	offsets := c.tracker.offsets(only)
+	if len(offsets) == 0 {
+		return
+	}
+	client.CommitOffsetsSync(ctx, offsets, func(_ *kgo.Client, _ *kmsg.OffsetCommitRequest, response *kmsg.OffsetCommitResponse, err error) {
+		if err != nil {
+			log.Printf("Failed to commit Kafka offsets: %v\n", err)
+			return
+		}
+		for _, topic := range response.Topics {
+			for _, partition := range topic.Partitions {
+				if err := kerr.ErrorForCode(partition.ErrorCode); err != nil {
+					log.Printf("Failed to commit Kafka offset of %s/%d: %v\n", topic.Topic, partition.Partition, err)
+					continue
+				}
+				c.tracker.committed(topic.Topic, partition.Partition, offsets[topic.Topic][partition.Partition].Offset)
+			}
+		}
+	})
+}
+
+// revoked commits what was done of the partitions moving to other members
+// before they are handed over. Their records not done are read again there.
+func (c *Consumer) revoked(ctx context.Context, client *kgo.Client, revoked map[string][]int32) {
+	c.commit(ctx, client, revoked)
+	c.tracker.drop(revoked)
+}
+
+// lost forgets partitions taken away without a chance to commit.
+func (c *Consumer) lost(_ context.Context, _ *kgo.Client, lost map[string][]int32) {
+	c.tracker.drop(lost)
+}
+
+func recordOf(r *kgo.Record) Record {
+	record := Record{
+		Topic:     r.Topic,
+		Partition: r.Partition,
+		Offset:    r.Offset,
+		Timestamp: r.Timestamp,
+		Key:       r.Key,
+		Value:     r.Value,
+	}
+	if len(r.Headers) > 0 {
+		record.Headers = make(map[string]string, len(r.Headers))
+		for _, header := range r.Headers {
+			record.Headers[header.Key] = string(header.Value)
+		}
+	}
+	return record
+}
+
+func sleep(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
+
+// tracker tracks the offsets of the partitions assigned to this member.
+type tracker struct {
+	mu         sync.Mutex
+	partitions map[string]map[int32]*partitionState
+	pending    int
+}
+
+// partitionState tracks the offsets of one partition.
+type partitionState struct {
+	// next is the offset after the last one delivered, pending the offsets
+	// delivered and not done.
+	next      int64
+	pending   map[int64]bool
+	committed int64
+}
+
+func newTracker() *tracker {
+	return &tracker{partitions: make(map[string]map[int32]*partitionState)}
+}
+
+// commitOffset is the offset to commit: the first pending one, or the next
+// to deliver if none is.
+func (p *partitionState) commitOffset() int64 {
+	offset := p.next
+	for pendingOffset := range p.pending {
+		if pendingOffset < offset {
+			offset = pendingOffset
+		}
+	}
+	return offset
+}
+
+// deliver records the delivery of a record and returns its done function.
+// Done records of a partition dropped since only count against
+// MaxInFlight.
+func (t *tracker) deliver(topic string, partition int32, offset int64) func() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	partitions := t.partitions[topic]
+	if partitions == nil {
+		partitions = make(map[int32]*partitionState)
+		t.partitions[topic] = partitions
+	}
+	p := partitions[partition]
+	if p == nil {
+		p = &partitionState{pending: make(map[int64]bool), committed: -1}
+		partitions[partition] = p
+	}
+	p.pending[offset] = true
+	if offset >= p.next {
+		p.next = offset + 1
+	}
+	t.pending++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			delete(p.pending, offset)
+			t.pending--
+		})
+	}
+}
+
+func (t *tracker) inFlight() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.pending
+}
+
+// offsets returns the offsets to commit of the partitions of only, or of
+// every partition if only is nil, that moved since their last commit.
+func (t *tracker) offsets(only map[string][]int32) map[string]map[int32]kgo.EpochOffset {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	offsets := make(map[string]map[int32]kgo.EpochOffset)
+	add := func(topic string, partition int32, p *partitionState) {
+		offset := p.commitOffset()
+		if offset <= p.committed {
+			return
+		}
+		if offsets[topic] == nil {
+			offsets[topic] = make(map[int32]kgo.EpochOffset)
+		}
+		offsets[topic][partition] = kgo.EpochOffset{Epoch: -1, Offset: offset}
+	}
+	if only == nil {
+		for topic, partitions := range t.partitions {
+			for partition, p := range partitions {
+				add(topic, partition, p)
+			}
+		}
+		return offsets
+	}
+	for topic, partitions := range only {
+		for _, partition := range partitions {
+			if p := t.partitions[topic][partition]; p != nil {
+				add(topic, partition, p)
+			}
+		}
+	}
+	return offsets
+}
+
+func (t *tracker) committed(topic string, partition int32, offset int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if p := t.partitions[topic][partition]; p != nil && offset > p.committed {
+		p.committed = offset
+	}
+}
+
+// drop forgets partitions no longer assigned. Their records still being
+// handled keep counting against MaxInFlight until done.
+func (t *tracker) drop(partitions map[string][]int32) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for topic, ps := range partitions {
+		for _, partition := range ps {
+			delete(t.partitions[topic], partition)
+		}
+	}
+}
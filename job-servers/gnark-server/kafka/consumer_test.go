@@ -0,0 +1,217 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/twmb/franz-go/pkg/kadm"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+func TestTrackerCommitsUpToFirstPending(t *testing.T) {
+	tracker := newTracker()
+	done0 := tracker.deliver("jobs", 0, 10)
+	done1 := tracker.deliver("jobs", 0, 11)
+	done2 := tracker.deliver("jobs", 0, 12)
+	if got := tracker.inFlight(); got != 3 {
+		t.Fatalf("inFlight = %d, want 3", got)
+	}
+
+	// Nothing is done: the first pending offset is committed, which is a
+	// no-op for the group but moves nothing past an unhandled record.
+	if got := tracker.offsets(nil)["jobs"][0].Offset; got != 10 {
+		t.Fatalf("offset = %d, want 10", got)
+	}
+	done1()
+	done2()
+	if got := tracker.offsets(nil)["jobs"][0].Offset; got != 10 {
+		t.Fatalf("offset with 10 pending = %d, want 10", got)
+	}
+	done0()
+	if got := tracker.offsets(nil)["jobs"][0].Offset; got != 13 {
+		t.Fatalf("offset with none pending = %d, want 13", got)
+	}
+	if got := tracker.inFlight(); got != 0 {
+		t.Fatalf("inFlight = %d, want 0", got)
+	}
+}
+
+func TestTrackerDoneIsIdempotent(t *testing.T) {
+	tracker := newTracker()
+	done := tracker.deliver("jobs", 0, 0)
+	tracker.deliver("jobs", 0, 1)
+	done()
+	done()
+	if got := tracker.inFlight(); got != 1 {
+		t.Fatalf("inFlight = %d, want 1", got)
+	}
+}
+
+func TestTrackerSkipsCommittedOffsets(t *testing.T) {
+	tracker := newTracker()
+	tracker.deliver("jobs", 0, 0)()
+	tracker.deliver("jobs", 1, 0)
+	tracker.committed("jobs", 0, 1)
+	offsets := tracker.offsets(nil)
+	if _, ok := offsets["jobs"][0]; ok {
+		t.Fatalf("committed partition 0 is committed again: %v", offsets)
+	}
+	if got := offsets["jobs"][1].Offset; got != 0 {
+		t.Fatalf("partition 1 offset = %d, want 0", got)
+	}
+}
+
+func TestTrackerOnlyAndDrop(t *testing.T) {
+	tracker := newTracker()
+	tracker.deliver("jobs", 0, 4)()
+	done := tracker.deliver("jobs", 1, 7)
+	offsets := tracker.offsets(map[string][]int32{"jobs": {0}})
+	if len(offsets["jobs"]) != 1 || offsets["jobs"][0].Offset != 5 {
+		t.Fatalf("offsets of partition 0 = %v, want 5 only", offsets)
+	}
+
+	// A dropped partition is no longer committed, but its record still
+	// counts against MaxInFlight until done.
+	tracker.drop(map[string][]int32{"jobs": {1}})
+	if _, ok := tracker.offsets(nil)["jobs"][1]; ok {
+		t.Fatal("dropped partition is still committed")
+	}
+	if got := tracker.inFlight(); got != 1 {
+		t.Fatalf("inFlight = %d, want 1", got)
+	}
+	done()
+	if got := tracker.inFlight(); got != 0 {
+		t.Fatalf("inFlight = %d, want 0", got)
+	}
+}
+
+// testBrokers returns the brokers of KAFKA_TEST_BROKERS, skipping the test
+// when it is not set.
+func testBrokers(t *testing.T) []string {
+	brokers := os.Getenv("KAFKA_TEST_BROKERS")
+	if brokers == "" {
+		t.Skip("KAFKA_TEST_BROKERS is not set")
+	}
+	return strings.Split(brokers, ",")
+}
+
+// createTopic creates a topic of partitions partitions, deleted when the
+// test ends.
+func createTopic(t *testing.T, brokers []string, partitions int32) string {
+	client, err := kgo.NewClient(kgo.SeedBrokers(brokers...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Close)
+	admin := kadm.NewClient(client)
+	topic := "gnark-server-test-" + uuid.NewString()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if _, err := admin.CreateTopic(ctx, partitions, 1, nil, topic); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.DeleteTopics(context.Background(), topic) })
+	return topic
+}
+
+func produce(t *testing.T, brokers []string, topic string, count int) {
+	client, err := kgo.NewClient(kgo.SeedBrokers(brokers...), kgo.ProducerBatchCompression(kgo.Lz4Compression()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	var records []*kgo.Record
+	for i := 0; i < count; i++ {
+		records = append(records, &kgo.Record{
+			Topic:   topic,
+			Key:     []byte(fmt.Sprint(i)),
+			Value:   []byte(fmt.Sprintf(`{"n":%d}`, i)),
+			Headers: []kgo.RecordHeader{{Key: "n", Value: []byte(fmt.Sprint(i))}},
+		})
+	}
+	if err := client.ProduceSync(ctx, records...).FirstErr(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// consume runs a consumer of topic in group until it handled want records,
+// calling done only for the records keep accepts, and returns their keys.
+func consume(t *testing.T, brokers []string, topic, group string, want int, keep func(Record) bool) map[string]Record {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	var mu sync.Mutex
+	records := make(map[string]Record)
+	consumer := &Consumer{
+		Config:         Config{Brokers: brokers, ClientId: "gnark-server-test"},
+		Group:          group,
+		Topics:         []string{topic},
+		SessionTimeout: 10 * time.Second,
+		CommitInterval: 100 * time.Millisecond,
+		MaxInFlight:    want,
+	}
+	stopped := make(chan error)
+	runCtx, stop := context.WithCancel(ctx)
+	go func() {
+		stopped <- consumer.Run(runCtx, func(record Record, done func()) {
+			mu.Lock()
+			defer mu.Unlock()
+			records[string(record.Key)] = record
+			if keep(record) {
+				done()
+			}
+			if len(records) == want {
+				stop()
+			}
+		})
+	}()
+	if err := <-stopped; err != nil {
+		t.Fatal(err)
+	}
+	stop()
+	if ctx.Err() != nil {
+		t.Fatalf("consumed %d records of %d before the deadline", len(records), want)
+	}
+	return records
+}
+
+func TestConsumerReadsProducedRecords(t *testing.T) {
+	brokers := testBrokers(t)
+	topic := createTopic(t, brokers, 3)
+	produce(t, brokers, topic, 20)
+
+	records := consume(t, brokers, topic, "group-"+uuid.NewString(), 20, func(Record) bool { return true })
+	for i := 0; i < 20; i++ {
+		record, ok := records[fmt.Sprint(i)]
+		if !ok {
+			t.Fatalf("record %d was not consumed", i)
+		}
+		if string(record.Value) != fmt.Sprintf(`{"n":%d}`, i) || record.Headers["n"] != fmt.Sprint(i) {
+			t.Fatalf("record %d = %q with headers %v", i, record.Value, record.Headers)
+		}
+	}
+}
+
+func TestConsumerRedeliversRecordsNotDone(t *testing.T) {
+	brokers := testBrokers(t)
+	topic := createTopic(t, brokers, 1)
+	produce(t, brokers, topic, 10)
+	group := "group-" + uuid.NewString()
+
+	// The first member leaves with record 5 not done: records 0 to 4 are
+	// committed and the rest are read again by the next member.
+	consume(t, brokers, topic, group, 10, func(record Record) bool { return string(record.Key) != "5" })
+	records := consume(t, brokers, topic, group, 5, func(Record) bool { return true })
+	for i := 5; i < 10; i++ {
+		if _, ok := records[fmt.Sprint(i)]; !ok {
+			t.Fatalf("record %d was not read again", i)
+		}
+	}
+}
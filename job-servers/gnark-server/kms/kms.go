@@ -0,0 +1,71 @@
+// Package kms decrypts data keys with AWS KMS.
+package kms
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"gnark-server/sigv4"
+)
+
+type Client struct {
+	// Endpoint defaults to https://kms.<Region>.amazonaws.com.
+	Endpoint    string
+	Region      string
+	Credentials sigv4.Credentials
+	HTTPClient  *http.Client
+}
+
+// Decrypt returns the plaintext of a ciphertext blob encrypted by KMS, such
+// as the CiphertextBlob of GenerateDataKey.
+func (c *Client) Decrypt(ctx context.Context, ciphertext []byte) ([]byte, error) {
+	body, _ := json.Marshal(map[string][]byte{"CiphertextBlob": ciphertext})
+	endpoint := c.Endpoint
+	if endpoint == "" {
+		endpoint = "https://kms." + c.Region + ".amazonaws.com"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService.Decrypt")
+	payloadHash := sha256.Sum256(body)
+	sigv4.Sign(req, hex.EncodeToString(payloadHash[:]), c.Region, "kms", c.Credentials, time.Now())
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 30 * time.Second}
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		json.Unmarshal(data, &apiErr)
+		return nil, fmt.Errorf("kms Decrypt: %s: %s %s", resp.Status, apiErr.Type, apiErr.Message)
+	}
+	var result struct {
+		Plaintext []byte `json:"Plaintext"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("kms Decrypt: invalid response: %w", err)
+	}
+	return result.Plaintext, nil
+}
@@ -0,0 +1,103 @@
+// Package leader elects one replica among those sharing a Redis to run
+// singleton duties, holding a lease on a Redis key that it renews.
+package leader
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// renewScript extends the lease of KEYS[1] to ARGV[2] ms if ARGV[1] holds it.
+var renewScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// releaseScript deletes KEYS[1] if ARGV[1] holds it.
+var releaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+type Elector struct {
+	Client redis.UniversalClient
+	Key    string
+	// Id identifies this replica, and must be unique.
+	Id string
+	// Lease is how long leadership lasts without renewal, and bounds how
+	// long the duties are left without a leader when it dies.
+	Lease time.Duration
+}
+
+// Run campaigns for leadership until ctx is done. Each time this replica
+// becomes the leader, every duty is started with a context cancelled when it
+// stops being the leader.
+func (e *Elector) Run(ctx context.Context, duties ...func(context.Context)) {
+	ticker := time.NewTicker(e.Lease / 3)
+	defer ticker.Stop()
+	var (
+		stop    context.CancelFunc
+		done    sync.WaitGroup
+		renewed time.Time
+	)
+	stepDown := func(reason string) {
+		stop()
+		done.Wait()
+		stop = nil
+		log.Printf("No longer the leader for %s: %s\n", e.Key, reason)
+	}
+	for {
+		if stop == nil {
+			acquired, err := e.Client.SetNX(ctx, e.Key, e.Id, e.Lease).Result()
+			if err != nil && ctx.Err() == nil {
+				log.Printf("Failed to campaign for leadership: %v\n", err)
+			}
+			if acquired {
+				renewed = time.Now()
+				log.Printf("Leader for %s\n", e.Key)
+				var leadCtx context.Context
+				leadCtx, stop = context.WithCancel(ctx)
+				for _, duty := range duties {
+					done.Add(1)
+					go func(duty func(context.Context)) {
+						defer done.Done()
+						duty(leadCtx)
+					}(duty)
+				}
+			}
+		} else {
+			start := time.Now()
+			n, err := renewScript.Run(ctx, e.Client, []string{e.Key}, e.Id, e.Lease.Milliseconds()).Int()
+			switch {
+			case err == nil && n == 1:
+				renewed = start
+			case err == nil:
+				stepDown("lease lost")
+			case time.Since(renewed) >= e.Lease-e.Lease/3:
+				// The lease may expire before the next attempt.
+				stepDown(err.Error())
+			default:
+				log.Printf("Failed to renew leadership: %v\n", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			if stop != nil {
+				stop()
+				done.Wait()
+				releaseScript.Run(context.Background(), e.Client, []string{e.Key}, e.Id)
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
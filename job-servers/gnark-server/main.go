@@ -2,22 +2,66 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
+	"math/big"
+	"net"
 	"net/http"
 	"os"
+	"runtime"
+	"time"
 
+	"gnark-server/accesslog"
+	"gnark-server/apierror"
+	"gnark-server/apiversion"
+	"gnark-server/artifacts"
+	"gnark-server/atrest"
+	"gnark-server/attestation"
+	"gnark-server/audit"
+	"gnark-server/auth"
 	"gnark-server/circuitData"
+	"gnark-server/clock"
+	"gnark-server/config"
+	"gnark-server/cors"
+	"gnark-server/fleet"
+	"gnark-server/gctune"
 	"gnark-server/handlers"
+	"gnark-server/ipfilter"
+	"gnark-server/kafka"
+	"gnark-server/kms"
+	"gnark-server/leader"
+	"gnark-server/memadmit"
+	"gnark-server/memstore"
+	"gnark-server/objectstore"
+	"gnark-server/payment"
+	"gnark-server/postgres"
+	"gnark-server/profiling"
+	"gnark-server/prover"
+	"gnark-server/receipt"
+	"gnark-server/redisbreaker"
+	"gnark-server/rediskey"
+	"gnark-server/relayer"
+	"gnark-server/resultsig"
+	"gnark-server/sigv4"
+	"gnark-server/slo"
+	"gnark-server/spool"
+	"gnark-server/webhook"
 
 	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
 	"github.com/joho/godotenv"
+	"github.com/nats-io/nats.go"
 )
 
 func main() {
 	godotenv.Load()
 
 	circuitName := flag.String("circuit", "", "circuit name")
+	store := flag.String("store", "", "result store: redis, postgres or memory (default: RESULT_STORE)")
 	flag.Parse()
 
 	if *circuitName == "" {
@@ -25,24 +69,26 @@ func main() {
 		os.Exit(1)
 	}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		log.Fatal("PORT environment variable is not set")
-		os.Exit(1)
+	if *store != "" {
+		os.Setenv("RESULT_STORE", *store)
 	}
-
-	redisURL := os.Getenv("REDIS_URL")
-	if redisURL == "" {
-		log.Fatal("REDIS_URL environment variable is not set")
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal("Configuration error: ", err)
 		return
 	}
-	opt, err := redis.ParseURL(redisURL)
+
+	rdb, err := newRedisClient(cfg)
 	if err != nil {
-		log.Fatal("Redis URL parsing error:", err)
+		log.Fatal("Redis configuration error:", err)
 		return
 	}
-
-	rdb := redis.NewClient(opt)
+	rediskey.Configure(cfg.RedisNamespace, cfg.RedisKeyPrefix)
+	if len(cfg.RedisClusterAddrs) > 0 {
+		rediskey.UseHashTags()
+	}
+	redisBreaker := redisbreaker.New(cfg.RedisBreakerThreshold, cfg.RedisBreakerCooldown)
+	rdb.AddHook(redisBreaker)
 	ctx := context.Background()
 
 	// Test connection
@@ -52,17 +98,596 @@ func main() {
 		return
 	}
 
-	data := circuitData.InitCircuitData(*circuitName)
+	skew, err := clock.Check(ctx, rdb, cfg.NTPServer)
+	if err != nil {
+		log.Printf("Clock skew check failed: %v\n", err)
+	} else if skew > cfg.MaxClockSkew || -skew > cfg.MaxClockSkew {
+		log.Fatalf("Clock skew of %s exceeds MAX_CLOCK_SKEW %s", skew, cfg.MaxClockSkew)
+		return
+	}
+	go clock.Monitor(ctx, rdb, cfg.NTPServer, cfg.MaxClockSkew, cfg.ClockSkewCheckInterval)
+
+	keyStore, err := auth.LoadKeyStore(cfg.APIKeysFile)
+	if err != nil {
+		log.Fatal("API keys loading error:", err)
+		return
+	}
+	for _, identity := range keyStore.Identities() {
+		if err := handlers.ValidateRedactionProfile(identity.Profile); err != nil {
+			log.Fatal("API keys loading error:", err)
+			return
+		}
+	}
+
+	var tokens *auth.TokenIssuer
+	if cfg.ServiceTokenSecret != "" {
+		tokens = auth.NewTokenIssuer(cfg.ServiceTokenSecret, cfg.ServiceTokenPreviousSecrets)
+		keyStore.Tokens = tokens
+	}
+
+	outbox := webhook.NewOutbox(rdb, cfg.WebhookMaxAttempts, cfg.WebhookMaxAge)
+	// Singleton duties run on the leader only when leader election is on.
+	duties := []func(context.Context){outbox.Run}
+
+	if cfg.ArtifactStoreURL != "" {
+		source, err := artifacts.NewStoreSource(cfg.ArtifactStoreURL, cfg.ArtifactStoreEndpoint, cfg.ArtifactStoreRegion,
+			cfg.ArtifactStoreAccessKeyID, cfg.ArtifactStoreSecretAccessKey)
+		if err != nil {
+			log.Fatal("Artifact store configuration error:", err)
+			return
+		}
+		source.DataDir = "data"
+		source.Parallelism = cfg.ArtifactFetchParallelism
+		if err := source.Sync(ctx, *circuitName); err != nil {
+			log.Fatal("Artifact fetch error:", err)
+			return
+		}
+	}
+	if len(cfg.ArtifactPeers) > 0 {
+		fetcher := &artifacts.Fetcher{
+			Peers:       artifacts.NormalizePeers(cfg.ArtifactPeers),
+			Key:         cfg.ArtifactShareKey,
+			DataDir:     "data",
+			Parallelism: cfg.ArtifactFetchParallelism,
+		}
+		if err := fetcher.FetchMissing(ctx, *circuitName); err != nil {
+			log.Fatal("Artifact fetch error:", err)
+			return
+		}
+	}
+
+	if cfg.ProverCPUs > 0 {
+		runtime.GOMAXPROCS(cfg.ProverCPUs)
+	}
+	log.Printf("Proving with %d workers on %d CPUs\n", cfg.ProverWorkers, runtime.GOMAXPROCS(0))
+
+	tuner := &gctune.Tuner{
+		Enabled:          cfg.GCTuning,
+		ProveGCPercent:   cfg.ProveGCPercent,
+		ProveMemoryLimit: cfg.ProveMemoryLimit,
+		IdleMemoryLimit:  cfg.IdleMemoryLimit,
+	}
+	tuner.Start()
+
+	loadOptions := circuitData.LoadOptions{
+		LazyProvingKey: cfg.LazyProvingKey,
+		MmapProvingKey: cfg.MmapProvingKey,
+	}
+	manifest, err := artifacts.CheckLocal("data", *circuitName, cfg.ArtifactManifestRequired)
+	if err != nil {
+		log.Fatal("Artifact verification error:", err)
+		return
+	}
+	data := circuitData.InitCircuitData(*circuitName, loadOptions)
+	recordKeys, err := loadRecordKeys(ctx, cfg)
+	if err != nil {
+		log.Fatal("Result encryption key error:", err)
+		return
+	}
 	state := &handlers.State{
-		CircuitData: data,
+		CircuitName:  *circuitName,
+		CircuitData:  &data,
+		LoadOptions:  loadOptions,
+		RedisClient:  rdb,
+		RedisBreaker: redisBreaker,
+		ResultTTL:    cfg.ResultTTL,
+		MaxResultTTL: cfg.MaxResultTTL,
+		Results:      handlers.NewRedisResultStore(rdb, cfg.ResultCompression, recordKeys),
+		Webhooks:     outbox,
+		PreVerify:    cfg.PreVerify,
+
+		ResultBufferSize: cfg.ResultBufferSize,
+		ResultBufferTTL:  cfg.ResultBufferTTL,
+		ResultSpoolDir:   cfg.ResultSpoolDir,
+		MaxScheduleDelay: cfg.MaxScheduleDelay,
+		FlipProbation:    cfg.FlipProbation,
+
+		CircuitManifest:         manifest,
+		RequireArtifactManifest: cfg.ArtifactManifestRequired,
+
+		SelfVerify:         cfg.SelfVerify,
+		SelfVerifyCircuits: cfg.SelfVerifyCircuits,
+
+		RacePeers:      cfg.RacePeers,
+		RacePeerAPIKey: cfg.RacePeerAPIKey,
+
+		RelayTimeout: cfg.RelayerTimeout,
+
+		Tokens:      tokens,
+		MaxTokenTTL: cfg.ServiceTokenMaxTTL,
+
+		GC:     tuner,
+		Prover: prover.Select(cfg.ProverBackend),
+
+		StartedAt:    time.Now(),
+		JobTimeout:   cfg.JobTimeout,
+		NodeId:       cfg.NodeID,
+		MaxAttempts:  cfg.JobMaxAttempts,
+		RetryBackoff: cfg.JobRetryBackoff,
+
+		HeartbeatInterval: cfg.JobHeartbeatInterval,
+		HeartbeatTTL:      cfg.JobHeartbeatTTL,
+
+		FailInterruptedJobs: cfg.StartupRecovery == "fail",
+
+		MaxQueueDepth:   cfg.MaxQueueDepth,
+		QueueRetryAfter: cfg.QueueRetryAfter,
+		DeadLetterTTL:   cfg.DeadLetterTTL,
+		InputTTL:        cfg.JobInputTTL,
+		UsageRetention:  cfg.UsageRetention,
+		Compression:     cfg.ResultCompression,
+
+		MaxPendingJobsPerKey: cfg.MaxPendingJobsPerKey,
+		HighPriorityBurst:    cfg.HighPriorityBurst,
+	}
+	if cfg.ProveMemoryFootprint > 0 {
+		state.Memory = &memadmit.Controller{Footprint: cfg.ProveMemoryFootprint, Timeout: cfg.MemoryAdmissionTimeout}
+		if fits, limit := state.Memory.Fits(); !fits {
+			log.Printf("PROVE_MEMORY_FOOTPRINT (%d bytes) exceeds the memory limit (%d bytes); submissions will be rejected\n", cfg.ProveMemoryFootprint, limit)
+		}
+	}
+	if cfg.CanaryCircuit != "" {
+		if err := state.LoadCanary(cfg.CanaryCircuit, cfg.CanaryPercent); err != nil {
+			log.Fatal("Canary circuit error:", err)
+			return
+		}
+	}
+	if cfg.StandbyCircuit != "" {
+		if err := state.LoadStandby(cfg.StandbyCircuit); err != nil {
+			log.Fatal("Standby circuit error:", err)
+			return
+		}
+	}
+	var pgStore *postgres.Store
+	if cfg.ResultStore == "postgres" || cfg.AuditLog == "postgres" {
+		pgStore, err = postgres.Open(ctx, cfg.PostgresURL, cfg.PostgresMaxConns)
+		if err != nil {
+			log.Fatalf("Failed to connect to Postgres: %v", err)
+		}
+	}
+	if cfg.ResultStore == "memory" {
+		state.Results = memstore.NewResults()
+	}
+	if !cfg.SharedRedis() {
+		state.Pending = memstore.NewPending()
+	}
+	if cfg.ResultStore == "postgres" {
+		pgStore.HistoryRetention = cfg.PostgresHistoryRetention
+		duties = append(duties, func(ctx context.Context) { pgStore.Run(ctx, time.Hour) })
+		state.Results = pgStore
+	}
+	switch cfg.AuditLog {
+	case "redis":
+		state.Audit = audit.NewRedisStore(rdb)
+	case "postgres":
+		auditLog, err := pgStore.AuditLog(ctx)
+		if err != nil {
+			log.Fatalf("Failed to create the audit log in Postgres: %v", err)
+		}
+		state.Audit = auditLog
+	}
+	if state.Audit != nil {
+		duties = append(duties, func(ctx context.Context) { audit.Run(ctx, state.Audit, cfg.AuditRetention, time.Hour) })
+	}
+	if cfg.ObjectStoreEndpoint != "" {
+		state.Objects, err = objectstore.New(cfg.ObjectStoreEndpoint, cfg.ObjectStoreBucket, cfg.ObjectStoreRegion, cfg.ObjectStoreAccessKeyID, cfg.ObjectStoreSecretAccessKey)
+		if err != nil {
+			log.Fatalf("Failed to configure object store: %v", err)
+		}
+		state.ObjectStorePrefix = cfg.ObjectStorePrefix
+		state.OffloadMinBytes = cfg.ObjectStoreMinBytes
+		state.OffloadedResultTTL = cfg.ObjectStoreResultTTL
+	}
+	if cfg.ReceiptSigningKey != "" {
+		state.Receipts, err = receipt.NewIssuer(cfg.ReceiptSigningKey, rdb, cfg.NodeID)
+		if err != nil {
+			log.Fatal("Receipt issuer initialization error:", err)
+			return
+		}
+	}
+	if cfg.ResultSigningKey != "" {
+		state.ResultSigner, err = resultsig.NewSigner(cfg.ResultSigningKey)
+		if err != nil {
+			log.Fatal("Result signer initialization error:", err)
+			return
+		}
+		log.Println("Signing results as", state.ResultSigner.Address().Hex())
+	}
+	if cfg.TEEAttestation != "" {
+		state.Attester, err = attestation.New(cfg.TEEAttestation)
+		if cfg.TEEAttestation == "auto" && errors.Is(err, attestation.ErrUnavailable) {
+			log.Println("Not running in a trusted execution environment; attestation is disabled")
+		} else if err != nil {
+			log.Fatal("Attestation initialization error:", err)
+			return
+		} else {
+			log.Println("Attesting the", state.Attester.Platform(), "environment")
+		}
+	}
+	if cfg.RelayerRPCURL != "" {
+		state.Relayer, err = relayer.New(ctx, cfg.RelayerRPCURL, cfg.RelayerPrivateKey, cfg.RelayerContract, cfg.RelayerMethod)
+		if err != nil {
+			log.Fatal("Relayer initialization error:", err)
+			return
+		}
+		state.Relayer.GasLimit = uint64(cfg.RelayerGasLimit)
+		log.Println("Relaying proofs from", state.Relayer.From().Hex())
+	}
+	if cfg.VerifierContract != "" {
+		state.Simulator, err = relayer.NewSimulator(ctx, cfg.SimulationRPCURL, cfg.VerifierContract)
+		if err != nil {
+			log.Fatal("Simulator initialization error:", err)
+			return
+		}
+	}
+	switch cfg.PaymentVerifier {
+	case "http":
+		state.Payments = &payment.HTTPVerifier{URL: cfg.PaymentServiceURL, Token: cfg.PaymentServiceToken, Timeout: cfg.PaymentTimeout}
+	case "onchain":
+		minFee, _ := new(big.Int).SetString(cfg.PaymentMinFee, 10)
+		state.Payments, err = payment.NewOnChainVerifier(ctx, cfg.PaymentRPCURL, cfg.PaymentRecipient, minFee, uint64(cfg.PaymentConfirmations))
+		if err != nil {
+			log.Fatal("Payment verifier initialization error:", err)
+			return
+		}
+	}
+	if state.Payments != nil {
+		log.Println("Requiring payment for submissions, verified by", cfg.PaymentVerifier)
+	}
+	state.SLO = &slo.Recorder{
+		Window:        cfg.SLOWindow,
+		Target:        cfg.SLOLatencyTarget,
+		Percentile:    cfg.SLOPercentile,
+		BreachPeriods: cfg.SLOBreachPeriods,
+	}
+	go state.SLO.Run(ctx, cfg.SLOEvalInterval)
+	if err := state.LoadResultSpool(); err != nil {
+		log.Fatal("Result spool error:", err)
+		return
+	}
+	go state.RunResultBuffer(ctx, time.Second)
+	if cfg.QueueBackend == "nats" {
+		conn, err := nats.Connect(cfg.NATSURL, nats.Name("gnark-server "+cfg.NodeID), nats.MaxReconnects(-1))
+		if err != nil {
+			log.Fatal("NATS connection error:", err)
+			return
+		}
+		err = state.UseJetStream(ctx, conn, handlers.NATSQueueConfig{
+			Stream:   cfg.NATSStream,
+			Subject:  cfg.NATSSubject,
+			AckWait:  cfg.NATSAckWait,
+			Replicas: cfg.NATSReplicas,
+		})
+		if err != nil {
+			log.Fatal("JetStream initialization error:", err)
+			return
+		}
+	}
+	state.StartWorkers(cfg.ProverWorkers)
+	if err := state.RecoverJobs(ctx); err != nil {
+		log.Printf("Failed to recover interrupted jobs: %v\n", err)
+	}
+	duties = append(duties, state.RunReaper)
+	go state.RunScheduler(ctx, time.Second)
+	if cfg.QueueBackend == "kafka" {
+		state.KafkaTenant = cfg.KafkaTenant
+		maxInFlight := cfg.KafkaMaxInFlight
+		if maxInFlight == 0 {
+			maxInFlight = 2 * cfg.ProverWorkers
+		}
+		consumer := &kafka.Consumer{
+			Config: kafka.Config{
+				Brokers:  cfg.KafkaBrokers,
+				ClientId: "gnark-server-" + cfg.NodeID,
+				TLS:      cfg.KafkaTLS,
+				Username: cfg.KafkaUsername,
+				Password: cfg.KafkaPassword,
+			},
+			Group:            cfg.KafkaGroup,
+			Topics:           cfg.KafkaTopics,
+			SessionTimeout:   cfg.KafkaSessionTimeout,
+			RebalanceTimeout: 2 * cfg.KafkaSessionTimeout,
+			CommitInterval:   5 * time.Second,
+			FromLatest:       cfg.KafkaStartOffset == "latest",
+			MaxInFlight:      maxInFlight,
+		}
+		log.Println("Consuming start-proof requests from Kafka topics", cfg.KafkaTopics, "in group", cfg.KafkaGroup)
+		go state.ConsumeKafka(ctx, consumer)
+	}
+	if cfg.LeaderElection {
+		elector := &leader.Elector{Client: rdb, Key: rediskey.Key("leader"), Id: cfg.NodeID + ":" + uuid.NewString(), Lease: cfg.LeaderLease}
+		go elector.Run(ctx, duties...)
+	} else {
+		for _, duty := range duties {
+			go duty(ctx)
+		}
+	}
+	if cfg.WarmUpProve {
+		state.WarmUp(cfg.WarmUpProofFile)
+	}
+	if err := state.RecordVkRotation(ctx); err != nil {
+		log.Printf("Failed to record vk rotation: %v\n", err)
+	}
+
+	reporter := &fleet.Reporter{
 		RedisClient: rdb,
+		NodeId:      cfg.NodeID,
+		Config:      cfg.Effective(),
+		DataDir:     "data",
+		Circuit:     state.LoadedCircuit,
+		Interval:    cfg.FleetReportInterval,
 	}
+	go reporter.Run(ctx)
 
 	http.HandleFunc("/health", handlers.HealthHandler)
-	http.HandleFunc("/start-proof", state.StartProof)
-	http.HandleFunc("/get-proof", state.GetProof)
-	log.Println("Server is running on port " + port)
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
-		panic(err)
+	http.HandleFunc("/ready", state.Ready)
+	apiversion.HandleFunc(http.DefaultServeMux, "/verifier/solidity", state.VerifierSolidity)
+	apiversion.HandleFunc(http.DefaultServeMux, "/circuit/info", state.CircuitInfo)
+	apiversion.HandleFunc(http.DefaultServeMux, "/circuit/", state.CircuitBench)
+	apiversion.HandleFunc(http.DefaultServeMux, "/version", state.Version)
+	apiversion.HandleFunc(http.DefaultServeMux, "/changelog", state.Changelog)
+	apiversion.HandleFunc(http.DefaultServeMux, "/openapi.json", state.OpenAPI)
+	if cfg.OpenAPIUI {
+		http.HandleFunc("/docs", handlers.SwaggerUI)
+	}
+	if state.Receipts != nil {
+		apiversion.Handle(http.DefaultServeMux, "/receipt/public-key", state.Receipts)
+	}
+	if state.ResultSigner != nil {
+		apiversion.Handle(http.DefaultServeMux, "/result/signer", state.ResultSigner)
+	}
+	if state.Attester != nil {
+		apiversion.HandleFunc(http.DefaultServeMux, "/attestation", state.Attestation)
+	}
+	http.Handle("/artifacts/", &artifacts.Server{DataDir: "data", Key: cfg.ArtifactShareKey})
+	bodyLimiter := &spool.Limiter{
+		MaxBodyBytes: cfg.MaxRequestBodyBytes,
+		MemoryLimit:  cfg.MaxInflightBodyMemoryBytes,
+		DiskLimit:    cfg.BodySpoolMaxBytes,
+		Dir:          cfg.BodySpoolDir,
+	}
+	apiversion.HandleFunc(http.DefaultServeMux, "/start-proof", keyStore.Middleware(bodyLimiter.Middleware(state.StartProof)))
+	apiversion.HandleFunc(http.DefaultServeMux, "/get-proof", keyStore.Middleware(state.GetProof))
+	apiversion.HandleFunc(http.DefaultServeMux, "/prove/dry-run", keyStore.Middleware(bodyLimiter.Middleware(state.DryRun)))
+	apiversion.HandleFunc(http.DefaultServeMux, "/start-dag", keyStore.Middleware(bodyLimiter.Middleware(state.StartDag)))
+	apiversion.HandleFunc(http.DefaultServeMux, "/get-dag", keyStore.Middleware(state.GetDag))
+	apiversion.HandleFunc(http.DefaultServeMux, "/usage", keyStore.Middleware(state.Usage))
+	// /jobs/ itself is the admin API.
+	for _, version := range apiversion.Supported {
+		http.HandleFunc(apiversion.Prefix(version)+"/jobs/", apiversion.With(version, keyStore.Middleware(state.RetryJob)))
+	}
+
+	admin := func(next http.HandlerFunc) http.HandlerFunc {
+		return keyStore.AdminMiddleware(cfg.AdminAPIKey, next)
+	}
+	http.HandleFunc("/jobs", admin(state.ListJobs))
+	http.HandleFunc("/jobs/", admin(state.JobAction))
+	http.HandleFunc("/admin/runbook/", admin(state.Runbook))
+	http.HandleFunc("/admin/changelog", admin(state.AnnounceChange))
+	http.HandleFunc("/admin/circuit", admin(state.CircuitAdmin))
+	http.HandleFunc("/admin/circuit/", admin(state.CircuitAdmin))
+	http.HandleFunc("/admin/tokens", admin(state.MintToken))
+	http.HandleFunc("/admin/fleet", admin(reporter.ServeHTTP))
+	http.HandleFunc("/admin/gc", admin(tuner.ServeHTTP))
+	http.HandleFunc("/admin/drain", admin(state.Drain))
+	http.HandleFunc("/admin/dead-letter", admin(state.DeadLetters))
+	http.HandleFunc("/admin/dead-letter/requeue", admin(state.RequeueJob))
+	http.HandleFunc("/admin/jobs", admin(state.DeleteJob))
+	http.HandleFunc("/admin/jobs/purge", admin(state.PurgeJobs))
+	http.HandleFunc("/admin/jobs/requeue", admin(state.RequeueJob))
+	http.HandleFunc("/admin/stats", admin(state.Stats))
+	http.HandleFunc("/admin/usage", admin(state.UsageAdmin))
+	http.HandleFunc("/admin/slo", admin(state.SLO.ServeHTTP))
+	// Its series are labelled with tenant names.
+	http.HandleFunc("/metrics", admin(state.SLO.ServeMetrics))
+	http.HandleFunc("/admin/audit", admin(state.AuditLog))
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		apierror.Error(w, "Not found", http.StatusNotFound)
+	})
+
+	handler := profiling.Middleware(cfg.PprofEnabled, admin, http.DefaultServeMux)
+	allowlistPaths := make(map[string][]string)
+	if len(cfg.AdminIPAllowlist) > 0 {
+		for _, prefix := range []string{"/admin/", "/jobs", "/debug/pprof/"} {
+			allowlistPaths[prefix] = cfg.AdminIPAllowlist
+		}
+	}
+	for prefix, cidrs := range cfg.IPAllowlistPaths {
+		allowlistPaths[prefix] = cidrs
+		// The client APIs are also served under each version prefix.
+		for _, version := range apiversion.Supported {
+			if _, ok := cfg.IPAllowlistPaths[apiversion.Prefix(version)+prefix]; !ok {
+				allowlistPaths[apiversion.Prefix(version)+prefix] = cidrs
+			}
+		}
+	}
+	filter, err := ipfilter.New(cfg.IPAllowlist, allowlistPaths, cfg.TrustedProxies)
+	if err != nil {
+		log.Fatalf("Invalid IP allowlist: %v", err)
+	}
+	corsPolicy := &cors.Policy{
+		Origins:        cfg.CORSAllowedOrigins,
+		Methods:        cfg.CORSAllowedMethods,
+		Headers:        cfg.CORSAllowedHeaders,
+		ExposedHeaders: cfg.CORSExposedHeaders,
+		MaxAge:         cfg.CORSMaxAge,
+	}
+	handler = filter.Middleware(corsPolicy.Middleware(handler))
+	if cfg.AccessLog {
+		accessLogger := &accesslog.Logger{
+			SampleRate:    cfg.AccessLogSampleRate,
+			SlowThreshold: cfg.AccessLogSlowThreshold,
+			SkipPaths:     cfg.AccessLogSkipPaths,
+			ClientIP:      filter.ClientIP,
+		}
+		handler = accessLogger.Middleware(handler)
+	}
+	handler = apierror.Middleware(handler)
+	serveErrors := make(chan error, 2)
+	if cfg.UnixSocket != "" {
+		listener, err := listenUnix(cfg.UnixSocket, cfg.UnixSocketMode)
+		if err != nil {
+			log.Fatalf("Failed to listen on %s: %v", cfg.UnixSocket, err)
+		}
+		log.Println("Server is running on unix socket " + cfg.UnixSocket)
+		go func() { serveErrors <- http.Serve(listener, ipfilter.Exempt(handler)) }()
+	}
+	if cfg.Port != "" {
+		log.Println("Server is running on port " + cfg.Port)
+		go func() { serveErrors <- http.ListenAndServe(":"+cfg.Port, handler) }()
+	}
+	panic(<-serveErrors)
+}
+
+// listenUnix listens on a unix socket at path with the permissions of mode,
+// replacing a socket left behind by a previous run but not one still served.
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if info, err := os.Stat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// loadRecordKeys returns the keys encrypting the records stored in Redis, or
+// nil if encryption is not enabled.
+func loadRecordKeys(ctx context.Context, cfg *config.Config) (*atrest.Keyring, error) {
+	var current []byte
+	var err error
+	switch {
+	case cfg.ResultEncryptionKey != "":
+		current, err = atrest.ParseKey(cfg.ResultEncryptionKey)
+	case cfg.ResultEncryptionKeyFile != "":
+		var keyFile []byte
+		if keyFile, err = os.ReadFile(cfg.ResultEncryptionKeyFile); err == nil {
+			current, err = atrest.ParseKey(string(keyFile))
+		}
+	case cfg.ResultEncryptionKMSCiphertext != "":
+		var ciphertext []byte
+		if ciphertext, err = base64.StdEncoding.DecodeString(cfg.ResultEncryptionKMSCiphertext); err != nil {
+			return nil, fmt.Errorf("RESULT_ENCRYPTION_KMS_CIPHERTEXT is not base64: %w", err)
+		}
+		client := &kms.Client{
+			Region: cfg.ResultEncryptionKMSRegion,
+			Credentials: sigv4.Credentials{
+				AccessKey:    cfg.AWSAccessKeyID,
+				SecretKey:    cfg.AWSSecretAccessKey,
+				SessionToken: cfg.AWSSessionToken,
+			},
+		}
+		current, err = client.Decrypt(ctx, ciphertext)
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var previous [][]byte
+	for _, encoded := range cfg.ResultEncryptionPreviousKeys {
+		key, err := atrest.ParseKey(encoded)
+		if err != nil {
+			return nil, err
+		}
+		previous = append(previous, key)
+	}
+	keys, err := atrest.NewKeyring(current, previous...)
+	if err != nil {
+		return nil, err
+	}
+	log.Println("Encrypting records stored in Redis")
+	return keys, nil
+}
+
+// newRedisClient connects to Redis as configured: through Sentinel, to a
+// cluster, to REDIS_URL or, in memory mode, to an in-process store.
+func newRedisClient(cfg *config.Config) (redis.UniversalClient, error) {
+	var tlsConfig *tls.Config
+	if cfg.RedisTLS {
+		tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	// go-redis reads 0 as its default; -1 disables retries.
+	maxRetries := cfg.RedisMaxRetries
+	if maxRetries == 0 {
+		maxRetries = -1
+	}
+	switch {
+	case cfg.ResultStore == "memory":
+		log.Println("Keeping all state in memory; it is lost when the server stops")
+		return memstore.NewRedis()
+	case !cfg.SharedRedis():
+		log.Println("Keeping results in Postgres and the other state in memory; queued jobs are lost when the server stops")
+		return memstore.NewRedis()
+	case len(cfg.RedisSentinelAddrs) > 0:
+		log.Printf("Connecting to Redis master %s through Sentinel %v\n", cfg.RedisSentinelMaster, cfg.RedisSentinelAddrs)
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       cfg.RedisSentinelMaster,
+			SentinelAddrs:    cfg.RedisSentinelAddrs,
+			SentinelPassword: cfg.RedisSentinelPassword,
+			Username:         cfg.RedisUsername,
+			Password:         cfg.RedisPassword,
+			DB:               cfg.RedisDB,
+			TLSConfig:        tlsConfig,
+			MaxRetries:       maxRetries,
+			MinRetryBackoff:  cfg.RedisMinRetryBackoff,
+			MaxRetryBackoff:  cfg.RedisMaxRetryBackoff,
+		}), nil
+	case len(cfg.RedisClusterAddrs) > 0:
+		log.Printf("Connecting to Redis Cluster %v\n", cfg.RedisClusterAddrs)
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:           cfg.RedisClusterAddrs,
+			Username:        cfg.RedisUsername,
+			Password:        cfg.RedisPassword,
+			TLSConfig:       tlsConfig,
+			MaxRetries:      maxRetries,
+			MinRetryBackoff: cfg.RedisMinRetryBackoff,
+			MaxRetryBackoff: cfg.RedisMaxRetryBackoff,
+		}), nil
+	}
+	opt, err := redis.ParseURL(cfg.RedisURL)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.RedisDB != 0 {
+		opt.DB = cfg.RedisDB
 	}
+	opt.MaxRetries = maxRetries
+	opt.MinRetryBackoff = cfg.RedisMinRetryBackoff
+	opt.MaxRetryBackoff = cfg.RedisMaxRetryBackoff
+	return redis.NewClient(opt), nil
 }
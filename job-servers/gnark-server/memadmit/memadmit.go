@@ -0,0 +1,179 @@
+// Package memadmit holds proves back until the memory available to the
+// process, within its cgroup limit, can fit one more, so that a node waits
+// instead of being OOM-killed with all its in-flight work.
+package memadmit
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pollInterval is how often the available memory is checked while a prove
+// waits for it.
+const pollInterval = time.Second
+
+var ErrInsufficientMemory = errors.New("not enough memory available to prove")
+
+// Controller admits proves of Footprint bytes each. Running proves are
+// counted as still needing their whole footprint, as they may not have
+// allocated it yet.
+type Controller struct {
+	Footprint int64
+	// Timeout bounds the wait for memory to become available.
+	Timeout time.Duration
+
+	mu      sync.Mutex
+	running int
+}
+
+// Acquire waits until available memory fits one more prove, returning a
+// function to call when it is done, or ErrInsufficientMemory after Timeout.
+func (c *Controller) Acquire(ctx context.Context) (func(), error) {
+	deadline := time.Now().Add(c.Timeout)
+	for {
+		ok, available := c.tryAcquire()
+		if ok {
+			return c.release, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("%w: %d bytes available, %d needed", ErrInsufficientMemory, available, c.needed())
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+func (c *Controller) tryAcquire() (bool, int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Proves are not held back when memory cannot be measured.
+	available, _, err := Available()
+	if err == nil && available < c.Footprint*int64(c.running+1) {
+		return false, available
+	}
+	c.running++
+	return true, available
+}
+
+func (c *Controller) needed() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Footprint * int64(c.running+1)
+}
+
+func (c *Controller) release() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.running--
+}
+
+// Fits reports whether a prove fits in the memory limit of the process at
+// all, and the limit.
+func (c *Controller) Fits() (bool, int64) {
+	_, limit, err := Available()
+	if err != nil {
+		return true, 0
+	}
+	return c.Footprint <= limit, limit
+}
+
+// Available returns the memory available to the process and its limit in
+// bytes: the headroom under the cgroup limit (v2 or v1), not counting
+// reclaimable page cache, or the system's available memory if lower.
+func Available() (available int64, limit int64, err error) {
+	meminfo, err := readKeyValues("/proc/meminfo")
+	if err != nil {
+		return 0, 0, err
+	}
+	// /proc/meminfo is in kB.
+	available, limit = meminfo["MemAvailable"]*1024, meminfo["MemTotal"]*1024
+	if limit == 0 {
+		return 0, 0, fmt.Errorf("no MemTotal in /proc/meminfo")
+	}
+
+	cgroupLimit, usage, ok := cgroupV2()
+	if !ok {
+		cgroupLimit, usage, ok = cgroupV1()
+	}
+	if ok && cgroupLimit < limit {
+		limit = cgroupLimit
+		if headroom := cgroupLimit - usage; headroom < available {
+			available = headroom
+		}
+	}
+	if available < 0 {
+		available = 0
+	}
+	return available, limit, nil
+}
+
+func cgroupV2() (limit int64, usage int64, ok bool) {
+	max, err := readInt("/sys/fs/cgroup/memory.max")
+	if err != nil {
+		return 0, 0, false
+	}
+	current, err := readInt("/sys/fs/cgroup/memory.current")
+	if err != nil {
+		return 0, 0, false
+	}
+	stat, _ := readKeyValues("/sys/fs/cgroup/memory.stat")
+	return max, current - stat["inactive_file"], true
+}
+
+func cgroupV1() (limit int64, usage int64, ok bool) {
+	max, err := readInt("/sys/fs/cgroup/memory/memory.limit_in_bytes")
+	if err != nil {
+		return 0, 0, false
+	}
+	current, err := readInt("/sys/fs/cgroup/memory/memory.usage_in_bytes")
+	if err != nil {
+		return 0, 0, false
+	}
+	stat, _ := readKeyValues("/sys/fs/cgroup/memory/memory.stat")
+	return max, current - stat["total_inactive_file"], true
+}
+
+// readInt reads a number from a cgroup file, "max" being unlimited.
+func readInt(path string) (int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	s := strings.TrimSpace(string(data))
+	if s == "max" {
+		return math.MaxInt64, nil
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+// readKeyValues reads "key value" or "key: value kB" lines.
+func readKeyValues(path string) (map[string]int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	values := make(map[string]int64)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		if v, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+			values[strings.TrimSuffix(fields[0], ":")] = v
+		}
+	}
+	return values, scanner.Err()
+}
@@ -0,0 +1,172 @@
+// Package memstore keeps the server state in-process, for development and
+// tests without Redis: job records and pending-job quotas in maps with
+// expiry, and the rest of the state Redis would hold in an embedded Redis.
+// Everything is lost when the process exits.
+package memstore
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const sweepInterval = time.Minute
+
+type record struct {
+	data     []byte
+	expireAt time.Time
+}
+
+func (r record) expired(now time.Time) bool {
+	return !r.expireAt.IsZero() && !now.Before(r.expireAt)
+}
+
+// expiry returns when a record stored now with ttl expires, the zero time
+// being never, as with Redis.
+func expiry(now time.Time, ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return now.Add(ttl)
+}
+
+// Results is a result store keeping job records in a map. A negative ttl
+// keeps the current expiry of a record, and a zero ttl never expires it.
+type Results struct {
+	mu      sync.Mutex
+	records map[string]record
+}
+
+func NewResults() *Results {
+	r := &Results{records: make(map[string]record)}
+	go r.sweep()
+	return r
+}
+
+// sweep drops expired records, which are otherwise only dropped when read.
+func (r *Results) sweep() {
+	for range time.Tick(sweepInterval) {
+		r.mu.Lock()
+		now := time.Now()
+		for jobId, rec := range r.records {
+			if rec.expired(now) {
+				delete(r.records, jobId)
+			}
+		}
+		r.mu.Unlock()
+	}
+}
+
+func (r *Results) Reserve(ctx context.Context, jobId string, data []byte, ttl time.Duration) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	if rec, ok := r.records[jobId]; ok && !rec.expired(now) {
+		return false, nil
+	}
+	r.records[jobId] = record{data: append([]byte(nil), data...), expireAt: expiry(now, ttl)}
+	return true, nil
+}
+
+func (r *Results) Get(ctx context.Context, jobId string) ([]byte, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rec, ok := r.records[jobId]
+	if !ok {
+		return nil, false, nil
+	}
+	if rec.expired(time.Now()) {
+		delete(r.records, jobId)
+		return nil, false, nil
+	}
+	return append([]byte(nil), rec.data...), true, nil
+}
+
+func (r *Results) Set(ctx context.Context, jobId string, data []byte, ttl time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	rec := record{data: append([]byte(nil), data...), expireAt: expiry(now, ttl)}
+	if old, ok := r.records[jobId]; ok && ttl < 0 && !old.expired(now) {
+		rec.expireAt = old.expireAt
+	}
+	r.records[jobId] = rec
+	return nil
+}
+
+func (r *Results) Delete(ctx context.Context, jobId string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.records, jobId)
+	return nil
+}
+
+// Pending counts the pending jobs of each tenant, as the tenant_pending
+// sorted sets do in Redis: jobs are added with the time they were submitted
+// and dropped when they finish, or once older than the result TTL.
+type Pending struct {
+	mu      sync.Mutex
+	tenants map[string]map[string]time.Time
+}
+
+func NewPending() *Pending {
+	return &Pending{tenants: make(map[string]map[string]time.Time)}
+}
+
+// Claim adds the jobIds not pending yet to the pending jobs of tenant,
+// unless that would make more than limit, after dropping those submitted
+// before now minus ttl. It returns the jobIds added and the pending count,
+// before the claim if it was refused.
+func (p *Pending) Claim(tenant string, limit int, jobIds []string, now time.Time, ttl time.Duration) ([]string, int, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	jobs := p.tenants[tenant]
+	if jobs == nil {
+		jobs = make(map[string]time.Time)
+		p.tenants[tenant] = jobs
+	}
+	for jobId, submittedAt := range jobs {
+		if !submittedAt.After(now.Add(-ttl)) {
+			delete(jobs, jobId)
+		}
+	}
+	var added []string
+	for _, jobId := range jobIds {
+		if _, ok := jobs[jobId]; !ok {
+			added = append(added, jobId)
+		}
+	}
+	count := len(jobs)
+	if count+len(added) > limit {
+		return nil, count, false
+	}
+	for _, jobId := range added {
+		jobs[jobId] = now
+	}
+	return added, count + len(added), true
+}
+
+// Add counts jobId as pending for tenant, submitted at now.
+func (p *Pending) Add(tenant string, jobId string, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	jobs := p.tenants[tenant]
+	if jobs == nil {
+		jobs = make(map[string]time.Time)
+		p.tenants[tenant] = jobs
+	}
+	jobs[jobId] = now
+}
+
+// Remove stops counting jobIds as pending for tenant.
+func (p *Pending) Remove(tenant string, jobIds ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	jobs := p.tenants[tenant]
+	for _, jobId := range jobIds {
+		delete(jobs, jobId)
+	}
+	if len(jobs) == 0 {
+		delete(p.tenants, tenant)
+	}
+}
@@ -0,0 +1,101 @@
+package memstore
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestResults(t *testing.T) {
+	ctx := context.Background()
+	r := NewResults()
+
+	if reserved, _ := r.Reserve(ctx, "job", []byte("pending"), time.Hour); !reserved {
+		t.Fatal("Reserve of a new job failed")
+	}
+	if reserved, _ := r.Reserve(ctx, "job", []byte("other"), time.Hour); reserved {
+		t.Fatal("Reserve of a reserved job succeeded")
+	}
+	r.Set(ctx, "job", []byte("done"), -1)
+	if data, found, _ := r.Get(ctx, "job"); !found || string(data) != "done" {
+		t.Fatalf("Get = %q, %v", data, found)
+	}
+	if expireAt := r.records["job"].expireAt; time.Until(expireAt) < 59*time.Minute {
+		t.Fatalf("Set with a negative ttl changed the expiry to %v", expireAt)
+	}
+	r.Delete(ctx, "job")
+	if _, found, _ := r.Get(ctx, "job"); found {
+		t.Fatal("deleted job is found")
+	}
+}
+
+func TestResultsExpiry(t *testing.T) {
+	ctx := context.Background()
+	r := NewResults()
+
+	r.Set(ctx, "job", []byte("done"), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if _, found, _ := r.Get(ctx, "job"); found {
+		t.Fatal("expired job is found")
+	}
+	if reserved, _ := r.Reserve(ctx, "job", []byte("pending"), 0); !reserved {
+		t.Fatal("Reserve of an expired job failed")
+	}
+	if !r.records["job"].expireAt.IsZero() {
+		t.Fatal("record stored with a zero ttl expires")
+	}
+}
+
+func TestPendingClaim(t *testing.T) {
+	p := NewPending()
+	now := time.Now()
+
+	claimed, count, ok := p.Claim("tenant", 2, []string{"a", "b"}, now, time.Hour)
+	if !ok || count != 2 || len(claimed) != 2 {
+		t.Fatalf("Claim = %v, %d, %v", claimed, count, ok)
+	}
+	// Claiming a pending job again adds nothing.
+	if claimed, count, ok := p.Claim("tenant", 2, []string{"a"}, now, time.Hour); !ok || count != 2 || len(claimed) != 0 {
+		t.Fatalf("Claim of a pending job = %v, %d, %v", claimed, count, ok)
+	}
+	if _, count, ok := p.Claim("tenant", 2, []string{"c"}, now, time.Hour); ok || count != 2 {
+		t.Fatalf("Claim over the limit = %d, %v", count, ok)
+	}
+	// Other tenants have their own count.
+	if _, _, ok := p.Claim("other", 2, []string{"c"}, now, time.Hour); !ok {
+		t.Fatal("Claim of another tenant failed")
+	}
+
+	p.Remove("tenant", "a")
+	if _, count, ok := p.Claim("tenant", 2, []string{"c"}, now, time.Hour); !ok || count != 2 {
+		t.Fatalf("Claim after a removal = %d, %v", count, ok)
+	}
+}
+
+func TestPendingDropsJobsOlderThanTTL(t *testing.T) {
+	p := NewPending()
+	now := time.Now()
+	p.Add("tenant", "old", now.Add(-2*time.Hour))
+	if _, count, ok := p.Claim("tenant", 1, []string{"new"}, now, time.Hour); !ok || count != 1 {
+		t.Fatalf("Claim = %d, %v", count, ok)
+	}
+}
+
+func TestRedisExpiresKeys(t *testing.T) {
+	rdb, err := NewRedis()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rdb.Close()
+	ctx := context.Background()
+	if err := rdb.Set(ctx, "key", "value", time.Second).Err(); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for rdb.Exists(ctx, "key").Val() == 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("key did not expire")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
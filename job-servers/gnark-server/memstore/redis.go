@@ -0,0 +1,29 @@
+package memstore
+
+import (
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// expiryInterval is how often the keys of the embedded Redis are expired.
+const expiryInterval = time.Second
+
+// NewRedis starts an embedded Redis, listening on a loopback port, for the
+// state other than job records and quotas, and returns a client of it.
+func NewRedis() (*redis.Client, error) {
+	server := miniredis.NewMiniRedis()
+	if err := server.StartAddr("127.0.0.1:0"); err != nil {
+		return nil, err
+	}
+	// miniredis only expires keys when its clock is moved forward.
+	go func() {
+		last := time.Now()
+		for now := range time.Tick(expiryInterval) {
+			server.FastForward(now.Sub(last))
+			last = now
+		}
+	}()
+	return redis.NewClient(&redis.Options{Addr: server.Addr()}), nil
+}
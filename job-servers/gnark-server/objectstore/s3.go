@@ -0,0 +1,141 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"gnark-server/sigv4"
+)
+
+// ErrNotFound is returned by Get for a missing object.
+var ErrNotFound = errors.New("object not found")
+
+// Client stores objects in a bucket of an S3-compatible store, signing
+// requests with AWS Signature Version 4. Google Cloud Storage is reached
+// through its XML API at https://storage.googleapis.com with HMAC keys and
+// region "auto". Objects are addressed path-style, endpoint/bucket/key.
+type Client struct {
+	Endpoint   *url.URL
+	Bucket     string
+	Region     string
+	AccessKey  string
+	SecretKey  string
+	HTTPClient *http.Client
+}
+
+func New(endpoint, bucket, region, accessKey, secretKey string) (*Client, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("object store endpoint must be an absolute http(s) URL")
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	return &Client{
+		Endpoint:   u,
+		Bucket:     bucket,
+		Region:     region,
+		AccessKey:  accessKey,
+		SecretKey:  secretKey,
+		HTTPClient: &http.Client{Timeout: time.Minute},
+	}, nil
+}
+
+func (c *Client) Put(ctx context.Context, key string, data []byte) error {
+	_, err := c.do(ctx, http.MethodPut, key, data)
+	return err
+}
+
+func (c *Client) Get(ctx context.Context, key string) ([]byte, error) {
+	return c.do(ctx, http.MethodGet, key, nil)
+}
+
+// Delete removes an object; deleting a missing object is not an error.
+func (c *Client) Delete(ctx context.Context, key string) error {
+	_, err := c.do(ctx, http.MethodDelete, key, nil)
+	if err == ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+func (c *Client) newRequest(ctx context.Context, method, key string, body []byte) (*http.Request, error) {
+	u := *c.Endpoint
+	u.Path = c.Endpoint.Path + "/" + c.Bucket + "/" + key
+	u.RawPath = escapePath(u.Path)
+	return http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+}
+
+func (c *Client) do(ctx context.Context, method, key string, body []byte) ([]byte, error) {
+	req, err := c.newRequest(ctx, method, key, body)
+	if err != nil {
+		return nil, err
+	}
+	payloadHash := sha256.Sum256(body)
+	c.sign(req, hex.EncodeToString(payloadHash[:]), time.Now())
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp, method, key); err != nil {
+		return nil, err
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// OpenRange streams length bytes of an object from offset.
+func (c *Client) OpenRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	payloadHash := sha256.Sum256(nil)
+	c.sign(req, hex.EncodeToString(payloadHash[:]), time.Now())
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkStatus(resp, http.MethodGet, key); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return nil, fmt.Errorf("object store GET %s: range not honoured: %s", key, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+func checkStatus(resp *http.Response, method, key string) error {
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	if resp.StatusCode/100 != 2 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("object store %s %s: %s: %s", method, key, resp.Status, data)
+	}
+	return nil
+}
+
+func (c *Client) sign(req *http.Request, payloadHash string, now time.Time) {
+	sigv4.Sign(req, payloadHash, c.Region, "s3", sigv4.Credentials{AccessKey: c.AccessKey, SecretKey: c.SecretKey}, now)
+}
+
+// escapePath encodes an object path as Signature Version 4 expects.
+func escapePath(path string) string {
+	return sigv4.EscapePath(path)
+}
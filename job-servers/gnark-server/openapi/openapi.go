@@ -0,0 +1,280 @@
+// Package openapi builds an OpenAPI 3 document from Go types, reading the
+// json tags of struct fields and an optional openapi tag with
+// comma-separated options:
+//
+//	required        the field is always set in requests or responses
+//	enum=a|b        the allowed values
+//	format=uuid     the string format
+//	-               the field is never sent to clients
+//
+// and a doc tag describing the field.
+package openapi
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const Version = "3.0.3"
+
+type Document struct {
+	OpenAPI    string               `json:"openapi"`
+	Info       Info                 `json:"info"`
+	Paths      map[string]*PathItem `json:"paths"`
+	Components Components           `json:"components"`
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema         `json:"schemas"`
+	SecuritySchemes map[string]*SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type SecurityScheme struct {
+	Type   string `json:"type"`
+	Scheme string `json:"scheme,omitempty"`
+	In     string `json:"in,omitempty"`
+	Name   string `json:"name,omitempty"`
+}
+
+// PathItem maps lowercase HTTP methods to their operation.
+type PathItem map[string]*Operation
+
+type Operation struct {
+	OperationId string                `json:"operationId"`
+	Summary     string                `json:"summary"`
+	Description string                `json:"description,omitempty"`
+	Tags        []string              `json:"tags,omitempty"`
+	Parameters  []Parameter           `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]*Response  `json:"responses"`
+	Security    []map[string][]string `json:"security,omitempty"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                  `json:"required"`
+	Content  map[string]*MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string                `json:"description"`
+	Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	AllOf                []*Schema          `json:"allOf,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	durationType   = reflect.TypeOf(time.Duration(0))
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+
+	unmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+)
+
+// Builder collects operations and the component schemas of the types they
+// use.
+type Builder struct {
+	doc Document
+}
+
+func NewBuilder(info Info) *Builder {
+	return &Builder{doc: Document{
+		OpenAPI:    Version,
+		Info:       info,
+		Paths:      map[string]*PathItem{},
+		Components: Components{Schemas: map[string]*Schema{}, SecuritySchemes: map[string]*SecurityScheme{}},
+	}}
+}
+
+func (b *Builder) AddSecurityScheme(name string, scheme *SecurityScheme) {
+	b.doc.Components.SecuritySchemes[name] = scheme
+}
+
+// Add registers op under method and path.
+func (b *Builder) Add(method string, path string, op *Operation) {
+	item, ok := b.doc.Paths[path]
+	if !ok {
+		item = &PathItem{}
+		b.doc.Paths[path] = item
+	}
+	(*item)[strings.ToLower(method)] = op
+}
+
+func (b *Builder) Document() *Document {
+	return &b.doc
+}
+
+// JSON is a JSON body of the type of v, and Schema its schema.
+func (b *Builder) JSON(v interface{}) map[string]*MediaType {
+	return map[string]*MediaType{"application/json": {Schema: b.Schema(v)}}
+}
+
+// Schema returns the schema of the type of v, a reference to a component
+// for named structs.
+func (b *Builder) Schema(v interface{}) *Schema {
+	return b.schemaOf(reflect.TypeOf(v))
+}
+
+func (b *Builder) schemaOf(t reflect.Type) *Schema {
+	switch t {
+	case timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case durationType:
+		return &Schema{Type: "integer", Format: "int64", Description: "nanoseconds"}
+	case rawMessageType:
+		return &Schema{}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		schema := b.schemaOf(t.Elem())
+		if schema.Ref != "" {
+			return schema
+		}
+		schema.Nullable = true
+		return schema
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: b.schemaOf(t.Elem())}
+	case reflect.Map:
+		// Maps with integer keys are encoded with their keys as strings too.
+		return &Schema{Type: "object", AdditionalProperties: b.schemaOf(t.Elem())}
+	case reflect.Struct:
+		if reflect.PointerTo(t).Implements(unmarshalerType) {
+			// Decoded its own way, so its fields say nothing of its JSON.
+			return &Schema{}
+		}
+		if t.Name() == "" {
+			return b.structSchema(t)
+		}
+		name := componentName(t)
+		if _, ok := b.doc.Components.Schemas[name]; !ok {
+			// Registered before its fields, so that recursive types end.
+			b.doc.Components.Schemas[name] = &Schema{}
+			*b.doc.Components.Schemas[name] = *b.structSchema(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + name}
+	}
+	// Interfaces and anything else may hold any value.
+	return &Schema{}
+}
+
+func (b *Builder) structSchema(t reflect.Type) *Schema {
+	schema := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	b.addFields(schema, t, map[string]bool{})
+	return schema
+}
+
+// addFields adds the fields of t to schema, then those of its embedded
+// structs that are not shadowed by a field named the same in seen or t, as
+// encoding/json does.
+func (b *Builder) addFields(schema *Schema, t reflect.Type, seen map[string]bool) {
+	var embedded []reflect.Type
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if field.Anonymous && name == "" {
+			fieldType := field.Type
+			if fieldType.Kind() == reflect.Pointer {
+				fieldType = fieldType.Elem()
+			}
+			if fieldType.Kind() == reflect.Struct {
+				embedded = append(embedded, fieldType)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		if field.Tag.Get("openapi") == "-" {
+			continue
+		}
+		property := b.schemaOf(field.Type)
+		description := field.Tag.Get("doc")
+		for _, option := range strings.Split(field.Tag.Get("openapi"), ",") {
+			key, value, _ := strings.Cut(option, "=")
+			switch key {
+			case "required":
+				schema.Required = append(schema.Required, name)
+			case "enum":
+				property.Enum = strings.Split(value, "|")
+			case "format":
+				property.Format = value
+			}
+		}
+		if description != "" {
+			if property.Ref != "" {
+				// Siblings of $ref are ignored, so the reference is wrapped.
+				property = &Schema{AllOf: []*Schema{property}}
+			}
+			property.Description = description
+		}
+		schema.Properties[name] = property
+	}
+	for _, fieldType := range embedded {
+		b.addFields(schema, fieldType, seen)
+	}
+}
+
+// componentName is the name of t with its first letter upper-cased, so
+// that unexported request types read like the others.
+func componentName(t reflect.Type) string {
+	name := []rune(t.Name())
+	name[0] = unicode.ToUpper(name[0])
+	return string(name)
+}
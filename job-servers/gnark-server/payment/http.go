@@ -0,0 +1,72 @@
+package payment
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// HTTPVerifier delegates the check to an external payment service, for
+// payments such as intmax2 transfers that the server cannot check itself.
+// The service is POSTed the Request as JSON and answers 200 with
+// {"paid": bool, "reason": "..."}.
+type HTTPVerifier struct {
+	URL string
+	// Token, when set, is sent as a bearer token.
+	Token   string
+	Timeout time.Duration
+	Client  *http.Client
+}
+
+type httpVerdict struct {
+	Paid   bool   `json:"paid"`
+	Reason string `json:"reason"`
+}
+
+func (v *HTTPVerifier) Verify(ctx context.Context, request Request) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	if v.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, v.Timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, v.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if v.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+v.Token)
+	}
+	client := v.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("payment service: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+		return fmt.Errorf("payment service returned status %d", resp.StatusCode)
+	}
+	var verdict httpVerdict
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&verdict); err != nil {
+		return fmt.Errorf("payment service: %w", err)
+	}
+	if !verdict.Paid {
+		if verdict.Reason == "" {
+			verdict.Reason = "not paid"
+		}
+		return unpaid("%s", verdict.Reason)
+	}
+	return nil
+}
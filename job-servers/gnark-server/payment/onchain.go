@@ -0,0 +1,126 @@
+package payment
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// OnChainVerifier accepts as payment the hash of a successful transaction
+// sending at least MinFee wei to Recipient from one of the Senders of the
+// request, included at least Confirmations blocks deep, counting its own.
+// Requiring the sender keeps a transaction hash, which is public, from paying
+// for anyone else's jobs.
+type OnChainVerifier struct {
+	client        *ethclient.Client
+	signer        types.Signer
+	Recipient     common.Address
+	MinFee        *big.Int
+	Confirmations uint64
+}
+
+func NewOnChainVerifier(ctx context.Context, rpcURL string, recipient string, minFee *big.Int, confirmations uint64) (*OnChainVerifier, error) {
+	if !common.IsHexAddress(recipient) {
+		return nil, fmt.Errorf("invalid payment recipient %q", recipient)
+	}
+	client, err := ethclient.DialContext(ctx, rpcURL)
+	if err != nil {
+		return nil, err
+	}
+	chainId, err := client.ChainID(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch chain id: %w", err)
+	}
+	return &OnChainVerifier{
+		client:        client,
+		signer:        types.LatestSignerForChainID(chainId),
+		Recipient:     common.HexToAddress(recipient),
+		MinFee:        minFee,
+		Confirmations: confirmations,
+	}, nil
+}
+
+// Normalize spells transaction hashes as 0x and 64 lowercase hex digits,
+// with or without the prefix and in any case, and leaves other references as
+// they are for Verify to refuse.
+func (v *OnChainVerifier) Normalize(reference string) string {
+	if hash, ok := transactionHash(reference); ok {
+		return hash.Hex()
+	}
+	return reference
+}
+
+func (v *OnChainVerifier) Verify(ctx context.Context, request Request) error {
+	hash, ok := transactionHash(request.Reference)
+	if !ok {
+		return unpaid("payment must be a transaction hash")
+	}
+	if len(request.Senders) == 0 {
+		return unpaid("no payment address is bound to the submitter")
+	}
+	tx, pending, err := v.client.TransactionByHash(ctx, hash)
+	if errors.Is(err, ethereum.NotFound) {
+		return unpaid("transaction %s not found", hash.Hex())
+	} else if err != nil {
+		return err
+	}
+	if pending {
+		return unpaid("transaction %s is pending", hash.Hex())
+	}
+	if tx.To() == nil || *tx.To() != v.Recipient {
+		return unpaid("transaction %s does not pay %s", hash.Hex(), v.Recipient.Hex())
+	}
+	sender, err := types.Sender(v.signer, tx)
+	if err != nil {
+		return unpaid("transaction %s: %v", hash.Hex(), err)
+	}
+	if !paysFrom(sender, request.Senders) {
+		return unpaid("transaction %s is not sent from a payment address of the submitter", hash.Hex())
+	}
+	if tx.Value().Cmp(v.MinFee) < 0 {
+		return unpaid("transaction %s pays %s wei, less than the fee of %s wei", hash.Hex(), tx.Value(), v.MinFee)
+	}
+	receipt, err := v.client.TransactionReceipt(ctx, hash)
+	if err != nil {
+		return err
+	}
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		return unpaid("transaction %s failed", hash.Hex())
+	}
+	head, err := v.client.BlockNumber(ctx)
+	if err != nil {
+		return err
+	}
+	if confirmations := head - receipt.BlockNumber.Uint64() + 1; confirmations < v.Confirmations {
+		return unpaid("transaction %s has %d of %d confirmations", hash.Hex(), confirmations, v.Confirmations)
+	}
+	return nil
+}
+
+func transactionHash(reference string) (common.Hash, bool) {
+	digits := strings.TrimPrefix(strings.TrimPrefix(reference, "0x"), "0X")
+	if len(digits) != 64 {
+		return common.Hash{}, false
+	}
+	if _, err := hex.DecodeString(digits); err != nil {
+		return common.Hash{}, false
+	}
+	return common.HexToHash(digits), true
+}
+
+func paysFrom(sender common.Address, senders []string) bool {
+	for _, address := range senders {
+		if common.IsHexAddress(address) && common.HexToAddress(address) == sender {
+			return true
+		}
+	}
+	return false
+}
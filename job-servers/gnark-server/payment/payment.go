@@ -0,0 +1,46 @@
+// Package payment verifies that start-proof requests are paid for before
+// their jobs are queued.
+package payment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrUnpaid is wrapped by the errors of Verify when the payment reference
+// does not pay for the job, as opposed to the payment being uncheckable.
+var ErrUnpaid = errors.New("payment required")
+
+// Request is what a Verifier is asked to check: Reference is the client's
+// payment reference, such as an intmax2 transfer or a transaction hash.
+type Request struct {
+	Reference string `json:"reference"`
+	JobId     string `json:"jobId"`
+	Circuit   string `json:"circuit"`
+	InputHash string `json:"inputHash"`
+	// Key and Tenant identify the submitter, and Senders are the addresses
+	// its key pays from.
+	Key     string   `json:"key"`
+	Tenant  string   `json:"tenant"`
+	Senders []string `json:"senders,omitempty"`
+}
+
+// Verifier checks the payment of a job before it is queued. Verify returns
+// nil if the payment is valid, an error wrapping ErrUnpaid if it is not, and
+// any other error if it could not be checked. Each reference pays for one
+// job only; the server enforces that across verifiers.
+type Verifier interface {
+	Verify(ctx context.Context, request Request) error
+}
+
+// Normalizer is implemented by Verifiers whose references can be spelled
+// several ways, such as hex strings: Normalize returns the one spelling under
+// which the server claims a reference, so that each pays for one job only.
+type Normalizer interface {
+	Normalize(reference string) string
+}
+
+func unpaid(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrUnpaid, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,81 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"gnark-server/audit"
+)
+
+var auditSchema = []string{
+	`CREATE TABLE IF NOT EXISTS gnark_audit_log (
+		id     bigserial PRIMARY KEY,
+		at     timestamptz NOT NULL,
+		action text NOT NULL,
+		actor  text NOT NULL,
+		event  jsonb NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS gnark_audit_log_at ON gnark_audit_log (at)`,
+}
+
+// AuditLog is an audit.Store keeping events in the gnark_audit_log table,
+// on the connections of the Store it was opened from.
+type AuditLog struct {
+	store *Store
+}
+
+// AuditLog creates the audit table if needed.
+func (s *Store) AuditLog(ctx context.Context) (*AuditLog, error) {
+	for _, statement := range auditSchema {
+		if _, err := s.exec(ctx, statement); err != nil {
+			return nil, err
+		}
+	}
+	return &AuditLog{store: s}, nil
+}
+
+func (l *AuditLog) Append(ctx context.Context, event audit.Event) error {
+	event.Id = 0
+	eventJSON, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = l.store.exec(ctx, `INSERT INTO gnark_audit_log (at, action, actor, event) VALUES ($1, $2, $3, $4::jsonb)`,
+		event.Time, event.Action, event.Actor, string(eventJSON))
+	return err
+}
+
+func (l *AuditLog) List(ctx context.Context, query audit.Query) ([]audit.Event, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	rows, err := l.store.pool.Query(ctx, `SELECT id, event::text FROM gnark_audit_log
+		WHERE ($1::bigint = 0 OR id < $1::bigint) AND ($2::text = '' OR action = $2::text) AND ($3::text = '' OR actor = $3::text)
+		ORDER BY id DESC LIMIT $4`,
+		query.Before, query.Action, query.Actor, query.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	events := make([]audit.Event, 0, query.Limit)
+	for rows.Next() {
+		var id int64
+		var eventJSON []byte
+		if err := rows.Scan(&id, &eventJSON); err != nil {
+			return nil, err
+		}
+		var event audit.Event
+		if err := json.Unmarshal(eventJSON, &event); err != nil {
+			return nil, fmt.Errorf("corrupt audit event: %w", err)
+		}
+		event.Id = id
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+func (l *AuditLog) Prune(ctx context.Context, retention time.Duration) error {
+	_, err := l.store.exec(ctx, `DELETE FROM gnark_audit_log WHERE at < now() - $1::interval`, retention)
+	return err
+}
@@ -0,0 +1,136 @@
+package postgres
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// queryTimeout bounds statements run without a context deadline.
+const queryTimeout = 30 * time.Second
+
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS gnark_proof_results (
+		job_id     text PRIMARY KEY,
+		record     jsonb NOT NULL,
+		created_at timestamptz NOT NULL DEFAULT now(),
+		updated_at timestamptz NOT NULL DEFAULT now(),
+		expires_at timestamptz NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS gnark_proof_results_expires_at ON gnark_proof_results (expires_at)`,
+}
+
+// Store keeps job records in the gnark_proof_results table, one row per job
+// with the record as jsonb. Rows past expires_at are no longer served but are
+// kept as job history for HistoryRetention (0: forever).
+type Store struct {
+	HistoryRetention time.Duration
+
+	pool *pgxpool.Pool
+}
+
+// Open connects to the database at rawURL with up to maxConns connections
+// and creates the table if needed.
+func Open(ctx context.Context, rawURL string, maxConns int) (*Store, error) {
+	cfg, err := pgxpool.ParseConfig(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	cfg.MaxConns = int32(maxConns)
+	pool, err := pgxpool.NewWithConfig(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{pool: pool}
+	for _, statement := range schema {
+		if _, err := s.exec(ctx, statement); err != nil {
+			pool.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// withTimeout bounds ctx by queryTimeout unless it has a deadline already.
+func withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, queryTimeout)
+}
+
+func (s *Store) exec(ctx context.Context, query string, args ...interface{}) (pgconn.CommandTag, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	return s.pool.Exec(ctx, query, args...)
+}
+
+func (s *Store) Reserve(ctx context.Context, jobId string, record []byte, ttl time.Duration) (bool, error) {
+	tag, err := s.exec(ctx, `INSERT INTO gnark_proof_results AS r (job_id, record, expires_at)
+		VALUES ($1, $2::jsonb, now() + $3::interval)
+		ON CONFLICT (job_id) DO UPDATE SET record = EXCLUDED.record, created_at = now(), updated_at = now(), expires_at = EXCLUDED.expires_at
+		WHERE r.expires_at <= now()`,
+		jobId, string(record), ttl)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+func (s *Store) Get(ctx context.Context, jobId string) ([]byte, bool, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	var record []byte
+	err := s.pool.QueryRow(ctx, `SELECT record::text FROM gnark_proof_results WHERE job_id = $1 AND expires_at > now()`, jobId).Scan(&record)
+	if err == pgx.ErrNoRows {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	return record, true, nil
+}
+
+func (s *Store) Set(ctx context.Context, jobId string, record []byte, ttl time.Duration) error {
+	if ttl < 0 {
+		_, err := s.exec(ctx, `UPDATE gnark_proof_results SET record = $2::jsonb, updated_at = now() WHERE job_id = $1`,
+			jobId, string(record))
+		return err
+	}
+	_, err := s.exec(ctx, `INSERT INTO gnark_proof_results (job_id, record, expires_at)
+		VALUES ($1, $2::jsonb, now() + $3::interval)
+		ON CONFLICT (job_id) DO UPDATE SET record = EXCLUDED.record, updated_at = now(), expires_at = EXCLUDED.expires_at`,
+		jobId, string(record), ttl)
+	return err
+}
+
+func (s *Store) Delete(ctx context.Context, jobId string) error {
+	_, err := s.exec(ctx, `DELETE FROM gnark_proof_results WHERE job_id = $1`, jobId)
+	return err
+}
+
+// Run deletes rows expired for longer than HistoryRetention every interval
+// until ctx is done.
+func (s *Store) Run(ctx context.Context, interval time.Duration) {
+	if s.HistoryRetention == 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		tag, err := s.exec(ctx, `DELETE FROM gnark_proof_results WHERE expires_at <= now() - $1::interval`, s.HistoryRetention)
+		if err != nil {
+			log.Printf("Failed to prune job history in Postgres: %v\n", err)
+		} else if n := tag.RowsAffected(); n > 0 {
+			log.Printf("Pruned %d expired jobs from Postgres\n", n)
+		}
+	}
+}
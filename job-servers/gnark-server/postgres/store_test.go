@@ -0,0 +1,103 @@
+package postgres
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"gnark-server/audit"
+
+	"github.com/google/uuid"
+)
+
+// testStore opens the database of POSTGRES_TEST_URL, skipping the test when
+// it is not set.
+func testStore(t *testing.T) *Store {
+	url := os.Getenv("POSTGRES_TEST_URL")
+	if url == "" {
+		t.Skip("POSTGRES_TEST_URL is not set")
+	}
+	s, err := Open(context.Background(), url, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.pool.Close)
+	return s
+}
+
+func TestStoreRecords(t *testing.T) {
+	s := testStore(t)
+	ctx := context.Background()
+	jobId := uuid.NewString()
+	t.Cleanup(func() { s.Delete(ctx, jobId) })
+
+	if _, found, err := s.Get(ctx, jobId); err != nil || found {
+		t.Fatalf("Get of a missing job = %v, %v", found, err)
+	}
+	if reserved, err := s.Reserve(ctx, jobId, []byte(`{"status":"pending"}`), time.Hour); err != nil || !reserved {
+		t.Fatalf("Reserve = %v, %v", reserved, err)
+	}
+	if reserved, err := s.Reserve(ctx, jobId, []byte(`{"status":"other"}`), time.Hour); err != nil || reserved {
+		t.Fatalf("second Reserve = %v, %v", reserved, err)
+	}
+	// A negative TTL keeps the expiry.
+	if err := s.Set(ctx, jobId, []byte(`{"status":"done"}`), -1); err != nil {
+		t.Fatal(err)
+	}
+	record, found, err := s.Get(ctx, jobId)
+	if err != nil || !found || string(record) != `{"status": "done"}` {
+		t.Fatalf("Get = %s, %v, %v", record, found, err)
+	}
+	if err := s.Delete(ctx, jobId); err != nil {
+		t.Fatal(err)
+	}
+	if _, found, err := s.Get(ctx, jobId); err != nil || found {
+		t.Fatalf("Get of a deleted job = %v, %v", found, err)
+	}
+}
+
+func TestStoreExpiry(t *testing.T) {
+	s := testStore(t)
+	ctx := context.Background()
+	jobId := uuid.NewString()
+	t.Cleanup(func() { s.Delete(ctx, jobId) })
+
+	if err := s.Set(ctx, jobId, []byte(`{}`), time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if _, found, err := s.Get(ctx, jobId); err != nil || found {
+		t.Fatalf("Get of an expired job = %v, %v", found, err)
+	}
+	// An expired row may be reserved again.
+	if reserved, err := s.Reserve(ctx, jobId, []byte(`{}`), time.Hour); err != nil || !reserved {
+		t.Fatalf("Reserve of an expired job = %v, %v", reserved, err)
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	s := testStore(t)
+	ctx := context.Background()
+	log, err := s.AuditLog(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actor := "test-" + uuid.NewString()
+	for _, action := range []string{"first", "second"} {
+		if err := log.Append(ctx, audit.Event{Time: time.Now(), Action: action, Actor: actor}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	events, err := log.List(ctx, audit.Query{Actor: actor, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].Action != "second" || events[1].Action != "first" || events[0].Id <= events[1].Id {
+		t.Fatalf("List = %+v, want second then first", events)
+	}
+	events, err = log.List(ctx, audit.Query{Actor: actor, Before: events[0].Id, Limit: 10})
+	if err != nil || len(events) != 1 || events[0].Action != "first" {
+		t.Fatalf("List before the second event = %+v, %v", events, err)
+	}
+}
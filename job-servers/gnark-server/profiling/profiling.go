@@ -0,0 +1,31 @@
+// Package profiling exposes the net/http/pprof profiles of the server to
+// admins.
+package profiling
+
+import (
+	"net/http"
+	// Registers the profile handlers under /debug/pprof/ on the default mux.
+	_ "net/http/pprof"
+	"strings"
+
+	"gnark-server/apierror"
+)
+
+const pathPrefix = "/debug/pprof/"
+
+// Middleware guards the pprof handlers next serves under /debug/pprof/:
+// unless enabled they are not found, otherwise they are guarded by admin.
+func Middleware(enabled bool, admin func(http.HandlerFunc) http.HandlerFunc, next http.Handler) http.Handler {
+	guarded := admin(next.ServeHTTP)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path+"/" == pathPrefix || strings.HasPrefix(r.URL.Path, pathPrefix) {
+			if !enabled {
+				apierror.Error(w, "Not found", http.StatusNotFound)
+				return
+			}
+			guarded(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,86 @@
+// Package prover selects the implementation used to produce PLONK proofs.
+package prover
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	plonk_bn254 "github.com/consensys/gnark/backend/plonk/bn254"
+	"github.com/consensys/gnark/backend/witness"
+	cs "github.com/consensys/gnark/constraint/bn254"
+)
+
+const (
+	BackendCPU  = "cpu"
+	BackendGPU  = "gpu"
+	BackendAuto = "auto"
+)
+
+type Backend interface {
+	Name() string
+	Prove(ccs *cs.SparseR1CS, pk *plonk_bn254.ProvingKey, fullWitness witness.Witness) (*plonk_bn254.Proof, error)
+}
+
+type cpuBackend struct{}
+
+func (cpuBackend) Name() string {
+	return BackendCPU
+}
+
+func (cpuBackend) Prove(ccs *cs.SparseR1CS, pk *plonk_bn254.ProvingKey, fullWitness witness.Witness) (*plonk_bn254.Proof, error) {
+	return plonk_bn254.Prove(ccs, pk, fullWitness)
+}
+
+// CPU is gnark's native prover.
+var CPU Backend = cpuBackend{}
+
+// gpuBackend is set by builds that include a GPU proving backend. gnark
+// v0.9.1 ships no accelerated PLONK prover, so it is nil in this tree: "gpu"
+// is refused and "auto" proves on the CPU.
+var gpuBackend Backend
+
+func ValidateBackend(name string) error {
+	switch name {
+	case BackendCPU, BackendAuto:
+		return nil
+	case BackendGPU:
+		if gpuBackend == nil {
+			return fmt.Errorf("prover backend %q is not available: this build has no GPU prover", name)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown prover backend %q", name)
+}
+
+// DetectGPUs returns the NVIDIA GPUs reported by the kernel driver.
+func DetectGPUs() []string {
+	entries, err := os.ReadDir("/proc/driver/nvidia/gpus")
+	if err != nil {
+		return nil
+	}
+	gpus := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		gpus = append(gpus, entry.Name())
+	}
+	return gpus
+}
+
+// Select returns the backend to prove with, name having been validated.
+// "gpu" and "auto" use the GPU backend when a GPU is present, and fall back
+// to the CPU otherwise; only "gpu" logs the fallback as a warning.
+func Select(name string) Backend {
+	if name == BackendCPU {
+		return CPU
+	}
+	gpus := DetectGPUs()
+	switch {
+	case gpuBackend != nil && len(gpus) > 0:
+		log.Println("Proving on GPU", gpus)
+		return gpuBackend
+	case name != BackendGPU:
+	default:
+		log.Println("WARNING: GPU prover backend requested but no GPU was detected, proving on CPU")
+	}
+	return CPU
+}
@@ -0,0 +1,209 @@
+// Package pubinputs checks the public inputs of submitted proofs against the
+// rules a circuit declares in data/<circuit>/public_inputs.json:
+//
+//	{
+//	  "count": 8,
+//	  "rules": [
+//	    {"name": "blockNumber", "indices": [7], "type": "uint", "min": "1"},
+//	    {"name": "recipient", "indices": [0, 1, 2, 3, 4], "type": "address"}
+//	  ]
+//	}
+//
+// Values spanning several public inputs are read from 32-bit limbs, most
+// significant first unless littleEndian is set.
+package pubinputs
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+)
+
+const (
+	// TypeU32 requires every input to fit in 32 bits.
+	TypeU32 = "u32"
+	// TypeUint reads the inputs as one number, within min and max if set.
+	TypeUint = "uint"
+	// TypeAddress reads five 32-bit limbs as a non-zero 160-bit address.
+	TypeAddress = "address"
+	// TypeBool requires every input to be 0 or 1.
+	TypeBool = "bool"
+	// TypeEquals reads the inputs as one number that must equal value.
+	TypeEquals = "equals"
+)
+
+type Rule struct {
+	Name         string `json:"name"`
+	Indices      []int  `json:"indices"`
+	Type         string `json:"type"`
+	Min          string `json:"min,omitempty"`
+	Max          string `json:"max,omitempty"`
+	Value        string `json:"value,omitempty"`
+	LittleEndian bool   `json:"littleEndian,omitempty"`
+
+	min, max, value *big.Int
+}
+
+type Rules struct {
+	// Count is the number of public inputs, if set.
+	Count int    `json:"count"`
+	Rules []Rule `json:"rules"`
+}
+
+// Violation is an input breaking a rule.
+type Violation struct {
+	Index int
+	Error string
+}
+
+// Load reads a rules file; it returns nil rules if the file does not exist.
+func Load(path string) (*Rules, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var rules Rules
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", path, err)
+	}
+	if err := rules.compile(); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", path, err)
+	}
+	return &rules, nil
+}
+
+func (r *Rules) compile() error {
+	if r.Count < 0 {
+		return fmt.Errorf("count must not be negative")
+	}
+	for i := range r.Rules {
+		rule := &r.Rules[i]
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule %d", i)
+		}
+		if len(rule.Indices) == 0 {
+			return fmt.Errorf("%s: indices are required", rule.Name)
+		}
+		for _, index := range rule.Indices {
+			if index < 0 || (r.Count > 0 && index >= r.Count) {
+				return fmt.Errorf("%s: index %d out of range", rule.Name, index)
+			}
+		}
+		var err error
+		switch rule.Type {
+		case TypeU32, TypeBool:
+		case TypeAddress:
+			if len(rule.Indices) != 5 {
+				return fmt.Errorf("%s: an address spans 5 public inputs", rule.Name)
+			}
+		case TypeUint:
+			if rule.min, err = parseNumber(rule.Min); err != nil {
+				return fmt.Errorf("%s: min: %w", rule.Name, err)
+			}
+			if rule.max, err = parseNumber(rule.Max); err != nil {
+				return fmt.Errorf("%s: max: %w", rule.Name, err)
+			}
+		case TypeEquals:
+			if rule.value, err = parseNumber(rule.Value); err != nil || rule.value == nil {
+				return fmt.Errorf("%s: value must be a number", rule.Name)
+			}
+		default:
+			return fmt.Errorf("%s: unknown type %q", rule.Name, rule.Type)
+		}
+	}
+	return nil
+}
+
+func parseNumber(s string) (*big.Int, error) {
+	if s == "" {
+		return nil, nil
+	}
+	v, ok := new(big.Int).SetString(s, 0)
+	if !ok {
+		return nil, fmt.Errorf("%q is not a number", s)
+	}
+	return v, nil
+}
+
+// Check returns the violations of the rules by publicInputs.
+func (r *Rules) Check(publicInputs []uint64) []Violation {
+	if r.Count > 0 && len(publicInputs) != r.Count {
+		return []Violation{{Index: -1, Error: fmt.Sprintf("expected %d public inputs, got %d", r.Count, len(publicInputs))}}
+	}
+	var violations []Violation
+	for _, rule := range r.Rules {
+		violations = append(violations, rule.check(publicInputs)...)
+	}
+	return violations
+}
+
+func (rule *Rule) check(publicInputs []uint64) []Violation {
+	first := rule.Indices[0]
+	for _, index := range rule.Indices {
+		if index >= len(publicInputs) {
+			return []Violation{{Index: index, Error: fmt.Sprintf("%s: missing public input %d", rule.Name, index)}}
+		}
+	}
+	var violations []Violation
+	switch rule.Type {
+	case TypeU32, TypeBool:
+		limit := uint64(1) << 32
+		if rule.Type == TypeBool {
+			limit = 2
+		}
+		for _, index := range rule.Indices {
+			if publicInputs[index] >= limit {
+				violations = append(violations, Violation{Index: index, Error: fmt.Sprintf("%s: %d is not a %s", rule.Name, publicInputs[index], rule.Type)})
+			}
+		}
+		return violations
+	}
+
+	value, bad := rule.combine(publicInputs)
+	if bad >= 0 {
+		return []Violation{{Index: bad, Error: fmt.Sprintf("%s: limb %d does not fit in 32 bits", rule.Name, publicInputs[bad])}}
+	}
+	switch rule.Type {
+	case TypeAddress:
+		if value.Sign() == 0 {
+			return []Violation{{Index: first, Error: fmt.Sprintf("%s: zero address", rule.Name)}}
+		}
+	case TypeUint:
+		if rule.min != nil && value.Cmp(rule.min) < 0 {
+			return []Violation{{Index: first, Error: fmt.Sprintf("%s: %s is below the minimum %s", rule.Name, value, rule.min)}}
+		}
+		if rule.max != nil && value.Cmp(rule.max) > 0 {
+			return []Violation{{Index: first, Error: fmt.Sprintf("%s: %s is above the maximum %s", rule.Name, value, rule.max)}}
+		}
+	case TypeEquals:
+		if value.Cmp(rule.value) != 0 {
+			return []Violation{{Index: first, Error: fmt.Sprintf("%s: expected %s, got %s", rule.Name, rule.value, value)}}
+		}
+	}
+	return nil
+}
+
+// combine reads the inputs of a rule as 32-bit limbs of one number. A
+// single input is taken whole. It returns the index of a limb that does not
+// fit, or -1.
+func (rule *Rule) combine(publicInputs []uint64) (*big.Int, int) {
+	if len(rule.Indices) == 1 {
+		return new(big.Int).SetUint64(publicInputs[rule.Indices[0]]), -1
+	}
+	value := new(big.Int)
+	for i := range rule.Indices {
+		index := rule.Indices[i]
+		if rule.LittleEndian {
+			index = rule.Indices[len(rule.Indices)-1-i]
+		}
+		limb := publicInputs[index]
+		if limb >= 1<<32 {
+			return nil, index
+		}
+		value.Lsh(value, 32).Or(value, new(big.Int).SetUint64(limb))
+	}
+	return value, -1
+}
@@ -0,0 +1,183 @@
+// Package receipt issues signed submission receipts. Receipts are numbered
+// from a counter shared through Redis, so their sequence numbers are strictly
+// increasing in the order jobs were accepted by any node of the fleet.
+package receipt
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"gnark-server/apierror"
+	"gnark-server/rediskey"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const redisSequenceKey = "receipt_sequence"
+
+// signingDomain prefixes the signed bytes so that a receipt signature cannot
+// be mistaken for a signature over anything else.
+const signingDomain = "gnark-server receipt v1\n"
+
+type Receipt struct {
+	JobId   string `json:"jobId"`
+	Circuit string `json:"circuit"`
+	// PayloadHash is the hex-encoded SHA-256 of the submitted proof field.
+	PayloadHash string    `json:"payloadHash"`
+	Sequence    int64     `json:"sequence"`
+	AcceptedAt  time.Time `json:"acceptedAt"`
+	NodeId      string    `json:"nodeId"`
+	KeyId       string    `json:"keyId"`
+	// Signature is the base64 Ed25519 signature over the receipt without it.
+	Signature string `json:"signature"`
+}
+
+// signedBytes is the signing domain followed by the compact JSON of the
+// receipt, in field order, with an empty signature and no HTML escaping, so
+// that any JSON encoder can reproduce it.
+func (r Receipt) signedBytes() ([]byte, error) {
+	r.Signature = ""
+	buf := bytes.NewBufferString(signingDomain)
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(r); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+type Issuer struct {
+	mu    sync.RWMutex
+	key   ed25519.PrivateKey
+	keyId string
+	// previousKeys are the public keys rotated out, by key ID, so that
+	// receipts signed before a rotation can still be verified.
+	previousKeys map[string]ed25519.PublicKey
+	redisClient  redis.UniversalClient
+	nodeId       string
+}
+
+// NewIssuer creates an issuer signing with the Ed25519 key derived from the
+// hex-encoded 32-byte seed.
+func NewIssuer(seedHex string, redisClient redis.UniversalClient, nodeId string) (*Issuer, error) {
+	key, err := parseSeed(seedHex)
+	if err != nil {
+		return nil, err
+	}
+	return &Issuer{key: key, keyId: KeyId(key.Public().(ed25519.PublicKey)), previousKeys: map[string]ed25519.PublicKey{}, redisClient: redisClient, nodeId: nodeId}, nil
+}
+
+func parseSeed(seedHex string) (ed25519.PrivateKey, error) {
+	seed, err := hex.DecodeString(seedHex)
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("receipt signing key must be a hex-encoded %d-byte seed", ed25519.SeedSize)
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// Rotate signs further receipts with the key derived from seedHex, and
+// returns its key ID. The public key it replaces stays published.
+func (i *Issuer) Rotate(seedHex string) (string, error) {
+	key, err := parseSeed(seedHex)
+	if err != nil {
+		return "", err
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.previousKeys[i.keyId] = i.key.Public().(ed25519.PublicKey)
+	i.key = key
+	i.keyId = KeyId(key.Public().(ed25519.PublicKey))
+	delete(i.previousKeys, i.keyId)
+	return i.keyId, nil
+}
+
+func (i *Issuer) signingKey() (ed25519.PrivateKey, string) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return i.key, i.keyId
+}
+
+// KeyId identifies a public key in receipts: the first 8 bytes of its
+// SHA-256, hex-encoded.
+func KeyId(publicKey ed25519.PublicKey) string {
+	digest := sha256.Sum256(publicKey)
+	return hex.EncodeToString(digest[:8])
+}
+
+func PayloadHash(payload []byte) string {
+	digest := sha256.Sum256(payload)
+	return hex.EncodeToString(digest[:])
+}
+
+// Issue numbers and signs a receipt for an accepted job.
+func (i *Issuer) Issue(ctx context.Context, jobId string, circuit string, payloadHash string) (Receipt, error) {
+	sequence, err := i.redisClient.Incr(ctx, rediskey.Key(redisSequenceKey)).Result()
+	if err != nil {
+		return Receipt{}, err
+	}
+	key, keyId := i.signingKey()
+	receipt := Receipt{
+		JobId:       jobId,
+		Circuit:     circuit,
+		PayloadHash: payloadHash,
+		Sequence:    sequence,
+		AcceptedAt:  time.Now().UTC(),
+		NodeId:      i.nodeId,
+		KeyId:       keyId,
+	}
+	signed, err := receipt.signedBytes()
+	if err != nil {
+		return Receipt{}, err
+	}
+	receipt.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key, signed))
+	return receipt, nil
+}
+
+// Verify checks the signature of receipt against publicKey.
+func Verify(receipt Receipt, publicKey ed25519.PublicKey) error {
+	signature, err := base64.StdEncoding.DecodeString(receipt.Signature)
+	if err != nil {
+		return fmt.Errorf("invalid receipt signature encoding: %w", err)
+	}
+	signed, err := receipt.signedBytes()
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(publicKey, signed, signature) {
+		return errors.New("invalid receipt signature")
+	}
+	return nil
+}
+
+// ServeHTTP returns the public key receipts are signed with, and those
+// rotated out by key ID.
+func (i *Issuer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		apierror.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	i.mu.RLock()
+	previousKeys := make(map[string]string, len(i.previousKeys))
+	for keyId, publicKey := range i.previousKeys {
+		previousKeys[keyId] = hex.EncodeToString(publicKey)
+	}
+	response := map[string]interface{}{
+		"algorithm":    "ed25519",
+		"keyId":        i.keyId,
+		"publicKey":    hex.EncodeToString(i.key.Public().(ed25519.PublicKey)),
+		"previousKeys": previousKeys,
+	}
+	i.mu.RUnlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
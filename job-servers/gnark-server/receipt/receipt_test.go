@@ -0,0 +1,54 @@
+package receipt
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+func TestRotateKeepsPreviousKeyPublished(t *testing.T) {
+	issuer, err := NewIssuer(strings.Repeat("01", ed25519.SeedSize), redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()}), "node")
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, err := issuer.Issue(context.Background(), "job", "circuit", "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyId, err := issuer.Rotate(strings.Repeat("02", ed25519.SeedSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := issuer.Issue(context.Background(), "job", "circuit", "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.KeyId != keyId || after.KeyId == before.KeyId {
+		t.Fatalf("receipt key %s after rotating from %s to %s", after.KeyId, before.KeyId, keyId)
+	}
+
+	w := httptest.NewRecorder()
+	issuer.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/receipt/public-key", nil))
+	var published struct {
+		KeyId        string            `json:"keyId"`
+		PreviousKeys map[string]string `json:"previousKeys"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&published); err != nil {
+		t.Fatal(err)
+	}
+	previous, err := hex.DecodeString(published.PreviousKeys[before.KeyId])
+	if err != nil || Verify(before, previous) != nil {
+		t.Fatalf("receipt signed before the rotation does not verify with the published keys %v", published.PreviousKeys)
+	}
+	if published.KeyId != keyId {
+		t.Fatalf("published key %s, want %s", published.KeyId, keyId)
+	}
+}
@@ -0,0 +1,125 @@
+// Package redisbreaker is a circuit breaker for Redis clients: after a run of
+// failed commands it fails the next ones right away for a cooldown, instead
+// of letting every request wait for its own timeouts, then lets one command
+// through to probe whether Redis is back.
+package redisbreaker
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ErrOpen is returned for the commands the breaker rejects.
+var ErrOpen = errors.New("redis is unavailable (circuit breaker open)")
+
+// Breaker is a redis.Hook. Only connection failures and timeouts count as
+// failures; error replies, such as redis.Nil, mean Redis is up.
+type Breaker struct {
+	// Threshold is the number of consecutive failures that opens the
+	// breaker (0 never does), and Cooldown how long it then stays open.
+	Threshold int
+	Cooldown  time.Duration
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func New(threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{Threshold: threshold, Cooldown: cooldown}
+}
+
+// Open reports whether commands are currently rejected.
+func (b *Breaker) Open() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.Threshold > 0 && b.failures >= b.Threshold && (time.Since(b.openedAt) < b.Cooldown || b.probing)
+}
+
+func (b *Breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.Threshold <= 0 || b.failures < b.Threshold {
+		return nil
+	}
+	if time.Since(b.openedAt) < b.Cooldown || b.probing {
+		return ErrOpen
+	}
+	b.probing = true
+	return nil
+}
+
+func (b *Breaker) record(err error) {
+	if err == ErrOpen {
+		return
+	}
+	failed := isFailure(err)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	wasOpen := b.Threshold > 0 && b.failures >= b.Threshold
+	b.probing = false
+	if !failed {
+		if wasOpen {
+			log.Println("Redis is reachable again, closing the circuit breaker")
+		}
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.Threshold > 0 && b.failures >= b.Threshold {
+		if !wasOpen {
+			log.Printf("Redis failed %d times in a row, opening the circuit breaker for %v: %v\n", b.failures, b.Cooldown, err)
+		}
+		b.openedAt = time.Now()
+	}
+}
+
+func isFailure(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var reply redis.Error
+	return !errors.As(err, &reply)
+}
+
+// Unavailable reports whether err means Redis could not be reached, as
+// opposed to a failed command.
+func Unavailable(err error) bool {
+	if errors.Is(err, ErrOpen) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+func (b *Breaker) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return ctx, b.allow()
+}
+
+func (b *Breaker) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	b.record(cmd.Err())
+	return nil
+}
+
+func (b *Breaker) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return ctx, b.allow()
+}
+
+func (b *Breaker) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	var err error
+	for _, cmd := range cmds {
+		if err = cmd.Err(); isFailure(err) {
+			break
+		}
+	}
+	b.record(err)
+	return nil
+}
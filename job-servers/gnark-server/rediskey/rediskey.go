@@ -0,0 +1,54 @@
+// Package rediskey names the Redis keys of the server, so that instances and
+// environments sharing one Redis keep apart. Every key is the configured
+// namespace and prefix followed by the name the package using it gives it.
+package rediskey
+
+import "sync/atomic"
+
+// DefaultPrefix is the prefix of the keys of servers that do not set one.
+const DefaultPrefix = "gnark_"
+
+var (
+	prefix   atomic.Value
+	hashTags atomic.Value
+)
+
+// Configure sets the prefix of every key; namespace, when set, goes before
+// it, followed by a colon, e.g. "staging:gnark_". It must be called before
+// the first key is used.
+func Configure(namespace string, keyPrefix string) {
+	if namespace != "" {
+		keyPrefix = namespace + ":" + keyPrefix
+	}
+	prefix.Store(keyPrefix)
+}
+
+// Prefix is the prefix of every key.
+func Prefix() string {
+	if p, ok := prefix.Load().(string); ok {
+		return p
+	}
+	return DefaultPrefix
+}
+
+// Key is the key of name.
+func Key(name string) string {
+	return Prefix() + name
+}
+
+// UseHashTags makes JobKey put job IDs in a hash tag, as Redis Cluster
+// requires. It must be called before the first key is used; other
+// deployments keep the keys they had.
+func UseHashTags() {
+	hashTags.Store(true)
+}
+
+// JobKey is the key of name for the job jobId. With hash tags the job ID is
+// in braces, e.g. "gnark_job_meta:{<jobId>}", so that Redis Cluster keeps
+// every key of a job in one slot and a transaction over them runs as one.
+func JobKey(name string, jobId string) string {
+	if tagged, _ := hashTags.Load().(bool); tagged {
+		return Key(name) + "{" + jobId + "}"
+	}
+	return Key(name) + jobId
+}
@@ -0,0 +1,29 @@
+package relayer
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto/kzg4844"
+)
+
+// BlobVersionedHashes computes the KZG commitment of every blob, zero padded
+// to the full blob size, and returns the corresponding EIP-4844 versioned
+// hashes.
+func BlobVersionedHashes(blobs [][]byte) ([]common.Hash, error) {
+	hashes := make([]common.Hash, len(blobs))
+	for i, data := range blobs {
+		var blob kzg4844.Blob
+		if len(data) > len(blob) {
+			return nil, fmt.Errorf("blob %d is %d bytes, more than %d", i, len(data), len(blob))
+		}
+		copy(blob[:], data)
+		commitment, err := kzg4844.BlobToCommitment(blob)
+		if err != nil {
+			return nil, err
+		}
+		hashes[i] = kzg4844.CalcBlobHashV1(sha256.New(), &commitment)
+	}
+	return hashes, nil
+}
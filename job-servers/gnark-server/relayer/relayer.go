@@ -0,0 +1,136 @@
+package relayer
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// Relayer submits transactions to a single contract from a single account,
+// keeping track of the account nonce locally so concurrent jobs don't collide.
+type Relayer struct {
+	client   *ethclient.Client
+	key      *ecdsa.PrivateKey
+	from     common.Address
+	to       common.Address
+	chainId  *big.Int
+	Method   string
+	GasLimit uint64
+
+	mu    sync.Mutex
+	nonce *uint64
+}
+
+func New(ctx context.Context, rpcURL string, privateKeyHex string, contract string, method string) (*Relayer, error) {
+	if !common.IsHexAddress(contract) {
+		return nil, fmt.Errorf("invalid contract address %q", contract)
+	}
+	key, err := crypto.HexToECDSA(strings.TrimPrefix(privateKeyHex, "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid relayer private key: %w", err)
+	}
+	client, err := ethclient.DialContext(ctx, rpcURL)
+	if err != nil {
+		return nil, err
+	}
+	chainId, err := client.ChainID(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch chain id: %w", err)
+	}
+	return &Relayer{
+		client:  client,
+		key:     key,
+		from:    crypto.PubkeyToAddress(key.PublicKey),
+		to:      common.HexToAddress(contract),
+		chainId: chainId,
+		Method:  method,
+	}, nil
+}
+
+func (r *Relayer) From() common.Address {
+	return r.from
+}
+
+// Submit signs and broadcasts a transaction calling the contract with
+// calldata and returns its hash without waiting for it to be mined.
+func (r *Relayer) Submit(ctx context.Context, calldata []byte) (common.Hash, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.nonce == nil {
+		nonce, err := r.client.PendingNonceAt(ctx, r.from)
+		if err != nil {
+			return common.Hash{}, fmt.Errorf("failed to fetch nonce: %w", err)
+		}
+		r.nonce = &nonce
+	}
+
+	gasLimit := r.GasLimit
+	if gasLimit == 0 {
+		estimated, err := r.client.EstimateGas(ctx, ethereum.CallMsg{From: r.from, To: &r.to, Data: calldata})
+		if err != nil {
+			return common.Hash{}, fmt.Errorf("failed to estimate gas: %w", err)
+		}
+		gasLimit = estimated * 12 / 10
+	}
+
+	tx, err := r.buildTx(ctx, *r.nonce, gasLimit, calldata)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	signed, err := types.SignTx(tx, types.LatestSignerForChainID(r.chainId), r.key)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	if err := r.client.SendTransaction(ctx, signed); err != nil {
+		// The local nonce may be stale (e.g. the account was used elsewhere);
+		// refetch it on the next submission.
+		r.nonce = nil
+		return common.Hash{}, fmt.Errorf("failed to send transaction: %w", err)
+	}
+	*r.nonce++
+	return signed.Hash(), nil
+}
+
+func (r *Relayer) buildTx(ctx context.Context, nonce uint64, gasLimit uint64, calldata []byte) (*types.Transaction, error) {
+	head, err := r.client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch latest header: %w", err)
+	}
+	if head.BaseFee == nil {
+		gasPrice, err := r.client.SuggestGasPrice(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch gas price: %w", err)
+		}
+		return types.NewTx(&types.LegacyTx{
+			Nonce:    nonce,
+			To:       &r.to,
+			Gas:      gasLimit,
+			GasPrice: gasPrice,
+			Data:     calldata,
+		}), nil
+	}
+	tip, err := r.client.SuggestGasTipCap(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch gas tip: %w", err)
+	}
+	feeCap := new(big.Int).Add(tip, new(big.Int).Mul(head.BaseFee, big.NewInt(2)))
+	return types.NewTx(&types.DynamicFeeTx{
+		ChainID:   r.chainId,
+		Nonce:     nonce,
+		To:        &r.to,
+		Gas:       gasLimit,
+		GasTipCap: tip,
+		GasFeeCap: feeCap,
+		Data:      calldata,
+	}), nil
+}
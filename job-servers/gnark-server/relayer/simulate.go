@@ -0,0 +1,52 @@
+package relayer
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// Simulator dry-runs calls to a view function returning bool, such as the
+// verifier's Verify, without broadcasting anything.
+type Simulator struct {
+	client   *ethclient.Client
+	contract common.Address
+}
+
+func NewSimulator(ctx context.Context, rpcURL string, contract string) (*Simulator, error) {
+	if !common.IsHexAddress(contract) {
+		return nil, fmt.Errorf("invalid contract address %q", contract)
+	}
+	client, err := ethclient.DialContext(ctx, rpcURL)
+	if err != nil {
+		return nil, err
+	}
+	return &Simulator{client: client, contract: common.HexToAddress(contract)}, nil
+}
+
+func (s *Simulator) Contract() common.Address {
+	return s.contract
+}
+
+// Simulate runs calldata with eth_call against the latest block and returns
+// the boolean result together with an eth_estimateGas estimate.
+func (s *Simulator) Simulate(ctx context.Context, calldata []byte) (bool, uint64, error) {
+	msg := ethereum.CallMsg{To: &s.contract, Data: calldata}
+	output, err := s.client.CallContract(ctx, msg, nil)
+	if err != nil {
+		return false, 0, err
+	}
+	if len(output) != 32 {
+		return false, 0, fmt.Errorf("unexpected return data of %d bytes", len(output))
+	}
+	verified := new(big.Int).SetBytes(output).Sign() != 0
+	gas, err := s.client.EstimateGas(ctx, msg)
+	if err != nil {
+		return verified, 0, err
+	}
+	return verified, gas, nil
+}
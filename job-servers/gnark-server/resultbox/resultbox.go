@@ -0,0 +1,124 @@
+// Package resultbox encrypts job results to a client's X25519 public key, so
+// that only the holder of the private key can read them: an ephemeral key
+// agrees a secret with the client's key, HKDF-SHA256 derives an AES-256-GCM
+// key from it, and the job id is authenticated with the ciphertext so that a
+// sealed result cannot be passed off as another job's.
+package resultbox
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	KeySize = 32
+	version = 1
+	// info prefixes the HKDF info, followed by the ephemeral and recipient
+	// public keys.
+	info = "gnark-server result v1"
+)
+
+var ErrMalformed = errors.New("malformed sealed result")
+
+// ParsePublicKey decodes a 32-byte X25519 public key, in hex or base64.
+func ParsePublicKey(s string) (*ecdh.PublicKey, error) {
+	key, err := decodeKey(s)
+	if err != nil {
+		return nil, fmt.Errorf("public key must be %d bytes in hex or base64", KeySize)
+	}
+	return ecdh.X25519().NewPublicKey(key)
+}
+
+// ParsePrivateKey decodes a 32-byte X25519 private key, in hex or base64.
+func ParsePrivateKey(s string) (*ecdh.PrivateKey, error) {
+	key, err := decodeKey(s)
+	if err != nil {
+		return nil, fmt.Errorf("private key must be %d bytes in hex or base64", KeySize)
+	}
+	return ecdh.X25519().NewPrivateKey(key)
+}
+
+func decodeKey(s string) ([]byte, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "0x")
+	if key, err := hex.DecodeString(s); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+	for _, encoding := range []*base64.Encoding{base64.StdEncoding, base64.URLEncoding, base64.RawStdEncoding, base64.RawURLEncoding} {
+		if key, err := encoding.DecodeString(s); err == nil && len(key) == KeySize {
+			return key, nil
+		}
+	}
+	return nil, ErrMalformed
+}
+
+// Seal encrypts plaintext to recipient, bound to jobId. The result is
+// version(1) ‖ ephemeral public key(32) ‖ nonce(12) ‖ ciphertext.
+func Seal(recipient *ecdh.PublicKey, jobId string, plaintext []byte) ([]byte, error) {
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(shared, ephemeral.PublicKey().Bytes(), recipient.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	sealed := append([]byte{version}, ephemeral.PublicKey().Bytes()...)
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	sealed = append(sealed, nonce...)
+	return aead.Seal(sealed, nonce, plaintext, []byte(jobId)), nil
+}
+
+// Open decrypts a result sealed by Seal for the public key of key.
+func Open(key *ecdh.PrivateKey, jobId string, sealed []byte) ([]byte, error) {
+	if len(sealed) < 1+KeySize || sealed[0] != version {
+		return nil, ErrMalformed
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(sealed[1 : 1+KeySize])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	shared, err := key.ECDH(ephemeral)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(shared, ephemeral.Bytes(), key.PublicKey().Bytes())
+	if err != nil {
+		return nil, err
+	}
+	rest := sealed[1+KeySize:]
+	if len(rest) < aead.NonceSize() {
+		return nil, ErrMalformed
+	}
+	return aead.Open(nil, rest[:aead.NonceSize()], rest[aead.NonceSize():], []byte(jobId))
+}
+
+func newAEAD(shared []byte, ephemeral []byte, recipient []byte) (cipher.AEAD, error) {
+	key := make([]byte, 32)
+	kdf := hkdf.New(sha256.New, shared, nil, append(append([]byte(info), ephemeral...), recipient...))
+	if _, err := io.ReadFull(kdf, key); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
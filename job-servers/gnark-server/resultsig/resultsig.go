@@ -0,0 +1,156 @@
+// Package resultsig signs proof results with the server's secp256k1 key, so
+// that their consumers can check which prover produced a result and that it
+// was not altered in transit or storage.
+package resultsig
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"gnark-server/apierror"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// signingDomain prefixes the signed bytes so that a result signature cannot
+// be mistaken for a signature over anything else.
+const signingDomain = "gnark-server result v1\n"
+
+// Signature binds a job to the proof and public inputs it produced.
+type Signature struct {
+	// Signer is the Ethereum address of the signing key.
+	Signer string `json:"signer"`
+	// ProofHash is the keccak256 of the Solidity-encoded proof bytes and
+	// PublicInputsHash that of the public inputs as 32-byte words.
+	ProofHash        string `json:"proofHash"`
+	PublicInputsHash string `json:"publicInputsHash"`
+	// Signature is the 65-byte [R || S || V] signature, V being 27 or 28,
+	// over Digest, 0x-prefixed hex.
+	Signature string `json:"signature"`
+}
+
+// Digest is the keccak256 of the signing domain, the job ID, and the proof
+// and public inputs hashes, which ecrecover on-chain also accepts.
+func Digest(jobId string, proofHash []byte, publicInputsHash []byte) []byte {
+	return crypto.Keccak256([]byte(signingDomain), []byte(jobId), proofHash, publicInputsHash)
+}
+
+type Signer struct {
+	mu      sync.RWMutex
+	key     *ecdsa.PrivateKey
+	address common.Address
+}
+
+// NewSigner creates a signer from a hex-encoded secp256k1 private key.
+func NewSigner(privateKeyHex string) (*Signer, error) {
+	key, err := parseKey(privateKeyHex)
+	if err != nil {
+		return nil, err
+	}
+	return &Signer{key: key, address: crypto.PubkeyToAddress(key.PublicKey)}, nil
+}
+
+func parseKey(privateKeyHex string) (*ecdsa.PrivateKey, error) {
+	key, err := crypto.HexToECDSA(strings.TrimPrefix(privateKeyHex, "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid result signing key: %w", err)
+	}
+	return key, nil
+}
+
+// Rotate signs further results with the hex-encoded secp256k1 private key,
+// and returns its address.
+func (s *Signer) Rotate(privateKeyHex string) (common.Address, error) {
+	key, err := parseKey(privateKeyHex)
+	if err != nil {
+		return common.Address{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.key = key
+	s.address = crypto.PubkeyToAddress(key.PublicKey)
+	return s.address, nil
+}
+
+func (s *Signer) signingKey() (*ecdsa.PrivateKey, common.Address) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.key, s.address
+}
+
+func (s *Signer) Address() common.Address {
+	_, address := s.signingKey()
+	return address
+}
+
+// PublicKey returns the uncompressed public key, 0x04 || X || Y.
+func (s *Signer) PublicKey() []byte {
+	key, _ := s.signingKey()
+	return crypto.FromECDSAPub(&key.PublicKey)
+}
+
+// Sign signs the result of jobId: proof, the Solidity-encoded proof bytes,
+// and publicInputsHash.
+func (s *Signer) Sign(jobId string, proof []byte, publicInputsHash []byte) (Signature, error) {
+	key, address := s.signingKey()
+	proofHash := crypto.Keccak256(proof)
+	signature, err := crypto.Sign(Digest(jobId, proofHash, publicInputsHash), key)
+	if err != nil {
+		return Signature{}, err
+	}
+	signature[crypto.RecoveryIDOffset] += 27
+	return Signature{
+		Signer:           address.Hex(),
+		ProofHash:        hexutil.Encode(proofHash),
+		PublicInputsHash: hexutil.Encode(publicInputsHash),
+		Signature:        hexutil.Encode(signature),
+	}, nil
+}
+
+// Verify checks that signature was made by signer over the result of jobId
+// with the proof bytes proof, and returns an error otherwise.
+func Verify(signature Signature, jobId string, proof []byte, signer common.Address) error {
+	proofHash := crypto.Keccak256(proof)
+	if hexutil.Encode(proofHash) != strings.ToLower(signature.ProofHash) {
+		return errors.New("proof does not match the signed proof hash")
+	}
+	publicInputsHash, err := hexutil.Decode(signature.PublicInputsHash)
+	if err != nil {
+		return fmt.Errorf("invalid public inputs hash: %w", err)
+	}
+	sig, err := hexutil.Decode(signature.Signature)
+	if err != nil || len(sig) != crypto.SignatureLength {
+		return errors.New("invalid result signature encoding")
+	}
+	sig[crypto.RecoveryIDOffset] -= 27
+	publicKey, err := crypto.SigToPub(Digest(jobId, proofHash, publicInputsHash), sig)
+	if err != nil {
+		return fmt.Errorf("invalid result signature: %w", err)
+	}
+	if crypto.PubkeyToAddress(*publicKey) != signer {
+		return errors.New("result was not signed by the expected signer")
+	}
+	return nil
+}
+
+// ServeHTTP returns the key results are signed with.
+func (s *Signer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		apierror.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	key, address := s.signingKey()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"algorithm": "secp256k1",
+		"address":   address.Hex(),
+		"publicKey": hexutil.Encode(crypto.FromECDSAPub(&key.PublicKey)),
+	})
+}
@@ -3,13 +3,17 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
+	"log"
 	"os"
+	"path/filepath"
+	"time"
 
+	"gnark-server/artifacts"
 	verifierCircuit "gnark-server/circuit"
-	"gnark-server/trusted_setup"
+	"gnark-server/srs"
 
 	"github.com/consensys/gnark-crypto/ecc"
-	"github.com/consensys/gnark-crypto/kzg"
 	"github.com/consensys/gnark/backend/plonk"
 	"github.com/consensys/gnark/constraint"
 	"github.com/consensys/gnark/frontend"
@@ -18,10 +22,10 @@ import (
 	"github.com/qope/gnark-plonky2-verifier/variables"
 )
 
-func loadCircuit(circuitName string) constraint.ConstraintSystem {
-	commonCircuitData := types.ReadCommonCircuitData("data/" + circuitName + "/common_circuit_data.json")
-	proofWithPis := variables.DeserializeProofWithPublicInputs(types.ReadProofWithPublicInputs("data/" + circuitName + "/proof_with_public_inputs.json"))
-	verifierOnlyCircuitData := variables.DeserializeVerifierOnlyCircuitData(types.ReadVerifierOnlyCircuitData("data/" + circuitName + "/verifier_only_circuit_data.json"))
+func loadCircuit(circuitDir string) constraint.ConstraintSystem {
+	commonCircuitData := types.ReadCommonCircuitData(filepath.Join(circuitDir, "common_circuit_data.json"))
+	proofWithPis := variables.DeserializeProofWithPublicInputs(types.ReadProofWithPublicInputs(filepath.Join(circuitDir, "proof_with_public_inputs.json")))
+	verifierOnlyCircuitData := variables.DeserializeVerifierOnlyCircuitData(types.ReadVerifierOnlyCircuitData(filepath.Join(circuitDir, "verifier_only_circuit_data.json")))
 	circuit := verifierCircuit.VerifierCircuit{
 		Proof:                   proofWithPis.Proof,
 		PublicInputs:            proofWithPis.PublicInputs,
@@ -31,94 +35,122 @@ func loadCircuit(circuitName string) constraint.ConstraintSystem {
 	builder := scs.NewBuilder
 	ccs, err := frontend.Compile(ecc.BN254.ScalarField(), builder, &circuit)
 	if err != nil {
-		panic(err)
+		log.Fatal("Circuit compilation error: ", err)
 	}
 	return ccs
 }
 
+// writeArtifact writes to a temporary file renamed into place once complete,
+// so an interrupted setup never leaves a truncated artifact for the server
+// to load.
+func writeArtifact(path string, write func(io.Writer) error) {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		log.Fatal("Failed to create ", path, ": ", err)
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		log.Fatal("Failed to write ", path, ": ", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		log.Fatal("Failed to write ", path, ": ", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		log.Fatal("Failed to write ", path, ": ", err)
+	}
+	log.Println("Wrote", path)
+}
+
+func writerTo(v io.WriterTo) func(io.Writer) error {
+	return func(w io.Writer) error {
+		_, err := v.WriteTo(w)
+		return err
+	}
+}
+
 func main() {
 	circuitName := flag.String("circuit", "", "circuit name")
+	dataDir := flag.String("data-dir", "data", "directory holding one subdirectory per circuit")
+	srsFile := flag.String("srs", "srs_setup", "KZG SRS file, downloaded from the Aztec Ignition ceremony if missing")
+	srsSource := flag.String("srs-source", srs.SourceAztecIgnition, "ceremony the SRS is downloaded from")
+	srsSHA256 := flag.String("srs-sha256", "", "expected SHA-256 of the SRS file (default: the digest recorded at download)")
+	srsOnly := flag.Bool("srs-only", false, "only download and verify the SRS")
+	ignitionStart := flag.Int("ignition-start", 174, "first Ignition contribution to verify when downloading the SRS")
+	skipCheck := flag.Bool("skip-check", false, "skip the test prove and verify with the sample proof")
+	version := flag.String("version", "", "version recorded in the artifact manifest")
 	flag.Parse()
 
+	srsOptions := srs.Options{Source: *srsSource, IgnitionStart: *ignitionStart, SHA256: *srsSHA256}
+	if *srsOnly {
+		if _, err := srs.Load(*srsFile, srsOptions); err != nil {
+			log.Fatal("SRS error: ", err)
+		}
+		fmt.Println("SRS", *srsFile, "verified")
+		return
+	}
 	if *circuitName == "" {
 		fmt.Println("Please provide circuit name")
 		os.Exit(1)
 	}
+	circuitDir := filepath.Join(*dataDir, *circuitName)
 
-	r1cs := loadCircuit(*circuitName)
-
-	proofWithPis := variables.DeserializeProofWithPublicInputs(types.ReadProofWithPublicInputs("data/" + *circuitName + "/proof_with_public_inputs.json"))
-	verifierOnlyCircuitData := variables.DeserializeVerifierOnlyCircuitData(types.ReadVerifierOnlyCircuitData("data/" + *circuitName + "/verifier_only_circuit_data.json"))
+	// 1. Compile
+	start := time.Now()
+	r1cs := loadCircuit(circuitDir)
+	log.Printf("Compiled circuit with %d constraints in %s\n", r1cs.GetNbConstraints(), time.Since(start))
 
-	// 1. One setup
-	var srs kzg.SRS = kzg.NewSRS(ecc.BN254)
-	{
-		fileName := "srs_setup"
+	// 2. PLONK setup
+	srsOptions.MinSize = int(ecc.NextPowerOfTwo(uint64(r1cs.GetNbConstraints()+r1cs.GetNbPublicVariables()))) + 3
+	kzgSRS, err := srs.Load(*srsFile, srsOptions)
+	if err != nil {
+		log.Fatal("SRS error: ", err)
+	}
+	start = time.Now()
+	pk, vk, err := plonk.Setup(r1cs, kzgSRS)
+	if err != nil {
+		log.Fatal("PLONK setup error: ", err)
+	}
+	log.Printf("PLONK setup done in %s\n", time.Since(start))
 
-		if _, err := os.Stat(fileName); os.IsNotExist(err) {
-			trusted_setup.DownloadAndSaveAztecIgnitionSrs(174, fileName)
+	// 3. Prove and verify the sample proof
+	if !*skipCheck {
+		proofWithPis := variables.DeserializeProofWithPublicInputs(types.ReadProofWithPublicInputs(filepath.Join(circuitDir, "proof_with_public_inputs.json")))
+		verifierOnlyCircuitData := variables.DeserializeVerifierOnlyCircuitData(types.ReadVerifierOnlyCircuitData(filepath.Join(circuitDir, "verifier_only_circuit_data.json")))
+		assignment := verifierCircuit.VerifierCircuit{
+			Proof:                   proofWithPis.Proof,
+			PublicInputs:            proofWithPis.PublicInputs,
+			VerifierOnlyCircuitData: verifierOnlyCircuitData,
 		}
-
-		fSRS, err := os.Open(fileName)
-
+		witness, err := frontend.NewWitness(&assignment, ecc.BN254.ScalarField())
 		if err != nil {
-			panic(err)
+			log.Fatal("Witness error: ", err)
 		}
-
-		_, err = srs.ReadFrom(fSRS)
-
-		fSRS.Close()
-
+		start = time.Now()
+		proof, err := plonk.Prove(r1cs, pk, witness)
 		if err != nil {
-			panic(err)
+			log.Fatal("Test prove error: ", err)
 		}
+		witnessPublic, err := witness.Public()
+		if err != nil {
+			log.Fatal("Witness error: ", err)
+		}
+		if err := plonk.Verify(proof, vk, witnessPublic); err != nil {
+			log.Fatal("Test proof does not verify: ", err)
+		}
+		log.Printf("Test prove and verify done in %s\n", time.Since(start))
 	}
-	pk, vk, err := plonk.Setup(r1cs, srs)
-	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
-	}
-	assignment := verifierCircuit.VerifierCircuit{
-		Proof:                   proofWithPis.Proof,
-		PublicInputs:            proofWithPis.PublicInputs,
-		VerifierOnlyCircuitData: verifierOnlyCircuitData,
-	}
-	witness, err := frontend.NewWitness(&assignment, ecc.BN254.ScalarField())
-	if err != nil {
-		panic(err)
-	}
-	proof, err := plonk.Prove(r1cs, pk, witness)
-	if err != nil {
-		panic(err)
-	}
-	// 3. Proof verification
-	witnessPublic, err := witness.Public()
-	if err != nil {
-		panic(err)
-	}
-	err = plonk.Verify(proof, vk, witnessPublic)
-	if err != nil {
-		panic(err)
-	}
-	{
-		fSol, _ := os.Create("data/" + *circuitName + "/verifier.sol")
-		_ = vk.ExportSolidity(fSol)
-		fSol.Close()
-	}
-	{
-		fVk, _ := os.Create("data/" + *circuitName + "/verifying.key")
-		_, _ = vk.WriteTo(fVk)
-		fVk.Close()
-	}
-	{
-		fPk, _ := os.Create("data/" + *circuitName + "/proving.key")
-		_, _ = pk.WriteTo(fPk)
-		fPk.Close()
-	}
-	{
-		fCs, _ := os.Create("data/" + *circuitName + "/circuit.r1cs")
-		_, _ = r1cs.WriteTo(fCs)
-		fCs.Close()
+
+	// 4. Artifacts, in the layout loaded by the server
+	writeArtifact(filepath.Join(circuitDir, "verifier.sol"), vk.ExportSolidity)
+	writeArtifact(filepath.Join(circuitDir, "verifying.key"), writerTo(vk))
+	writeArtifact(filepath.Join(circuitDir, "proving.key"), writerTo(pk))
+	writeArtifact(filepath.Join(circuitDir, "circuit.r1cs"), writerTo(r1cs))
+	if _, err := artifacts.WriteManifest(*dataDir, *circuitName, *version); err != nil {
+		log.Fatal("Failed to write the artifact manifest: ", err)
 	}
+	log.Println("Wrote", filepath.Join(circuitDir, artifacts.ManifestFile))
 	fmt.Println("Setup done!")
 }
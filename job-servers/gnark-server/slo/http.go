@@ -0,0 +1,55 @@
+package slo
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"gnark-server/apierror"
+)
+
+// ServeHTTP returns the SLO report.
+func (r *Recorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		apierror.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(r.Report())
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// ServeMetrics writes the report in the Prometheus text format.
+func (r *Recorder) ServeMetrics(w http.ResponseWriter, req *http.Request) {
+	report := r.Report()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	summary := func(name string, help string, value func(Series) Quantiles) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s summary\n", name, help, name)
+		for _, s := range report.Series {
+			labels := fmt.Sprintf(`tenant="%s",circuit="%s"`, labelEscaper.Replace(s.Tenant), labelEscaper.Replace(s.Circuit))
+			q := value(s)
+			fmt.Fprintf(w, "%s{%s,quantile=\"0.5\"} %g\n", name, labels, float64(q.P50)/1000)
+			fmt.Fprintf(w, "%s{%s,quantile=\"0.9\"} %g\n", name, labels, float64(q.P90)/1000)
+			fmt.Fprintf(w, "%s{%s,quantile=\"0.99\"} %g\n", name, labels, float64(q.P99)/1000)
+			fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, s.Jobs)
+		}
+	}
+	summary("gnark_job_queue_wait_seconds", "Time jobs finished in the SLO window waited for a prover worker.", func(s Series) Quantiles { return s.QueueWait })
+	summary("gnark_job_latency_seconds", "End-to-end latency of jobs finished in the SLO window.", func(s Series) Quantiles { return s.Latency })
+
+	fmt.Fprintf(w, "# HELP gnark_tenant_slo_breached_periods Consecutive SLO evaluations the tenant's latency exceeded the target.\n# TYPE gnark_tenant_slo_breached_periods gauge\n")
+	for _, t := range report.Tenants {
+		fmt.Fprintf(w, "gnark_tenant_slo_breached_periods{tenant=\"%s\"} %d\n", labelEscaper.Replace(t.Tenant), t.BreachedPeriods)
+	}
+	fmt.Fprintf(w, "# HELP gnark_tenant_slo_flagged Whether the tenant persistently exceeds the latency SLO.\n# TYPE gnark_tenant_slo_flagged gauge\n")
+	for _, t := range report.Tenants {
+		flagged := 0
+		if t.Flagged {
+			flagged = 1
+		}
+		fmt.Fprintf(w, "gnark_tenant_slo_flagged{tenant=\"%s\"} %d\n", labelEscaper.Replace(t.Tenant), flagged)
+	}
+}
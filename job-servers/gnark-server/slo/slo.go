@@ -0,0 +1,251 @@
+// Package slo tracks queue wait and end-to-end latency of proof jobs per
+// tenant and circuit, and flags tenants whose latency stays above the SLO.
+package slo
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxSamples bounds the memory of one tenant and circuit; older samples are
+// dropped first.
+const maxSamples = 10000
+
+type sample struct {
+	at        time.Time
+	queueWait time.Duration
+	latency   time.Duration
+}
+
+type seriesKey struct {
+	tenant  string
+	circuit string
+}
+
+type Recorder struct {
+	// Window is how far back samples are kept.
+	Window time.Duration
+	// Target is the end-to-end latency that Percentile of a tenant's jobs
+	// must stay within.
+	Target     time.Duration
+	Percentile float64
+	// BreachPeriods is the number of consecutive evaluations over Target
+	// after which a tenant is flagged.
+	BreachPeriods int
+
+	mu       sync.Mutex
+	series   map[seriesKey][]sample
+	breaches map[string]int
+}
+
+// Record adds the queue wait and end-to-end latency of a finished job.
+func (r *Recorder) Record(tenant string, circuit string, queueWait time.Duration, latency time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.series == nil {
+		r.series = make(map[seriesKey][]sample)
+	}
+	key := seriesKey{tenant: tenant, circuit: circuit}
+	samples := append(r.series[key], sample{at: time.Now(), queueWait: queueWait, latency: latency})
+	if len(samples) > maxSamples {
+		samples = samples[len(samples)-maxSamples:]
+	}
+	r.series[key] = samples
+}
+
+// prune drops samples older than Window and series left empty.
+func (r *Recorder) prune(now time.Time) {
+	for key, samples := range r.series {
+		i := sort.Search(len(samples), func(i int) bool {
+			return now.Sub(samples[i].at) <= r.Window
+		})
+		if i == len(samples) {
+			delete(r.series, key)
+			continue
+		}
+		r.series[key] = samples[i:]
+	}
+}
+
+// Quantiles are in milliseconds.
+type Quantiles struct {
+	P50 int64 `json:"p50Ms"`
+	P90 int64 `json:"p90Ms"`
+	P99 int64 `json:"p99Ms"`
+}
+
+type Series struct {
+	Tenant    string    `json:"tenant"`
+	Circuit   string    `json:"circuit"`
+	Jobs      int       `json:"jobs"`
+	QueueWait Quantiles `json:"queueWait"`
+	Latency   Quantiles `json:"latency"`
+}
+
+type Tenant struct {
+	Tenant string `json:"tenant"`
+	Jobs   int    `json:"jobs"`
+	// LatencyMs is the tenant's latency at the SLO percentile.
+	LatencyMs       int64 `json:"latencyMs"`
+	BreachedPeriods int   `json:"breachedPeriods"`
+	Flagged         bool  `json:"flagged"`
+}
+
+type Report struct {
+	WindowMs   int64    `json:"windowMs"`
+	TargetMs   int64    `json:"targetMs"`
+	Percentile float64  `json:"percentile"`
+	Series     []Series `json:"series"`
+	Tenants    []Tenant `json:"tenants"`
+}
+
+func quantile(sorted []time.Duration, q float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(q * float64(len(sorted)-1))
+	return sorted[i]
+}
+
+func quantiles(durations []time.Duration) Quantiles {
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	return Quantiles{
+		P50: quantile(durations, 0.5).Milliseconds(),
+		P90: quantile(durations, 0.9).Milliseconds(),
+		P99: quantile(durations, 0.99).Milliseconds(),
+	}
+}
+
+// proveDurations returns, sorted, the time recent jobs of circuit took from
+// leaving the queue to finishing, across tenants.
+func (r *Recorder) proveDurations(circuit string) []time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.prune(time.Now())
+
+	var durations []time.Duration
+	for key, samples := range r.series {
+		if key.circuit != circuit {
+			continue
+		}
+		for _, s := range samples {
+			durations = append(durations, s.latency-s.queueWait)
+		}
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	return durations
+}
+
+// ProveDuration is the median prove duration of recent jobs of circuit, or
+// false if there are none.
+func (r *Recorder) ProveDuration(circuit string) (time.Duration, bool) {
+	durations := r.proveDurations(circuit)
+	if len(durations) == 0 {
+		return 0, false
+	}
+	return quantile(durations, 0.5), true
+}
+
+// ProveTimes summarizes the prove durations of recent jobs of circuit.
+type ProveTimes struct {
+	WindowMs int64 `json:"windowMs"`
+	Jobs     int   `json:"jobs"`
+	P50      int64 `json:"p50Ms"`
+	P95      int64 `json:"p95Ms"`
+}
+
+func (r *Recorder) ProveTimes(circuit string) ProveTimes {
+	durations := r.proveDurations(circuit)
+	return ProveTimes{
+		WindowMs: r.Window.Milliseconds(),
+		Jobs:     len(durations),
+		P50:      quantile(durations, 0.5).Milliseconds(),
+		P95:      quantile(durations, 0.95).Milliseconds(),
+	}
+}
+
+// Report summarizes the samples in the window.
+func (r *Recorder) Report() Report {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.prune(time.Now())
+
+	report := Report{
+		WindowMs:   r.Window.Milliseconds(),
+		TargetMs:   r.Target.Milliseconds(),
+		Percentile: r.Percentile,
+		Series:     []Series{},
+		Tenants:    []Tenant{},
+	}
+	tenantLatencies := make(map[string][]time.Duration)
+	for key, samples := range r.series {
+		waits := make([]time.Duration, len(samples))
+		latencies := make([]time.Duration, len(samples))
+		for i, s := range samples {
+			waits[i] = s.queueWait
+			latencies[i] = s.latency
+		}
+		tenantLatencies[key.tenant] = append(tenantLatencies[key.tenant], latencies...)
+		report.Series = append(report.Series, Series{
+			Tenant:    key.tenant,
+			Circuit:   key.circuit,
+			Jobs:      len(samples),
+			QueueWait: quantiles(waits),
+			Latency:   quantiles(latencies),
+		})
+	}
+	for tenant, latencies := range tenantLatencies {
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		report.Tenants = append(report.Tenants, Tenant{
+			Tenant:          tenant,
+			Jobs:            len(latencies),
+			LatencyMs:       quantile(latencies, r.Percentile/100).Milliseconds(),
+			BreachedPeriods: r.breaches[tenant],
+			Flagged:         r.breaches[tenant] >= r.BreachPeriods,
+		})
+	}
+	sort.Slice(report.Series, func(i, j int) bool {
+		if report.Series[i].Tenant != report.Series[j].Tenant {
+			return report.Series[i].Tenant < report.Series[j].Tenant
+		}
+		return report.Series[i].Circuit < report.Series[j].Circuit
+	})
+	sort.Slice(report.Tenants, func(i, j int) bool { return report.Tenants[i].Tenant < report.Tenants[j].Tenant })
+	return report
+}
+
+// Evaluate compares every tenant's latency with Target, counting consecutive
+// breaches, and logs an alert when a tenant becomes flagged.
+func (r *Recorder) Evaluate() {
+	report := r.Report()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	breaches := make(map[string]int, len(report.Tenants))
+	for _, tenant := range report.Tenants {
+		if tenant.LatencyMs <= report.TargetMs {
+			continue
+		}
+		breaches[tenant.Tenant] = r.breaches[tenant.Tenant] + 1
+		if breaches[tenant.Tenant] == r.BreachPeriods {
+			log.Printf("ALERT latency SLO breached tenant=%s p%g=%dms target=%dms periods=%d\n", tenant.Tenant, r.Percentile, tenant.LatencyMs, report.TargetMs, r.BreachPeriods)
+		}
+	}
+	r.breaches = breaches
+}
+
+// Run evaluates the SLO every interval until ctx is done.
+func (r *Recorder) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		r.Evaluate()
+	}
+}
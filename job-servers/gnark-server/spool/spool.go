@@ -0,0 +1,108 @@
+package spool
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+
+	"gnark-server/apierror"
+)
+
+// Limiter buffers request bodies before handing them to a handler. Bodies are
+// kept in memory while the total of in-flight buffered bytes stays below
+// MemoryLimit, and are spilled to temporary files in Dir otherwise. Spilled
+// bytes are bounded by DiskLimit.
+type Limiter struct {
+	MaxBodyBytes int64
+	MemoryLimit  int64
+	DiskLimit    int64
+	Dir          string
+
+	mu         sync.Mutex
+	memoryUsed int64
+	diskUsed   int64
+}
+
+func (l *Limiter) reserve(used *int64, limit int64, size int64) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if *used+size > limit {
+		return false
+	}
+	*used += size
+	return true
+}
+
+func (l *Limiter) release(used *int64, size int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	*used -= size
+}
+
+func (l *Limiter) Middleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		size := r.ContentLength
+		if size > l.MaxBodyBytes {
+			apierror.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		body := http.MaxBytesReader(w, r.Body, l.MaxBodyBytes)
+
+		if size >= 0 && l.reserve(&l.memoryUsed, l.MemoryLimit, size) {
+			defer l.release(&l.memoryUsed, size)
+			buf := make([]byte, size)
+			if _, err := io.ReadFull(body, buf); err != nil {
+				readError(w, err)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(buf))
+			next(w, r)
+			return
+		}
+
+		// The length of chunked bodies is unknown up front, so they reserve
+		// the maximum body size.
+		reserved := size
+		if reserved < 0 {
+			reserved = l.MaxBodyBytes
+		}
+		if !l.reserve(&l.diskUsed, l.DiskLimit, reserved) {
+			w.Header().Set("Retry-After", "30")
+			apierror.Error(w, "Server is busy, retry later", http.StatusServiceUnavailable)
+			return
+		}
+		defer l.release(&l.diskUsed, reserved)
+
+		f, err := os.CreateTemp(l.Dir, "gnark-body-*")
+		if err != nil {
+			log.Printf("Failed to create spool file: %v\n", err)
+			apierror.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+		if _, err := io.Copy(f, body); err != nil {
+			readError(w, err)
+			return
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			apierror.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		r.Body = f
+		next(w, r)
+	}
+}
+
+func readError(w http.ResponseWriter, err error) {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		apierror.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	apierror.Error(w, "Failed to read request body", http.StatusBadRequest)
+}
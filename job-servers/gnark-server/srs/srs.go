@@ -0,0 +1,180 @@
+// Package srs obtains the KZG structured reference string used by the PLONK
+// setup: it downloads it from a known ceremony, checks its structure and
+// caches it locally together with its SHA-256 digest.
+package srs
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"gnark-server/trusted_setup"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	kzg_bn254 "github.com/consensys/gnark-crypto/ecc/bn254/kzg"
+)
+
+const SourceAztecIgnition = "aztec-ignition"
+
+type Options struct {
+	// Source is the ceremony to download from when the file is missing.
+	Source string
+	// IgnitionStart is the first Ignition contribution whose successors are
+	// checked when downloading.
+	IgnitionStart int
+	// SHA256, when set, is the expected hex digest of the file.
+	SHA256 string
+	// MinSize is the minimum number of G1 points required.
+	MinSize int
+}
+
+// Load returns the SRS cached at path, downloading it first if the file does
+// not exist. The file's digest is checked against opts.SHA256 (or against the
+// digest recorded next to it at download time) and its points against each
+// other before it is returned.
+func Load(path string, opts Options) (*kzg_bn254.SRS, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if err := Download(path, opts); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	digest, err := fileDigest(path)
+	if err != nil {
+		return nil, err
+	}
+	expected := strings.ToLower(opts.SHA256)
+	if expected == "" {
+		expected, err = readDigest(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if expected != "" && digest != expected {
+		return nil, fmt.Errorf("SRS %s has SHA-256 %s, expected %s", path, digest, expected)
+	}
+	if expected == "" {
+		log.Println("No digest recorded for SRS", path, "- only its structure is checked")
+	}
+
+	srs := &kzg_bn254.SRS{}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if _, err := srs.ReadFrom(bufio.NewReader(f)); err != nil {
+		return nil, fmt.Errorf("failed to read SRS: %w", err)
+	}
+	if len(srs.Pk.G1) < opts.MinSize {
+		return nil, fmt.Errorf("SRS has %d points, at least %d are required", len(srs.Pk.G1), opts.MinSize)
+	}
+	if err := Verify(srs); err != nil {
+		return nil, err
+	}
+	return srs, nil
+}
+
+// Download fetches the SRS from opts.Source into path and records its digest
+// in path + ".sha256".
+func Download(path string, opts Options) error {
+	tmp := path + ".tmp"
+	switch opts.Source {
+	case SourceAztecIgnition, "":
+		log.Println("Downloading the Aztec Ignition SRS to", path)
+		trusted_setup.DownloadAndSaveAztecIgnitionSrs(opts.IgnitionStart, tmp)
+	default:
+		return fmt.Errorf("unknown SRS source %q", opts.Source)
+	}
+	digest, err := fileDigest(tmp)
+	if err != nil {
+		return err
+	}
+	if opts.SHA256 != "" && digest != strings.ToLower(opts.SHA256) {
+		os.Remove(tmp)
+		return fmt.Errorf("downloaded SRS has SHA-256 %s, expected %s", digest, opts.SHA256)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return os.WriteFile(path+".sha256", []byte(digest+"\n"), 0644)
+}
+
+// Verify checks that the SRS consists of successive powers of one secret:
+// the first points are the generators, every point is in the prime-order
+// subgroup, and for random scalars rᵢ, e(∑ rᵢ·G1[i+1], G₂) = e(∑ rᵢ·G1[i], [τ]G₂).
+func Verify(srs *kzg_bn254.SRS) error {
+	points := srs.Pk.G1
+	if len(points) < 2 {
+		return errors.New("SRS has fewer than 2 points")
+	}
+	_, _, g1, g2 := bn254.Generators()
+	if !points[0].Equal(&g1) || !srs.Vk.G1.Equal(&g1) || !srs.Vk.G2[0].Equal(&g2) {
+		return errors.New("SRS does not start with the generators")
+	}
+	for i := range points {
+		if !points[i].IsInSubGroup() {
+			return fmt.Errorf("SRS point %d is not in the subgroup", i)
+		}
+	}
+	if !srs.Vk.G2[1].IsInSubGroup() {
+		return errors.New("SRS [τ]G₂ is not in the subgroup")
+	}
+
+	scalars := make([]fr.Element, len(points)-1)
+	for i := range scalars {
+		if _, err := scalars[i].SetRandom(); err != nil {
+			return err
+		}
+	}
+	var shifted, base bn254.G1Affine
+	if _, err := shifted.MultiExp(points[1:], scalars, ecc.MultiExpConfig{}); err != nil {
+		return err
+	}
+	if _, err := base.MultiExp(points[:len(points)-1], scalars, ecc.MultiExpConfig{}); err != nil {
+		return err
+	}
+	base.Neg(&base)
+	ok, err := bn254.PairingCheck([]bn254.G1Affine{shifted, base}, []bn254.G2Affine{srs.Vk.G2[0], srs.Vk.G2[1]})
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("SRS points are not successive powers of the same secret")
+	}
+	return nil
+}
+
+func fileDigest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func readDigest(path string) (string, error) {
+	data, err := os.ReadFile(path + ".sha256")
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.ToLower(strings.TrimSpace(string(data))), nil
+}
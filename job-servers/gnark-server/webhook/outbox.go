@@ -0,0 +1,186 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"gnark-server/rediskey"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	redisOutboxKey          = "webhook_outbox"
+	redisScheduleKey        = "webhook_schedule"
+	redisLockKeyPrefix      = "webhook_lock:"
+	redisDeliveredKeyPrefix = "webhook_delivered:"
+
+	pollInterval = time.Second
+	batchSize    = 16
+	lockTTL      = 30 * time.Second
+	maxBackoff   = 5 * time.Minute
+)
+
+// Delivery is a pending webhook call. Id is the deduplication key and is sent
+// to the receiver as the Idempotency-Key header.
+type Delivery struct {
+	Id        string          `json:"id"`
+	URL       string          `json:"url"`
+	Payload   json.RawMessage `json:"payload"`
+	Attempts  int             `json:"attempts"`
+	CreatedAt time.Time       `json:"createdAt"`
+}
+
+type Outbox struct {
+	RedisClient redis.UniversalClient
+	HTTPClient  *http.Client
+	MaxAttempts int
+	MaxAge      time.Duration
+}
+
+func NewOutbox(rdb redis.UniversalClient, maxAttempts int, maxAge time.Duration) *Outbox {
+	return &Outbox{
+		RedisClient: rdb,
+		HTTPClient:  &http.Client{Timeout: 10 * time.Second},
+		MaxAttempts: maxAttempts,
+		MaxAge:      maxAge,
+	}
+}
+
+func ValidateURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("webhook URL must be an absolute http(s) URL")
+	}
+	return nil
+}
+
+// Enqueue adds the delivery to pipe, so that it is persisted in the same
+// transaction as the job result it reports.
+func (o *Outbox) Enqueue(ctx context.Context, pipe redis.Pipeliner, delivery Delivery) error {
+	deliveryJSON, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+	pipe.HSet(ctx, rediskey.Key(redisOutboxKey), delivery.Id, deliveryJSON)
+	pipe.ZAdd(ctx, rediskey.Key(redisScheduleKey), &redis.Z{Score: float64(time.Now().UnixMilli()), Member: delivery.Id})
+	return nil
+}
+
+func (o *Outbox) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		ids, err := o.RedisClient.ZRangeByScore(ctx, rediskey.Key(redisScheduleKey), &redis.ZRangeBy{
+			Min:   "-inf",
+			Max:   fmt.Sprint(time.Now().UnixMilli()),
+			Count: batchSize,
+		}).Result()
+		if err != nil {
+			log.Printf("Failed to read webhook outbox: %v\n", err)
+			continue
+		}
+		for _, id := range ids {
+			o.process(ctx, id)
+		}
+	}
+}
+
+func (o *Outbox) process(ctx context.Context, id string) {
+	locked, err := o.RedisClient.SetNX(ctx, rediskey.Key(redisLockKeyPrefix)+id, 1, lockTTL).Result()
+	if err != nil || !locked {
+		return
+	}
+	defer o.RedisClient.Del(ctx, rediskey.Key(redisLockKeyPrefix)+id)
+
+	deliveryJSON, err := o.RedisClient.HGet(ctx, rediskey.Key(redisOutboxKey), id).Result()
+	if err == redis.Nil {
+		o.RedisClient.ZRem(ctx, rediskey.Key(redisScheduleKey), id)
+		return
+	} else if err != nil {
+		log.Printf("Failed to read webhook delivery %s: %v\n", id, err)
+		return
+	}
+	var delivery Delivery
+	if err := json.Unmarshal([]byte(deliveryJSON), &delivery); err != nil {
+		log.Printf("Dropping malformed webhook delivery %s: %v\n", id, err)
+		o.remove(ctx, id)
+		return
+	}
+
+	delivered, err := o.RedisClient.Exists(ctx, rediskey.Key(redisDeliveredKeyPrefix)+id).Result()
+	if err != nil {
+		return
+	}
+	if delivered == 0 {
+		if err := o.send(ctx, delivery); err != nil {
+			o.reschedule(ctx, delivery, err)
+			return
+		}
+		o.RedisClient.Set(ctx, rediskey.Key(redisDeliveredKeyPrefix)+id, 1, o.MaxAge)
+		log.Println("Webhook delivered. jobId", id)
+	}
+	o.remove(ctx, id)
+}
+
+func (o *Outbox) send(ctx context.Context, delivery Delivery) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", delivery.Id)
+	resp, err := o.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (o *Outbox) reschedule(ctx context.Context, delivery Delivery, cause error) {
+	delivery.Attempts++
+	if delivery.Attempts >= o.MaxAttempts || time.Since(delivery.CreatedAt) > o.MaxAge {
+		log.Printf("Giving up on webhook for jobId %s after %d attempts: %v\n", delivery.Id, delivery.Attempts, cause)
+		o.remove(ctx, delivery.Id)
+		return
+	}
+	backoff := time.Second << delivery.Attempts
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	deliveryJSON, err := json.Marshal(delivery)
+	if err != nil {
+		return
+	}
+	pipe := o.RedisClient.TxPipeline()
+	pipe.HSet(ctx, rediskey.Key(redisOutboxKey), delivery.Id, deliveryJSON)
+	pipe.ZAdd(ctx, rediskey.Key(redisScheduleKey), &redis.Z{Score: float64(time.Now().Add(backoff).UnixMilli()), Member: delivery.Id})
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("Failed to reschedule webhook for jobId %s: %v\n", delivery.Id, err)
+	}
+}
+
+func (o *Outbox) remove(ctx context.Context, id string) {
+	pipe := o.RedisClient.TxPipeline()
+	pipe.HDel(ctx, rediskey.Key(redisOutboxKey), id)
+	pipe.ZRem(ctx, rediskey.Key(redisScheduleKey), id)
+	pipe.Exec(ctx)
+}
@@ -0,0 +1,294 @@
+// Package wrapper wraps plonky2 proofs in PLONK proofs over BN254 that the
+// circuit's Solidity verifier accepts. It is the proving core of the server,
+// for services that embed it in-process instead of calling the HTTP API:
+//
+//	p, err := wrapper.Load("data", "withdrawal", wrapper.Options{SelfVerify: true})
+//	...
+//	proof, err := p.Prove(ctx, input)
+//	calldata := proof.SolidityHex()
+package wrapper
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+
+	verifierCircuit "gnark-server/circuit"
+	"gnark-server/circuitData"
+	"gnark-server/prover"
+	"gnark-server/utils"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fp"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark-crypto/ecc/bn254/kzg"
+	plonk_bn254 "github.com/consensys/gnark/backend/plonk/bn254"
+	"github.com/consensys/gnark/backend/witness"
+	"github.com/consensys/gnark/frontend"
+	"github.com/qope/gnark-plonky2-verifier/types"
+	"github.com/qope/gnark-plonky2-verifier/variables"
+)
+
+// Prover proves with the data of one circuit. It is safe for concurrent use.
+type Prover interface {
+	// Prove wraps input. When ctx is done first, Prove returns its error
+	// while the prove, which cannot be interrupted, finishes in the
+	// background.
+	Prove(ctx context.Context, input types.ProofWithPublicInputsRaw) (*Proof, error)
+	// Verify checks proof against the verifying key.
+	Verify(proof *Proof) error
+	// ExportSolidity writes the Solidity verifier contract of the circuit.
+	ExportSolidity(w io.Writer) error
+}
+
+type Options struct {
+	// Backend produces the PLONK proofs; prover.CPU when nil.
+	Backend prover.Backend
+	// PreVerify solves the constraint system before proving, which fails
+	// fast on a plonky2 proof that does not verify.
+	PreVerify bool
+	// SelfVerify verifies every proof produced against the verifying key.
+	SelfVerify bool
+}
+
+// Witness is the assignment of the verifier circuit to a plonky2 proof.
+type Witness struct {
+	full witness.Witness
+	// PublicInputs are the public inputs of the PLONK proof.
+	PublicInputs []*big.Int
+}
+
+// Proof is a PLONK proof with its public inputs.
+type Proof struct {
+	PLONK        *plonk_bn254.Proof
+	PublicInputs []*big.Int
+	public       fr.Vector
+}
+
+// NewWitness assigns input to the verifier circuit of data.
+func NewWitness(data *circuitData.CircuitData, input types.ProofWithPublicInputsRaw) (*Witness, error) {
+	proofWithPis := variables.DeserializeProofWithPublicInputs(input)
+	assignment := verifierCircuit.VerifierCircuit{
+		Proof:                   proofWithPis.Proof,
+		PublicInputs:            proofWithPis.PublicInputs,
+		VerifierOnlyCircuitData: data.VerifierOnlyCircuitData,
+	}
+	full, err := frontend.NewWitness(&assignment, ecc.BN254.ScalarField())
+	if err != nil {
+		return nil, err
+	}
+	publicInputs, err := utils.ExtractPublicInputs(full)
+	if err != nil {
+		return nil, err
+	}
+	return &Witness{full: full, PublicInputs: publicInputs}, nil
+}
+
+// Solve checks that w satisfies the constraint system of data, i.e. that the
+// plonky2 proof verifies, without proving.
+func Solve(data *circuitData.CircuitData, w *Witness) error {
+	return data.Ccs.IsSolved(w.full)
+}
+
+// ProveWitness proves w with backend, reading the proving key of data first
+// if it is loaded lazily.
+func ProveWitness(backend prover.Backend, data *circuitData.CircuitData, w *Witness) (*Proof, error) {
+	pk, err := data.ProvingKey()
+	if err != nil {
+		return nil, err
+	}
+	public, err := w.full.Public()
+	if err != nil {
+		return nil, err
+	}
+	proof, err := backend.Prove(&data.Ccs, pk, w.full)
+	if err != nil {
+		return nil, err
+	}
+	return &Proof{PLONK: proof, PublicInputs: w.PublicInputs, public: public.Vector().(fr.Vector)}, nil
+}
+
+// Verify checks proof against the verifying key of data.
+func Verify(data *circuitData.CircuitData, proof *Proof) error {
+	return plonk_bn254.Verify(proof.PLONK, &data.Vk, proof.public)
+}
+
+// Solidity is the proof as the Solidity verifier takes it.
+func (p *Proof) Solidity() []byte {
+	return p.PLONK.MarshalSolidity()
+}
+
+// SolidityHex is Solidity hex-encoded, as the server returns it.
+func (p *Proof) SolidityHex() string {
+	return hex.EncodeToString(p.Solidity())
+}
+
+// ParseSolidityHex reads back a proof of data encoded by SolidityHex, with
+// its public inputs in decimal, such as a proof returned by another server,
+// so that it can be verified.
+func ParseSolidityHex(data *circuitData.CircuitData, proofHex string, publicInputs []string) (*Proof, error) {
+	encoded, err := hex.DecodeString(strings.TrimPrefix(proofHex, "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid proof: %w", err)
+	}
+	commitments := len(data.Vk.Qcp)
+	if len(encoded) != 9*2*fp.Bytes+8*fr.Bytes+commitments*(fr.Bytes+2*fp.Bytes) {
+		return nil, fmt.Errorf("invalid proof: %d bytes", len(encoded))
+	}
+	r := solidityReader{encoded: encoded}
+	proof := &plonk_bn254.Proof{
+		BatchedProof:     kzg.BatchOpeningProof{ClaimedValues: make([]fr.Element, 7+commitments)},
+		Bsb22Commitments: make([]kzg.Digest, commitments),
+	}
+	for i := range proof.LRO {
+		r.point(&proof.LRO[i])
+	}
+	for i := range proof.H {
+		r.point(&proof.H[i])
+	}
+	for i := 2; i < 7; i++ {
+		r.scalar(&proof.BatchedProof.ClaimedValues[i])
+	}
+	r.point(&proof.Z)
+	r.scalar(&proof.ZShiftedOpening.ClaimedValue)
+	r.scalar(&proof.BatchedProof.ClaimedValues[0])
+	r.scalar(&proof.BatchedProof.ClaimedValues[1])
+	r.point(&proof.BatchedProof.H)
+	r.point(&proof.ZShiftedOpening.H)
+	for i := 0; i < commitments; i++ {
+		r.scalar(&proof.BatchedProof.ClaimedValues[7+i])
+	}
+	for i := range proof.Bsb22Commitments {
+		r.point(&proof.Bsb22Commitments[i])
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("invalid proof: %w", r.err)
+	}
+
+	public := make(fr.Vector, len(publicInputs))
+	values := make([]*big.Int, len(publicInputs))
+	for i, input := range publicInputs {
+		value, ok := new(big.Int).SetString(input, 10)
+		if !ok || value.Sign() < 0 || value.Cmp(fr.Modulus()) >= 0 {
+			return nil, fmt.Errorf("invalid public input %q", input)
+		}
+		public[i].SetBigInt(value)
+		values[i] = value
+	}
+	return &Proof{PLONK: proof, PublicInputs: values, public: public}, nil
+}
+
+// solidityReader reads the points and scalars of a Solidity-encoded proof,
+// keeping the first error.
+type solidityReader struct {
+	encoded []byte
+	err     error
+}
+
+func (r *solidityReader) point(p *bn254.G1Affine) {
+	if r.err == nil {
+		_, r.err = p.SetBytes(r.encoded[:2*fp.Bytes])
+	}
+	r.encoded = r.encoded[2*fp.Bytes:]
+}
+
+func (r *solidityReader) scalar(e *fr.Element) {
+	if r.err == nil {
+		r.err = e.SetBytesCanonical(r.encoded[:fr.Bytes])
+	}
+	r.encoded = r.encoded[fr.Bytes:]
+}
+
+// PublicInputStrings are the public inputs in decimal, as the server returns
+// them.
+func (p *Proof) PublicInputStrings() []string {
+	return decimal(p.PublicInputs)
+}
+
+// PublicInputStrings are the public inputs in decimal.
+func (w *Witness) PublicInputStrings() []string {
+	return decimal(w.PublicInputs)
+}
+
+func decimal(inputs []*big.Int) []string {
+	strs := make([]string, len(inputs))
+	for i, input := range inputs {
+		strs[i] = input.String()
+	}
+	return strs
+}
+
+type circuitProver struct {
+	data *circuitData.CircuitData
+	opts Options
+}
+
+// New returns the Prover of data.
+func New(data *circuitData.CircuitData, opts Options) Prover {
+	if opts.Backend == nil {
+		opts.Backend = prover.CPU
+	}
+	return &circuitProver{data: data, opts: opts}
+}
+
+// Load loads the circuit circuitName from dataDir, laid out like the
+// server's data directory, and returns its Prover.
+func Load(dataDir string, circuitName string, opts Options) (Prover, error) {
+	data, err := circuitData.LoadCircuitData(circuitName, circuitData.LoadOptions{DataDir: dataDir})
+	if err != nil {
+		return nil, err
+	}
+	return New(&data, opts), nil
+}
+
+func (p *circuitProver) Prove(ctx context.Context, input types.ProofWithPublicInputsRaw) (*Proof, error) {
+	type outcome struct {
+		proof *Proof
+		err   error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		proof, err := p.prove(input)
+		done <- outcome{proof, err}
+	}()
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case result := <-done:
+		return result.proof, result.err
+	}
+}
+
+func (p *circuitProver) prove(input types.ProofWithPublicInputsRaw) (*Proof, error) {
+	w, err := NewWitness(p.data, input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build witness: %w", err)
+	}
+	if p.opts.PreVerify {
+		if err := Solve(p.data, w); err != nil {
+			return nil, fmt.Errorf("plonky2 proof verification failed: %w", err)
+		}
+	}
+	proof, err := ProveWitness(p.opts.Backend, p.data, w)
+	if err != nil {
+		return nil, err
+	}
+	if p.opts.SelfVerify {
+		if err := Verify(p.data, proof); err != nil {
+			return nil, fmt.Errorf("produced proof does not verify against the verifying key: %w", err)
+		}
+	}
+	return proof, nil
+}
+
+func (p *circuitProver) Verify(proof *Proof) error {
+	return Verify(p.data, proof)
+}
+
+func (p *circuitProver) ExportSolidity(w io.Writer) error {
+	return p.data.Vk.ExportSolidity(w)
+}
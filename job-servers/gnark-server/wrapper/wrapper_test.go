@@ -0,0 +1,63 @@
+package wrapper
+
+import (
+	"testing"
+
+	"gnark-server/circuitData"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/backend/plonk"
+	plonk_bn254 "github.com/consensys/gnark/backend/plonk/bn254"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/scs"
+	"github.com/consensys/gnark/test"
+)
+
+type cubeCircuit struct {
+	X frontend.Variable
+	Y frontend.Variable `gnark:",public"`
+}
+
+func (c *cubeCircuit) Define(api frontend.API) error {
+	api.AssertIsEqual(api.Mul(c.X, c.X, c.X), c.Y)
+	return nil
+}
+
+func TestParseSolidityHexVerifies(t *testing.T) {
+	ccs, err := frontend.Compile(ecc.BN254.ScalarField(), scs.NewBuilder, &cubeCircuit{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srs, err := test.NewKZGSRS(ccs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, vk, err := plonk.Setup(ccs, srs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	full, err := frontend.NewWitness(&cubeCircuit{X: 3, Y: 27}, ecc.BN254.ScalarField())
+	if err != nil {
+		t.Fatal(err)
+	}
+	proved, err := plonk.Prove(ccs, pk, full)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := &circuitData.CircuitData{Vk: *vk.(*plonk_bn254.VerifyingKey)}
+	proofHex := (&Proof{PLONK: proved.(*plonk_bn254.Proof)}).SolidityHex()
+
+	proof, err := ParseSolidityHex(data, proofHex, []string{"27"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Verify(data, proof); err != nil {
+		t.Fatalf("parsed proof does not verify: %v", err)
+	}
+	if proof, err := ParseSolidityHex(data, proofHex, []string{"28"}); err != nil || Verify(data, proof) == nil {
+		t.Fatalf("proof verifies with other public inputs: %v", err)
+	}
+	if _, err := ParseSolidityHex(data, proofHex[2:], []string{"27"}); err == nil {
+		t.Fatal("truncated proof accepted")
+	}
+}